@@ -1,10 +1,25 @@
 package api
 
+import "encoding/json"
+
 // swagger:ignore
 type CoreClientServiceActionMessagesResponse struct {
 	Messages []string `json:"messages"`
 }
 
+// swagger:model ActionDetailsResponse
+type ActionDetailsResponse struct {
+	// The ID of the transaction
+	// example: 2IXwq4klvWbnPf1YaAc1XD85jJX
+	ActionID string `json:"actionID"`
+
+	// The messages signed by the agent to approve the transaction, hex encoded
+	Messages []string `json:"messages"`
+
+	// The metadata of the transaction provided by the Qredo backend along with the messages, as it's received
+	Details map[string]json.RawMessage `json:"details,omitempty"`
+}
+
 // swagger:ignore
 type CoreClientServiceActionApproveRequest struct {
 	Signatures []string `json:"signatures"`
@@ -12,27 +27,325 @@ type CoreClientServiceActionApproveRequest struct {
 	ActionID   string   `json:"action_id,omitempty"`
 }
 
+// swagger:ignore
+type CoreClientServiceActionRejectRequest struct {
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// The codes of the reasons set by the signing agent when a transaction is rejected. The API callers and the
+// auto-approval rules can set their own codes
+const (
+	RejectCodeManual  = "manual"
+	RejectCodeRule    = "rule"
+	RejectCodeDefault = "default_decision"
+	RejectCodePolicy  = "policy"
+)
+
+// swagger:model RejectReason
+type RejectReason struct {
+	// The code of the reason, ex. manual, rule, default_decision, policy, or a code set by the caller
+	// example: limit_exceeded
+	Code string `json:"code" validate:"omitempty,max=64"`
+
+	// The explanation of the rejection
+	// example: withdrawals over 1 BTC need a manual approval
+	Message string `json:"message,omitempty" validate:"omitempty,max=512"`
+}
+
+// String returns the reason as it's recorded in the journal, the code then the message
+func (r RejectReason) String() string {
+	if len(r.Message) == 0 {
+		return r.Code
+	}
+	return r.Code + ": " + r.Message
+}
+
 // swagger:model ActionResponse
 type ActionResponse struct {
 	// The ID of the transaction
 	// example: 2IXwq4klvWbnPf1YaAc1XD85jJX
 	ActionID string `json:"actionID"`
 
-	// The status of the transaction
-	// enum: approved,rejected
+	// The current state of the transaction, pending while it's waiting for more approvers
+	// enum: received,approving,rejecting,approved,rejected,failed,expired,pending
 	Status string `json:"status"`
+
+	// The number of distinct approvers who approved the transaction, when it needs several approvers
+	// example: 1
+	Approvals int `json:"approvals,omitempty"`
+
+	// The number of distinct approvers required, when the transaction needs several approvers
+	// example: 2
+	Quorum int `json:"quorum,omitempty"`
 }
 
-func NewApprovedActionResponse(action_id string) ActionResponse {
+// The states of a transaction tracked by the signing agent. A received transaction is being approved or rejected,
+// then it's approved, rejected, failed when the approval or rejection failed, or expired. A transaction needing
+// several approvers is pending until the quorum is reached
+const (
+	ActionStateReceived  = "received"
+	ActionStateApproving = "approving"
+	ActionStateRejecting = "rejecting"
+	ActionStateApproved  = "approved"
+	ActionStateRejected  = "rejected"
+	ActionStateFailed    = "failed"
+	ActionStateExpired   = "expired"
+	ActionStatePending   = "pending"
+)
+
+func NewActionResponse(action_id, state string) ActionResponse {
 	return ActionResponse{
 		ActionID: action_id,
-		Status:   "approved",
+		Status:   state,
 	}
 }
 
+func NewApprovedActionResponse(action_id string) ActionResponse {
+	return NewActionResponse(action_id, ActionStateApproved)
+}
+
 func NewRejectedActionResponse(action_id string) ActionResponse {
+	return NewActionResponse(action_id, ActionStateRejected)
+}
+
+func NewPendingActionResponse(action_id string, approvals, quorum int) ActionResponse {
 	return ActionResponse{
-		ActionID: action_id,
-		Status:   "rejected",
+		ActionID:  action_id,
+		Status:    ActionStatePending,
+		Approvals: approvals,
+		Quorum:    quorum,
 	}
 }
+
+// The methods of the commands sent by a client of the local websocket feed
+const (
+	FeedCommandApprove = "approve"
+	FeedCommandReject  = "reject"
+)
+
+// swagger:model FeedCommand
+type FeedCommand struct {
+	// The JSON-RPC version, optional
+	// example: 2.0
+	JSONRPC string `json:"jsonrpc,omitempty"`
+
+	// The ID of the command, returned as it is in the response to correlate them
+	// example: 1
+	ID json.RawMessage `json:"id,omitempty"`
+
+	// The command to carry out
+	// enum: approve,reject
+	// example: approve
+	Method string `json:"method"`
+
+	// The parameters of the command
+	Params FeedCommandParams `json:"params"`
+}
+
+// swagger:model FeedCommandParams
+type FeedCommandParams struct {
+	// The ID of the transaction
+	// example: 2IXwq4klvWbnPf1YaAc1XD85jJX
+	ActionID string `json:"actionID"`
+
+	// The reason of the rejection, for the reject command
+	Reason *RejectReason `json:"reason,omitempty"`
+}
+
+// swagger:model FeedCommandResponse
+type FeedCommandResponse struct {
+	// The JSON-RPC version
+	// example: 2.0
+	JSONRPC string `json:"jsonrpc"`
+
+	// The ID of the command
+	// example: 1
+	ID json.RawMessage `json:"id"`
+
+	// The outcome of the command, when it succeeded
+	Result *ActionResponse `json:"result,omitempty"`
+
+	// The error, when the command failed
+	Error *FeedCommandError `json:"error,omitempty"`
+}
+
+// swagger:model FeedCommandError
+type FeedCommandError struct {
+	// The JSON-RPC error code
+	// example: -32000
+	Code int `json:"code"`
+
+	// The error message
+	// example: action not found
+	Message string `json:"message"`
+}
+
+// swagger:model ActionEvent
+type ActionEvent struct {
+	// The kind of event recorded for the transaction
+	// enum: received,decision,retry,approved,rejected,failed
+	// example: approved
+	Event string `json:"event"`
+
+	// What triggered the event
+	// enum: feed,auto,rest
+	// example: auto
+	Source string `json:"source"`
+
+	// Additional details about the event, ex. the decision taken or the error message
+	// example: approve
+	Detail string `json:"detail,omitempty"`
+
+	// The time the event was recorded, utc unix time
+	// example: 1670341423
+	Timestamp int64 `json:"timestamp"`
+}
+
+// swagger:model ActionRecord
+type ActionRecord struct {
+	// The ID of the transaction
+	// example: 2IXwq4klvWbnPf1YaAc1XD85jJX
+	ActionID string `json:"actionID"`
+
+	// The ID of the agent
+	// example: 98cTMMSPrDdcDDVU8idhuJGK2U1P4vmQcsp8wnED8pPR
+	AgentID string `json:"agentID"`
+
+	// The type of the transaction
+	// example: ApproveWithdraw
+	Type string `json:"type"`
+
+	// The last known outcome of the transaction
+	// enum: received,approved,rejected,failed
+	// example: approved
+	Status string `json:"status"`
+
+	// The time that the transaction will expire, utc unix time
+	// example: 1676184187
+	ExpireTime int64 `json:"expireTime"`
+
+	// The time of the first recorded event, utc unix time
+	// example: 1670341423
+	FirstSeen int64 `json:"firstSeen"`
+
+	// The time of the last recorded event, utc unix time
+	// example: 1670341425
+	LastUpdated int64 `json:"lastUpdated"`
+
+	// The events recorded for the transaction, oldest first
+	Events []ActionEvent `json:"events"`
+}
+
+// swagger:model ActionListResponse
+type ActionListResponse struct {
+	// The transactions matching the filter, most recently updated first
+	Actions []ActionRecord `json:"actions"`
+}
+
+// swagger:model PendingAction
+type PendingAction struct {
+	// The ID of the transaction
+	// example: 2IXwq4klvWbnPf1YaAc1XD85jJX
+	ActionID string `json:"actionID"`
+
+	// The ID of the agent
+	// example: 98cTMMSPrDdcDDVU8idhuJGK2U1P4vmQcsp8wnED8pPR
+	AgentID string `json:"agentID"`
+
+	// The type of the transaction
+	// example: ApproveWithdraw
+	Type string `json:"type"`
+
+	// The time that the transaction was started, utc unix time
+	// example: 1670341423
+	Timestamp int64 `json:"timestamp"`
+
+	// The time that the transaction will expire, utc unix time
+	// example: 1676184187
+	ExpireTime int64 `json:"expireTime"`
+
+	// The time the transaction was received from the feed, utc unix time
+	// example: 1670341424
+	Received int64 `json:"received"`
+}
+
+// swagger:model PendingActionListResponse
+type PendingActionListResponse struct {
+	// The transactions waiting for a decision, the first to expire first
+	Actions []PendingAction `json:"actions"`
+}
+
+// swagger:model BatchActionRequest
+type BatchActionRequest struct {
+	// The IDs of the transactions to approve or reject
+	// example: ["2IXwq4klvWbnPf1YaAc1XD85jJX","2IXwq7ldRMxqMrszcwMMYTI5iZS"]
+	ActionIDs []string `json:"actionIDs"`
+
+	// The reason of the rejection, sent to reject the transactions only. The code is manual when it's not set
+	Reason *RejectReason `json:"reason,omitempty"`
+}
+
+// swagger:model BatchActionResult
+type BatchActionResult struct {
+	ActionResponse
+
+	// The reason the transaction couldn't be approved or rejected, empty when it succeeded
+	// example: action is already rejected
+	Error string `json:"error,omitempty"`
+}
+
+// swagger:model BatchActionResponse
+type BatchActionResponse struct {
+	// The result for every transaction, in the order of the request
+	Results []BatchActionResult `json:"results"`
+}
+
+// swagger:model ShadowDecision
+type ShadowDecision struct {
+	// The ID of the transaction
+	// example: 2IXwq4klvWbnPf1YaAc1XD85jJX
+	ActionID string `json:"actionID"`
+
+	// The ID of the agent
+	// example: 98cTMMSPrDdcDDVU8idhuJGK2U1P4vmQcsp8wnED8pPR
+	AgentID string `json:"agentID"`
+
+	// The type of the transaction
+	// example: ApproveWithdraw
+	Type string `json:"type"`
+
+	// The decision the auto-approval would have taken
+	// enum: approve,reject,ignore
+	// example: approve
+	Decision string `json:"decision"`
+
+	// The name of the rule that decided, empty for the default decision
+	// example: small withdrawals
+	Rule string `json:"rule,omitempty"`
+
+	// The time of the decision, utc unix time
+	// example: 1670341424
+	Timestamp int64 `json:"timestamp"`
+
+	// The outcome of the transaction recorded in the journal, ex. approved or rejected by a person
+	// example: approved
+	Outcome string `json:"outcome,omitempty"`
+}
+
+// swagger:model ShadowDecisionListResponse
+type ShadowDecisionListResponse struct {
+	// Whether the auto-approval runs in shadow mode
+	// example: true
+	Shadow bool `json:"shadow"`
+
+	// The latest decisions taken in shadow mode, most recent first
+	Decisions []ShadowDecision `json:"decisions"`
+}
+
+// swagger:model ShadowModeRequest
+type ShadowModeRequest struct {
+	// Run the auto-approval in shadow mode
+	// example: true
+	Shadow bool `json:"shadow"`
+}
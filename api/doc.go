@@ -18,6 +18,24 @@ type DOCClientRegisterRequest struct {
 	Body ClientRegisterRequest
 }
 
+// swagger:parameters actionReject
+type DOCActionRejectRequest struct {
+	// in:body
+	Body RejectReason
+}
+
+// swagger:parameters BatchApprove BatchReject
+type DOCBatchActionRequest struct {
+	// in:body
+	Body BatchActionRequest
+}
+
+// swagger:parameters SetShadowMode
+type DOCShadowModeRequest struct {
+	// in:body
+	Body ShadowModeRequest
+}
+
 // swagger:model GetClientResponse
 type DOCGetClientResponse struct {
 	// in:body
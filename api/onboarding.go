@@ -120,3 +120,9 @@ type GetClientResponse struct {
 	// example: http://localhost:8007/api/v1/client/feed
 	FeedURL string `json:"feedURL"`
 }
+
+// swagger:model AgentListResponse
+type AgentListResponse struct {
+	// The registered agents, the system agent first
+	Agents []GetClientResponse `json:"agents"`
+}
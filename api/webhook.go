@@ -0,0 +1,64 @@
+package api
+
+import "encoding/json"
+
+// swagger:model DeadLetter
+type DeadLetter struct {
+	// The ID of the delivery, the same for every endpoint the action was posted to
+	// example: 3c9a3f5e-4c8a-4a0e-9d0b-0c7f3b7c2a51
+	ID string `json:"id"`
+
+	// The name of the endpoint
+	// example: risk-service
+	Endpoint string `json:"endpoint"`
+
+	// The URL the action was posted to
+	// example: https://risk.example.com/qredo/actions
+	URL string `json:"url"`
+
+	// The action, as received on the feed
+	Payload json.RawMessage `json:"payload"`
+
+	// The number of delivery attempts
+	// example: 5
+	Attempts int `json:"attempts"`
+
+	// The error of the last attempt
+	// example: status 503
+	Error string `json:"error"`
+
+	// The time the delivery failed, as unix seconds
+	// example: 1682593274
+	Timestamp int64 `json:"timestamp"`
+}
+
+// swagger:model DeadLetterListResponse
+type DeadLetterListResponse struct {
+	// The actions that couldn't be delivered, oldest first
+	DeadLetters []DeadLetter `json:"deadLetters"`
+}
+
+// swagger:model DeadLetterReplayResult
+type DeadLetterReplayResult struct {
+	// The name of the endpoint
+	// example: risk-service
+	Endpoint string `json:"endpoint"`
+
+	// Whether the action was delivered, the dead letter is then removed
+	// example: true
+	Delivered bool `json:"delivered"`
+
+	// The error of the replay, when the action wasn't delivered
+	// example: status 503
+	Error string `json:"error,omitempty"`
+}
+
+// swagger:model DeadLetterReplayResponse
+type DeadLetterReplayResponse struct {
+	// The ID of the delivery
+	// example: 3c9a3f5e-4c8a-4a0e-9d0b-0c7f3b7c2a51
+	ID string `json:"id"`
+
+	// The result of the replay for every endpoint the delivery failed for
+	Results []DeadLetterReplayResult `json:"results"`
+}
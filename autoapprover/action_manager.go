@@ -1,30 +1,49 @@
 package autoapprover
 
 import (
+	"errors"
+	"time"
+
+	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/defs"
+	"github.com/qredo/signing-agent/journal"
 	"github.com/qredo/signing-agent/lib"
+	"github.com/qredo/signing-agent/metrics"
 
 	"go.uber.org/zap"
 )
 
-// Action manager provides functionality to approve and reject an action given its id
+// ErrActionHandled is returned when the action was already picked up by another signing agent, with the load balancing enabled
+var ErrActionHandled = defs.ErrConflict().WithDetail("action already handled by another signing agent")
+
+// IsActionHandled returns whether err is ErrActionHandled. errors.Is can't tell it apart, as any *defs.APIError matches it
+func IsActionHandled(err error) bool {
+	var apiErr *defs.APIError
+	return errors.As(err, &apiErr) && apiErr == ErrActionHandled
+}
+
+// Action manager provides functionality to approve and reject an action given its id. The reason of a rejection is
+// recorded, and sent to the Qredo backend when it's enabled
 type ActionManager interface {
 	Approve(actionID string) error
-	Reject(actionID string) error
+	Reject(actionID string, reason api.RejectReason) error
 }
 
 type actionManage struct {
 	core                 lib.SigningAgentClient
 	syncronizer          ActionSyncronizer
 	log                  *zap.SugaredLogger
+	journal              journal.Journal
 	loadBalancingEnabled bool
 }
 
 // NewActionManager return an ActionManager that's an instance of actionManage
-func NewActionManager(core lib.SigningAgentClient, syncronizer ActionSyncronizer, log *zap.SugaredLogger, loadBalancingEnabled bool) ActionManager {
+func NewActionManager(core lib.SigningAgentClient, syncronizer ActionSyncronizer, log *zap.SugaredLogger, loadBalancingEnabled bool, journal journal.Journal) ActionManager {
 	return &actionManage{
 		core:                 core,
 		syncronizer:          syncronizer,
 		log:                  log,
+		journal:              journal,
 		loadBalancingEnabled: loadBalancingEnabled,
 	}
 }
@@ -34,10 +53,10 @@ func (a *actionManage) Approve(actionID string) error {
 	if a.loadBalancingEnabled {
 		if !a.syncronizer.ShouldHandleAction(actionID) {
 			a.log.Debugf("action [%v] was already approved!", actionID)
-			return nil
+			return ErrActionHandled
 		}
 
-		if err := a.syncronizer.AcquireLock(); err != nil {
+		if err := a.syncronizer.AcquireLock(actionID); err != nil {
 			a.log.Errorf("%v action-id %v", err, actionID)
 			return err
 		}
@@ -48,10 +67,43 @@ func (a *actionManage) Approve(actionID string) error {
 		}()
 	}
 
-	return a.core.ActionApprove(actionID)
+	return a.handle(actionID, DecisionApprove, "", a.core.ActionApprove)
 }
 
-// Reject the action for the given actionID
-func (a *actionManage) Reject(actionID string) error {
-	return a.core.ActionReject(actionID)
+// Reject the action for the given actionID, with the reason
+func (a *actionManage) Reject(actionID string, reason api.RejectReason) error {
+	return a.handle(actionID, DecisionReject, reason.String(), func(actionID string) error {
+		return a.core.ActionRejectWithReason(actionID, &reason)
+	})
+}
+
+// handle calls the core for the decision and records the outcome, with the detail
+func (a *actionManage) handle(actionID, decision, detail string, do func(actionID string) error) error {
+	a.record(actionID, journal.EventDecision, decision)
+
+	start := time.Now()
+	err := do(actionID)
+	metrics.ActionDuration.WithLabelValues(metrics.SourceREST, decision).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		metrics.ActionFailures.WithLabelValues(metrics.SourceREST, decision).Inc()
+		a.record(actionID, journal.EventFailed, err.Error())
+		return err
+	}
+
+	if decision == DecisionReject {
+		metrics.ActionsRejected.WithLabelValues(metrics.SourceREST).Inc()
+		a.record(actionID, journal.EventRejected, detail)
+	} else {
+		metrics.ActionsApproved.WithLabelValues(metrics.SourceREST).Inc()
+		a.record(actionID, journal.EventApproved, "")
+	}
+
+	return nil
+}
+
+func (a *actionManage) record(actionID, event, detail string) {
+	if err := a.journal.Record(journal.NewEntry(actionID, event, journal.SourceREST, detail)); err != nil {
+		a.log.Errorf("failed to record [%v] for action-id %v, err: %v", event, actionID, err)
+	}
 }
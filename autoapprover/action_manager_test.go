@@ -4,7 +4,12 @@ import (
 	"errors"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/journal"
 	"github.com/qredo/signing-agent/lib"
+	"github.com/qredo/signing-agent/metrics"
 	"github.com/qredo/signing-agent/util"
 
 	"github.com/stretchr/testify/assert"
@@ -16,13 +21,13 @@ func TestActionManage_Approve_shouldnt_handle_action(t *testing.T) {
 		NextShouldHandle: false,
 	}
 	coreMock := &lib.MockSigningAgentClient{}
-	sut := NewActionManager(coreMock, syncronizerMock, util.NewTestLogger(), true)
+	sut := NewActionManager(coreMock, syncronizerMock, util.NewTestLogger(), true, &journal.MockJournal{})
 
 	//Act
 	res := sut.Approve("some test action id")
 
 	//Assert
-	assert.Nil(t, res)
+	assert.Equal(t, ErrActionHandled, res)
 	assert.True(t, syncronizerMock.ShouldHandleActionCalled)
 	assert.Equal(t, "some test action id", syncronizerMock.LastActionId)
 	assert.False(t, coreMock.ActionApproveCalled)
@@ -35,7 +40,7 @@ func TestActionManage_Approve_fails_to_acquire_lock(t *testing.T) {
 		NextLockError:    errors.New("some lock error"),
 	}
 	coreMock := &lib.MockSigningAgentClient{}
-	sut := NewActionManager(coreMock, syncronizerMock, util.NewTestLogger(), true)
+	sut := NewActionManager(coreMock, syncronizerMock, util.NewTestLogger(), true, &journal.MockJournal{})
 
 	//Act
 	res := sut.Approve("some test action id")
@@ -54,7 +59,7 @@ func TestActionManage_Approve_approves(t *testing.T) {
 		NextReleaseError: errors.New("some unlock error"),
 	}
 	coreMock := &lib.MockSigningAgentClient{}
-	sut := NewActionManager(coreMock, syncronizerMock, util.NewTestLogger(), true)
+	sut := NewActionManager(coreMock, syncronizerMock, util.NewTestLogger(), true, &journal.MockJournal{})
 
 	//Act
 	res := sut.Approve("some test action id")
@@ -70,10 +75,10 @@ func TestActionManage_Reject_returns_error(t *testing.T) {
 	coreMock := &lib.MockSigningAgentClient{
 		NextError: errors.New("some reject error"),
 	}
-	sut := NewActionManager(coreMock, nil, util.NewTestLogger(), true)
+	sut := NewActionManager(coreMock, nil, util.NewTestLogger(), true, &journal.MockJournal{})
 
 	//Act
-	err := sut.Reject("some test action id")
+	err := sut.Reject("some test action id", api.RejectReason{Code: api.RejectCodeManual})
 
 	//Assert
 	assert.NotNil(t, err)
@@ -81,3 +86,72 @@ func TestActionManage_Reject_returns_error(t *testing.T) {
 	assert.True(t, coreMock.ActionRejectCalled)
 	assert.Equal(t, "some test action id", coreMock.LastRejectActionId)
 }
+
+func TestActionManage_Reject_records_decision_and_outcome(t *testing.T) {
+	//Arrange
+	coreMock := &lib.MockSigningAgentClient{}
+	journalMock := &journal.MockJournal{}
+	sut := NewActionManager(coreMock, nil, util.NewTestLogger(), false, journalMock)
+
+	//Act
+	err := sut.Reject("some test action id", api.RejectReason{Code: "limit_exceeded", Message: "over the limit"})
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Equal(t, []string{journal.EventDecision, journal.EventRejected}, journalMock.Events())
+	assert.Equal(t, journal.SourceREST, journalMock.Entries[1].Source)
+	assert.Equal(t, "some test action id", journalMock.Entries[1].ActionID)
+	assert.Equal(t, "limit_exceeded: over the limit", journalMock.Entries[1].Detail)
+	assert.Equal(t, &api.RejectReason{Code: "limit_exceeded", Message: "over the limit"}, coreMock.LastRejectReason)
+}
+
+func TestActionManage_Approve_records_failure(t *testing.T) {
+	//Arrange
+	coreMock := &lib.MockSigningAgentClient{
+		NextError: errors.New("some approve error"),
+	}
+	journalMock := &journal.MockJournal{}
+	sut := NewActionManager(coreMock, nil, util.NewTestLogger(), false, journalMock)
+
+	//Act
+	err := sut.Approve("some test action id")
+
+	//Assert
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{journal.EventDecision, journal.EventFailed}, journalMock.Events())
+	assert.Equal(t, "some approve error", journalMock.Entries[1].Detail)
+}
+
+func TestActionManage_Reject_counts_metrics(t *testing.T) {
+	//Arrange
+	coreMock := &lib.MockSigningAgentClient{}
+	sut := NewActionManager(coreMock, nil, util.NewTestLogger(), false, &journal.MockJournal{})
+	rejected := testutil.ToFloat64(metrics.ActionsRejected.WithLabelValues(metrics.SourceREST))
+	failures := testutil.ToFloat64(metrics.ActionFailures.WithLabelValues(metrics.SourceREST, DecisionReject))
+
+	//Act
+	err := sut.Reject("some test action id", api.RejectReason{Code: api.RejectCodeManual})
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Equal(t, rejected+1, testutil.ToFloat64(metrics.ActionsRejected.WithLabelValues(metrics.SourceREST)))
+	assert.Equal(t, failures, testutil.ToFloat64(metrics.ActionFailures.WithLabelValues(metrics.SourceREST, DecisionReject)))
+}
+
+func TestActionManage_Approve_counts_failure(t *testing.T) {
+	//Arrange
+	coreMock := &lib.MockSigningAgentClient{
+		NextError: errors.New("some approve error"),
+	}
+	sut := NewActionManager(coreMock, nil, util.NewTestLogger(), false, &journal.MockJournal{})
+	approved := testutil.ToFloat64(metrics.ActionsApproved.WithLabelValues(metrics.SourceREST))
+	failures := testutil.ToFloat64(metrics.ActionFailures.WithLabelValues(metrics.SourceREST, DecisionApprove))
+
+	//Act
+	err := sut.Approve("some test action id")
+
+	//Assert
+	assert.NotNil(t, err)
+	assert.Equal(t, approved, testutil.ToFloat64(metrics.ActionsApproved.WithLabelValues(metrics.SourceREST)))
+	assert.Equal(t, failures+1, testutil.ToFloat64(metrics.ActionFailures.WithLabelValues(metrics.SourceREST, DecisionApprove)))
+}
@@ -1,63 +1,149 @@
 // Package autoapprover provides a mechanism to receive action information as bytes.
-// The action data is analyzed and if it meets the requirements, the action is approved.
-// It supports approval retrying based on defined intervals
+// The action data is analyzed against the configured rules and, depending on the decision, the action
+// is approved, rejected or left for a manual decision.
+// It supports approval retrying based on defined intervals. The actions to approve or reject are kept in a queue
+// handled by a fixed number of workers, and the ones not done when the service stops are resumed on the next start
 
 package autoapprover
 
 import (
 	"encoding/json"
+	"fmt"
+	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/qredo/signing-agent/api"
 	"github.com/qredo/signing-agent/config"
 	"github.com/qredo/signing-agent/hub"
+	"github.com/qredo/signing-agent/journal"
 	"github.com/qredo/signing-agent/lib"
+	"github.com/qredo/signing-agent/metrics"
+	"github.com/qredo/signing-agent/queue"
 )
 
+// ActionTracker keeps the state of the actions, so that an action approved or rejected automatically isn't approved
+// or rejected again meanwhile, through the API, the feed commands or the gRPC API
+type ActionTracker interface {
+	// Begin moves the action to approving or rejecting for the decision. It returns false when the action is already
+	// in progress or done, and an error when it's in a state conflicting with the decision
+	Begin(actionID, decision string) (bool, error)
+
+	// End moves the action to approved or rejected for the decision, or to failed when the decision failed
+	End(actionID, decision string, err error)
+
+	// States returns the current states of the actions, the unknown or forgotten ones are left out
+	States(actionIDs []string) map[string]string
+}
+
 type AutoApprover struct {
 	hub.FeedClient
 	log                  *zap.SugaredLogger
 	cfgAutoApproval      *config.AutoApprove
+	cfgCoApproval        *config.CoApproval
 	core                 lib.SigningAgentClient
 	syncronizer          ActionSyncronizer
+	rules                *rulesEngine
+	journal              journal.Journal
+	actionQueue          queue.Queue
+	workers              int
+	agentID              string
 	lastError            error
 	loadBalancingEnabled bool
+	tracker              ActionTracker
+	shadow               shadowMode
+	policy               *policyHook //when set, the policy decision point is consulted before every approval
 }
 
 // NewAutoApprover returns a new *AutoApprover instance initialized with the provided parameters
 // The AutoApprover has an internal FeedClient which means it will be stopped when the service stops
 // or the Feed channel is closed on the sender side
-func NewAutoApprover(core lib.SigningAgentClient, log *zap.SugaredLogger, config *config.Config, syncronizer ActionSyncronizer) *AutoApprover {
-	return &AutoApprover{
+func NewAutoApprover(core lib.SigningAgentClient, log *zap.SugaredLogger, config *config.Config, syncronizer ActionSyncronizer, journal journal.Journal, actionQueue queue.Queue) *AutoApprover {
+	a := &AutoApprover{
 		FeedClient:           hub.NewFeedClient(true),
 		log:                  log,
 		cfgAutoApproval:      &config.AutoApprove,
+		cfgCoApproval:        &config.CoApproval,
 		core:                 core,
 		syncronizer:          syncronizer,
+		rules:                newRulesEngine(&config.AutoApprove),
+		journal:              journal,
+		actionQueue:          actionQueue,
+		workers:              config.ActionQueue.Workers,
 		loadBalancingEnabled: config.LoadBalancing.Enable,
+		shadow:               shadowMode{enabled: config.AutoApprove.Shadow},
 	}
+
+	if config.AutoApprove.Policy.Enabled {
+		a.policy = newPolicyHook(&config.AutoApprove.Policy, core)
+	}
+
+	return a
+}
+
+// SetTracker sets the tracker keeping the state of every action handled automatically
+func (a *AutoApprover) SetTracker(tracker ActionTracker) {
+	a.tracker = tracker
 }
 
 // Listen is constantly listening for messages on the Feed channel.
+// The actions to approve or reject are queued and handled by a fixed number of workers.
+// The actions left in the queue by a previous run are resumed first.
 // The Feed channel is always closed by the sender. Then this happens, the AutoApprover stops
 func (a *AutoApprover) Listen() {
+	a.agentID = a.core.GetSystemAgentID()
+
+	jobs := newWorkList()
+	for i := 0; i < a.workers; i++ {
+		go a.work(jobs)
+	}
+	a.resume(jobs)
+
 	for {
 		if message, ok := <-a.Feed; !ok {
 			//channel was closed by the sender
 			a.log.Info("AutoApproval: stopped")
+			jobs.close()
 			return
-		} else {
-			a.handleMessage(message)
+		} else if job := a.handleMessage(message); job != nil {
+			jobs.push(job)
 		}
 	}
 }
 
-func (a *AutoApprover) handleMessage(message []byte) {
+// handleMessage takes the decision for the action in the message and returns the queued job when the action
+// has to be approved or rejected
+func (a *AutoApprover) handleMessage(message []byte) *queue.Job {
 	var action actionInfo
 	if err := json.Unmarshal(message, &action); err == nil {
+		if len(action.Status) > 0 && action.Status != api.ActionStatePending {
+			a.shadow.setOutcome(action.ID, action.Status)
+		}
+
 		if action.IsNotExpired() {
+			decision, rule, reason := a.rules.evaluate(&action)
+			if a.IsShadow() {
+				a.shadowDecision(&action, decision, rule)
+				a.recordDecision(&action, decision, rule, journal.SourceShadow)
+				return nil
+			}
+
+			// started for the shadow mode only, the actions are never approved or rejected
+			if a.cfgAutoApproval.Shadow && !a.cfgAutoApproval.Enabled {
+				a.log.Debugf("AutoApproval: shadow mode off and auto-approval not enabled, action [%v] skipped", action.ID)
+				return nil
+			}
+
+			a.log.Debugf("AutoApproval: decision [%v] for action [%v] by rule [%v]", decision, action.ID, rule)
+			a.recordDecision(&action, decision, rule, journal.SourceAuto)
+
+			if decision == DecisionIgnore {
+				a.log.Infof("AutoApproval: action [%v] left for a manual decision", action.ID)
+				return nil
+			}
+
 			if a.shouldHandleAction(action.ID) {
-				go a.handleAction(&action)
+				return a.enqueue(&action, decision, reason)
 			}
 		} else {
 			a.log.Infof("AutoApproval: action [%v] has expired", action.ID)
@@ -66,6 +152,119 @@ func (a *AutoApprover) handleMessage(message []byte) {
 		a.log.Errorf("AutoApproval: error [%v] while unmarshaling the message [%v]", err, string(message))
 		a.lastError = err
 	}
+
+	return nil
+}
+
+// enqueue queues the job of the action under the agent served by the AutoApprover, the one carrying out the decision,
+// so that it's resumed by the same agent only
+func (a *AutoApprover) enqueue(action *actionInfo, decision string, reason api.RejectReason) *queue.Job {
+	job := &queue.Job{
+		ActionID:   action.ID,
+		AgentID:    a.agentID,
+		Type:       action.Type,
+		ExpireTime: action.ExpireTime,
+		Decision:   decision,
+		Queued:     time.Now().Unix(),
+	}
+	if decision == DecisionReject {
+		job.Reason = &reason
+	}
+
+	queued, err := a.actionQueue.Push(job)
+	if err != nil {
+		a.log.Errorf("AutoApproval: failed to save queued action [%v], it won't be resumed after a restart, err: %v", action.ID, err)
+	} else if !queued {
+		a.log.Debugf("AutoApproval: action [%v] is already queued", action.ID)
+		return nil
+	}
+
+	return job
+}
+
+// resume queues the jobs left by a previous run, with the retry budget they have left. The jobs were claimed by this
+// instance when they were queued, so they are handled again without checking whether another instance picked them up
+func (a *AutoApprover) resume(jobs *workList) {
+	for _, job := range a.actionQueue.Pending(a.agentID) {
+		a.log.Infof("AutoApproval: resuming action [%v] to %v after %d failed attempt(s)", job.ActionID, job.Decision, job.Attempts)
+		jobs.push(job)
+	}
+}
+
+func (a *AutoApprover) work(jobs *workList) {
+	for job, ok := jobs.pop(); ok; job, ok = jobs.pop() {
+		a.processJob(job)
+	}
+}
+
+// processJob approves or rejects the action, then removes the job from the queue, whatever the outcome.
+// The actions needing several approvers are never approved automatically, they are left for the co-approval
+func (a *AutoApprover) processJob(job *queue.Job) {
+	defer a.done(job)
+
+	if job.ExpireTime <= time.Now().Unix() {
+		a.log.Infof("AutoApproval: queued action [%v] has expired", job.ActionID)
+		return
+	}
+
+	if quorum := a.quorum(job.Type); job.Decision == DecisionApprove && quorum > 1 {
+		a.log.Infof("AutoApproval: action [%v] needs %d approvers, left for the co-approval", job.ActionID, quorum)
+		a.record(job.ActionID, job.AgentID, journal.EventDecision, fmt.Sprintf("ignore (needs %d approvers)", quorum))
+		return
+	}
+
+	if job.Decision == DecisionApprove && a.policy != nil && !a.consultPolicy(job) {
+		return
+	}
+
+	a.handleAction(job)
+}
+
+// consultPolicy asks the policy decision point before the action is approved. The job is switched to a rejection when
+// the policy rejects the action, and false is returned when the action is left for a manual decision
+func (a *AutoApprover) consultPolicy(job *queue.Job) bool {
+	decision, cached, err := a.policy.decide(job)
+	if err != nil {
+		metrics.PolicyFailures.Inc()
+		if a.cfgAutoApproval.Policy.FailOpen {
+			a.log.Warnf("AutoApproval: policy call failed for action [%v], approving it as decided by the rules, err: %v", job.ActionID, err)
+			a.record(job.ActionID, job.AgentID, journal.EventDecision, "approve (policy failed, fail-open)")
+			return true
+		}
+
+		a.log.Warnf("AutoApproval: policy call failed for action [%v], leaving it for a manual decision, err: %v", job.ActionID, err)
+		a.record(job.ActionID, job.AgentID, journal.EventDecision, "defer (policy failed)")
+		return false
+	}
+
+	metrics.PolicyDecisions.WithLabelValues(decision).Inc()
+	a.log.Infof("AutoApproval: policy decision [%v] for action [%v], cached: %v", decision, job.ActionID, cached)
+	if !cached {
+		a.record(job.ActionID, job.AgentID, journal.EventDecision, decision+" (policy)")
+	}
+
+	switch decision {
+	case PolicyReject:
+		job.Decision = DecisionReject
+		job.Reason = &api.RejectReason{Code: api.RejectCodePolicy, Message: "rejected by the policy decision point"}
+	case PolicyDefer:
+		return false
+	}
+	return true
+}
+
+// quorum returns the number of approvers the action type needs, 1 when the co-approval is disabled
+func (a *AutoApprover) quorum(actionType string) int {
+	if a.cfgCoApproval == nil {
+		return 1
+	}
+	return a.cfgCoApproval.Quorum(actionType)
+}
+
+func (a *AutoApprover) done(job *queue.Job) {
+	if err := a.actionQueue.Done(job.AgentID, job.ActionID); err != nil {
+		a.log.Errorf("AutoApproval: failed to remove action [%v] from the queue, err: %v", job.ActionID, err)
+	}
 }
 
 func (a *AutoApprover) shouldHandleAction(actionId string) bool {
@@ -80,38 +279,128 @@ func (a *AutoApprover) shouldHandleAction(actionId string) bool {
 	return true
 }
 
-func (a *AutoApprover) handleAction(action *actionInfo) {
+func (a *AutoApprover) handleAction(job *queue.Job) {
 	if a.loadBalancingEnabled {
-		if err := a.syncronizer.AcquireLock(); err != nil {
-			a.log.Warnf("AutoApproval, mutex lock: %v action [%v]", err, action.ID)
+		if err := a.syncronizer.AcquireLock(job.ActionID); err != nil {
+			a.log.Warnf("AutoApproval, mutex lock: %v action [%v]", err, job.ActionID)
 			return
 		}
 		defer func() {
-			if err := a.syncronizer.Release(action.ID); err != nil {
-				a.log.Warnf("AutoApproval, mutex unlock: %v action [%v]", err, action.ID)
+			if err := a.syncronizer.Release(job.ActionID); err != nil {
+				a.log.Warnf("AutoApproval, mutex unlock: %v action [%v]", err, job.ActionID)
 			}
 		}()
 	}
 
-	a.approveAction(action.ID, action.AgentID)
+	if a.tracker != nil {
+		ok, err := a.tracker.Begin(job.ActionID, job.Decision)
+		if err != nil {
+			a.log.Warnf("AutoApproval: action [%v] not %v, err: %v", job.ActionID, job.Decision, err)
+			return
+		}
+		if !ok {
+			a.log.Infof("AutoApproval: action [%v] is already handled", job.ActionID)
+			return
+		}
+	}
+
+	var err error
+	if job.Decision == DecisionReject {
+		err = a.rejectAction(job)
+	} else {
+		err = a.approveAction(job)
+	}
+
+	if a.tracker != nil {
+		a.tracker.End(job.ActionID, job.Decision, err)
+	}
 }
 
-func (a *AutoApprover) approveAction(actionId, agentId string) {
+func (a *AutoApprover) approveAction(job *queue.Job) error {
+	return a.retryAction(job, DecisionApprove, journal.EventApproved, "", a.core.ActionApprove)
+}
+
+// rejectAction rejects the action with the reason of the job. The jobs queued without a reason are rejected by the rules
+func (a *AutoApprover) rejectAction(job *queue.Job) error {
+	reason := job.Reason
+	if reason == nil {
+		reason = &api.RejectReason{Code: api.RejectCodeRule}
+	}
+
+	return a.retryAction(job, DecisionReject, journal.EventRejected, reason.String(), func(actionID string) error {
+		return a.core.ActionRejectWithReason(actionID, reason)
+	})
+}
+
+// retryAction retries the operation until it succeeds or the retry budget is spent. The outcome is recorded with the detail.
+// The progress is saved to the queue after every failed attempt, so that a resumed job only gets the budget left.
+// The error of the last attempt is returned when the budget is spent
+func (a *AutoApprover) retryAction(job *queue.Job, operation, outcome, detail string, do func(actionID string) error) error {
+	actionId, agentId := job.ActionID, job.AgentID
 	timer := newRetryTimer(a.cfgAutoApproval.RetryInterval, a.cfgAutoApproval.RetryIntervalMax)
+	timer.resume(job.Attempts, time.Duration(job.Elapsed)*time.Millisecond)
 	for {
-		if err := a.core.ActionApprove(actionId); err == nil {
-			a.log.Infof("AutoApproval: action [%v] approved automatically", actionId)
-			return
-		} else {
-			a.log.Errorf("AutoApproval: approval failed for [actionID:%v]. Error msg: %v", agentId, actionId, err)
+		start := time.Now()
+		err := do(actionId)
+		metrics.ActionDuration.WithLabelValues(metrics.SourceAuto, operation).Observe(time.Since(start).Seconds())
 
-			if timer.isTimeOut() {
-				a.log.Warnf("AutoApproval: auto action approve failed [actionID:%v]", actionId)
-				return
-			}
+		if err == nil {
+			a.log.Infof("AutoApproval: action [%v] %v automatically", actionId, outcome)
+			a.countOutcome(operation)
+			a.record(actionId, agentId, outcome, detail)
+			return nil
+		}
+
+		a.log.Errorf("AutoApproval: %v failed for [agentID:%v, actionID:%v]. Error msg: %v", operation, agentId, actionId, err)
+		if timer.isTimeOut() {
+			a.log.Warnf("AutoApproval: auto action %v failed [actionID:%v]", operation, actionId)
+			metrics.ActionFailures.WithLabelValues(metrics.SourceAuto, operation).Inc()
+			a.record(actionId, agentId, journal.EventFailed, err.Error())
+			return err
+		}
 
-			a.log.Warnf("AutoApproval: auto approve action is repeated [actionID:%v] ", actionId)
-			timer.retry()
+		a.log.Warnf("AutoApproval: auto %v action is repeated [actionID:%v] ", operation, actionId)
+		metrics.ActionRetries.WithLabelValues(operation).Inc()
+		a.record(actionId, agentId, journal.EventRetry, err.Error())
+
+		job.Attempts++
+		job.Elapsed = timer.elapsed().Milliseconds()
+		if err := a.actionQueue.Update(job); err != nil {
+			a.log.Errorf("AutoApproval: failed to save the progress of action [%v], err: %v", actionId, err)
 		}
+		timer.retry()
+	}
+}
+
+func (a *AutoApprover) countOutcome(operation string) {
+	if operation == DecisionReject {
+		metrics.ActionsRejected.WithLabelValues(metrics.SourceAuto).Inc()
+	} else {
+		metrics.ActionsApproved.WithLabelValues(metrics.SourceAuto).Inc()
+	}
+}
+
+func (a *AutoApprover) recordDecision(action *actionInfo, decision, rule, source string) {
+	detail := decision
+	if len(rule) > 0 {
+		detail = fmt.Sprintf("%v (rule: %v)", decision, rule)
+	}
+
+	entry := journal.NewEntry(action.ID, journal.EventDecision, source, detail)
+	entry.AgentID = action.AgentID
+	entry.Type = action.Type
+	entry.ExpireTime = action.ExpireTime
+
+	if err := a.journal.Record(entry); err != nil {
+		a.log.Errorf("AutoApproval: failed to record decision for action [%v], err: %v", action.ID, err)
+	}
+}
+
+func (a *AutoApprover) record(actionId, agentId, event, detail string) {
+	entry := journal.NewEntry(actionId, event, journal.SourceAuto, detail)
+	entry.AgentID = agentId
+
+	if err := a.journal.Record(entry); err != nil {
+		a.log.Errorf("AutoApproval: failed to record [%v] for action [%v], err: %v", event, actionId, err)
 	}
 }
@@ -1,16 +1,25 @@
 package autoapprover
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/qredo/signing-agent/api"
 	"github.com/qredo/signing-agent/config"
 	"github.com/qredo/signing-agent/hub"
+	"github.com/qredo/signing-agent/journal"
 	"github.com/qredo/signing-agent/lib"
+	"github.com/qredo/signing-agent/queue"
 	"github.com/qredo/signing-agent/util"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/test-go/testify/assert"
 	"go.uber.org/goleak"
 )
@@ -30,8 +39,9 @@ func (m *mockActionSyncronizer) ShouldHandleAction(actionID string) bool {
 	m.LastActionId = actionID
 	return m.NextShouldHandle
 }
-func (m *mockActionSyncronizer) AcquireLock() error {
+func (m *mockActionSyncronizer) AcquireLock(actionID string) error {
 	m.AcquireLockCalled = true
+	m.LastActionId = actionID
 	return m.NextLockError
 }
 func (m *mockActionSyncronizer) Release(actionID string) error {
@@ -40,14 +50,51 @@ func (m *mockActionSyncronizer) Release(actionID string) error {
 	return m.NextReleaseError
 }
 
+type mockActionTracker struct {
+	NextBegin    bool
+	NextBeginErr error
+	NextStates   map[string]string
+	Calls        []string
+	LastEndErr   error
+}
+
+func (m *mockActionTracker) Begin(actionID, decision string) (bool, error) {
+	m.Calls = append(m.Calls, "begin "+actionID+" "+decision)
+	return m.NextBegin, m.NextBeginErr
+}
+
+func (m *mockActionTracker) End(actionID, decision string, err error) {
+	m.Calls = append(m.Calls, "end "+actionID+" "+decision)
+	m.LastEndErr = err
+}
+
+func (m *mockActionTracker) States(actionIDs []string) map[string]string {
+	states := make(map[string]string)
+	for _, actionID := range actionIDs {
+		if state, ok := m.NextStates[actionID]; ok {
+			states[actionID] = state
+		}
+	}
+	return states
+}
+
+func newTestQueue(t *testing.T, fileName string) queue.Queue {
+	actionQueue, err := queue.NewQueue(&config.ActionQueue{File: fileName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return actionQueue
+}
+
 func TestAutoApprover_Listen_fails_to_unmarshal(t *testing.T) {
 	//Arrange
 	defer goleak.VerifyNone(t)
 	mock_core := &lib.MockSigningAgentClient{}
 	sut := &AutoApprover{
-		core:       mock_core,
-		FeedClient: hub.NewFeedClient(true),
-		log:        util.NewTestLogger(),
+		core:        mock_core,
+		FeedClient:  hub.NewFeedClient(true),
+		log:         util.NewTestLogger(),
+		actionQueue: newTestQueue(t, ""),
 	}
 	defer close(sut.Feed)
 	go sut.Listen()
@@ -83,7 +130,7 @@ func TestAutoApprover_handleMessage_shouldnt_handle_action(t *testing.T) {
 	//Arrange
 	syncronizerMock := &mockActionSyncronizer{}
 
-	sut := NewAutoApprover(nil, util.NewTestLogger(), &config.Config{LoadBalancing: config.LoadBalancing{Enable: true}}, syncronizerMock)
+	sut := NewAutoApprover(nil, util.NewTestLogger(), &config.Config{LoadBalancing: config.LoadBalancing{Enable: true}, AutoApprove: config.AutoApprove{DefaultDecision: DecisionApprove}}, syncronizerMock, &journal.MockJournal{}, newTestQueue(t, ""))
 	bytes, _ := json.Marshal(actionInfo{
 		ID:         "actionid",
 		ExpireTime: time.Now().Add(time.Minute).Unix(),
@@ -105,15 +152,14 @@ func TestAutoApprover_handleMessage_fails_to_lock(t *testing.T) {
 		NextShouldHandle: true,
 	}
 
-	sut := NewAutoApprover(nil, util.NewTestLogger(), &config.Config{LoadBalancing: config.LoadBalancing{Enable: true}}, syncronizerMock)
+	sut := NewAutoApprover(nil, util.NewTestLogger(), &config.Config{LoadBalancing: config.LoadBalancing{Enable: true}, AutoApprove: config.AutoApprove{DefaultDecision: DecisionApprove}}, syncronizerMock, &journal.MockJournal{}, newTestQueue(t, ""))
 	bytes, _ := json.Marshal(actionInfo{
 		ID:         "actionid",
 		ExpireTime: time.Now().Add(time.Minute).Unix(),
 	})
 
 	//Act
-	sut.handleMessage(bytes)
-	<-time.After(time.Second) //give it a second to process
+	sut.processJob(sut.handleMessage(bytes))
 
 	//Assert
 	assert.True(t, syncronizerMock.AcquireLockCalled)
@@ -127,14 +173,15 @@ func TestAutoApprover_handleAction_acquires_lock_and_approves(t *testing.T) {
 		NextReleaseError: errors.New("some release error"),
 	}
 	coreMock := &lib.MockSigningAgentClient{}
-	sut := NewAutoApprover(coreMock, util.NewTestLogger(), &config.Config{LoadBalancing: config.LoadBalancing{Enable: true}}, syncronizerMock)
-	action := actionInfo{
-		ID:         "actionid",
+	sut := NewAutoApprover(coreMock, util.NewTestLogger(), &config.Config{LoadBalancing: config.LoadBalancing{Enable: true}, AutoApprove: config.AutoApprove{DefaultDecision: DecisionApprove}}, syncronizerMock, &journal.MockJournal{}, newTestQueue(t, ""))
+	job := queue.Job{
+		ActionID:   "actionid",
 		ExpireTime: time.Now().Add(time.Minute).Unix(),
+		Decision:   DecisionApprove,
 	}
 
 	//Act
-	sut.handleAction(&action)
+	sut.handleAction(&job)
 
 	//Assert
 	assert.True(t, syncronizerMock.AcquireLockCalled)
@@ -148,20 +195,456 @@ func TestAutoApprover_approveAction_retries_to_approve(t *testing.T) {
 	coreMock := &lib.MockSigningAgentClient{
 		NextError: errors.New("some error"),
 	}
+	journalMock := &journal.MockJournal{}
 	sut := &AutoApprover{
 		core: coreMock,
 		cfgAutoApproval: &config.AutoApprove{
 			RetryIntervalMax: 3,
 			RetryInterval:    1,
 		},
-		log: util.NewTestLogger(),
+		log:         util.NewTestLogger(),
+		journal:     journalMock,
+		actionQueue: newTestQueue(t, ""),
 	}
 
 	//Act
-	sut.approveAction("some action id", "some agent id")
+	sut.approveAction(&queue.Job{ActionID: "some action id", AgentID: "some agent id"})
 
 	//Assert
 	assert.True(t, coreMock.ActionApproveCalled)
 	assert.Equal(t, "some action id", coreMock.LastActionId)
 	assert.True(t, coreMock.Counter > 1)
+	events := journalMock.Events()
+	assert.Equal(t, journal.EventRetry, events[0])
+	assert.Equal(t, journal.EventFailed, events[len(events)-1])
+}
+
+func TestAutoApprover_handleMessage_ignored_by_rules(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	syncronizerMock := &mockActionSyncronizer{
+		NextShouldHandle: true,
+	}
+	coreMock := &lib.MockSigningAgentClient{}
+	cfg := &config.Config{
+		LoadBalancing: config.LoadBalancing{Enable: true},
+		AutoApprove: config.AutoApprove{
+			DefaultDecision: DecisionIgnore,
+		},
+	}
+	sut := NewAutoApprover(coreMock, util.NewTestLogger(), cfg, syncronizerMock, &journal.MockJournal{}, newTestQueue(t, ""))
+	bytes, _ := json.Marshal(actionInfo{
+		ID:         "actionid",
+		ExpireTime: time.Now().Add(time.Minute).Unix(),
+	})
+
+	//Act
+	sut.handleMessage(bytes)
+
+	//Assert
+	assert.False(t, syncronizerMock.ShouldHandleActionCalled)
+	assert.False(t, coreMock.ActionApproveCalled)
+	assert.False(t, coreMock.ActionRejectCalled)
+}
+
+func TestAutoApprover_handleAction_rejects(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	coreMock := &lib.MockSigningAgentClient{}
+	journalMock := &journal.MockJournal{}
+	sut := NewAutoApprover(coreMock, util.NewTestLogger(), &config.Config{}, nil, journalMock, newTestQueue(t, ""))
+	trackerMock := &mockActionTracker{NextBegin: true}
+	sut.SetTracker(trackerMock)
+	job := queue.Job{
+		ActionID:   "actionid",
+		ExpireTime: time.Now().Add(time.Minute).Unix(),
+		Decision:   DecisionReject,
+		Reason:     &api.RejectReason{Code: "limit_exceeded", Message: "over the limit"},
+	}
+
+	//Act
+	sut.handleAction(&job)
+
+	//Assert
+	assert.True(t, coreMock.ActionRejectCalled)
+	assert.Equal(t, "actionid", coreMock.LastRejectActionId)
+	assert.Equal(t, job.Reason, coreMock.LastRejectReason)
+	assert.False(t, coreMock.ActionApproveCalled)
+	assert.Equal(t, []string{"begin actionid reject", "end actionid reject"}, trackerMock.Calls)
+	assert.Nil(t, trackerMock.LastEndErr)
+	assert.Equal(t, "limit_exceeded: over the limit", journalMock.Entries[0].Detail)
+}
+
+func TestAutoApprover_handleMessage_queues_reject_reason(t *testing.T) {
+	//Arrange
+	cfg := &config.Config{
+		AutoApprove: config.AutoApprove{
+			DefaultDecision: DecisionApprove,
+			Rules: []config.AutoApproveRule{
+				{Name: "withdrawals", Types: []string{"ApproveWithdraw"}, Decision: DecisionReject, Reason: config.RejectReason{Code: "withdrawals_closed", Message: "no withdrawals today"}},
+			},
+		},
+	}
+	sut := NewAutoApprover(&lib.MockSigningAgentClient{}, util.NewTestLogger(), cfg, nil, &journal.MockJournal{}, newTestQueue(t, ""))
+	withdrawal, _ := json.Marshal(actionInfo{ID: "withdrawal", Type: "ApproveWithdraw", ExpireTime: time.Now().Add(time.Minute).Unix()})
+	transfer, _ := json.Marshal(actionInfo{ID: "transfer", Type: "ApproveTransfer", ExpireTime: time.Now().Add(time.Minute).Unix()})
+
+	//Act
+	rejected := sut.handleMessage(withdrawal)
+	approved := sut.handleMessage(transfer)
+
+	//Assert
+	assert.Equal(t, &api.RejectReason{Code: "withdrawals_closed", Message: "no withdrawals today"}, rejected.Reason)
+	assert.Nil(t, approved.Reason)
+}
+
+func TestAutoApprover_handleMessage_queues_action_once(t *testing.T) {
+	//Arrange
+	actionQueue := newTestQueue(t, "")
+	sut := NewAutoApprover(nil, util.NewTestLogger(), &config.Config{AutoApprove: config.AutoApprove{DefaultDecision: DecisionApprove}}, nil, &journal.MockJournal{}, actionQueue)
+	sut.agentID = "agentid"
+	bytes, _ := json.Marshal(actionInfo{
+		ID:         "actionid",
+		AgentID:    "other agentid",
+		Type:       "ApproveWithdraw",
+		ExpireTime: time.Now().Add(time.Minute).Unix(),
+	})
+
+	//Act
+	job := sut.handleMessage(bytes)
+	duplicate := sut.handleMessage(bytes)
+
+	//Assert
+	assert.NotNil(t, job)
+	assert.Equal(t, DecisionApprove, job.Decision)
+	assert.Nil(t, duplicate)
+	pending := actionQueue.Pending("agentid")
+	assert.Len(t, pending, 1)
+	assert.Equal(t, "actionid", pending[0].ActionID)
+	assert.Equal(t, "ApproveWithdraw", pending[0].Type)
+}
+
+func TestAutoApprover_approveAction_saves_progress(t *testing.T) {
+	//Arrange
+	coreMock := &lib.MockSigningAgentClient{
+		NextError: errors.New("some error"),
+	}
+	actionQueue := newTestQueue(t, "")
+	sut := &AutoApprover{
+		core: coreMock,
+		cfgAutoApproval: &config.AutoApprove{
+			RetryIntervalMax: 1,
+			RetryInterval:    1,
+		},
+		log:         util.NewTestLogger(),
+		journal:     &journal.MockJournal{},
+		actionQueue: actionQueue,
+	}
+	job := &queue.Job{ActionID: "some action id", AgentID: "some agent id"}
+	_, _ = actionQueue.Push(job)
+
+	//Act
+	sut.approveAction(job)
+
+	//Assert
+	assert.Equal(t, 2, coreMock.Counter)
+	pending := actionQueue.Pending("some agent id")
+	assert.Len(t, pending, 1)
+	assert.Equal(t, 1, pending[0].Attempts)
+}
+
+func TestAutoApprover_approveAction_resumes_with_remaining_budget(t *testing.T) {
+	//Arrange
+	coreMock := &lib.MockSigningAgentClient{
+		NextError: errors.New("some error"),
+	}
+	journalMock := &journal.MockJournal{}
+	sut := &AutoApprover{
+		core: coreMock,
+		cfgAutoApproval: &config.AutoApprove{
+			RetryIntervalMax: 300,
+			RetryInterval:    5,
+		},
+		log:         util.NewTestLogger(),
+		journal:     journalMock,
+		actionQueue: newTestQueue(t, ""),
+	}
+
+	//Act
+	sut.approveAction(&queue.Job{ActionID: "some action id", Attempts: 10, Elapsed: 300000})
+
+	//Assert
+	assert.Equal(t, 1, coreMock.Counter)
+	assert.Equal(t, []string{journal.EventFailed}, journalMock.Events())
+}
+
+func TestAutoApprover_Listen_resumes_queued_actions(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	fileName := filepath.Join(t.TempDir(), "action_queue.db")
+	previous := newTestQueue(t, fileName)
+	_, _ = previous.Push(&queue.Job{ActionID: "some action id", AgentID: "some agent id", Decision: DecisionReject, ExpireTime: time.Now().Add(time.Minute).Unix(), Attempts: 1})
+	_, _ = previous.Push(&queue.Job{ActionID: "expired action id", AgentID: "some agent id", Decision: DecisionApprove, ExpireTime: 12360})
+	_, _ = previous.Push(&queue.Job{ActionID: "other action id", AgentID: "other agent id", Decision: DecisionApprove, ExpireTime: time.Now().Add(time.Minute).Unix()})
+
+	coreMock := lib.NewMockSigningAgentClient("some agent id")
+	actionQueue := newTestQueue(t, fileName)
+	cfg := &config.Config{ActionQueue: config.ActionQueue{Workers: 2}}
+	sut := NewAutoApprover(coreMock, util.NewTestLogger(), cfg, nil, &journal.MockJournal{}, actionQueue)
+
+	//Act
+	go sut.Listen()
+	<-time.After(time.Second) //give it time to process
+	close(sut.Feed)
+
+	//Assert
+	assert.True(t, coreMock.ActionRejectCalled)
+	assert.Equal(t, "some action id", coreMock.LastRejectActionId)
+	assert.False(t, coreMock.ActionApproveCalled)
+	assert.Empty(t, actionQueue.Pending("some agent id"))
+	assert.Len(t, actionQueue.Pending("other agent id"), 1)
+}
+
+func TestAutoApprover_Listen_resumes_actions_claimed_with_load_balancing(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	fileName := filepath.Join(t.TempDir(), "action_queue.db")
+	previous := newTestQueue(t, fileName)
+	_, _ = previous.Push(&queue.Job{ActionID: "some action id", AgentID: "some agent id", Decision: DecisionApprove, ExpireTime: time.Now().Add(time.Minute).Unix()})
+
+	syncronizerMock := &mockActionSyncronizer{
+		NextShouldHandle: false,
+	}
+	coreMock := lib.NewMockSigningAgentClient("some agent id")
+	actionQueue := newTestQueue(t, fileName)
+	cfg := &config.Config{
+		ActionQueue:   config.ActionQueue{Workers: 1},
+		LoadBalancing: config.LoadBalancing{Enable: true},
+	}
+	sut := NewAutoApprover(coreMock, util.NewTestLogger(), cfg, syncronizerMock, &journal.MockJournal{}, actionQueue)
+
+	//Act
+	go sut.Listen()
+	<-time.After(time.Second) //give it time to process
+	close(sut.Feed)
+
+	//Assert
+	assert.False(t, syncronizerMock.ShouldHandleActionCalled)
+	assert.True(t, syncronizerMock.AcquireLockCalled)
+	assert.True(t, coreMock.ActionApproveCalled)
+	assert.Equal(t, "some action id", coreMock.LastActionId)
+	assert.Empty(t, actionQueue.Pending("some agent id"))
+}
+
+// testLocks stands for the distributed locks, a lock can only be unlocked by the mutex holding it
+type testLocks struct {
+	lock       sync.Mutex
+	holders    map[string]*testMutex
+	locked     []string
+	mismatches int
+}
+
+type testMutex struct {
+	name  string
+	locks *testLocks
+}
+
+func (m *testMutex) Lock() error {
+	for {
+		m.locks.lock.Lock()
+		if m.locks.holders[m.name] == nil {
+			m.locks.holders[m.name] = m
+			m.locks.locked = append(m.locks.locked, m.name)
+			m.locks.lock.Unlock()
+			return nil
+		}
+		m.locks.lock.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (m *testMutex) Unlock() (bool, error) {
+	m.locks.lock.Lock()
+	defer m.locks.lock.Unlock()
+
+	if m.locks.holders[m.name] != m {
+		m.locks.mismatches++
+		return false, errors.New("lock not held")
+	}
+	delete(m.locks.holders, m.name)
+	return true, nil
+}
+
+type testCache struct {
+	lock sync.Mutex
+	keys map[string]bool
+}
+
+func (c *testCache) Get(ctx context.Context, key string) *redis.StringCmd {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	cmd := redis.NewStringCmd(ctx)
+	if !c.keys[key] {
+		cmd.SetErr(redis.Nil)
+	}
+	return cmd
+}
+
+func (c *testCache) Set(ctx context.Context, key string, _ interface{}, _ time.Duration) *redis.StatusCmd {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.keys[key] = true
+	return redis.NewStatusCmd(ctx)
+}
+
+// slowCore approves the actions after a while, so that the workers handle several of them at the same time
+type slowCore struct {
+	*lib.MockSigningAgentClient
+	lock     sync.Mutex
+	approved []string
+}
+
+func (c *slowCore) ActionApprove(actionID string) error {
+	time.Sleep(20 * time.Millisecond)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.approved = append(c.approved, actionID)
+	return nil
+}
+
+func TestAutoApprover_work_handles_several_actions_with_load_balancing(t *testing.T) {
+	//Arrange
+	locks := &testLocks{holders: make(map[string]*testMutex)}
+	cache := &testCache{keys: make(map[string]bool)}
+	syncronizer := NewSyncronizer(&config.LoadBalancing{Enable: true, ActionIDExpirationSec: 60}, cache, nil).(*syncronize)
+	syncronizer.newMutex = func(name string) mutex {
+		return &testMutex{name: name, locks: locks}
+	}
+	core := &slowCore{MockSigningAgentClient: &lib.MockSigningAgentClient{}}
+	cfg := &config.Config{
+		ActionQueue:   config.ActionQueue{Workers: 4},
+		LoadBalancing: config.LoadBalancing{Enable: true},
+		AutoApprove:   config.AutoApprove{DefaultDecision: DecisionApprove},
+	}
+	sut := NewAutoApprover(core, util.NewTestLogger(), cfg, syncronizer, &journal.MockJournal{}, newTestQueue(t, ""))
+
+	jobs := newWorkList()
+	var wg sync.WaitGroup
+	for i := 0; i < sut.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sut.work(jobs)
+		}()
+	}
+
+	var expected []string
+	for i := 0; i < 12; i++ {
+		actionID := fmt.Sprintf("action %d", i)
+		expected = append(expected, actionID)
+		bytes, _ := json.Marshal(actionInfo{ID: actionID, ExpireTime: time.Now().Add(time.Minute).Unix()})
+		jobs.push(sut.handleMessage(bytes))
+	}
+
+	//Act
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline) && len(sut.actionQueue.Pending("")) > 0; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	jobs.close()
+	wg.Wait()
+
+	//Assert
+	sort.Strings(expected)
+	sort.Strings(core.approved)
+	sort.Strings(locks.locked)
+	assert.Equal(t, expected, core.approved)
+	assert.Equal(t, expected, locks.locked)
+	assert.Zero(t, locks.mismatches)
+	assert.Empty(t, locks.holders)
+	assert.Empty(t, syncronizer.mutexes)
+	for _, actionID := range expected {
+		assert.False(t, syncronizer.ShouldHandleAction(actionID))
+	}
+}
+
+func TestAutoApprover_handleAction_tracks_the_state(t *testing.T) {
+	var testCases = []struct {
+		name      string
+		begin     bool
+		beginErr  error
+		coreErr   error
+		approved  bool
+		endCalled bool
+	}{
+		{"approved", true, nil, nil, true, true},
+		{"failed", true, nil, errors.New("some error"), true, true},
+		{"already in progress", false, nil, nil, false, false},
+		{"conflicting state", false, errors.New("action is already rejected"), nil, false, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			coreMock := &lib.MockSigningAgentClient{NextError: tc.coreErr}
+			cfg := &config.Config{AutoApprove: config.AutoApprove{RetryInterval: 1, RetryIntervalMax: 1}}
+			sut := NewAutoApprover(coreMock, util.NewTestLogger(), cfg, nil, &journal.MockJournal{}, newTestQueue(t, ""))
+			trackerMock := &mockActionTracker{NextBegin: tc.begin, NextBeginErr: tc.beginErr}
+			sut.SetTracker(trackerMock)
+
+			//Act
+			sut.handleAction(&queue.Job{ActionID: "actionid", Decision: DecisionApprove})
+
+			//Assert
+			assert.Equal(t, tc.approved, coreMock.ActionApproveCalled)
+			if tc.endCalled {
+				assert.Equal(t, []string{"begin actionid approve", "end actionid approve"}, trackerMock.Calls)
+				assert.Equal(t, tc.coreErr, trackerMock.LastEndErr)
+			} else {
+				assert.Equal(t, []string{"begin actionid approve"}, trackerMock.Calls)
+			}
+		})
+	}
+}
+
+func TestAutoApprover_processJob_leaves_quorum_to_co_approval(t *testing.T) {
+	var testCases = []struct {
+		name       string
+		actionType string
+		decision   string
+		approved   bool
+		rejected   bool
+	}{
+		{"needs several approvers", "ApproveWithdraw", DecisionApprove, false, false},
+		{"single approver", "ApproveTransfer", DecisionApprove, true, false},
+		{"rejected", "ApproveWithdraw", DecisionReject, false, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			coreMock := &lib.MockSigningAgentClient{}
+			journalMock := &journal.MockJournal{}
+			cfg := &config.Config{
+				CoApproval: config.CoApproval{
+					Enabled:  true,
+					Policies: []config.CoApprovalPolicy{{Types: []string{"ApproveWithdraw"}, Quorum: 2}},
+				},
+			}
+			sut := NewAutoApprover(coreMock, util.NewTestLogger(), cfg, nil, journalMock, newTestQueue(t, ""))
+
+			//Act
+			sut.processJob(&queue.Job{ActionID: "actionid", Type: tc.actionType, Decision: tc.decision, ExpireTime: time.Now().Add(time.Minute).Unix()})
+
+			//Assert
+			assert.Equal(t, tc.approved, coreMock.ActionApproveCalled)
+			assert.Equal(t, tc.rejected, coreMock.ActionRejectCalled)
+			if !tc.approved && !tc.rejected {
+				assert.Equal(t, "ignore (needs 2 approvers)", journalMock.Entries[0].Detail)
+			}
+		})
+	}
 }
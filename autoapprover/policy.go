@@ -0,0 +1,248 @@
+package autoapprover
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/config"
+	"github.com/qredo/signing-agent/queue"
+)
+
+// The decisions returned by the policy decision point
+const (
+	PolicyApprove = "approve"
+	PolicyReject  = "reject"
+	PolicyDefer   = "defer"
+)
+
+const (
+	policyFormatOPA = "opa"
+
+	// policyResponseMaxSize is the maximum size of a response read from the policy decision point
+	policyResponseMaxSize = 64 * 1024
+)
+
+// policyInput holds the details of the action sent to the policy decision point,
+// with the messages to sign and the transaction metadata fetched from Qredo
+type policyInput struct {
+	ActionID   string                     `json:"actionID"`
+	AgentID    string                     `json:"agentID"`
+	Type       string                     `json:"type"`
+	ExpireTime int64                      `json:"expireTime"`
+	Decision   string                     `json:"decision"`
+	Messages   []string                   `json:"messages,omitempty"`
+	Details    map[string]json.RawMessage `json:"details,omitempty"`
+}
+
+// actionFetcher fetches the payload of an action from Qredo
+type actionFetcher interface {
+	GetAction(actionID string) (*api.ActionDetailsResponse, error)
+}
+
+// policyResponse is the response of the policy decision point. The decision is read from result with the opa format,
+// which is either the decision or an object holding it
+type policyResponse struct {
+	Decision string          `json:"decision"`
+	Result   json.RawMessage `json:"result"`
+}
+
+type cachedDecision struct {
+	decision string
+	expires  time.Time
+}
+
+// policyHook asks the external policy decision point whether an action can be approved.
+// The decisions are cached by policy input, so that an action whose details changed is sent again
+type policyHook struct {
+	cfg        *config.AutoApprovePolicy
+	httpClient *http.Client
+	fetcher    actionFetcher
+	lock       sync.Mutex
+	cache      map[string]cachedDecision
+	now        func() time.Time
+}
+
+// newPolicyHook returns a policyHook sending the details of the actions given by the fetcher, when there's one
+func newPolicyHook(cfg *config.AutoApprovePolicy, fetcher actionFetcher) *policyHook {
+	return &policyHook{
+		cfg: cfg,
+		httpClient: &http.Client{
+			Timeout: time.Duration(cfg.TimeoutMs) * time.Millisecond,
+		},
+		fetcher: fetcher,
+		cache:   make(map[string]cachedDecision),
+		now:     time.Now,
+	}
+}
+
+// decide returns the decision of the policy decision point for the job, approve, reject or defer.
+// It returns whether the decision was taken from the cache
+func (p *policyHook) decide(job *queue.Job) (string, bool, error) {
+	input, err := p.input(job)
+	if err != nil {
+		return "", false, err
+	}
+
+	key, err := cacheKey(input)
+	if err != nil {
+		return "", false, err
+	}
+
+	if decision, ok := p.cached(key); ok {
+		return decision, true, nil
+	}
+
+	decision, err := p.post(input)
+	if err != nil {
+		return "", false, err
+	}
+
+	p.store(key, decision)
+	return decision, false, nil
+}
+
+// input returns the policy input of the job, with the details of the action when there's a fetcher
+func (p *policyHook) input(job *queue.Job) (*policyInput, error) {
+	input := &policyInput{
+		ActionID:   job.ActionID,
+		AgentID:    job.AgentID,
+		Type:       job.Type,
+		ExpireTime: job.ExpireTime,
+		Decision:   job.Decision,
+	}
+
+	if p.fetcher == nil {
+		return input, nil
+	}
+
+	details, err := p.fetcher.GetAction(job.ActionID)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch action details")
+	}
+	if details != nil {
+		input.Messages = details.Messages
+		input.Details = details.Details
+	}
+
+	return input, nil
+}
+
+// cacheKey returns the hash of the policy input, the key of the decision in the cache
+func cacheKey(input *policyInput) (string, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return "", errors.Wrap(err, "encode input")
+	}
+
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+func (p *policyHook) post(input *policyInput) (string, error) {
+	var body interface{} = input
+	if p.cfg.Format == policyFormatOPA {
+		body = map[string]interface{}{"input": input}
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return "", errors.Wrap(err, "encode request")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.cfg.URL, bytes.NewReader(payload))
+	if err != nil {
+		return "", errors.Wrap(err, "create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(p.cfg.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+p.cfg.Token)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "request error")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return "", errors.Errorf("status %v", resp.StatusCode)
+	}
+
+	response := &policyResponse{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, policyResponseMaxSize)).Decode(response); err != nil {
+		return "", errors.Wrap(err, "decode response")
+	}
+
+	return p.decision(response)
+}
+
+// decision returns the decision in the response, or an error when it isn't a known one
+func (p *policyHook) decision(response *policyResponse) (string, error) {
+	decision := response.Decision
+	if p.cfg.Format == policyFormatOPA {
+		if err := json.Unmarshal(response.Result, &decision); err != nil {
+			result := &policyResponse{}
+			if err := json.Unmarshal(response.Result, result); err != nil {
+				return "", errors.New("invalid result")
+			}
+			decision = result.Decision
+		}
+	}
+
+	switch decision {
+	case PolicyApprove, PolicyReject, PolicyDefer:
+		return decision, nil
+	default:
+		return "", errors.Errorf("invalid decision [%s]", decision)
+	}
+}
+
+// cached returns the decision cached for the key, and drops the expired ones
+func (p *policyHook) cached(key string) (string, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.evict()
+	cached, ok := p.cache[key]
+	if !ok {
+		return "", false
+	}
+	return cached.decision, true
+}
+
+// store caches the decision, and drops the expired ones
+func (p *policyHook) store(key, decision string) {
+	if p.cfg.CacheTTLSec <= 0 {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := p.evict()
+	p.cache[key] = cachedDecision{
+		decision: decision,
+		expires:  now.Add(time.Duration(p.cfg.CacheTTLSec) * time.Second),
+	}
+}
+
+// evict drops the expired decisions from the cache and returns the current time. Caller must handle concurrency
+func (p *policyHook) evict() time.Time {
+	now := p.now()
+	for key, cached := range p.cache {
+		if !now.Before(cached.expires) {
+			delete(p.cache, key)
+		}
+	}
+	return now
+}
@@ -0,0 +1,237 @@
+package autoapprover
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/config"
+	"github.com/qredo/signing-agent/journal"
+	"github.com/qredo/signing-agent/lib"
+	"github.com/qredo/signing-agent/queue"
+	"github.com/qredo/signing-agent/util"
+
+	"github.com/test-go/testify/assert"
+)
+
+// newTestPolicyServer returns a policy decision point answering with the response, and counting the calls it receives
+func newTestPolicyServer(t *testing.T, status int, response string, calls *int, lastBody *map[string]interface{}) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		if lastBody != nil {
+			_ = json.NewDecoder(r.Body).Decode(lastBody)
+		}
+		assert.Equal(t, "Bearer some token", r.Header.Get("Authorization"))
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestPolicyConfig(url, format string) *config.AutoApprovePolicy {
+	return &config.AutoApprovePolicy{
+		Enabled:     true,
+		URL:         url,
+		Format:      format,
+		Token:       "some token",
+		TimeoutMs:   1000,
+		CacheTTLSec: 60,
+	}
+}
+
+func newTestPolicyJob() *queue.Job {
+	return &queue.Job{
+		ActionID:   "some action id",
+		AgentID:    "some agent id",
+		Type:       "ApproveWithdraw",
+		ExpireTime: time.Now().Add(time.Minute).Unix(),
+		Decision:   DecisionApprove,
+	}
+}
+
+func TestPolicyHook_decide(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		format   string
+		status   int
+		response string
+		expected string
+		err      string
+	}{
+		{"http", "http", http.StatusOK, `{"decision":"reject"}`, PolicyReject, ""},
+		{"opa result", "opa", http.StatusOK, `{"result":"defer"}`, PolicyDefer, ""},
+		{"opa result object", "opa", http.StatusOK, `{"result":{"decision":"approve"}}`, PolicyApprove, ""},
+		{"opa undefined result", "opa", http.StatusOK, `{}`, "", "invalid result"},
+		{"invalid decision", "http", http.StatusOK, `{"decision":"maybe"}`, "", "invalid decision [maybe]"},
+		{"error status", "http", http.StatusInternalServerError, ``, "", "status 500"},
+		{"invalid response", "http", http.StatusOK, `not json`, "", "decode response"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			calls := 0
+			server := newTestPolicyServer(t, tc.status, tc.response, &calls, nil)
+			sut := newPolicyHook(newTestPolicyConfig(server.URL, tc.format), nil)
+
+			//Act
+			decision, cached, err := sut.decide(newTestPolicyJob())
+
+			//Assert
+			assert.Equal(t, 1, calls)
+			assert.False(t, cached)
+			assert.Equal(t, tc.expected, decision)
+			if len(tc.err) == 0 {
+				assert.Nil(t, err)
+			} else {
+				assert.NotNil(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+			}
+		})
+	}
+}
+
+func TestPolicyHook_decide_sends_action_details(t *testing.T) {
+	//Arrange
+	calls := 0
+	body := map[string]interface{}{}
+	server := newTestPolicyServer(t, http.StatusOK, `{"result":"approve"}`, &calls, &body)
+	coreMock := &lib.MockSigningAgentClient{
+		NextActionDetails: &api.ActionDetailsResponse{
+			ActionID: "some action id",
+			Messages: []string{"some message"},
+			Details:  map[string]json.RawMessage{"amount": json.RawMessage(`100`)},
+		},
+	}
+	sut := newPolicyHook(newTestPolicyConfig(server.URL, "opa"), coreMock)
+	job := newTestPolicyJob()
+
+	//Act
+	_, _, err := sut.decide(job)
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Equal(t, "some action id", coreMock.LastGetActionId)
+	assert.Equal(t, map[string]interface{}{
+		"input": map[string]interface{}{
+			"actionID":   "some action id",
+			"agentID":    "some agent id",
+			"type":       "ApproveWithdraw",
+			"expireTime": float64(job.ExpireTime),
+			"decision":   DecisionApprove,
+			"messages":   []interface{}{"some message"},
+			"details":    map[string]interface{}{"amount": float64(100)},
+		},
+	}, body)
+}
+
+func TestPolicyHook_decide_fails_to_fetch_action_details(t *testing.T) {
+	//Arrange
+	calls := 0
+	server := newTestPolicyServer(t, http.StatusOK, `{"decision":"approve"}`, &calls, nil)
+	coreMock := &lib.MockSigningAgentClient{NextGetActionError: errors.New("some error")}
+	sut := newPolicyHook(newTestPolicyConfig(server.URL, "http"), coreMock)
+
+	//Act
+	_, _, err := sut.decide(newTestPolicyJob())
+
+	//Assert
+	assert.NotNil(t, err)
+	assert.Equal(t, "fetch action details: some error", err.Error())
+	assert.Equal(t, 0, calls)
+}
+
+func TestPolicyHook_decide_caches_decisions(t *testing.T) {
+	//Arrange
+	calls := 0
+	server := newTestPolicyServer(t, http.StatusOK, `{"decision":"approve"}`, &calls, nil)
+	coreMock := &lib.MockSigningAgentClient{
+		NextActionDetails: &api.ActionDetailsResponse{Messages: []string{"some message"}},
+	}
+	sut := newPolicyHook(newTestPolicyConfig(server.URL, "http"), coreMock)
+	now := time.Now()
+	sut.now = func() time.Time { return now }
+
+	//Act
+	_, first, _ := sut.decide(newTestPolicyJob())
+	_, second, _ := sut.decide(newTestPolicyJob())
+	coreMock.NextActionDetails = &api.ActionDetailsResponse{Messages: []string{"some other message"}}
+	_, changed, _ := sut.decide(newTestPolicyJob())
+	now = now.Add(time.Minute)
+	_, expired, _ := sut.decide(newTestPolicyJob())
+
+	//Assert
+	assert.False(t, first)
+	assert.True(t, second)
+	assert.False(t, changed)
+	assert.False(t, expired)
+	assert.Equal(t, 3, calls)
+	assert.Len(t, sut.cache, 1)
+}
+
+func TestAutoApprover_processJob_consults_policy(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		status   int
+		response string
+		failOpen bool
+		approved bool
+		rejected bool
+	}{
+		{"approved", http.StatusOK, `{"decision":"approve"}`, false, true, false},
+		{"rejected", http.StatusOK, `{"decision":"reject"}`, false, false, true},
+		{"deferred", http.StatusOK, `{"decision":"defer"}`, false, false, false},
+		{"failed closed", http.StatusServiceUnavailable, ``, false, false, false},
+		{"failed open", http.StatusServiceUnavailable, ``, true, true, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			calls := 0
+			server := newTestPolicyServer(t, tc.status, tc.response, &calls, nil)
+			cfg := &config.Config{}
+			cfg.AutoApprove.Policy = *newTestPolicyConfig(server.URL, "http")
+			cfg.AutoApprove.Policy.FailOpen = tc.failOpen
+			coreMock := &lib.MockSigningAgentClient{}
+			journalMock := &journal.MockJournal{}
+			sut := NewAutoApprover(coreMock, util.NewTestLogger(), cfg, nil, journalMock, newTestQueue(t, ""))
+
+			//Act
+			sut.processJob(newTestPolicyJob())
+
+			//Assert
+			assert.Equal(t, 1, calls)
+			assert.Equal(t, tc.approved, coreMock.ActionApproveCalled)
+			assert.Equal(t, tc.rejected, coreMock.ActionRejectCalled)
+			if tc.rejected {
+				assert.Equal(t, api.RejectCodePolicy, coreMock.LastRejectReason.Code)
+			}
+			assert.Equal(t, journal.EventDecision, journalMock.Events()[0])
+		})
+	}
+}
+
+func TestAutoApprover_processJob_doesnt_consult_policy_to_reject(t *testing.T) {
+	//Arrange
+	calls := 0
+	server := newTestPolicyServer(t, http.StatusOK, `{"decision":"approve"}`, &calls, nil)
+	cfg := &config.Config{}
+	cfg.AutoApprove.Policy = *newTestPolicyConfig(server.URL, "http")
+	coreMock := &lib.MockSigningAgentClient{}
+	sut := NewAutoApprover(coreMock, util.NewTestLogger(), cfg, nil, &journal.MockJournal{}, newTestQueue(t, ""))
+	job := newTestPolicyJob()
+	job.Decision = DecisionReject
+
+	//Act
+	sut.processJob(job)
+
+	//Assert
+	assert.Equal(t, 0, calls)
+	assert.True(t, coreMock.ActionRejectCalled)
+}
@@ -26,3 +26,13 @@ func (t *retryTimer) retry() {
 	time.Sleep(time.Duration(t.baseInc) * time.Second)
 	t.baseInc += t.retryInterval
 }
+
+// resume continues the timer of an action that already failed the given number of attempts over the elapsed time
+func (t *retryTimer) resume(attempts int, elapsed time.Duration) {
+	t.start = t.start.Add(-elapsed)
+	t.baseInc = t.retryInterval * (attempts + 1)
+}
+
+func (t *retryTimer) elapsed() time.Duration {
+	return time.Since(t.start)
+}
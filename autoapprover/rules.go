@@ -0,0 +1,106 @@
+package autoapprover
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/config"
+)
+
+// The decisions the rules engine can take for an action
+const (
+	DecisionApprove = "approve"
+	DecisionReject  = "reject"
+	DecisionIgnore  = "ignore"
+)
+
+// rulesEngine decides what happens to an action based on the rules defined in the autoApproval config.
+// The rules are evaluated in order and the first matching rule decides. If none matches, the default decision is used
+type rulesEngine struct {
+	rules           []config.AutoApproveRule
+	defaultDecision string
+	defaultReason   config.RejectReason
+}
+
+func newRulesEngine(cfg *config.AutoApprove) *rulesEngine {
+	defaultDecision := cfg.DefaultDecision
+	if len(defaultDecision) == 0 {
+		defaultDecision = DecisionIgnore
+	}
+
+	return &rulesEngine{
+		rules:           cfg.Rules,
+		defaultDecision: defaultDecision,
+		defaultReason:   cfg.DefaultReason,
+	}
+}
+
+// evaluate returns the decision for the action, the name of the rule that took it and the reason given when the action
+// is rejected. The rule name is empty when the default decision is returned
+func (e *rulesEngine) evaluate(action *actionInfo) (string, string, api.RejectReason) {
+	for _, rule := range e.rules {
+		if ruleMatches(&rule, action) {
+			reason := rejectReason(&rule.Reason, api.RejectCodeRule, fmt.Sprintf("rejected by rule [%s]", rule.Name))
+			return normalizeDecision(rule.Decision), rule.Name, reason
+		}
+	}
+
+	reason := rejectReason(&e.defaultReason, api.RejectCodeDefault, "no rule matched the action")
+	return normalizeDecision(e.defaultDecision), "", reason
+}
+
+// rejectReason returns the configured reason, with the given code and message when they aren't set
+func rejectReason(reason *config.RejectReason, code, message string) api.RejectReason {
+	r := api.RejectReason{
+		Code:    reason.Code,
+		Message: reason.Message,
+	}
+	if len(r.Code) == 0 {
+		r.Code = code
+	}
+	if len(r.Message) == 0 {
+		r.Message = message
+	}
+	return r
+}
+
+func ruleMatches(rule *config.AutoApproveRule, action *actionInfo) bool {
+	if !matchesAny(rule.Types, action.Type) || !matchesAny(rule.Statuses, action.Status) || !matchesAny(rule.AgentIDs, action.AgentID) {
+		return false
+	}
+
+	expiresIn := action.ExpireTime - time.Now().Unix()
+	if rule.MinExpirySec > 0 && expiresIn < int64(rule.MinExpirySec) {
+		return false
+	}
+	if rule.MaxExpirySec > 0 && expiresIn > int64(rule.MaxExpirySec) {
+		return false
+	}
+
+	return true
+}
+
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeDecision makes sure an unknown decision never leads to an approval
+func normalizeDecision(decision string) string {
+	switch decision {
+	case DecisionApprove, DecisionReject:
+		return decision
+	default:
+		return DecisionIgnore
+	}
+}
@@ -0,0 +1,92 @@
+package autoapprover
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/config"
+)
+
+func TestRulesEngine_evaluate_no_rules_ignores_by_default(t *testing.T) {
+	//Arrange
+	sut := newRulesEngine(&config.AutoApprove{})
+	action := &actionInfo{
+		ID:         "actionid",
+		ExpireTime: time.Now().Add(time.Minute).Unix(),
+	}
+
+	//Act
+	decision, rule, reason := sut.evaluate(action)
+
+	//Assert
+	assert.Equal(t, DecisionIgnore, decision)
+	assert.Empty(t, rule)
+	assert.Equal(t, api.RejectReason{Code: api.RejectCodeDefault, Message: "no rule matched the action"}, reason)
+}
+
+func TestRulesEngine_evaluate_first_matching_rule_decides(t *testing.T) {
+	//Arrange
+	sut := newRulesEngine(&config.AutoApprove{
+		DefaultDecision: DecisionIgnore,
+		Rules: []config.AutoApproveRule{
+			{Name: "transfers", Types: []string{"ApproveTransfer"}, Decision: DecisionApprove},
+			{Name: "other agent", AgentIDs: []string{"other agent id"}, Decision: DecisionApprove},
+			{Name: "pending withdrawals", Types: []string{"ApproveWithdraw"}, Statuses: []string{"pending"}, Decision: DecisionReject, Reason: config.RejectReason{Code: "withdrawals_closed"}},
+			{Name: "all withdrawals", Types: []string{"ApproveWithdraw"}, Decision: DecisionApprove},
+		},
+	})
+	action := &actionInfo{
+		ID:         "actionid",
+		AgentID:    "agentid",
+		Type:       "ApproveWithdraw",
+		Status:     "pending",
+		ExpireTime: time.Now().Add(time.Minute).Unix(),
+	}
+
+	//Act
+	decision, rule, reason := sut.evaluate(action)
+
+	//Assert
+	assert.Equal(t, DecisionReject, decision)
+	assert.Equal(t, "pending withdrawals", rule)
+	assert.Equal(t, api.RejectReason{Code: "withdrawals_closed", Message: "rejected by rule [pending withdrawals]"}, reason)
+}
+
+func TestRulesEngine_evaluate_expiry_window(t *testing.T) {
+	//Arrange
+	sut := newRulesEngine(&config.AutoApprove{
+		DefaultDecision: DecisionIgnore,
+		Rules: []config.AutoApproveRule{
+			{Name: "too soon", MinExpirySec: 120, Decision: DecisionApprove},
+			{Name: "too late", MaxExpirySec: 30, Decision: DecisionApprove},
+			{Name: "in window", MinExpirySec: 30, MaxExpirySec: 120, Decision: DecisionReject},
+		},
+	})
+	action := &actionInfo{
+		ID:         "actionid",
+		ExpireTime: time.Now().Add(time.Minute).Unix(),
+	}
+
+	//Act
+	decision, rule, _ := sut.evaluate(action)
+
+	//Assert
+	assert.Equal(t, DecisionReject, decision)
+	assert.Equal(t, "in window", rule)
+}
+
+func TestRulesEngine_evaluate_unknown_decision_is_ignored(t *testing.T) {
+	//Arrange
+	sut := newRulesEngine(&config.AutoApprove{
+		DefaultDecision: "some unknown decision",
+	})
+
+	//Act
+	decision, _, _ := sut.evaluate(&actionInfo{})
+
+	//Assert
+	assert.Equal(t, DecisionIgnore, decision)
+}
@@ -0,0 +1,125 @@
+package autoapprover
+
+import (
+	"sync"
+	"time"
+
+	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/metrics"
+)
+
+// shadowHistorySize is the number of shadow decisions kept, the oldest are dropped first
+const shadowHistorySize = 1000
+
+// shadowMode holds whether the decisions are only recorded, and the latest decisions taken in shadow mode
+type shadowMode struct {
+	lock      sync.RWMutex
+	enabled   bool
+	decisions []api.ShadowDecision
+}
+
+func (s *shadowMode) isEnabled() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.enabled
+}
+
+func (s *shadowMode) setEnabled(enabled bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.enabled = enabled
+}
+
+func (s *shadowMode) add(decision api.ShadowDecision) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.decisions) == shadowHistorySize {
+		s.decisions = s.decisions[1:]
+	}
+	s.decisions = append(s.decisions, decision)
+}
+
+// setOutcome sets the outcome of the latest decision taken for the action, if it's still kept
+func (s *shadowMode) setOutcome(actionID, outcome string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i := len(s.decisions) - 1; i >= 0; i-- {
+		if s.decisions[i].ActionID == actionID {
+			s.decisions[i].Outcome = outcome
+			return
+		}
+	}
+}
+
+// pending returns the IDs of the actions whose outcome isn't known yet, or may still change after a failure
+func (s *shadowMode) pending() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	actionIDs := make([]string, 0)
+	for _, decision := range s.decisions {
+		if len(decision.Outcome) == 0 || decision.Outcome == api.ActionStateFailed {
+			actionIDs = append(actionIDs, decision.ActionID)
+		}
+	}
+	return actionIDs
+}
+
+// list returns the decisions, most recent first
+func (s *shadowMode) list() []api.ShadowDecision {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	decisions := make([]api.ShadowDecision, 0, len(s.decisions))
+	for i := len(s.decisions) - 1; i >= 0; i-- {
+		decisions = append(decisions, s.decisions[i])
+	}
+	return decisions
+}
+
+// SetShadow switches the shadow mode on or off. In shadow mode, the decisions are recorded but the actions are never approved or rejected
+func (a *AutoApprover) SetShadow(shadow bool) {
+	a.shadow.setEnabled(shadow)
+	a.log.Infof("AutoApproval: shadow mode set to [%v]", shadow)
+}
+
+// IsShadow returns whether the auto approver runs in shadow mode
+func (a *AutoApprover) IsShadow() bool {
+	return a.shadow.isEnabled()
+}
+
+// ShadowDecisions returns the latest decisions taken in shadow mode, most recent first, with the outcome of the actions,
+// so that the decisions can be compared to the ones taken by people. The outcomes are kept along with the decisions:
+// they're reported by the feed, or taken from the states of the actions tracked
+func (a *AutoApprover) ShadowDecisions() []api.ShadowDecision {
+	if a.tracker != nil {
+		for actionID, state := range a.tracker.States(a.shadow.pending()) {
+			switch state {
+			case api.ActionStateReceived, api.ActionStateApproving, api.ActionStateRejecting:
+			default:
+				a.shadow.setOutcome(actionID, state)
+			}
+		}
+	}
+
+	return a.shadow.list()
+}
+
+// shadowDecision logs, counts and keeps the decision taken in shadow mode
+func (a *AutoApprover) shadowDecision(action *actionInfo, decision, rule string) {
+	a.log.Infof("AutoApproval: shadow decision [%v] for action [%v] by rule [%v]", decision, action.ID, rule)
+	metrics.ShadowDecisions.WithLabelValues(decision).Inc()
+
+	a.shadow.add(api.ShadowDecision{
+		ActionID:  action.ID,
+		AgentID:   action.AgentID,
+		Type:      action.Type,
+		Decision:  decision,
+		Rule:      rule,
+		Timestamp: time.Now().Unix(),
+	})
+}
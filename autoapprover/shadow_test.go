@@ -0,0 +1,151 @@
+package autoapprover
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/config"
+	"github.com/qredo/signing-agent/journal"
+	"github.com/qredo/signing-agent/lib"
+	"github.com/qredo/signing-agent/util"
+
+	"github.com/test-go/testify/assert"
+)
+
+func newShadowTestMessage(actionID, actionType string) []byte {
+	bytes, _ := json.Marshal(actionInfo{
+		ID:         actionID,
+		Type:       actionType,
+		ExpireTime: time.Now().Add(time.Minute).Unix(),
+	})
+	return bytes
+}
+
+func TestAutoApprover_handleMessage_shadow_records_decision(t *testing.T) {
+	//Arrange
+	coreMock := &lib.MockSigningAgentClient{}
+	journalMock := &journal.MockJournal{}
+	cfg := &config.Config{
+		AutoApprove: config.AutoApprove{
+			Enabled:         true,
+			Shadow:          true,
+			DefaultDecision: DecisionReject,
+			Rules:           []config.AutoApproveRule{{Name: "withdrawals", Types: []string{"ApproveWithdraw"}, Decision: DecisionApprove}},
+		},
+	}
+	sut := NewAutoApprover(coreMock, util.NewTestLogger(), cfg, nil, journalMock, newTestQueue(t, ""))
+
+	//Act
+	first := sut.handleMessage(newShadowTestMessage("first", "ApproveWithdraw"))
+	second := sut.handleMessage(newShadowTestMessage("second", "ApproveTransfer"))
+
+	//Assert
+	assert.Nil(t, first)
+	assert.Nil(t, second)
+	assert.False(t, coreMock.ActionApproveCalled)
+	assert.False(t, coreMock.ActionRejectCalled)
+	assert.Equal(t, []string{journal.EventDecision, journal.EventDecision}, journalMock.Events())
+	assert.Equal(t, journal.SourceShadow, journalMock.Entries[0].Source)
+
+	decisions := sut.ShadowDecisions()
+	assert.Len(t, decisions, 2)
+	assert.Equal(t, "second", decisions[0].ActionID)
+	assert.Equal(t, DecisionReject, decisions[0].Decision)
+	assert.Empty(t, decisions[0].Rule)
+	assert.Equal(t, "first", decisions[1].ActionID)
+	assert.Equal(t, DecisionApprove, decisions[1].Decision)
+	assert.Equal(t, "withdrawals", decisions[1].Rule)
+}
+
+func TestAutoApprover_handleMessage_shadow_switched_off(t *testing.T) {
+	var testCases = []struct {
+		name    string
+		enabled bool
+		queued  bool
+	}{
+		{"auto-approval enabled", true, true},
+		{"started in shadow mode only", false, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			cfg := &config.Config{
+				AutoApprove: config.AutoApprove{
+					Enabled:         tc.enabled,
+					Shadow:          true,
+					DefaultDecision: DecisionApprove,
+				},
+			}
+			sut := NewAutoApprover(&lib.MockSigningAgentClient{}, util.NewTestLogger(), cfg, nil, &journal.MockJournal{}, newTestQueue(t, ""))
+			sut.SetShadow(false)
+
+			//Act
+			job := sut.handleMessage(newShadowTestMessage("some action id", "ApproveWithdraw"))
+
+			//Assert
+			assert.False(t, sut.IsShadow())
+			assert.Equal(t, tc.queued, job != nil)
+			assert.Empty(t, sut.ShadowDecisions())
+		})
+	}
+}
+
+func TestAutoApprover_ShadowDecisions_adds_outcomes(t *testing.T) {
+	//Arrange
+	sut := NewAutoApprover(&lib.MockSigningAgentClient{}, util.NewTestLogger(), &config.Config{}, nil, &journal.MockJournal{}, newTestQueue(t, ""))
+	sut.SetTracker(&mockActionTracker{NextStates: map[string]string{
+		"first":  api.ActionStateApproved,
+		"second": api.ActionStateApproving,
+	}})
+	sut.shadow.add(api.ShadowDecision{ActionID: "first", Decision: DecisionReject})
+	sut.shadow.add(api.ShadowDecision{ActionID: "second", Decision: DecisionApprove})
+	sut.shadow.add(api.ShadowDecision{ActionID: "third", Decision: DecisionApprove})
+
+	//Act
+	sut.handleMessage([]byte(`{"id":"third","status":"rejected"}`))
+	decisions := sut.ShadowDecisions()
+
+	//Assert
+	assert.Equal(t, []api.ShadowDecision{
+		{ActionID: "third", Decision: DecisionApprove, Outcome: api.ActionStateRejected},
+		{ActionID: "second", Decision: DecisionApprove},
+		{ActionID: "first", Decision: DecisionReject, Outcome: api.ActionStateApproved},
+	}, decisions)
+}
+
+func TestAutoApprover_ShadowDecisions_keeps_outcomes(t *testing.T) {
+	//Arrange
+	tracker := &mockActionTracker{NextStates: map[string]string{"first": api.ActionStateApproved}}
+	sut := NewAutoApprover(&lib.MockSigningAgentClient{}, util.NewTestLogger(), &config.Config{}, nil, &journal.MockJournal{}, newTestQueue(t, ""))
+	sut.SetTracker(tracker)
+	sut.shadow.add(api.ShadowDecision{ActionID: "first", Decision: DecisionReject})
+	_ = sut.ShadowDecisions()
+
+	//Act
+	tracker.NextStates = nil // the tracker forgot the action
+	decisions := sut.ShadowDecisions()
+
+	//Assert
+	assert.Equal(t, []api.ShadowDecision{
+		{ActionID: "first", Decision: DecisionReject, Outcome: api.ActionStateApproved},
+	}, decisions)
+}
+
+func TestShadowMode_add_drops_oldest(t *testing.T) {
+	//Arrange
+	sut := &shadowMode{}
+
+	//Act
+	for i := 0; i <= shadowHistorySize; i++ {
+		sut.add(api.ShadowDecision{Timestamp: int64(i)})
+	}
+
+	//Assert
+	decisions := sut.list()
+	assert.Len(t, decisions, shadowHistorySize)
+	assert.Equal(t, int64(shadowHistorySize), decisions[0].Timestamp)
+	assert.Equal(t, int64(1), decisions[shadowHistorySize-1].Timestamp)
+}
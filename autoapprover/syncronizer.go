@@ -2,6 +2,8 @@ package autoapprover
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/qredo/signing-agent/config"
@@ -12,51 +14,65 @@ var rCtx = context.Background()
 // ActionSyncronizer provides functionality to manage the approval of a action when load balancing is enabled
 type ActionSyncronizer interface {
 	ShouldHandleAction(actionID string) bool
-	AcquireLock() error
+	AcquireLock(actionID string) error
 	Release(actionID string) error
 }
 
 type syncronize struct {
 	cache            cache
-	mutex            mutex
 	sync             syncI
 	cfgLoadBalancing *config.LoadBalancing
+	lock             sync.Mutex
+	mutexes          map[string]mutex // the mutexes held, by action, so that several actions can be handled at the same time
+	newMutex         func(name string) mutex
 }
 
 // NewSyncronizer returns a new ActionSyncronizer that's an instance of syncronize
 func NewSyncronizer(conf *config.LoadBalancing, cache cache, sync syncI) ActionSyncronizer {
-	return &syncronize{
+	s := &syncronize{
 		cfgLoadBalancing: conf,
 		cache:            cache,
 		sync:             sync,
+		mutexes:          make(map[string]mutex),
 	}
+	s.newMutex = func(name string) mutex {
+		return s.sync.NewMutex(name)
+	}
+	return s
 }
 
 // ShouldHandleAction returns true if the action wasn't already picked up by another agent
 func (a *syncronize) ShouldHandleAction(actionID string) bool {
-	if err := a.cache.Get(rCtx, actionID).Err(); err == nil {
-		return false
-	}
-
-	// set the mutex to lock the action
-	a.mutex = a.sync.NewMutex(actionID)
-	return true
+	return a.cache.Get(rCtx, actionID).Err() != nil
 }
 
-// AcquireLock locks the mutex set for the action to be approved
-func (a *syncronize) AcquireLock() error {
-	if err := a.mutex.Lock(); err != nil {
+// AcquireLock locks the mutex of the action to be approved. The mutex is kept until the action is released
+func (a *syncronize) AcquireLock(actionID string) error {
+	m := a.newMutex(actionID)
+	if err := m.Lock(); err != nil {
 		time.Sleep(time.Duration(a.cfgLoadBalancing.OnLockErrorTimeOutMs) * time.Millisecond)
 		return err
 	}
 
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.mutexes[actionID] = m
 	return nil
 }
 
-// Release unlocks the mutex and sets the action id in the cache to signal it was already approved
+// Release unlocks the mutex of the action and sets the action id in the cache to signal it was already approved
 func (a *syncronize) Release(actionID string) error {
-	_, err := a.mutex.Unlock()
+	a.lock.Lock()
+	m, ok := a.mutexes[actionID]
+	delete(a.mutexes, actionID)
+	a.lock.Unlock()
+
 	a.cache.Set(rCtx, actionID, 1, time.Duration(a.cfgLoadBalancing.ActionIDExpirationSec)*time.Second)
+	if !ok {
+		return fmt.Errorf("no lock held for action [%s]", actionID)
+	}
 
+	_, err := m.Unlock()
 	return err
 }
@@ -9,7 +9,6 @@ import (
 	"github.com/qredo/signing-agent/config"
 
 	"github.com/go-redis/redis/v8"
-	"github.com/go-redsync/redsync/v4"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -45,20 +44,15 @@ func TestSyncronize_ShouldHandleAction_already_handled(t *testing.T) {
 	assert.Equal(t, "test action Id", cacheMock.LastKey)
 }
 
-func TestSyncronize_ShouldHandleAction_sets_mutex(t *testing.T) {
+func TestSyncronize_ShouldHandleAction_not_handled(t *testing.T) {
 	//Arrange
 	stringCmd := redis.NewStringCmd(context.Background())
 	stringCmd.SetErr(errors.New("some error"))
 	cacheMock := &mockCache{
 		NextStringCmd: stringCmd,
 	}
-	syncMock := &mockSync{
-		NextMutex: &redsync.Mutex{},
-	}
-	sut := &syncronize{
-		cfgLoadBalancing: &config.LoadBalancing{Enable: true},
-		cache:            cacheMock,
-		sync:             syncMock}
+	syncMock := &mockSync{}
+	sut := NewSyncronizer(&config.LoadBalancing{Enable: true}, cacheMock, syncMock)
 
 	//Act
 	res := sut.ShouldHandleAction("test action Id")
@@ -67,58 +61,93 @@ func TestSyncronize_ShouldHandleAction_sets_mutex(t *testing.T) {
 	assert.True(t, res)
 	assert.True(t, cacheMock.GetCalled)
 	assert.Equal(t, "test action Id", cacheMock.LastKey)
-	assert.True(t, syncMock.NewMutexCalled)
-	assert.Equal(t, "test action Id", syncMock.LastName)
-	assert.NotNil(t, sut.mutex)
+	assert.False(t, syncMock.NewMutexCalled)
+}
+
+func newTestSyncronizer(cfg *config.LoadBalancing, cache cache, mutexes map[string]*mutexMock) *syncronize {
+	return &syncronize{
+		cfgLoadBalancing: cfg,
+		cache:            cache,
+		mutexes:          make(map[string]mutex),
+		newMutex: func(name string) mutex {
+			return mutexes[name]
+		},
+	}
 }
 
 func TestSyncronize_AcquireLock_fails_to_lock_returns_error(t *testing.T) {
 	//Arrange
-	mutexMock := &mutexMock{
+	lockMock := &mutexMock{
 		NextError: errors.New("some lock error"),
 	}
-	sut := &syncronize{
-		cfgLoadBalancing: &config.LoadBalancing{OnLockErrorTimeOutMs: 2},
-		mutex:            mutexMock}
+	sut := newTestSyncronizer(&config.LoadBalancing{OnLockErrorTimeOutMs: 2}, nil, map[string]*mutexMock{"test action id": lockMock})
 
 	//Act
-	res := sut.AcquireLock()
+	res := sut.AcquireLock("test action id")
 
 	//Assert
 	assert.NotNil(t, res)
 	assert.Equal(t, "some lock error", res.Error())
-	assert.True(t, mutexMock.LockCalled)
+	assert.True(t, lockMock.LockCalled)
+	assert.Empty(t, sut.mutexes)
 }
 
 func TestSyncronize_AcquireLock_locks(t *testing.T) {
 	//Arrange
-	mutexMock := &mutexMock{}
-	sut := &syncronize{
-		cfgLoadBalancing: &config.LoadBalancing{OnLockErrorTimeOutMs: 2},
-		mutex:            mutexMock}
+	lockMock := &mutexMock{}
+	sut := newTestSyncronizer(&config.LoadBalancing{OnLockErrorTimeOutMs: 2}, nil, map[string]*mutexMock{"test action id": lockMock})
+
+	//Act
+	res := sut.AcquireLock("test action id")
+
+	//Assert
+	assert.Nil(t, res)
+	assert.True(t, lockMock.LockCalled)
+	assert.Equal(t, lockMock, sut.mutexes["test action id"])
+}
+
+func TestSyncronize_Release_unlocks_the_mutex_of_the_action(t *testing.T) {
+	//Arrange
+	first, second := &mutexMock{}, &mutexMock{}
+	sut := newTestSyncronizer(&config.LoadBalancing{ActionIDExpirationSec: 2}, &mockCache{}, map[string]*mutexMock{"first": first, "second": second})
+	_ = sut.AcquireLock("first")
+	_ = sut.AcquireLock("second")
 
 	//Act
-	res := sut.AcquireLock()
+	res := sut.Release("first")
 
 	//Assert
 	assert.Nil(t, res)
-	assert.True(t, mutexMock.LockCalled)
+	assert.True(t, first.UnlockCalled)
+	assert.False(t, second.UnlockCalled)
+	assert.Equal(t, map[string]mutex{"second": second}, sut.mutexes)
+}
+
+func TestSyncronize_Release_without_lock_returns_error(t *testing.T) {
+	//Arrange
+	mockCache := &mockCache{}
+	sut := newTestSyncronizer(&config.LoadBalancing{ActionIDExpirationSec: 2}, mockCache, nil)
+
+	//Act
+	res := sut.Release("test action id")
+
+	//Assert
+	assert.NotNil(t, res)
+	assert.Equal(t, "no lock held for action [test action id]", res.Error())
+	assert.True(t, mockCache.SetCalled)
 }
 
 func TestSyncronize_Release_returns_error(t *testing.T) {
 	//Arrange
-	mutexMock := &mutexMock{
+	lockMock := &mutexMock{
 		NextUnlock: false,
-		NextError:  errors.New("some unlock error"),
 	}
 	mockCache := &mockCache{
 		NextStringCmd: redis.NewStringCmd(context.Background()),
 	}
-	sut := &syncronize{
-		cfgLoadBalancing: &config.LoadBalancing{ActionIDExpirationSec: 2},
-		mutex:            mutexMock,
-		cache:            mockCache,
-	}
+	sut := newTestSyncronizer(&config.LoadBalancing{ActionIDExpirationSec: 2}, mockCache, map[string]*mutexMock{"test action id": lockMock})
+	_ = sut.AcquireLock("test action id")
+	lockMock.NextError = errors.New("some unlock error")
 
 	//Act
 	res := sut.Release("test action id")
@@ -126,7 +155,7 @@ func TestSyncronize_Release_returns_error(t *testing.T) {
 	//Assert
 	assert.NotNil(t, res)
 	assert.Equal(t, "some unlock error", res.Error())
-	assert.True(t, mutexMock.UnlockCalled)
+	assert.True(t, lockMock.UnlockCalled)
 	assert.True(t, mockCache.SetCalled)
 	assert.Equal(t, "test action id", mockCache.LastKey)
 	assert.Equal(t, 1, mockCache.LastValue)
@@ -0,0 +1,60 @@
+package autoapprover
+
+import (
+	"sync"
+
+	"github.com/qredo/signing-agent/queue"
+)
+
+// workList hands the queued jobs to the workers, in order. Pushing never blocks, so that
+// the AutoApprover keeps reading its feed while all the workers are busy
+type workList struct {
+	lock   sync.Mutex
+	cond   *sync.Cond
+	jobs   []*queue.Job
+	closed bool
+}
+
+func newWorkList() *workList {
+	w := &workList{}
+	w.cond = sync.NewCond(&w.lock)
+	return w
+}
+
+func (w *workList) push(job *queue.Job) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return
+	}
+
+	w.jobs = append(w.jobs, job)
+	w.cond.Signal()
+}
+
+// pop waits for the next job. It returns false once the list is closed, the jobs left are then resumed on the next start
+func (w *workList) pop() (*queue.Job, bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for len(w.jobs) == 0 && !w.closed {
+		w.cond.Wait()
+	}
+
+	if w.closed {
+		return nil, false
+	}
+
+	job := w.jobs[0]
+	w.jobs = w.jobs[1:]
+	return job, true
+}
+
+func (w *workList) close() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.closed = true
+	w.cond.Broadcast()
+}
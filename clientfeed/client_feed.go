@@ -1,5 +1,6 @@
 // Package clientfeed provides functionality to register to a feed hub to receive bytes data as TextMessage
 // The received data is being then written also as a TextMessage to an open websocket connection.
+// The client can send JSON-RPC style commands on the same connection to approve or reject actions.
 
 package clientfeed
 
@@ -22,6 +23,7 @@ type UnregisterFunc func(client *hub.FeedClient)
 type ClientFeed interface {
 	Start(wg *sync.WaitGroup)
 	Listen()
+	ReadCommands()
 	GetFeedClient() *hub.FeedClient
 }
 
@@ -35,11 +37,13 @@ type clientFeedImpl struct {
 	pingPeriod time.Duration
 	readyState string
 	unregister UnregisterFunc
+	commands   ActionCommander
+	writeLock  sync.Mutex
 }
 
 // NewClientFeed returns a new ClientFeed which is an instance of ClientFeedImpl initialized with the provided parameters
 // ClientFeed has an external FeedClient which means it can unregister itself from the feed hub to stop receiving data
-func NewClientFeed(conn hub.WebsocketConnection, log *zap.SugaredLogger, unregister UnregisterFunc, config *config.WebSocketConfig) ClientFeed {
+func NewClientFeed(conn hub.WebsocketConnection, log *zap.SugaredLogger, unregister UnregisterFunc, commands ActionCommander, config *config.WebSocketConfig) ClientFeed {
 	return &clientFeedImpl{
 		FeedClient: hub.NewFeedClient(false),
 		conn:       conn,
@@ -50,6 +54,7 @@ func NewClientFeed(conn hub.WebsocketConnection, log *zap.SugaredLogger, unregis
 		pingPeriod: time.Duration(config.PingPeriod) * time.Second,
 		readyState: defs.ConnectionState.Open,
 		unregister: unregister,
+		commands:   commands,
 	}
 }
 
@@ -108,13 +113,21 @@ func (c *clientFeedImpl) Listen() {
 			return
 		} else {
 			c.log.Infof("ClientFeed: writing message to websocket conn: %v", string(message))
-			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			if err := c.write(websocket.TextMessage, message); err != nil {
 				c.log.Errorf("ClientFeed: error while writing data to websocket conn:%v", err)
 			}
 		}
 	}
 }
 
+// write makes sure the feed messages and the command responses are not written at the same time
+func (c *clientFeedImpl) write(messageType int, data []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	return c.conn.WriteMessage(messageType, data)
+}
+
 func (c *clientFeedImpl) setHandlers() {
 	c.conn.SetPongHandler(func(message string) error {
 		if err := c.conn.SetReadDeadline(time.Now().Add(c.pongWait)); err != nil {
@@ -0,0 +1,129 @@
+package clientfeed
+
+import (
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/qredo/signing-agent/api"
+)
+
+// The JSON-RPC error codes of the command responses
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeActionFailed   = -32000
+)
+
+const jsonRPCVersion = "2.0"
+
+// ActionCommander carries out the commands sent by the client on the feed, and tells the current state of the actions
+type ActionCommander interface {
+	Approve(actionID string) error
+	Reject(actionID string, reason api.RejectReason) error
+	State(actionID string) (string, bool)
+}
+
+// ReadCommands reads the commands sent by the client until the connection is closed.
+// Every command is carried out in order and its response is written back on the connection
+func (c *clientFeedImpl) ReadCommands() {
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			c.log.Debugf("ClientFeed: stopped reading commands, err: %v", err)
+			//the connection is broken, stop receiving messages
+			c.unregister(&c.FeedClient)
+			return
+		}
+
+		response, err := json.Marshal(c.handleCommand(message))
+		if err != nil {
+			c.log.Errorf("ClientFeed: error while encoding the command response, err: %v", err)
+			continue
+		}
+
+		if err = c.write(websocket.TextMessage, response); err != nil {
+			c.log.Errorf("ClientFeed: error while writing the command response to websocket conn: %v", err)
+		}
+	}
+}
+
+func (c *clientFeedImpl) handleCommand(message []byte) *api.FeedCommandResponse {
+	command := api.FeedCommand{}
+	if err := json.Unmarshal(message, &command); err != nil {
+		c.log.Debugf("ClientFeed: invalid command [%v], err: %v", string(message), err)
+		return newCommandError(nil, CodeParseError, "invalid JSON")
+	}
+
+	if len(command.Method) == 0 {
+		return newCommandError(command.ID, CodeInvalidRequest, "missing method")
+	}
+
+	if len(command.Params.ActionID) == 0 {
+		return newCommandError(command.ID, CodeInvalidParams, "missing actionID")
+	}
+
+	if c.commands == nil {
+		return newCommandError(command.ID, CodeMethodNotFound, "commands not supported on this feed")
+	}
+
+	var (
+		err   error
+		state string
+	)
+	switch command.Method {
+	case api.FeedCommandApprove:
+		err = c.commands.Approve(command.Params.ActionID)
+		state = api.ActionStateApproved
+	case api.FeedCommandReject:
+		reason := api.RejectReason{Code: api.RejectCodeManual}
+		if command.Params.Reason != nil {
+			reason = *command.Params.Reason
+			if len(reason.Code) == 0 {
+				reason.Code = api.RejectCodeManual
+			}
+		}
+		err = c.commands.Reject(command.Params.ActionID, reason)
+		state = api.ActionStateRejected
+	default:
+		return newCommandError(command.ID, CodeMethodNotFound, "unknown method "+command.Method)
+	}
+
+	if err != nil {
+		c.log.Errorf("ClientFeed: %v command failed for action [%v], err: %v", command.Method, command.Params.ActionID, err)
+		return newCommandError(command.ID, CodeActionFailed, err.Error())
+	}
+
+	if current, ok := c.commands.State(command.Params.ActionID); ok {
+		state = current
+	}
+	result := api.NewActionResponse(command.Params.ActionID, state)
+
+	c.log.Infof("ClientFeed: action [%v] %v", command.Params.ActionID, result.Status)
+	return &api.FeedCommandResponse{
+		JSONRPC: jsonRPCVersion,
+		ID:      commandID(command.ID),
+		Result:  &result,
+	}
+}
+
+func newCommandError(id json.RawMessage, code int, message string) *api.FeedCommandResponse {
+	return &api.FeedCommandResponse{
+		JSONRPC: jsonRPCVersion,
+		ID:      commandID(id),
+		Error: &api.FeedCommandError{
+			Code:    code,
+			Message: message,
+		},
+	}
+}
+
+// commandID returns the ID of the command, or null when the command has none
+func commandID(id json.RawMessage) json.RawMessage {
+	if len(id) == 0 {
+		return json.RawMessage("null")
+	}
+	return id
+}
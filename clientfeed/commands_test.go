@@ -0,0 +1,174 @@
+package clientfeed
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+
+	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/config"
+	"github.com/qredo/signing-agent/hub"
+	"github.com/qredo/signing-agent/util"
+)
+
+type mockActionCommander struct {
+	Approved  []string
+	Rejected  []string
+	Reasons   []api.RejectReason
+	NextError error
+	States    map[string]string
+}
+
+func (m *mockActionCommander) Approve(actionID string) error {
+	m.Approved = append(m.Approved, actionID)
+	return m.NextError
+}
+
+func (m *mockActionCommander) Reject(actionID string, reason api.RejectReason) error {
+	m.Rejected = append(m.Rejected, actionID)
+	m.Reasons = append(m.Reasons, reason)
+	return m.NextError
+}
+
+func (m *mockActionCommander) State(actionID string) (string, bool) {
+	state, ok := m.States[actionID]
+	return state, ok
+}
+
+// scriptedConnection returns the messages in order on read, then an EOF error, and keeps the written messages
+type scriptedConnection struct {
+	hub.MockWebsocketConnection
+	lock     sync.Mutex
+	messages []string
+	written  []string
+}
+
+func (c *scriptedConnection) ReadMessage() (int, []byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(c.messages) == 0 {
+		return 0, nil, io.EOF
+	}
+
+	message := c.messages[0]
+	c.messages = c.messages[1:]
+	return 1, []byte(message), nil
+}
+
+func (c *scriptedConnection) WriteMessage(messageType int, data []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.written = append(c.written, string(data))
+	return nil
+}
+
+func TestClientFeedImpl_ReadCommands_answers_commands_and_unregisters(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	conn := &scriptedConnection{
+		messages: []string{
+			`{"jsonrpc":"2.0","id":1,"method":"approve","params":{"actionID":"some action id"}}`,
+			`{"id":"second","method":"reject","params":{"actionID":"other action id"}}`,
+			`{"id":"third","method":"reject","params":{"actionID":"third action id","reason":{"message":"over the limit"}}}`,
+		},
+	}
+	commands := &mockActionCommander{}
+	var lastUnregisteredClient *hub.FeedClient
+	unregister := func(client *hub.FeedClient) {
+		lastUnregisteredClient = client
+	}
+	sut := NewClientFeed(conn, util.NewTestLogger(), unregister, commands, &config.WebSocketConfig{})
+
+	//Act
+	sut.ReadCommands()
+
+	//Assert
+	assert.Equal(t, []string{"some action id"}, commands.Approved)
+	assert.Equal(t, []string{"other action id", "third action id"}, commands.Rejected)
+	assert.Equal(t, []api.RejectReason{{Code: api.RejectCodeManual}, {Code: api.RejectCodeManual, Message: "over the limit"}}, commands.Reasons)
+	require.Len(t, conn.written, 3)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":{"actionID":"some action id","status":"approved"}}`, conn.written[0])
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":"second","result":{"actionID":"other action id","status":"rejected"}}`, conn.written[1])
+	assert.Equal(t, sut.GetFeedClient(), lastUnregisteredClient)
+}
+
+func TestClientFeedImpl_handleCommand_tells_tracked_state(t *testing.T) {
+	//Arrange
+	sut := &clientFeedImpl{
+		log: util.NewTestLogger(),
+		commands: &mockActionCommander{States: map[string]string{
+			"approving action id": api.ActionStateApproving,
+		}},
+	}
+
+	//Act
+	approving := sut.handleCommand([]byte(`{"id":1,"method":"approve","params":{"actionID":"approving action id"}}`))
+	untracked := sut.handleCommand([]byte(`{"id":2,"method":"reject","params":{"actionID":"untracked action id"}}`))
+
+	//Assert
+	assert.Equal(t, &api.ActionResponse{ActionID: "approving action id", Status: api.ActionStateApproving}, approving.Result)
+	assert.Equal(t, &api.ActionResponse{ActionID: "untracked action id", Status: api.ActionStateRejected}, untracked.Result)
+}
+
+func TestClientFeedImpl_handleCommand_errors(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		commands ActionCommander
+		message  string
+		code     int
+		errMsg   string
+	}{
+		{"invalid json", &mockActionCommander{}, `not json`, CodeParseError, "invalid JSON"},
+		{"missing method", &mockActionCommander{}, `{"id":1,"params":{"actionID":"some action id"}}`, CodeInvalidRequest, "missing method"},
+		{"missing action id", &mockActionCommander{}, `{"id":1,"method":"approve"}`, CodeInvalidParams, "missing actionID"},
+		{"unknown method", &mockActionCommander{}, `{"id":1,"method":"sign","params":{"actionID":"some action id"}}`, CodeMethodNotFound, "unknown method sign"},
+		{"no commander", nil, `{"id":1,"method":"approve","params":{"actionID":"some action id"}}`, CodeMethodNotFound, "commands not supported on this feed"},
+		{"action failed", &mockActionCommander{NextError: errors.New("some approve error")}, `{"id":1,"method":"approve","params":{"actionID":"some action id"}}`, CodeActionFailed, "some approve error"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			sut := &clientFeedImpl{
+				log:      util.NewTestLogger(),
+				commands: tc.commands,
+			}
+
+			//Act
+			res := sut.handleCommand([]byte(tc.message))
+
+			//Assert
+			assert.Nil(t, res.Result)
+			require.NotNil(t, res.Error)
+			assert.Equal(t, tc.code, res.Error.Code)
+			assert.Equal(t, tc.errMsg, res.Error.Message)
+			data, _ := json.Marshal(res)
+			assert.Contains(t, string(data), `"jsonrpc":"2.0"`)
+		})
+	}
+}
+
+func TestClientFeedImpl_handleCommand_returns_null_id(t *testing.T) {
+	//Arrange
+	sut := &clientFeedImpl{
+		log:      util.NewTestLogger(),
+		commands: &mockActionCommander{},
+	}
+
+	//Act
+	res := sut.handleCommand([]byte(`{"method":"approve","params":{"actionID":"some action id"}}`))
+
+	//Assert
+	data, err := json.Marshal(res)
+	require.Nil(t, err)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":null,"result":{"actionID":"some action id","status":"approved"}}`, string(data))
+	assert.Equal(t, &api.ActionResponse{ActionID: "some action id", Status: "approved"}, res.Result)
+}
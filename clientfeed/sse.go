@@ -0,0 +1,127 @@
+package clientfeed
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/qredo/signing-agent/hub"
+)
+
+// SSEContentType is the content type of the Server-Sent Events stream
+const SSEContentType = "text/event-stream"
+
+// sseEventAction is the type of the events carrying an action
+const sseEventAction = "action"
+
+var sseHeartbeat = []byte(": heartbeat\n\n")
+
+// EventStream is a client receiving messages from a feed hub it's registered to and writing them as Server-Sent Events
+// to a HTTP response. A comment is written as heartbeat when no event was sent for a ping period, so that idle connections stay open
+type EventStream struct {
+	hub.FeedClient
+	w          http.ResponseWriter
+	flusher    http.Flusher
+	log        *zap.SugaredLogger
+	pingPeriod time.Duration
+}
+
+// NewEventStream returns a new EventStream writing to the response, or an error if the response can't be flushed.
+// The EventStream has an external FeedClient which formats every message as an event whose ID can be used to resume the stream
+func NewEventStream(w http.ResponseWriter, log *zap.SugaredLogger, pingPeriod time.Duration) (*EventStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("streaming not supported by the response writer")
+	}
+
+	stream := &EventStream{
+		FeedClient: hub.NewFeedClient(false),
+		w:          w,
+		flusher:    flusher,
+		log:        log,
+		pingPeriod: pingPeriod,
+	}
+	stream.Format = FormatEvent
+	return stream, nil
+}
+
+// GetFeedClient returns the internal FeedClient structure used to register itself to the feed hub in order to start receiving data on the Feed channel
+func (s *EventStream) GetFeedClient() *hub.FeedClient {
+	return &s.FeedClient
+}
+
+// Serve writes the response headers, then the events received on the Feed channel until it's closed.
+// When the request is done or a write fails, the stream unregisters itself and waits for the Feed channel to be closed
+func (s *EventStream) Serve(ctx context.Context, unregister UnregisterFunc) {
+	header := s.w.Header()
+	header.Set("Content-Type", SSEContentType)
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	s.w.WriteHeader(http.StatusOK)
+	s.flusher.Flush()
+
+	ticker := time.NewTicker(s.pingPeriod)
+	defer ticker.Stop()
+
+	// once stopped, the events left are dropped until the hub closes the Feed channel
+	stopped := false
+	done := ctx.Done()
+	stop := func() {
+		if !stopped {
+			stopped = true
+			done = nil
+			unregister(&s.FeedClient)
+		}
+	}
+
+	for {
+		select {
+		case event, ok := <-s.Feed:
+			if !ok {
+				s.log.Debug("EventStream: client feed channel was closed")
+				return
+			}
+
+			if !stopped && !s.write(event) {
+				stop()
+			}
+		case <-ticker.C:
+			if !stopped && !s.write(sseHeartbeat) {
+				stop()
+			}
+		case <-done:
+			s.log.Debug("EventStream: request done, unregistering the client")
+			stop()
+		}
+	}
+}
+
+func (s *EventStream) write(data []byte) bool {
+	if _, err := s.w.Write(data); err != nil {
+		s.log.Errorf("EventStream: error while writing the event, err: %v", err)
+		return false
+	}
+
+	s.flusher.Flush()
+	return true
+}
+
+// FormatEvent returns the message as an action event with the given ID.
+// Every line of the message is written as a data field, so that multi-line messages are kept whole
+func FormatEvent(eventID uint64, message []byte) []byte {
+	var event bytes.Buffer
+	fmt.Fprintf(&event, "id: %d\nevent: %s\n", eventID, sseEventAction)
+	for _, line := range bytes.Split(bytes.TrimRight(message, "\n"), []byte("\n")) {
+		event.WriteString("data: ")
+		event.Write(line)
+		event.WriteByte('\n')
+	}
+	event.WriteByte('\n')
+
+	return event.Bytes()
+}
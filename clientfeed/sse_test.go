@@ -0,0 +1,109 @@
+package clientfeed
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+
+	"github.com/qredo/signing-agent/hub"
+	"github.com/qredo/signing-agent/util"
+)
+
+// failingResponseWriter fails every write of the body
+type failingResponseWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w *failingResponseWriter) Write([]byte) (int, error) {
+	return 0, http.ErrHandlerTimeout
+}
+
+func TestFormatEvent(t *testing.T) {
+	//Act
+	event := FormatEvent(7, []byte("{\n\"id\":\"some action id\"\n}\n"))
+
+	//Assert
+	assert.Equal(t, "id: 7\nevent: action\ndata: {\ndata: \"id\":\"some action id\"\ndata: }\n\n", string(event))
+}
+
+func TestNewEventStream(t *testing.T) {
+	//Act
+	sut, err := NewEventStream(httptest.NewRecorder(), util.NewTestLogger(), time.Second)
+
+	//Assert
+	require.Nil(t, err)
+	assert.False(t, sut.GetFeedClient().IsInternal)
+	assert.Equal(t, "id: 1\nevent: action\ndata: message\n\n", string(sut.GetFeedClient().Format(1, []byte("message"))))
+}
+
+func TestEventStream_Serve_writes_events_and_heartbeats(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	recorder := httptest.NewRecorder()
+	sut, _ := NewEventStream(recorder, util.NewTestLogger(), 20*time.Millisecond)
+	go func() {
+		sut.Feed <- sut.Format(1, []byte(`{"id":"some action id"}`))
+		<-time.After(100 * time.Millisecond)
+		close(sut.Feed)
+	}()
+
+	//Act
+	sut.Serve(context.Background(), func(client *hub.FeedClient) {
+		t.Error("unexpected unregister")
+	})
+
+	//Assert
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, SSEContentType, recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "no-cache", recorder.Header().Get("Cache-Control"))
+	assert.True(t, recorder.Flushed)
+	body := recorder.Body.String()
+	assert.Contains(t, body, "id: 1\nevent: action\ndata: {\"id\":\"some action id\"}\n\n")
+	assert.Contains(t, body, ": heartbeat\n\n")
+}
+
+func TestEventStream_Serve_unregisters_when_request_done(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	sut, _ := NewEventStream(httptest.NewRecorder(), util.NewTestLogger(), time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var lastUnregisteredClient *hub.FeedClient
+
+	//Act
+	sut.Serve(ctx, func(client *hub.FeedClient) {
+		lastUnregisteredClient = client
+		close(client.Feed)
+	})
+
+	//Assert
+	assert.Equal(t, sut.GetFeedClient(), lastUnregisteredClient)
+}
+
+func TestEventStream_Serve_unregisters_when_write_fails(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	sut, _ := NewEventStream(&failingResponseWriter{httptest.NewRecorder()}, util.NewTestLogger(), time.Second)
+	unregisterCount := 0
+
+	//Act
+	go func() {
+		sut.Feed <- []byte("first event")
+		// the events sent after the failure are dropped until the feed is closed
+		sut.Feed <- []byte("second event")
+		close(sut.Feed)
+	}()
+	sut.Serve(context.Background(), func(client *hub.FeedClient) {
+		assert.Equal(t, sut.GetFeedClient(), client)
+		unregisterCount++
+	})
+
+	//Assert
+	assert.Equal(t, 1, unregisterCount)
+}
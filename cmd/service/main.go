@@ -9,6 +9,7 @@ import (
 	"github.com/jessevdk/go-flags"
 
 	"github.com/qredo/signing-agent/config"
+	"github.com/qredo/signing-agent/lib"
 	"github.com/qredo/signing-agent/rest"
 	"github.com/qredo/signing-agent/rest/version"
 	"github.com/qredo/signing-agent/util"
@@ -46,6 +47,7 @@ func (c *startCmd) Execute([]string) error {
 
 	log := util.NewLogger(&cfg.Logging)
 	log.Info("Loaded config file from " + c.ConfigFile)
+	log.Debugf("Config: %v", cfg)
 
 	ver := version.DefaultVersion()
 	if len(buildType) > 0 {
@@ -93,6 +95,132 @@ func (c *initCmd) Execute([]string) error {
 	return nil
 }
 
+type encryptStoreCmd struct {
+	ConfigFile string `short:"c" long:"config" description:"path to configuration file" default:"cc.yaml"`
+	Input      string `short:"i" long:"input" description:"path to the plaintext store file, defaults to the store file in the config"`
+}
+
+func (c *encryptStoreCmd) Execute([]string) error {
+	var cfg config.Config
+	cfg.Default()
+
+	if err := cfg.Load(c.ConfigFile); err != nil {
+		return err
+	}
+
+	input := c.Input
+	if len(input) == 0 {
+		input = cfg.Store.FileConfig
+	}
+
+	if err := util.MigrateFileStore(input, cfg.Store.EncryptedConfig); err != nil {
+		return err
+	}
+
+	fmt.Printf("written encrypted store %s\n", cfg.Store.EncryptedConfig.File)
+	fmt.Printf("set the store type to `encrypted` in %s and securely delete %s\n\n", c.ConfigFile, input)
+	return nil
+}
+
+// backupPassphraseEnv is the environment variable holding the passphrase of the backup bundle
+const backupPassphraseEnv = "SIGNING_AGENT_BACKUP_PASSPHRASE"
+
+type backupCmd struct {
+	ConfigFile     string `short:"c" long:"config" description:"path to configuration file" default:"cc.yaml"`
+	Output         string `short:"o" long:"output" description:"path to the backup file to write" required:"true"`
+	PassphraseFile string `short:"p" long:"passphrase-file" description:"path to a file holding the backup passphrase, used when SIGNING_AGENT_BACKUP_PASSPHRASE is not set"`
+}
+
+func (c *backupCmd) Execute([]string) error {
+	if _, err := os.Stat(c.Output); err == nil {
+		return fmt.Errorf("backup file %s already exists", c.Output)
+	}
+
+	store, err := openStorage(c.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	backup, err := store.Export()
+	if err != nil {
+		return err
+	}
+
+	passphrase, err := util.ReadNewPassphraseFrom(backupPassphraseEnv, c.PassphraseFile, "Backup passphrase: ")
+	if err != nil {
+		return err
+	}
+
+	bundle, err := lib.WriteBackup(backup, passphrase)
+	if err != nil {
+		return err
+	}
+
+	if err = os.WriteFile(c.Output, bundle, 0600); err != nil {
+		return err
+	}
+
+	fmt.Printf("written backup of %d agent(s) to %s\n\n", len(backup.Agents), c.Output)
+	return nil
+}
+
+type restoreCmd struct {
+	ConfigFile     string `short:"c" long:"config" description:"path to configuration file" default:"cc.yaml"`
+	Input          string `short:"i" long:"input" description:"path to the backup file to restore" required:"true"`
+	PassphraseFile string `short:"p" long:"passphrase-file" description:"path to a file holding the backup passphrase, used when SIGNING_AGENT_BACKUP_PASSPHRASE is not set"`
+	Force          bool   `short:"f" long:"force" description:"restore even if the store already has a registered agent"`
+}
+
+func (c *restoreCmd) Execute([]string) error {
+	bundle, err := os.ReadFile(c.Input)
+	if err != nil {
+		return err
+	}
+
+	passphrase, err := util.ReadPassphraseFrom(backupPassphraseEnv, c.PassphraseFile, "Backup passphrase: ")
+	if err != nil {
+		return err
+	}
+
+	backup, err := lib.ReadBackup(bundle, passphrase)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStorage(c.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	if err = store.Import(backup, c.Force); err != nil {
+		return err
+	}
+
+	fmt.Printf("restored %d agent(s) from %s, system agent %s\n\n", len(backup.Agents), c.Input, backup.SystemAgentID)
+	return nil
+}
+
+// openStorage returns the agent storage using the store configured in configFile
+func openStorage(configFile string) (*lib.Storage, error) {
+	var cfg config.Config
+	cfg.Default()
+
+	if err := cfg.Load(configFile); err != nil {
+		return nil, err
+	}
+
+	store := util.CreateStore(&cfg)
+	if store == nil {
+		return nil, fmt.Errorf("unsupported store type: %s", cfg.Store.Type)
+	}
+
+	if err := store.Init(); err != nil {
+		return nil, err
+	}
+
+	return lib.NewStore(store), nil
+}
+
 func main() {
 	startText()
 
@@ -100,6 +228,9 @@ func main() {
 
 	_, _ = parser.AddCommand("init", "init config", "write default config", &initCmd{})
 	_, _ = parser.AddCommand("start", "start service", "", &startCmd{})
+	_, _ = parser.AddCommand("encrypt-store", "encrypt store", "convert a plaintext file store to an encrypted store", &encryptStoreCmd{})
+	_, _ = parser.AddCommand("backup", "backup agents", "write the registered agents to a passphrase encrypted backup file", &backupCmd{})
+	_, _ = parser.AddCommand("restore", "restore agents", "restore the agents of a backup file to the configured store", &restoreCmd{})
 	_, _ = parser.AddCommand("version", "print version", "print service version and quit", &versionCmd{})
 
 	_, err := parser.Parse()
@@ -0,0 +1,321 @@
+// Package coapproval holds back the approval of the actions needing several approvers.
+// The approvals of the authenticated API callers are collected per action, and the action is only approved
+// once the quorum of its type is reached. The approvals collected are dropped when the action is rejected,
+// expires or is no longer pending. They are saved to the KV store when one is set, so that they survive a restart.
+
+package coapproval
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"go.uber.org/zap"
+
+	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/autoapprover"
+	"github.com/qredo/signing-agent/config"
+	"github.com/qredo/signing-agent/defs"
+	"github.com/qredo/signing-agent/journal"
+	"github.com/qredo/signing-agent/util"
+)
+
+// Actions returns the pending actions and the state of the actions
+type Actions interface {
+	Get(actionID string) (api.PendingAction, bool)
+	State(actionID string) (string, bool)
+}
+
+// ballot holds the approvers of an action, in the order they approved it
+type ballot struct {
+	approvers  []string
+	actionType string
+	expireTime int64
+	releasing  bool
+}
+
+// savedBallot is a ballot as it's saved to the KV store
+type savedBallot struct {
+	Approvers  []string `json:"approvers"`
+	Type       string   `json:"type"`
+	ExpireTime int64    `json:"expireTime"`
+}
+
+func (b *ballot) hasApprover(approver string) bool {
+	for _, a := range b.approvers {
+		if a == approver {
+			return true
+		}
+	}
+	return false
+}
+
+// Manager is an ActionManager approving the actions only once their quorum is reached
+type Manager struct {
+	autoapprover.ActionManager
+	cfg     *config.CoApproval
+	actions Actions
+	journal journal.Journal
+	log     *zap.SugaredLogger
+	lock    sync.Mutex
+	ballots map[string]*ballot
+	now     func() time.Time
+	store   util.KVStore
+	key     string
+}
+
+// NewManager returns a *Manager collecting the approvals of the pending actions and calling the given ActionManager
+// once the quorum is reached
+func NewManager(cfg *config.CoApproval, actionManager autoapprover.ActionManager, actions Actions, journal journal.Journal, log *zap.SugaredLogger) *Manager {
+	return &Manager{
+		ActionManager: actionManager,
+		cfg:           cfg,
+		actions:       actions,
+		journal:       journal,
+		log:           log,
+		ballots:       make(map[string]*ballot),
+		now:           time.Now,
+	}
+}
+
+// SetStore sets the KV store the approvals are saved to, under the key, and loads the approvals saved by a previous run
+func (m *Manager) SetStore(store util.KVStore, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.store = store
+	m.key = key
+
+	data, err := store.Get(key)
+	if err != nil {
+		if err == defs.KVErrNotFound {
+			return nil
+		}
+		return errors.Wrap(err, "load approvals")
+	}
+
+	saved := make(map[string]savedBallot)
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return errors.Wrap(err, "decode approvals")
+	}
+
+	for actionID, s := range saved {
+		m.ballots[actionID] = &ballot{
+			approvers:  s.Approvers,
+			actionType: s.Type,
+			expireTime: s.ExpireTime,
+		}
+	}
+	m.log.Infof("CoApproval: loaded the approvals of %d action(s)", len(saved))
+
+	return nil
+}
+
+// Approve approves the action when it needs a single approval. The actions needing several approvers can only be approved
+// through Vote, by identified callers. The actions already handled are left to the given ActionManager, which tells their
+// state, while the actions not tracked, as the ones received before a restart, are refused when a policy needs several approvers
+func (m *Manager) Approve(actionID string) error {
+	action, ok := m.get(actionID)
+	if !ok {
+		if err := m.checkUntracked(actionID); err != nil {
+			return err
+		}
+		return m.ActionManager.Approve(actionID)
+	}
+
+	if quorum := m.cfg.Quorum(action.Type); quorum > 1 {
+		return defs.ErrForbidden().WithDetail(fmt.Sprintf("action needs %d approvers, approve it through the API", quorum))
+	}
+
+	return m.ActionManager.Approve(actionID)
+}
+
+// Reject rejects the action right away with the reason, dropping the approvals collected for it
+func (m *Manager) Reject(actionID string, reason api.RejectReason) error {
+	if err := m.ActionManager.Reject(actionID, reason); err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	m.drop(actionID)
+	m.lock.Unlock()
+
+	return nil
+}
+
+// Vote records the approval of the action by the approver. The action is approved once the quorum of its type is reached,
+// otherwise the pending response tells the number of approvals collected. An approver approving again only retries
+// the approval of the action, when its quorum is reached but the approval failed. The actions not pending are handled
+// as by Approve
+func (m *Manager) Vote(actionID, approver string) (api.ActionResponse, error) {
+	if len(approver) == 0 {
+		return api.ActionResponse{}, defs.ErrUnauthorized().WithDetail("the approver must be authenticated")
+	}
+
+	action, ok := m.get(actionID)
+	if !ok {
+		if err := m.checkUntracked(actionID); err != nil {
+			return api.ActionResponse{}, err
+		}
+	}
+
+	if !ok || m.cfg.Quorum(action.Type) <= 1 {
+		if err := m.ActionManager.Approve(actionID); err != nil {
+			return api.ActionResponse{}, err
+		}
+		return m.response(actionID), nil
+	}
+
+	quorum := m.cfg.Quorum(action.Type)
+
+	m.lock.Lock()
+	m.sweep()
+
+	b, ok := m.ballots[actionID]
+	if !ok {
+		b = &ballot{actionType: action.Type, expireTime: action.ExpireTime}
+		m.ballots[actionID] = b
+	}
+
+	if !b.hasApprover(approver) {
+		b.approvers = append(b.approvers, approver)
+		m.save()
+		m.record(actionID, approver)
+		m.log.Infof("CoApproval: action [%v] approved by [%v], %d of %d", actionID, approver, len(b.approvers), quorum)
+	}
+
+	approvals := len(b.approvers)
+	if approvals < quorum || b.releasing {
+		m.lock.Unlock()
+		return api.NewPendingActionResponse(actionID, approvals, quorum), nil
+	}
+
+	b.releasing = true
+	m.lock.Unlock()
+
+	err := m.ActionManager.Approve(actionID)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if err != nil {
+		b.releasing = false
+		return api.ActionResponse{}, err
+	}
+
+	m.drop(actionID)
+	return m.response(actionID), nil
+}
+
+// get returns the pending action, or the action of the approvals loaded from the KV store when it isn't tracked yet,
+// as after a restart
+func (m *Manager) get(actionID string) (api.PendingAction, bool) {
+	if action, ok := m.actions.Get(actionID); ok {
+		return action, true
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	b, ok := m.ballots[actionID]
+	if !ok || m.isDone(actionID, b) {
+		return api.PendingAction{}, false
+	}
+
+	return api.PendingAction{
+		ActionID:   actionID,
+		Type:       b.actionType,
+		ExpireTime: b.expireTime,
+	}, true
+}
+
+// checkUntracked fails closed for an action that's neither pending nor has approvals: its type, and so its quorum, is
+// unknown. The actions already handled are let through, the ActionManager tells their state without signing them again
+func (m *Manager) checkUntracked(actionID string) error {
+	if state, known := m.actions.State(actionID); known && state != api.ActionStateFailed {
+		return nil
+	}
+
+	for _, policy := range m.cfg.Policies {
+		if policy.Quorum > 1 {
+			return defs.ErrConflict().WithDetail("action not received on the feed yet, its quorum can't be checked")
+		}
+	}
+
+	return nil
+}
+
+// response returns the current state of the action once it's approved
+func (m *Manager) response(actionID string) api.ActionResponse {
+	if state, ok := m.actions.State(actionID); ok {
+		return api.NewActionResponse(actionID, state)
+	}
+	return api.NewApprovedActionResponse(actionID)
+}
+
+// isDone returns whether the action of the ballot expired, or is known and no longer pending. The ballots of the actions
+// not tracked yet are kept until they expire. It must be called with the lock held
+func (m *Manager) isDone(actionID string, b *ballot) bool {
+	if b.expireTime > 0 && b.expireTime <= m.now().Unix() {
+		return true
+	}
+
+	if _, ok := m.actions.Get(actionID); ok {
+		return false
+	}
+	_, known := m.actions.State(actionID)
+	return known
+}
+
+// sweep drops the ballots of the actions expired or no longer pending. It must be called with the lock held
+func (m *Manager) sweep() {
+	for actionID, b := range m.ballots {
+		if !b.releasing && m.isDone(actionID, b) {
+			m.log.Debugf("CoApproval: dropping the %d approvals of action [%v]", len(b.approvers), actionID)
+			m.drop(actionID)
+		}
+	}
+}
+
+// drop removes the ballot of the action. It must be called with the lock held
+func (m *Manager) drop(actionID string) {
+	if _, ok := m.ballots[actionID]; !ok {
+		return
+	}
+
+	delete(m.ballots, actionID)
+	m.save()
+}
+
+// save writes the ballots to the KV store, if any. It must be called with the lock held
+func (m *Manager) save() {
+	if m.store == nil {
+		return
+	}
+
+	saved := make(map[string]savedBallot, len(m.ballots))
+	for actionID, b := range m.ballots {
+		saved[actionID] = savedBallot{
+			Approvers:  b.approvers,
+			Type:       b.actionType,
+			ExpireTime: b.expireTime,
+		}
+	}
+
+	data, err := json.Marshal(saved)
+	if err == nil {
+		err = m.store.Set(m.key, data)
+	}
+	if err != nil {
+		m.log.Errorf("CoApproval: failed to save the approvals, err: %v", err)
+	}
+}
+
+func (m *Manager) record(actionID, approver string) {
+	if err := m.journal.Record(journal.NewEntry(actionID, journal.EventVote, journal.SourceREST, approver)); err != nil {
+		m.log.Errorf("CoApproval: failed to record the approval of action [%v], err: %v", actionID, err)
+	}
+}
@@ -0,0 +1,323 @@
+package coapproval
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/config"
+	"github.com/qredo/signing-agent/defs"
+	"github.com/qredo/signing-agent/journal"
+	"github.com/qredo/signing-agent/util"
+)
+
+type mockActionManager struct {
+	Approved  []string
+	Rejected  []string
+	NextError error
+}
+
+func (m *mockActionManager) Approve(actionID string) error {
+	m.Approved = append(m.Approved, actionID)
+	return m.NextError
+}
+
+func (m *mockActionManager) Reject(actionID string, _ api.RejectReason) error {
+	m.Rejected = append(m.Rejected, actionID)
+	return m.NextError
+}
+
+type mockActions map[string]api.PendingAction
+
+func (m mockActions) Get(actionID string) (api.PendingAction, bool) {
+	action, ok := m[actionID]
+	return action, ok
+}
+
+func (m mockActions) State(actionID string) (string, bool) {
+	switch actionID {
+	case "approved action id":
+		return api.ActionStateApproved, true
+	case "rejected action id":
+		return api.ActionStateRejected, true
+	}
+	return "", false
+}
+
+func newTestManager(actionManager *mockActionManager, actions mockActions, journal journal.Journal) *Manager {
+	cfg := &config.CoApproval{
+		Enabled: true,
+		Policies: []config.CoApprovalPolicy{
+			{Types: []string{"ApproveWithdraw"}, Quorum: 2},
+			{Types: []string{"ApproveTransfer"}, Quorum: 1},
+		},
+	}
+	return NewManager(cfg, actionManager, actions, journal, util.NewTestLogger())
+}
+
+func assertAPIError(t *testing.T, err error, expectedCode int, expectedDetail string) {
+	apiErr, ok := err.(*defs.APIError)
+	assert.True(t, ok)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, expectedCode, code)
+	assert.Equal(t, expectedDetail, detail)
+}
+
+func TestManager_Vote_approves_once_quorum_reached(t *testing.T) {
+	//Arrange
+	actionManager := &mockActionManager{}
+	journalMock := &journal.MockJournal{}
+	sut := newTestManager(actionManager, mockActions{
+		"some action id": {ActionID: "some action id", Type: "ApproveWithdraw", ExpireTime: time.Now().Add(time.Minute).Unix()},
+	}, journalMock)
+
+	//Act
+	first, firstErr := sut.Vote("some action id", "alice")
+	again, againErr := sut.Vote("some action id", "alice")
+	second, secondErr := sut.Vote("some action id", "bob")
+
+	//Assert
+	assert.Nil(t, firstErr)
+	assert.Equal(t, api.NewPendingActionResponse("some action id", 1, 2), first)
+	assert.Nil(t, againErr)
+	assert.Equal(t, api.NewPendingActionResponse("some action id", 1, 2), again)
+	assert.Nil(t, secondErr)
+	assert.Equal(t, api.NewApprovedActionResponse("some action id"), second)
+	assert.Equal(t, []string{"some action id"}, actionManager.Approved)
+	assert.Equal(t, []string{journal.EventVote, journal.EventVote}, journalMock.Events())
+	assert.Empty(t, sut.ballots)
+}
+
+func TestManager_Vote_keeps_approvals_when_approval_fails(t *testing.T) {
+	//Arrange
+	actionManager := &mockActionManager{NextError: errors.New("some error")}
+	sut := newTestManager(actionManager, mockActions{
+		"some action id": {ActionID: "some action id", Type: "ApproveWithdraw"},
+	}, &journal.MockJournal{})
+	_, _ = sut.Vote("some action id", "alice")
+	_, failedErr := sut.Vote("some action id", "bob")
+	actionManager.NextError = nil
+
+	//Act
+	res, err := sut.Vote("some action id", "alice")
+
+	//Assert
+	assert.NotNil(t, failedErr)
+	assert.Nil(t, err)
+	assert.Equal(t, api.NewApprovedActionResponse("some action id"), res)
+	assert.Equal(t, []string{"some action id", "some action id"}, actionManager.Approved)
+}
+
+func TestManager_Vote_single_approval(t *testing.T) {
+	//Arrange
+	actionManager := &mockActionManager{}
+	sut := newTestManager(actionManager, mockActions{
+		"some action id": {ActionID: "some action id", Type: "ApproveTransfer"},
+	}, &journal.MockJournal{})
+
+	//Act
+	res, err := sut.Vote("some action id", "alice")
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Equal(t, api.NewApprovedActionResponse("some action id"), res)
+	assert.Equal(t, []string{"some action id"}, actionManager.Approved)
+}
+
+func TestManager_not_pending_left_to_action_manager(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		actionID string
+		err      error
+		expected api.ActionResponse
+	}{
+		{"already approved", "approved action id", nil, api.NewApprovedActionResponse("approved action id")},
+		{"conflict", "rejected action id", defs.ErrConflict().WithDetail("action is already rejected"), api.ActionResponse{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			actionManager := &mockActionManager{NextError: tc.err}
+			sut := newTestManager(actionManager, mockActions{}, &journal.MockJournal{})
+
+			//Act
+			res, voteErr := sut.Vote(tc.actionID, "alice")
+			approveErr := sut.Approve(tc.actionID)
+
+			//Assert
+			assert.Equal(t, tc.err, voteErr)
+			assert.Equal(t, tc.err, approveErr)
+			assert.Equal(t, tc.expected, res)
+			assert.Equal(t, []string{tc.actionID, tc.actionID}, actionManager.Approved)
+			assert.Empty(t, sut.ballots)
+		})
+	}
+}
+
+func TestManager_untracked_action_needs_quorum(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		policies []config.CoApprovalPolicy
+		approved []string
+	}{
+		{"refused when a policy needs several approvers", []config.CoApprovalPolicy{{Types: []string{"ApproveWithdraw"}, Quorum: 2}}, nil},
+		{"approved when every policy needs a single approver", []config.CoApprovalPolicy{{Quorum: 1}}, []string{"unknown action id", "unknown action id"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			actionManager := &mockActionManager{}
+			sut := newTestManager(actionManager, mockActions{}, &journal.MockJournal{})
+			sut.cfg.Policies = tc.policies
+
+			//Act
+			res, voteErr := sut.Vote("unknown action id", "alice")
+			approveErr := sut.Approve("unknown action id")
+
+			//Assert
+			assert.Equal(t, tc.approved, actionManager.Approved)
+			if tc.approved != nil {
+				assert.Nil(t, voteErr)
+				assert.Nil(t, approveErr)
+				assert.Equal(t, api.NewApprovedActionResponse("unknown action id"), res)
+				return
+			}
+
+			assertAPIError(t, voteErr, http.StatusConflict, "action not received on the feed yet, its quorum can't be checked")
+			assertAPIError(t, approveErr, http.StatusConflict, "action not received on the feed yet, its quorum can't be checked")
+			assert.Empty(t, sut.ballots)
+		})
+	}
+}
+
+func TestManager_Vote_drops_approvals_of_actions_not_pending(t *testing.T) {
+	//Arrange
+	actions := mockActions{
+		"rejected action id": {ActionID: "rejected action id", Type: "ApproveWithdraw"},
+		"other action id":    {ActionID: "other action id", Type: "ApproveWithdraw"},
+	}
+	sut := newTestManager(&mockActionManager{}, actions, &journal.MockJournal{})
+	_, _ = sut.Vote("rejected action id", "alice")
+	delete(actions, "rejected action id")
+
+	//Act
+	_, err := sut.Vote("other action id", "alice")
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Len(t, sut.ballots, 1)
+	assert.Contains(t, sut.ballots, "other action id")
+}
+
+func TestManager_errors(t *testing.T) {
+	var testCases = []struct {
+		name   string
+		call   func(sut *Manager) error
+		code   int
+		detail string
+	}{
+		{"vote without approver", func(sut *Manager) error {
+			_, err := sut.Vote("some action id", "")
+			return err
+		}, http.StatusUnauthorized, "the approver must be authenticated"},
+		{"approve needs quorum", func(sut *Manager) error {
+			return sut.Approve("some action id")
+		}, http.StatusForbidden, "action needs 2 approvers, approve it through the API"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			actionManager := &mockActionManager{}
+			sut := newTestManager(actionManager, mockActions{
+				"some action id": {ActionID: "some action id", Type: "ApproveWithdraw"},
+			}, &journal.MockJournal{})
+
+			//Act
+			err := tc.call(sut)
+
+			//Assert
+			assertAPIError(t, err, tc.code, tc.detail)
+			assert.Empty(t, actionManager.Approved)
+		})
+	}
+}
+
+func TestManager_Reject_drops_approvals(t *testing.T) {
+	//Arrange
+	actionManager := &mockActionManager{}
+	sut := newTestManager(actionManager, mockActions{
+		"some action id": {ActionID: "some action id", Type: "ApproveWithdraw"},
+	}, &journal.MockJournal{})
+	_, _ = sut.Vote("some action id", "alice")
+
+	//Act
+	err := sut.Reject("some action id", api.RejectReason{Code: api.RejectCodeManual})
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"some action id"}, actionManager.Rejected)
+	assert.Empty(t, sut.ballots)
+}
+
+func TestManager_SetStore_restores_approvals(t *testing.T) {
+	//Arrange
+	store := util.NewFileStore(filepath.Join(t.TempDir(), "store.db"))
+	assert.Nil(t, store.Init())
+	expireTime := time.Now().Add(time.Minute).Unix()
+	actions := mockActions{
+		"some action id": {ActionID: "some action id", Type: "ApproveWithdraw", ExpireTime: expireTime},
+	}
+	previous := newTestManager(&mockActionManager{}, actions, &journal.MockJournal{})
+	assert.Nil(t, previous.SetStore(store, "some key"))
+	_, _ = previous.Vote("some action id", "alice")
+
+	// the tracker starts empty after a restart
+	actionManager := &mockActionManager{}
+	sut := newTestManager(actionManager, mockActions{}, &journal.MockJournal{})
+
+	//Act
+	err := sut.SetStore(store, "some key")
+	again, againErr := sut.Vote("some action id", "alice")
+	approved, approvedErr := sut.Vote("some action id", "bob")
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Nil(t, againErr)
+	assert.Equal(t, api.NewPendingActionResponse("some action id", 1, 2), again)
+	assert.Nil(t, approvedErr)
+	assert.Equal(t, api.NewApprovedActionResponse("some action id"), approved)
+	assert.Equal(t, []string{"some action id"}, actionManager.Approved)
+	data, _ := store.Get("some key")
+	assert.JSONEq(t, `{}`, string(data))
+}
+
+func TestManager_SetStore_drops_expired_approvals(t *testing.T) {
+	//Arrange
+	store := util.NewFileStore(filepath.Join(t.TempDir(), "store.db"))
+	assert.Nil(t, store.Init())
+	saved, _ := json.Marshal(map[string]savedBallot{
+		"expired action id": {Approvers: []string{"alice"}, Type: "ApproveWithdraw", ExpireTime: time.Now().Add(-time.Minute).Unix()},
+		"some action id":    {Approvers: []string{"alice"}, Type: "ApproveWithdraw", ExpireTime: time.Now().Add(time.Minute).Unix()},
+	})
+	assert.Nil(t, store.Set("some key", saved))
+	sut := newTestManager(&mockActionManager{}, mockActions{}, &journal.MockJournal{})
+	assert.Nil(t, sut.SetStore(store, "some key"))
+
+	//Act
+	res, _ := sut.Vote("some action id", "alice")
+
+	//Assert
+	assert.Equal(t, api.NewPendingActionResponse("some action id", 1, 2), res)
+	assert.Len(t, sut.ballots, 1)
+	data, _ := store.Get("some key")
+	assert.NotContains(t, string(data), "expired action id")
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"strings"
 
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
@@ -9,23 +10,41 @@ import (
 
 // swagger:model ConfigResponse
 type Config struct {
-	Base          Base            `yaml:"base" json:"base"`
-	HTTP          HttpSettings    `yaml:"http" json:"http"`
-	Logging       Logging         `yaml:"logging" json:"logging"`
-	LoadBalancing LoadBalancing   `yaml:"loadBalancing" json:"loadBalancing"`
-	Store         Store           `yaml:"store" json:"store"`
-	AutoApprove   AutoApprove     `yaml:"autoApproval" json:"autoApproval"`
-	Websocket     WebSocketConfig `yaml:"websocket" json:"websocket"`
+	Base          Base             `yaml:"base" json:"base"`
+	HTTP          HttpSettings     `yaml:"http" json:"http"`
+	GRPC          GRPC             `yaml:"grpc" json:"grpc"`
+	Logging       Logging          `yaml:"logging" json:"logging"`
+	LoadBalancing LoadBalancing    `yaml:"loadBalancing" json:"loadBalancing"`
+	Store         Store            `yaml:"store" json:"store"`
+	AutoApprove   AutoApprove      `yaml:"autoApproval" json:"autoApproval"`
+	ActionQueue   ActionQueue      `yaml:"actionQueue" json:"actionQueue"`
+	Batch         Batch            `yaml:"batch" json:"batch"`
+	Websocket     WebSocketConfig  `yaml:"websocket" json:"websocket"`
+	FeedBuffer    FeedBuffer       `yaml:"feedBuffer" json:"feedBuffer"`
+	Journal       Journal          `yaml:"journal" json:"journal"`
+	Webhooks      Webhooks         `yaml:"webhooks" json:"webhooks"`
+	CoApproval    CoApproval       `yaml:"coApproval" json:"coApproval"`
+	Agents        map[string]Agent `yaml:"agents" json:"agents,omitempty"`
+}
+
+// Agent-based Signing Agent config: the settings of a single agent, keyed by agent ID in `agents`.
+// The settings not given for an agent are taken from the top level ones, except enabled and shadow, which are always the agent's.
+type Agent struct {
+	AutoApprove *AutoApprove `yaml:"autoApproval" json:"autoApproval"`
 }
 
 type Base struct {
 	// The pin number to use to provide a zero knowledge proof token for communication with the Partner API
 	// example: 123456
-	PIN int `yaml:"pin" json:"pin"`
+	PIN int `yaml:"pin" json:"pin" sensitive:"true"`
 
 	// The URL of the Qredo API
 	// example: https://sandbox-api.qredo.network
 	QredoAPI string `yaml:"qredoAPI" json:"qredoAPI"`
+
+	// Send the reason of a rejection to the Qredo API, in the body of the request. The reason is always recorded in the journal
+	// example: false
+	SendRejectReason bool `yaml:"sendRejectReason" json:"sendRejectReason"`
 }
 
 type TLSConfig struct {
@@ -40,6 +59,10 @@ type TLSConfig struct {
 	// The key file to use for the TLS server
 	// example: tls/domain.key
 	KeyFile string `yaml:"keyFile" json:"keyFile"`
+
+	// The CA file used to verify the client certificates. When set, the clients can authenticate with a certificate signed by this CA
+	// example: tls/client-ca.crt
+	ClientCAFile string `yaml:"clientCAFile" json:"clientCAFile"`
 }
 
 type AutoApprove struct {
@@ -47,6 +70,11 @@ type AutoApprove struct {
 	// example: true
 	Enabled bool `yaml:"enabled" json:"enabled"`
 
+	// Evaluate the rules and record the decision for every transaction received, without approving or rejecting it.
+	// It can be switched at runtime, when either enabled or shadow is set
+	// example: false
+	Shadow bool `yaml:"shadow" json:"shadow"`
+
 	// The maximum time in which the signing agent retries to approve an action. After that, it’s considered as a failure
 	// example: 300
 	RetryIntervalMax int `yaml:"retryIntervalMaxSec" json:"retryIntervalMaxSec"`
@@ -54,6 +82,123 @@ type AutoApprove struct {
 	// The interval in which the Signing Agent is attempting to approve an action. It will retry until the `retryIntervalMaxSec` is reached
 	// example: 5
 	RetryInterval int `yaml:"retryIntervalSec" json:"retryIntervalSec"`
+
+	// The decision taken for an action that doesn't match any of the rules. Defaults to ignore, so that only the actions
+	// matched by a rule are approved automatically
+	// enum: approve, reject, ignore
+	// example: ignore
+	DefaultDecision string `yaml:"defaultDecision" json:"defaultDecision"`
+
+	// The reason of the rejection, when the default decision is reject
+	DefaultReason RejectReason `yaml:"defaultReason" json:"defaultReason"`
+
+	// The list of rules evaluated in order for every action received. The first matching rule decides
+	Rules []AutoApproveRule `yaml:"rules" json:"rules"`
+
+	// The external policy decision point consulted before every automatic approval
+	Policy AutoApprovePolicy `yaml:"policy" json:"policy"`
+}
+
+// AutoApprovePolicy-based Signing Agent config: the external policy decision point consulted before approving an action.
+// The actions rejected or left for a manual decision by the rules aren't sent.
+type AutoApprovePolicy struct {
+	// Consult the policy decision point before every automatic approval
+	// example: true
+	Enabled bool `yaml:"enabled" json:"enabled"`
+
+	// The URL the action details are posted to
+	// example: https://risk.example.org/v1/data/signing/decision
+	URL string `yaml:"url" json:"url"`
+
+	// The format of the request and the response. With opa, the details are posted as the input and the decision is read from the result
+	// enum: http, opa
+	// example: http
+	Format string `yaml:"format" json:"format"`
+
+	// The bearer token sent in the Authorization header, optional
+	// example: 8f2c4e6a9b1d3f5e
+	Token string `yaml:"token" json:"token" sensitive:"true"`
+
+	// The timeout of a call to the policy decision point
+	// example: 2000
+	TimeoutMs int `yaml:"timeoutMs" json:"timeoutMs"`
+
+	// Keep the approval decided by the rules when the call fails. Otherwise, the action is left for a manual decision
+	// example: false
+	FailOpen bool `yaml:"failOpen" json:"failOpen"`
+
+	// How long a decision is cached for the same action details. 0 disables the cache
+	// example: 60
+	CacheTTLSec int `yaml:"cacheTTLSec" json:"cacheTTLSec"`
+}
+
+// AutoApproveRule is a rule matching the actions by type, status, agent and expiry, and deciding whether to approve, reject or ignore them
+type AutoApproveRule struct {
+	// The name of the rule, used for logging
+	// example: small withdrawals
+	Name string `yaml:"name" json:"name"`
+
+	// The action types the rule applies to
+	// example: ["ApproveWithdraw"]
+	Types []string `yaml:"types" json:"types"`
+
+	// The action statuses the rule applies to
+	// example: ["pending"]
+	Statuses []string `yaml:"statuses" json:"statuses"`
+
+	// The agent IDs the rule applies to
+	// example: ["98cTMMSPrDdcDDVU8idhuJGK2U1P4vmQcsp8wnED8pPR"]
+	AgentIDs []string `yaml:"agentIDs" json:"agentIDs"`
+
+	// The minimum time left until the action expires, in seconds. 0 means no minimum
+	// example: 30
+	MinExpirySec int `yaml:"minExpirySec" json:"minExpirySec"`
+
+	// The maximum time left until the action expires, in seconds. 0 means no maximum
+	// example: 3600
+	MaxExpirySec int `yaml:"maxExpirySec" json:"maxExpirySec"`
+
+	// The decision taken when the rule matches
+	// enum: approve, reject, ignore
+	// example: approve
+	Decision string `yaml:"decision" json:"decision"`
+
+	// The reason of the rejection, when the decision is reject
+	Reason RejectReason `yaml:"reason" json:"reason"`
+}
+
+// RejectReason is the reason recorded and sent for an action rejected by the auto-approval
+type RejectReason struct {
+	// The code of the reason, rule or default_decision when empty
+	// example: limit_exceeded
+	Code string `yaml:"code" json:"code"`
+
+	// The explanation of the rejection
+	// example: withdrawals over 1 BTC need a manual approval
+	Message string `yaml:"message" json:"message"`
+}
+
+// ActionQueue-based Signing Agent config: the queue of the actions waiting for their automatic approval or rejection.
+type ActionQueue struct {
+	// The number of actions approved or rejected at the same time
+	// example: 4
+	Workers int `yaml:"workers" json:"workers"`
+
+	// The path to the file where the queued actions are kept until approved or rejected, so that they're resumed after a restart.
+	// When empty, the queue is only kept in memory
+	// example: /volume/action_queue.db
+	File string `yaml:"file" json:"file"`
+}
+
+// Batch holds the limits of the endpoints approving or rejecting several actions in a single call
+type Batch struct {
+	// The number of actions of a batch approved or rejected at the same time
+	// example: 4
+	Parallelism int `yaml:"parallelism" json:"parallelism"`
+
+	// The maximum number of actions in a batch
+	// example: 100
+	MaxActions int `yaml:"maxActions" json:"maxActions"`
 }
 
 type WebSocketConfig struct {
@@ -88,11 +233,17 @@ type WebSocketConfig struct {
 	// The websocket upgrader write buffer size in bytes
 	// example: 1024
 	WriteBufferSize int `yaml:"writeBufferSize" json:"writeBufferSize"`
+
+	// Query the Qredo API for the pending actions, GET {qredoAPI}/coreclient/actions?status=pending, when the feed is
+	// connected or reconnected, and broadcast the ones not received on the feed yet. Off by default, as the endpoint isn't
+	// served by every Qredo API. The replay is turned off when the endpoint answers with a 404
+	// example: false
+	ReplayMissedActions bool `yaml:"replayMissedActions" json:"replayMissedActions"`
 }
 
 type Store struct {
 	// The type of store to use to store the private key information for the Signing Agent
-	// enum: file, oci, aws
+	// enum: file, encrypted, oci, aws, vault
 	// example: file
 	Type string `default:"file" yaml:"type" json:"type"`
 
@@ -100,27 +251,94 @@ type Store struct {
 	// example: /volume/ccstore.db
 	FileConfig string `yaml:"file" json:"file"`
 
-	OciConfig OciConfig `yaml:"oci" json:"oci"`
-	AwsConfig AWSConfig `yaml:"aws" json:"aws"`
+	EncryptedConfig EncryptedFileConfig `yaml:"encrypted" json:"encrypted"`
+	OciConfig       OciConfig           `yaml:"oci" json:"oci"`
+	AwsConfig       AWSConfig           `yaml:"aws" json:"aws"`
+	VaultConfig     VaultConfig         `yaml:"vault" json:"vault"`
+}
+
+// EncryptedFileConfig-based Signing Agent config: used when Base `store` `type` is `encrypted`.
+// The passphrase is read from the environment variable, else from the passphrase file, else it's prompted for on the terminal.
+type EncryptedFileConfig struct {
+	// The path to the encrypted storage file
+	// example: /volume/ccstore.enc
+	File string `yaml:"file" json:"file"`
+
+	// The environment variable holding the passphrase used to derive the encryption key
+	// example: SIGNING_AGENT_STORE_PASSPHRASE
+	PassphraseEnv string `yaml:"passphraseEnv" json:"passphraseEnv"`
+
+	// The path to the file holding the passphrase used to derive the encryption key
+	// example: /run/secrets/store_passphrase
+	PassphraseFile string `yaml:"passphraseFile" json:"passphraseFile"`
 }
 
 // OciConfig-based Signing Agent config: used when Base `store` `type` is `oci`.
 type OciConfig struct {
 	// The OCID where the vault and encryption key reside
 	// example: ocid1.tenancy.oc1...
-	Compartment string `yaml:"compartment" json:"compartment"`
+	Compartment string `yaml:"compartment" json:"compartment" sensitive:"true"`
 
 	// The OCID of the vault where the secret will be stored
 	// example: ocid1.vault.oc1...
-	Vault string `yaml:"vault" json:"vault"`
+	Vault string `yaml:"vault" json:"vault" sensitive:"true"`
 
 	// The encryption key used for both the secret and the data inside the secret
 	// example: ocid1.key.oc1...
-	SecretEncryptionKey string `yaml:"secretEncryptionKey" json:"secretEncryptionKey"`
+	SecretEncryptionKey string `yaml:"secretEncryptionKey" json:"secretEncryptionKey" sensitive:"true"`
 
 	// The name of secret that will be used to store the data
 	// example: automated_approver_config
-	ConfigSecret string `yaml:"configSecret" json:"configSecret"`
+	ConfigSecret string `yaml:"configSecret" json:"configSecret" sensitive:"true"`
+}
+
+// VaultConfig-based Signing Agent config: used when Base `store` `type` is `vault`.
+// The data is kept in a single secret of a HashiCorp Vault KV version 2 secrets engine.
+type VaultConfig struct {
+	// The address of the Vault server
+	// example: https://vault.example.org:8200
+	Address string `yaml:"address" json:"address"`
+
+	// The Vault Enterprise namespace, if any
+	// example: ns1
+	Namespace string `yaml:"namespace" json:"namespace"`
+
+	// The path where the KV version 2 secrets engine is mounted
+	// example: secret
+	Mount string `yaml:"mount" json:"mount"`
+
+	// The path of the secret, inside the mount, where the data is stored
+	// example: signing-agent
+	Path string `yaml:"path" json:"path"`
+
+	// The auth method used to get a Vault token
+	// enum: token, approle, kubernetes
+	// example: approle
+	AuthMethod string `yaml:"authMethod" json:"authMethod"`
+
+	// The path where the auth method is mounted, defaults to the name of the auth method
+	// example: approle
+	AuthMount string `yaml:"authMount" json:"authMount"`
+
+	// The Vault token when the `token` auth method is used
+	// example: hvs.CAESIJ...
+	Token string `yaml:"token" json:"token" sensitive:"true"`
+
+	// The role ID when the `approle` auth method is used
+	// example: 675a50e7-cfe0-be76-e35f-49ec009731ea
+	RoleID string `yaml:"roleID" json:"roleID"`
+
+	// The secret ID when the `approle` auth method is used
+	// example: ed0a642f-2acf-c2da-232f-1b21300d5f29
+	SecretID string `yaml:"secretID" json:"secretID" sensitive:"true"`
+
+	// The Vault role when the `kubernetes` auth method is used
+	// example: signing-agent
+	Role string `yaml:"role" json:"role"`
+
+	// The path to the service account token when the `kubernetes` auth method is used
+	// example: /var/run/secrets/kubernetes.io/serviceaccount/token
+	ServiceAccountTokenFile string `yaml:"serviceAccountTokenFile" json:"serviceAccountTokenFile"`
 }
 
 // AWSConfig-based Signing Agent config: used when Base `store` `type` is `aws`.
@@ -131,7 +349,7 @@ type AWSConfig struct {
 
 	// The name of the AWS Secrets Manager secret containing the encrypted data
 	// example: secrets_manager_secret
-	SecretName string `yaml:"configSecret" json:"configSecret"`
+	SecretName string `yaml:"configSecret" json:"configSecret" sensitive:"true"`
 }
 
 type HttpSettings struct {
@@ -148,6 +366,67 @@ type HttpSettings struct {
 	LogAllRequests bool `yaml:"logAllRequests" json:"logAllRequests"`
 
 	TLS TLSConfig `yaml:"TLS" json:"TLS"`
+
+	Auth HttpAuth `yaml:"auth" json:"auth"`
+}
+
+// GRPC holds the settings of the gRPC API, served next to the build in API with the same TLS and auth settings
+type GRPC struct {
+	// Enable the gRPC API
+	// example: true
+	Enabled bool `yaml:"enabled" json:"enabled"`
+
+	// The address and port the gRPC API listens on
+	// example: 0.0.0.0:8008
+	Addr string `yaml:"addr" json:"addr"`
+}
+
+type HttpAuth struct {
+	// Require the clients of the build in API to authenticate, except on the open paths
+	// example: true
+	Enabled bool `yaml:"enabled" json:"enabled"`
+
+	// The paths open to the clients that don't authenticate. The paths of the API include the /api/v1 prefix
+	// example: ["/api/v1/healthcheck/version", "/api/v1/healthcheck/status"]
+	OpenPaths []string `yaml:"openPaths" json:"openPaths"`
+
+	// The static bearer tokens or API keys accepted in the Authorization: Bearer or the X-API-Key header
+	Tokens []AuthToken `yaml:"tokens" json:"tokens"`
+
+	// The keys used to verify the HMAC-SHA256 signed requests
+	HMACKeys []AuthHMACKey `yaml:"hmacKeys" json:"hmacKeys"`
+
+	// The maximum difference, in seconds, between the X-Timestamp of a signed request and the server time
+	// example: 300
+	HMACMaxSkewSec int `yaml:"hmacMaxSkewSec" json:"hmacMaxSkewSec"`
+
+	// The maximum size, in bytes, of the body of a signed request, read to verify its signature
+	// example: 1048576
+	HMACMaxBodyBytes int64 `yaml:"hmacMaxBodyBytes" json:"hmacMaxBodyBytes"`
+
+	// Accept the clients presenting a certificate verified against the TLS clientCAFile. The identity is the certificate common name
+	// example: true
+	MTLS bool `yaml:"mTLS" json:"mTLS"`
+}
+
+type AuthToken struct {
+	// The identity of the client using the token
+	// example: ops-dashboard
+	Name string `yaml:"name" json:"name"`
+
+	// The token value
+	// example: 3c9f0d5e8a7b4c21
+	Token string `yaml:"token" json:"token" sensitive:"true"`
+}
+
+type AuthHMACKey struct {
+	// The key id sent by the client in the X-Key-Id header, also used as the client identity
+	// example: approver-service
+	ID string `yaml:"id" json:"id"`
+
+	// The shared secret used to sign the requests
+	// example: 8d1e4b7c2a9f4e6b
+	Secret string `yaml:"secret" json:"secret" sensitive:"true"`
 }
 
 type Logging struct {
@@ -162,6 +441,107 @@ type Logging struct {
 	Level string `yaml:"level" json:"level"`
 }
 
+// FeedBuffer-based Signing Agent config: the messages waiting to be delivered to every client of the feed.
+type FeedBuffer struct {
+	// The number of messages kept for a client that's not done with the previous ones
+	// example: 100
+	Size int `yaml:"size" json:"size"`
+
+	// What happens to a client whose buffer is full: drop its oldest message, wait for room up to `blockTimeoutMs` then disconnect it, or disconnect it
+	// enum: drop-oldest, block, disconnect
+	// example: disconnect
+	Policy string `yaml:"policy" json:"policy"`
+
+	// The time waited for room in the buffer of a client with the `block` policy, in milliseconds
+	// example: 1000
+	BlockTimeout int `yaml:"blockTimeoutMs" json:"blockTimeoutMs"`
+
+	// The number of last messages kept, so that the Server-Sent Events clients can resume from the last one received
+	// example: 100
+	HistorySize int `yaml:"historySize" json:"historySize"`
+}
+
+type Journal struct {
+	// Record every action seen on the feed, the decisions taken and their outcome
+	// example: true
+	Enabled bool `yaml:"enabled" json:"enabled"`
+
+	// The path to the journal file. When empty, the journal is only kept in memory
+	// example: /volume/journal.db
+	File string `yaml:"file" json:"file"`
+
+	// The number of days an action is kept after its last update. When 0, the actions are kept for ever
+	// example: 30
+	RetentionDays int `yaml:"retentionDays" json:"retentionDays"`
+}
+
+type Webhooks struct {
+	// Post every action received on the feed to the configured endpoints
+	// example: true
+	Enabled bool `yaml:"enabled" json:"enabled"`
+
+	// The endpoints the actions are posted to
+	Endpoints []WebhookEndpoint `yaml:"endpoints" json:"endpoints"`
+
+	// The maximum number of delivery attempts of an action to an endpoint. After that, the action is written to the dead-letter file
+	// example: 5
+	MaxAttempts int `yaml:"maxAttempts" json:"maxAttempts"`
+
+	// The time waited before the first retry of a failed delivery. It's doubled after every attempt, up to `retryIntervalMaxSec`
+	// example: 1
+	RetryInterval int `yaml:"retryIntervalSec" json:"retryIntervalSec"`
+
+	// The maximum time waited between two delivery attempts
+	// example: 60
+	RetryIntervalMax int `yaml:"retryIntervalMaxSec" json:"retryIntervalMaxSec"`
+
+	// The timeout of a single delivery attempt
+	// example: 10
+	Timeout int `yaml:"timeoutSec" json:"timeoutSec"`
+
+	// The number of actions waiting to be delivered to an endpoint. When full, new actions are written to the dead-letter file
+	// example: 100
+	QueueSize int `yaml:"queueSize" json:"queueSize"`
+
+	// The path to the file where the actions that couldn't be delivered are written. When empty, they are only kept in memory
+	// example: /volume/webhook_deadletter.db
+	DeadLetterFile string `yaml:"deadLetterFile" json:"deadLetterFile"`
+}
+
+type WebhookEndpoint struct {
+	// The name of the endpoint, used in the logs and metrics
+	// example: approval-service
+	Name string `yaml:"name" json:"name"`
+
+	// The URL the actions are posted to
+	// example: https://approvals.example.org/qredo/actions
+	URL string `yaml:"url" json:"url"`
+
+	// The shared secret used to sign the requests with HMAC-SHA256
+	// example: 5b2e9c7d1f4a8e3b
+	Secret string `yaml:"secret" json:"secret" sensitive:"true"`
+}
+
+// CoApproval holds the quorum policies of the actions that have to be approved by several API callers before the agent approves them
+type CoApproval struct {
+	// Require the approval of several authenticated API callers for the actions matching the policies
+	// example: true
+	Enabled bool `yaml:"enabled" json:"enabled"`
+
+	// The policies evaluated in order for every approval. The first matching policy gives the quorum, the actions matching none need a single approval
+	Policies []CoApprovalPolicy `yaml:"policies" json:"policies"`
+}
+
+type CoApprovalPolicy struct {
+	// The action types the policy applies to. An empty list matches any type
+	// example: ["ApproveWithdraw"]
+	Types []string `yaml:"types" json:"types"`
+
+	// The number of distinct approvers required before the action is approved
+	// example: 2
+	Quorum int `yaml:"quorum" json:"quorum"`
+}
+
 type LoadBalancing struct {
 	// Enables the load-balancing logic
 	// example: true
@@ -189,7 +569,7 @@ type RedisConfig struct {
 
 	// The Redis password
 	// example: just a password
-	Password string `yaml:"password" json:"password"`
+	Password string `yaml:"password" json:"password" sensitive:"true"`
 
 	// Redis database to be selected after connecting to the server
 	// example: 0
@@ -204,6 +584,17 @@ func (c *Config) Default() {
 		TLS: TLSConfig{
 			Enabled: false,
 		},
+		Auth: HttpAuth{
+			Enabled:          false,
+			OpenPaths:        []string{"/api/v1/healthcheck/version", "/api/v1/healthcheck/status"},
+			HMACMaxSkewSec:   300,
+			HMACMaxBodyBytes: 1 << 20,
+		},
+	}
+
+	c.GRPC = GRPC{
+		Enabled: false,
+		Addr:    "127.0.0.1:8008",
 	}
 
 	c.Base.PIN = 0
@@ -212,21 +603,70 @@ func (c *Config) Default() {
 		Enabled:          false,
 		RetryIntervalMax: 300,
 		RetryInterval:    5,
+		DefaultDecision:  "ignore",
+		Policy: AutoApprovePolicy{
+			Enabled:     false,
+			Format:      "http",
+			TimeoutMs:   2000,
+			CacheTTLSec: 60,
+		},
+	}
+	c.ActionQueue = ActionQueue{
+		Workers: 4,
+		File:    "action_queue.db",
+	}
+	c.Batch = Batch{
+		Parallelism: 4,
+		MaxActions:  100,
 	}
 	c.Websocket = WebSocketConfig{
-		ReconnectTimeOut:  300,
-		ReconnectInterval: 5,
-		QredoWebsocket:    "wss://play-api.qredo.network/api/v1/p/coreclient/feed",
-		PingPeriod:        5,
-		PongWait:          10,
-		WriteWait:         10,
-		ReadBufferSize:    512,
-		WriteBufferSize:   1024,
+		ReconnectTimeOut:    300,
+		ReconnectInterval:   5,
+		QredoWebsocket:      "wss://play-api.qredo.network/api/v1/p/coreclient/feed",
+		PingPeriod:          5,
+		PongWait:            10,
+		WriteWait:           10,
+		ReadBufferSize:      512,
+		WriteBufferSize:     1024,
+		ReplayMissedActions: false,
+	}
+	c.FeedBuffer = FeedBuffer{
+		Size:         100,
+		Policy:       "disconnect",
+		BlockTimeout: 1000,
+		HistorySize:  100,
+	}
+	c.Journal = Journal{
+		Enabled:       false,
+		File:          "journal.db",
+		RetentionDays: 30,
+	}
+	c.Webhooks = Webhooks{
+		Enabled:          false,
+		MaxAttempts:      5,
+		RetryInterval:    1,
+		RetryIntervalMax: 60,
+		Timeout:          10,
+		QueueSize:        100,
+		DeadLetterFile:   "webhook_deadletter.db",
+	}
+	c.CoApproval = CoApproval{
+		Enabled: false,
 	}
 	c.Logging.Level = "info"
 	c.Logging.Format = "json"
 	c.Store.Type = "file"
 	c.Store.FileConfig = "ccstore.db"
+	c.Store.EncryptedConfig = EncryptedFileConfig{
+		File:          "ccstore.enc",
+		PassphraseEnv: "SIGNING_AGENT_STORE_PASSPHRASE",
+	}
+	c.Store.VaultConfig = VaultConfig{
+		Mount:                   "secret",
+		Path:                    "signing-agent",
+		AuthMethod:              "token",
+		ServiceAccountTokenFile: "/var/run/secrets/kubernetes.io/serviceaccount/token",
+	}
 	c.LoadBalancing = LoadBalancing{
 		Enable:                false,
 		OnLockErrorTimeOutMs:  300,
@@ -252,9 +692,287 @@ func (c *Config) Load(fileName string) error {
 		return errors.Wrap(err, "parse config file")
 	}
 
+	if err := c.AutoApprove.Validate(); err != nil {
+		return errors.Wrap(err, "validate autoApproval config")
+	}
+
+	if c.ActionQueue.Workers <= 0 {
+		return errors.New("validate actionQueue config: workers must be positive")
+	}
+
+	if err := c.Batch.Validate(); err != nil {
+		return errors.Wrap(err, "validate batch config")
+	}
+
+	if err := c.FeedBuffer.Validate(); err != nil {
+		return errors.Wrap(err, "validate feedBuffer config")
+	}
+
+	if err := c.HTTP.Validate(); err != nil {
+		return errors.Wrap(err, "validate http config")
+	}
+
+	if c.Journal.RetentionDays < 0 {
+		return errors.New("validate journal config: retentionDays can't be negative")
+	}
+
+	if err := c.Webhooks.Validate(); err != nil {
+		return errors.Wrap(err, "validate webhooks config")
+	}
+
+	if err := c.CoApproval.Validate(); err != nil {
+		return errors.Wrap(err, "validate coApproval config")
+	}
+
+	if c.CoApproval.Enabled && !c.HTTP.Auth.Enabled {
+		return errors.New("validate coApproval config: the http auth must be enabled to identify the approvers")
+	}
+
+	for agentID := range c.Agents {
+		agentConfig := c.ForAgent(agentID)
+		if err := agentConfig.AutoApprove.Validate(); err != nil {
+			return errors.Wrapf(err, "validate autoApproval config for agent [%s]", agentID)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks that at least one authentication method is configured when the authentication is enabled.
+func (h *HttpSettings) Validate() error {
+	auth := &h.Auth
+	if !auth.Enabled {
+		return nil
+	}
+
+	if len(auth.Tokens) == 0 && len(auth.HMACKeys) == 0 && !auth.MTLS {
+		return errors.New("auth enabled but no tokens, hmacKeys or mTLS configured")
+	}
+
+	for i, token := range auth.Tokens {
+		if len(token.Token) == 0 {
+			return errors.Errorf("empty token %d [%s]", i, token.Name)
+		}
+	}
+
+	for i, key := range auth.HMACKeys {
+		if len(key.ID) == 0 || len(key.Secret) == 0 {
+			return errors.Errorf("empty id or secret for hmac key %d", i)
+		}
+	}
+
+	if len(auth.HMACKeys) > 0 && auth.HMACMaxBodyBytes <= 0 {
+		return errors.New("hmacMaxBodyBytes must be positive")
+	}
+
+	if auth.MTLS && (!h.TLS.Enabled || len(h.TLS.ClientCAFile) == 0) {
+		return errors.New("mTLS requires TLS enabled and a clientCAFile")
+	}
+
+	return nil
+}
+
+// Validate checks the size and the policy of the feed buffers.
+func (f *FeedBuffer) Validate() error {
+	if f.Size <= 0 {
+		return errors.New("size must be positive")
+	}
+
+	if f.HistorySize < 0 {
+		return errors.New("historySize can't be negative")
+	}
+
+	switch f.Policy {
+	case "drop-oldest", "disconnect":
+	case "block":
+		if f.BlockTimeout <= 0 {
+			return errors.New("blockTimeoutMs must be positive with the block policy")
+		}
+	default:
+		return errors.Errorf("invalid policy [%s]", f.Policy)
+	}
+
+	return nil
+}
+
+// Validate checks that the webhook endpoints are fully configured when the webhooks are enabled.
+func (w *Webhooks) Validate() error {
+	if !w.Enabled {
+		return nil
+	}
+
+	if len(w.Endpoints) == 0 {
+		return errors.New("webhooks enabled but no endpoints configured")
+	}
+
+	names := make(map[string]bool)
+	for i, endpoint := range w.Endpoints {
+		if len(endpoint.Name) == 0 || len(endpoint.URL) == 0 || len(endpoint.Secret) == 0 {
+			return errors.Errorf("empty name, url or secret for endpoint %d", i)
+		}
+
+		if !strings.HasPrefix(endpoint.URL, "http://") && !strings.HasPrefix(endpoint.URL, "https://") {
+			return errors.Errorf("invalid url for endpoint %d [%s]", i, endpoint.Name)
+		}
+
+		if names[endpoint.Name] {
+			return errors.Errorf("duplicate endpoint name [%s]", endpoint.Name)
+		}
+		names[endpoint.Name] = true
+	}
+
+	if w.MaxAttempts <= 0 || w.QueueSize <= 0 {
+		return errors.New("maxAttempts and queueSize must be positive")
+	}
+
 	return nil
 }
 
+// Validate checks that the quorum of every policy is positive when the co-approval is enabled.
+func (c *CoApproval) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	for i, policy := range c.Policies {
+		if policy.Quorum <= 0 {
+			return errors.Errorf("quorum must be positive for policy %d", i)
+		}
+	}
+
+	return nil
+}
+
+// Quorum returns the quorum of the first policy matching the action type, or 1 when none matches
+// or the co-approval is disabled.
+func (c *CoApproval) Quorum(actionType string) int {
+	if !c.Enabled {
+		return 1
+	}
+
+	for _, policy := range c.Policies {
+		if len(policy.Types) == 0 || contains(policy.Types, actionType) {
+			return policy.Quorum
+		}
+	}
+	return 1
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the batch limits are positive.
+func (b *Batch) Validate() error {
+	if b.Parallelism <= 0 {
+		return errors.New("parallelism must be positive")
+	}
+
+	if b.MaxActions <= 0 {
+		return errors.New("maxActions must be positive")
+	}
+
+	return nil
+}
+
+// ForAgent returns the config of the agent, where the agent settings override the top level ones.
+// The returned config is a copy, the top level config is returned as it is when the agent has no settings
+func (c *Config) ForAgent(agentID string) *Config {
+	agent, ok := c.Agents[agentID]
+	if !ok || agent.AutoApprove == nil {
+		return c
+	}
+
+	agentConfig := *c
+	agentConfig.AutoApprove = agent.AutoApprove.merge(&c.AutoApprove)
+	return &agentConfig
+}
+
+// merge returns a copy of the auto approval settings where the ones not set are taken from the top level settings.
+// The enabled and shadow flags are always kept, there's no telling an unset flag from a false one
+func (a *AutoApprove) merge(top *AutoApprove) AutoApprove {
+	merged := *a
+	if merged.RetryIntervalMax == 0 {
+		merged.RetryIntervalMax = top.RetryIntervalMax
+	}
+	if merged.RetryInterval == 0 {
+		merged.RetryInterval = top.RetryInterval
+	}
+	if len(merged.DefaultDecision) == 0 {
+		merged.DefaultDecision = top.DefaultDecision
+	}
+	if len(merged.DefaultReason.Code) == 0 && len(merged.DefaultReason.Message) == 0 {
+		merged.DefaultReason = top.DefaultReason
+	}
+	if merged.Rules == nil {
+		merged.Rules = top.Rules
+	}
+	if len(merged.Policy.URL) == 0 {
+		merged.Policy = top.Policy
+	}
+	return merged
+}
+
+// Validate checks the decisions defined for the auto approval are known.
+func (a *AutoApprove) Validate() error {
+	if !isValidDecision(a.DefaultDecision) {
+		return errors.Errorf("invalid defaultDecision [%s]", a.DefaultDecision)
+	}
+
+	for i, rule := range a.Rules {
+		if !isValidDecision(rule.Decision) {
+			return errors.Errorf("invalid decision [%s] for rule %d [%s]", rule.Decision, i, rule.Name)
+		}
+	}
+
+	if err := a.Policy.Validate(); err != nil {
+		return errors.Wrap(err, "policy")
+	}
+
+	return nil
+}
+
+// Validate checks the policy decision point is fully configured when it's enabled.
+func (p *AutoApprovePolicy) Validate() error {
+	if !p.Enabled {
+		return nil
+	}
+
+	if !strings.HasPrefix(p.URL, "http://") && !strings.HasPrefix(p.URL, "https://") {
+		return errors.Errorf("invalid url [%s]", p.URL)
+	}
+
+	switch p.Format {
+	case "http", "opa":
+	default:
+		return errors.Errorf("invalid format [%s]", p.Format)
+	}
+
+	if p.TimeoutMs <= 0 {
+		return errors.New("timeoutMs must be positive")
+	}
+
+	if p.CacheTTLSec < 0 {
+		return errors.New("cacheTTLSec can't be negative")
+	}
+
+	return nil
+}
+
+func isValidDecision(decision string) bool {
+	switch decision {
+	case "approve", "reject", "ignore":
+		return true
+	default:
+		return false
+	}
+}
+
 // Save saves yaml config.
 func (c *Config) Save(fileName string) error {
 	b, err := yaml.Marshal(c)
@@ -0,0 +1,281 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_ForAgent_returns_config_without_agent_settings(t *testing.T) {
+	//Arrange
+	cfg := &Config{}
+	cfg.Default()
+
+	//Act
+	agentConfig := cfg.ForAgent("some agent")
+
+	//Assert
+	assert.Same(t, cfg, agentConfig)
+}
+
+func TestConfig_ForAgent_overrides_auto_approval(t *testing.T) {
+	//Arrange
+	cfg := &Config{}
+	cfg.Default()
+	cfg.AutoApprove.Enabled = true
+	cfg.Agents = map[string]Agent{
+		"some agent": {
+			AutoApprove: &AutoApprove{
+				Enabled:         false,
+				DefaultDecision: "reject",
+			},
+		},
+	}
+
+	//Act
+	agentConfig := cfg.ForAgent("some agent")
+
+	//Assert
+	assert.False(t, agentConfig.AutoApprove.Enabled)
+	assert.Equal(t, "reject", agentConfig.AutoApprove.DefaultDecision)
+	assert.Equal(t, cfg.AutoApprove.RetryInterval, agentConfig.AutoApprove.RetryInterval)
+	assert.Equal(t, cfg.AutoApprove.RetryIntervalMax, agentConfig.AutoApprove.RetryIntervalMax)
+	assert.Equal(t, cfg.AutoApprove.Policy, agentConfig.AutoApprove.Policy)
+	assert.True(t, cfg.AutoApprove.Enabled)
+}
+
+func TestConfig_ForAgent_takes_unset_auto_approval_settings_from_top_level(t *testing.T) {
+	//Arrange
+	cfg := &Config{}
+	cfg.Default()
+	cfg.AutoApprove.DefaultDecision = "reject"
+	cfg.AutoApprove.DefaultReason = RejectReason{Code: "some code", Message: "some message"}
+	cfg.AutoApprove.Rules = []AutoApproveRule{{Name: "some rule", Decision: "approve"}}
+	cfg.Agents = map[string]Agent{
+		"some agent":  {AutoApprove: &AutoApprove{Enabled: true}},
+		"other agent": {AutoApprove: &AutoApprove{Enabled: true, Rules: []AutoApproveRule{}}},
+	}
+
+	//Act
+	agentConfig := cfg.ForAgent("some agent")
+	otherConfig := cfg.ForAgent("other agent")
+
+	//Assert
+	assert.True(t, agentConfig.AutoApprove.Enabled)
+	assert.Equal(t, "reject", agentConfig.AutoApprove.DefaultDecision)
+	assert.Equal(t, cfg.AutoApprove.DefaultReason, agentConfig.AutoApprove.DefaultReason)
+	assert.Equal(t, cfg.AutoApprove.Rules, agentConfig.AutoApprove.Rules)
+	assert.Empty(t, otherConfig.AutoApprove.Rules)
+}
+
+func TestConfig_Load_validates_merged_agent_auto_approval(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		agent    string
+		expected string
+	}{
+		{"only enabled", "enabled: true", ""},
+		{"invalid decision", "defaultDecision: some decision", "validate autoApproval config for agent [some agent]: invalid defaultDecision [some decision]"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			fileName := filepath.Join(t.TempDir(), "config.yaml")
+			data := "agents:\n  some agent:\n    autoApproval:\n      " + tc.agent + "\n"
+			require.Nil(t, os.WriteFile(fileName, []byte(data), 0600))
+			cfg := &Config{}
+
+			//Act
+			err := cfg.Load(fileName)
+
+			//Assert
+			if len(tc.expected) == 0 {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expected)
+			}
+		})
+	}
+}
+
+func TestWebhooks_Validate(t *testing.T) {
+	endpoint := WebhookEndpoint{Name: "some endpoint", URL: "https://some.host/actions", Secret: "some secret"}
+	otherEndpoint := WebhookEndpoint{Name: "some other endpoint", URL: "http://some.other.host/actions", Secret: "some other secret"}
+
+	var testCases = []struct {
+		name      string
+		endpoints []WebhookEndpoint
+		expected  string
+	}{
+		{"valid endpoints", []WebhookEndpoint{endpoint, otherEndpoint}, ""},
+		{"no endpoint", nil, "webhooks enabled but no endpoints configured"},
+		{"no secret", []WebhookEndpoint{{Name: "some endpoint", URL: "https://some.host/actions"}}, "empty name, url or secret for endpoint 0"},
+		{"invalid url", []WebhookEndpoint{{Name: "some endpoint", URL: "ftp://some.host", Secret: "some secret"}}, "invalid url for endpoint 0 [some endpoint]"},
+		{"duplicate name", []WebhookEndpoint{endpoint, endpoint}, "duplicate endpoint name [some endpoint]"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			cfg := &Config{}
+			cfg.Default()
+			cfg.Webhooks.Enabled = true
+			cfg.Webhooks.Endpoints = tc.endpoints
+
+			//Act
+			err := cfg.Webhooks.Validate()
+
+			//Assert
+			if len(tc.expected) == 0 {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expected)
+			}
+		})
+	}
+}
+
+func TestAutoApprovePolicy_Validate(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		update   func(policy *AutoApprovePolicy)
+		expected string
+	}{
+		{"valid", func(*AutoApprovePolicy) {}, ""},
+		{"opa", func(policy *AutoApprovePolicy) { policy.Format = "opa" }, ""},
+		{"invalid url", func(policy *AutoApprovePolicy) { policy.URL = "risk.example.org" }, "invalid url [risk.example.org]"},
+		{"invalid format", func(policy *AutoApprovePolicy) { policy.Format = "grpc" }, "invalid format [grpc]"},
+		{"no timeout", func(policy *AutoApprovePolicy) { policy.TimeoutMs = 0 }, "timeoutMs must be positive"},
+		{"negative cache ttl", func(policy *AutoApprovePolicy) { policy.CacheTTLSec = -1 }, "cacheTTLSec can't be negative"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			cfg := &Config{}
+			cfg.Default()
+			cfg.AutoApprove.Policy.Enabled = true
+			cfg.AutoApprove.Policy.URL = "https://risk.example.org/decide"
+			tc.update(&cfg.AutoApprove.Policy)
+
+			//Act
+			err := cfg.AutoApprove.Policy.Validate()
+
+			//Assert
+			if len(tc.expected) == 0 {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expected)
+			}
+		})
+	}
+}
+
+func TestCoApproval_Validate(t *testing.T) {
+	var testCases = []struct {
+		name       string
+		coApproval CoApproval
+		expected   string
+	}{
+		{"disabled", CoApproval{Policies: []CoApprovalPolicy{{Quorum: 0}}}, ""},
+		{"valid policies", CoApproval{Enabled: true, Policies: []CoApprovalPolicy{{Types: []string{"ApproveWithdraw"}, Quorum: 2}, {Quorum: 1}}}, ""},
+		{"invalid quorum", CoApproval{Enabled: true, Policies: []CoApprovalPolicy{{Quorum: 2}, {Quorum: 0}}}, "quorum must be positive for policy 1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Act
+			err := tc.coApproval.Validate()
+
+			//Assert
+			if len(tc.expected) == 0 {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expected)
+			}
+		})
+	}
+}
+
+func TestFeedBuffer_Validate(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		buffer   FeedBuffer
+		expected string
+	}{
+		{"default", FeedBuffer{Size: 100, Policy: "disconnect", BlockTimeout: 1000, HistorySize: 100}, ""},
+		{"drop oldest", FeedBuffer{Size: 1, Policy: "drop-oldest"}, ""},
+		{"no size", FeedBuffer{Policy: "disconnect"}, "size must be positive"},
+		{"block without timeout", FeedBuffer{Size: 100, Policy: "block"}, "blockTimeoutMs must be positive with the block policy"},
+		{"negative history size", FeedBuffer{Size: 100, Policy: "disconnect", HistorySize: -1}, "historySize can't be negative"},
+		{"invalid policy", FeedBuffer{Size: 100, Policy: "wait"}, "invalid policy [wait]"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Act
+			err := tc.buffer.Validate()
+
+			//Assert
+			if len(tc.expected) == 0 {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expected)
+			}
+		})
+	}
+}
+
+func TestBatch_Validate(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		batch    Batch
+		expected string
+	}{
+		{"default", Batch{Parallelism: 4, MaxActions: 100}, ""},
+		{"no parallelism", Batch{MaxActions: 100}, "parallelism must be positive"},
+		{"no max actions", Batch{Parallelism: 4}, "maxActions must be positive"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Act
+			err := tc.batch.Validate()
+
+			//Assert
+			if len(tc.expected) == 0 {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expected)
+			}
+		})
+	}
+}
+
+func TestCoApproval_Quorum(t *testing.T) {
+	var testCases = []struct {
+		name       string
+		coApproval CoApproval
+		actionType string
+		expected   int
+	}{
+		{"disabled", CoApproval{Policies: []CoApprovalPolicy{{Quorum: 2}}}, "ApproveWithdraw", 1},
+		{"matching type", CoApproval{Enabled: true, Policies: []CoApprovalPolicy{{Types: []string{"ApproveWithdraw"}, Quorum: 3}, {Quorum: 2}}}, "ApproveWithdraw", 3},
+		{"any type", CoApproval{Enabled: true, Policies: []CoApprovalPolicy{{Types: []string{"ApproveWithdraw"}, Quorum: 3}, {Quorum: 2}}}, "ApproveTransfer", 2},
+		{"no matching policy", CoApproval{Enabled: true, Policies: []CoApprovalPolicy{{Types: []string{"ApproveWithdraw"}, Quorum: 3}}}, "ApproveTransfer", 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Act
+			quorum := tc.coApproval.Quorum(tc.actionType)
+
+			//Assert
+			assert.Equal(t, tc.expected, quorum)
+		})
+	}
+}
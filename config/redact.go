@@ -0,0 +1,92 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// RedactedValue replaces the non-empty string fields tagged as sensitive
+const RedactedValue = "*****"
+
+// Redact returns a copy of v where the struct fields tagged with `sensitive:"true"` are masked.
+// Sensitive strings are replaced by RedactedValue when set, any other sensitive field is set to its zero value.
+// v is left unchanged
+func Redact(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	return redactValue(reflect.ValueOf(v)).Interface()
+}
+
+// MarshalJSON makes sure the sensitive fields are masked whenever the config is written as JSON
+func (c Config) MarshalJSON() ([]byte, error) {
+	type plain Config
+	redacted := Redact(c).(Config)
+	return json.Marshal(plain(redacted))
+}
+
+// String returns the JSON representation of the config, with the sensitive fields masked, so that it's safe to log
+func (c Config) String() string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
+
+func redactValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.New(v.Elem().Type())
+		copied.Elem().Set(redactValue(v.Elem()))
+		return copied
+	case reflect.Struct:
+		copied := reflect.New(v.Type()).Elem()
+		copied.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+
+			if field.Tag.Get("sensitive") == "true" {
+				copied.Field(i).Set(maskValue(v.Field(i)))
+			} else {
+				copied.Field(i).Set(redactValue(v.Field(i)))
+			}
+		}
+		return copied
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			copied.Index(i).Set(redactValue(v.Index(i)))
+		}
+		return copied
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			copied.SetMapIndex(iter.Key(), redactValue(iter.Value()))
+		}
+		return copied
+	default:
+		return v
+	}
+}
+
+func maskValue(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.String && v.Len() > 0 {
+		return reflect.ValueOf(RedactedValue).Convert(v.Type())
+	}
+	return reflect.Zero(v.Type())
+}
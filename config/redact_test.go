@@ -0,0 +1,78 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testSensitiveConfig() *Config {
+	cfg := &Config{}
+	cfg.Default()
+	cfg.Base.PIN = 1234
+	cfg.LoadBalancing.RedisConfig.Password = "some redis password"
+	cfg.Store.OciConfig.Vault = "ocid1.vault.oc1.some-vault"
+	cfg.Store.AwsConfig.SecretName = "some aws secret"
+	cfg.HTTP.Auth.Tokens = []AuthToken{{Name: "dashboard", Token: "some token"}}
+	return cfg
+}
+
+func TestRedact_masks_sensitive_fields(t *testing.T) {
+	//Arrange
+	cfg := testSensitiveConfig()
+
+	//Act
+	redacted := Redact(cfg).(*Config)
+
+	//Assert
+	assert.Equal(t, 0, redacted.Base.PIN)
+	assert.Equal(t, RedactedValue, redacted.LoadBalancing.RedisConfig.Password)
+	assert.Equal(t, RedactedValue, redacted.Store.OciConfig.Vault)
+	assert.Equal(t, "", redacted.Store.OciConfig.SecretEncryptionKey)
+	assert.Equal(t, RedactedValue, redacted.Store.AwsConfig.SecretName)
+	assert.Equal(t, "dashboard", redacted.HTTP.Auth.Tokens[0].Name)
+	assert.Equal(t, RedactedValue, redacted.HTTP.Auth.Tokens[0].Token)
+	assert.Equal(t, cfg.Base.QredoAPI, redacted.Base.QredoAPI)
+}
+
+func TestRedact_leaves_original_unchanged(t *testing.T) {
+	//Arrange
+	cfg := testSensitiveConfig()
+
+	//Act
+	_ = Redact(cfg)
+
+	//Assert
+	assert.Equal(t, 1234, cfg.Base.PIN)
+	assert.Equal(t, "some redis password", cfg.LoadBalancing.RedisConfig.Password)
+	assert.Equal(t, "some token", cfg.HTTP.Auth.Tokens[0].Token)
+}
+
+func TestConfig_MarshalJSON_masks_sensitive_fields(t *testing.T) {
+	//Arrange
+	cfg := testSensitiveConfig()
+
+	//Act
+	data, err := json.Marshal(cfg)
+
+	//Assert
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), "1234")
+	assert.NotContains(t, string(data), "some redis password")
+	assert.NotContains(t, string(data), "some token")
+	assert.Contains(t, string(data), "\"password\":\"*****\"")
+}
+
+func TestConfig_String_masks_sensitive_fields(t *testing.T) {
+	//Arrange
+	cfg := testSensitiveConfig()
+
+	//Act
+	logged := fmt.Sprintf("%v", cfg)
+
+	//Assert
+	assert.NotContains(t, logged, "some redis password")
+	assert.Contains(t, logged, "\"qredoAPI\":\"https://play-api.qredo.network/api/v1/p\"")
+}
@@ -4,6 +4,8 @@ const AuthHeader = "x-api-zkp"
 
 type RequestContext struct {
 	TraceID string
+	// Identity is the authenticated caller of a protected route
+	Identity string
 }
 
 const (
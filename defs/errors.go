@@ -9,10 +9,13 @@ import (
 
 var KVErrNotFound = errors.New("not found")
 
-func ErrNotFound() *APIError   { return &APIError{code: http.StatusNotFound} }
-func ErrBadRequest() *APIError { return &APIError{code: http.StatusBadRequest} }
-func ErrForbidden() *APIError  { return &APIError{code: http.StatusForbidden} }
-func ErrInternal() *APIError   { return &APIError{code: http.StatusInternalServerError} }
+func ErrNotFound() *APIError           { return &APIError{code: http.StatusNotFound} }
+func ErrBadRequest() *APIError         { return &APIError{code: http.StatusBadRequest} }
+func ErrUnauthorized() *APIError       { return &APIError{code: http.StatusUnauthorized} }
+func ErrForbidden() *APIError          { return &APIError{code: http.StatusForbidden} }
+func ErrConflict() *APIError           { return &APIError{code: http.StatusConflict} }
+func ErrInternal() *APIError           { return &APIError{code: http.StatusInternalServerError} }
+func ErrServiceUnavailable() *APIError { return &APIError{code: http.StatusServiceUnavailable} }
 
 type APIError struct {
 	wrapped error
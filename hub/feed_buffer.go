@@ -0,0 +1,160 @@
+package hub
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/qredo/signing-agent/metrics"
+)
+
+// The policies applied to a client whose buffer is full
+const (
+	PolicyDropOldest = "drop-oldest"
+	PolicyBlock      = "block"
+	PolicyDisconnect = "disconnect"
+)
+
+var clientCount uint64
+
+// clientBuffer keeps the messages broadcast to a client until it takes them from its Feed channel,
+// so that a client busy with a message doesn't miss the next ones.
+// The buffer owns the Feed channel of the client and closes it once the buffer is closed.
+// A buffer of size 0 has no limit, it's used for the internal clients which must not miss any message
+type clientBuffer struct {
+	client   *FeedClient
+	name     string
+	size     int
+	lock     sync.Mutex
+	messages [][]byte
+	added    chan struct{}
+	taken    chan struct{}
+	closed   chan struct{}
+}
+
+func newClientBuffer(client *FeedClient, size int) *clientBuffer {
+	return &clientBuffer{
+		client: client,
+		name:   fmt.Sprintf("%s-%d", metrics.ClientType(client.IsInternal), atomic.AddUint64(&clientCount, 1)),
+		size:   size,
+		added:  make(chan struct{}, 1),
+		taken:  make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+}
+
+// run delivers the buffered messages to the client, oldest first, until the buffer is closed
+func (b *clientBuffer) run() {
+	defer func() {
+		close(b.client.Feed)
+		metrics.FeedClientLag.DeleteLabelValues(b.name)
+	}()
+
+	for {
+		message, ok := b.take()
+		if !ok {
+			select {
+			case <-b.added:
+				continue
+			case <-b.closed:
+				return
+			}
+		}
+
+		select {
+		case b.client.Feed <- message:
+		case <-b.closed:
+			return
+		}
+	}
+}
+
+// close stops the delivery, the messages left in the buffer are dropped
+func (b *clientBuffer) close() {
+	close(b.closed)
+}
+
+// offer adds the message to the buffer when there's room for it
+func (b *clientBuffer) offer(message []byte) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.size > 0 && len(b.messages) >= b.size {
+		return false
+	}
+
+	b.messages = append(b.messages, message)
+	b.updateLag()
+	notify(b.added)
+	return true
+}
+
+// push adds the message to the buffer whatever its size
+func (b *clientBuffer) push(message []byte) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.messages = append(b.messages, message)
+	b.updateLag()
+	notify(b.added)
+}
+
+// replaceOldest drops the oldest message of the buffer to make room for the new one
+func (b *clientBuffer) replaceOldest(message []byte) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if len(b.messages) > 0 {
+		b.messages = b.messages[1:]
+	}
+	b.messages = append(b.messages, message)
+	b.updateLag()
+	notify(b.added)
+}
+
+// waitAndOffer waits up to the timeout for room in the buffer to add the message, it gives up when the buffer is closed
+func (b *clientBuffer) waitAndOffer(message []byte, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-b.taken:
+			if b.offer(message) {
+				return true
+			}
+		case <-timer.C:
+			return b.offer(message)
+		case <-b.closed:
+			return false
+		}
+	}
+}
+
+func (b *clientBuffer) take() ([]byte, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if len(b.messages) == 0 {
+		return nil, false
+	}
+
+	message := b.messages[0]
+	b.messages[0] = nil
+	b.messages = b.messages[1:]
+	b.updateLag()
+	notify(b.taken)
+	return message, true
+}
+
+func (b *clientBuffer) updateLag() {
+	metrics.FeedClientLag.WithLabelValues(b.name).Set(float64(len(b.messages)))
+}
+
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
@@ -3,6 +3,13 @@ package hub
 type FeedClient struct {
 	Feed       chan []byte
 	IsInternal bool
+	// Filter selects the actions delivered to the client, all of them are delivered when nil
+	Filter *FeedFilter
+	// Format, when set, turns every message and its event ID into the data written to the Feed channel, ex. a Server-Sent Event
+	Format func(eventID uint64, message []byte) []byte
+	// ResumeAfter, when set, is the ID of the last event received by the client.
+	// The events of the feed history that came after it are delivered as soon as the client is registered
+	ResumeAfter *uint64
 }
 
 func NewFeedClient(isInternal bool) FeedClient {
@@ -11,3 +18,9 @@ func NewFeedClient(isInternal bool) FeedClient {
 		IsInternal: isInternal,
 	}
 }
+
+// FeedListener is an internal client of the feed hub, processing the messages received on its Feed channel until it's closed
+type FeedListener interface {
+	GetFeedClient() *FeedClient
+	Listen()
+}
@@ -0,0 +1,95 @@
+package hub
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+// The query parameters of the feed upgrade request used to filter the actions received
+const (
+	FilterParamTypes    = "types"
+	FilterParamStatuses = "statuses"
+	FilterParamAgentIDs = "agentIDs"
+)
+
+// FeedFilter selects the actions delivered to a feed client. An empty list matches any value,
+// all the lists must match for an action to be delivered
+type FeedFilter struct {
+	Types    []string
+	Statuses []string
+	AgentIDs []string
+}
+
+// filteredAction holds the fields of an action message the filters are applied to
+type filteredAction struct {
+	AgentID string `json:"coreClientID"`
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+}
+
+// NewFeedFilter returns the filter given by the query parameters, ex. `?types=ApproveWithdraw,ApproveTransfer&statuses=pending`.
+// A parameter can be repeated or hold comma separated values. It returns nil when no filter is given
+func NewFeedFilter(query url.Values) *FeedFilter {
+	filter := &FeedFilter{
+		Types:    filterValues(query, FilterParamTypes),
+		Statuses: filterValues(query, FilterParamStatuses),
+		AgentIDs: filterValues(query, FilterParamAgentIDs),
+	}
+
+	if len(filter.Types) == 0 && len(filter.Statuses) == 0 && len(filter.AgentIDs) == 0 {
+		return nil
+	}
+
+	return filter
+}
+
+// Matches returns true if the action in the message passes the filter. Messages that aren't actions never match
+func (f *FeedFilter) Matches(message []byte) bool {
+	return f.matches(parseFilteredAction(message))
+}
+
+func (f *FeedFilter) matches(action *filteredAction) bool {
+	if action == nil {
+		return false
+	}
+
+	return matchesAny(f.Types, action.Type) && matchesAny(f.Statuses, action.Status) && matchesAny(f.AgentIDs, action.AgentID)
+}
+
+// parseFilteredAction returns nil when the message isn't an action
+func parseFilteredAction(message []byte) *filteredAction {
+	action := &filteredAction{}
+	if err := json.Unmarshal(message, action); err != nil {
+		return nil
+	}
+
+	return action
+}
+
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func filterValues(query url.Values, param string) []string {
+	values := make([]string, 0)
+	for _, value := range query[param] {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); len(v) > 0 {
+				values = append(values, v)
+			}
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,49 @@
+package hub
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewFeedFilter(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		query    string
+		expected *FeedFilter
+	}{
+		{"no filter", "", nil},
+		{"empty values", "types=&statuses=,", nil},
+		{"comma separated", "types=ApproveWithdraw,ApproveTransfer", &FeedFilter{Types: []string{"ApproveWithdraw", "ApproveTransfer"}, Statuses: []string{}, AgentIDs: []string{}}},
+		{"repeated", "statuses=pending&statuses=expired&agentIDs=some%20agent%20id", &FeedFilter{Types: []string{}, Statuses: []string{"pending", "expired"}, AgentIDs: []string{"some agent id"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			query, _ := url.ParseQuery(tc.query)
+
+			//Act
+			filter := NewFeedFilter(query)
+
+			//Assert
+			assert.Equal(t, tc.expected, filter)
+		})
+	}
+}
+
+func TestFeedFilter_Matches(t *testing.T) {
+	//Arrange
+	sut := &FeedFilter{
+		Types:    []string{"ApproveWithdraw", "ApproveTransfer"},
+		Statuses: []string{"pending"},
+	}
+
+	//Act//Assert
+	assert.True(t, sut.Matches([]byte(`{"id":"some id","coreClientID":"some agent id","type":"ApproveWithdraw","status":"pending"}`)))
+	assert.True(t, sut.Matches([]byte(`{"id":"some id","type":"ApproveTransfer","status":"pending"}`)))
+	assert.False(t, sut.Matches([]byte(`{"id":"some id","type":"ApproveWithdraw","status":"expired"}`)))
+	assert.False(t, sut.Matches([]byte(`{"id":"some id","type":"ApproveDeposit","status":"pending"}`)))
+	assert.False(t, sut.Matches([]byte("not json")))
+}
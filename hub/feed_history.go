@@ -0,0 +1,48 @@
+package hub
+
+// feedEvent is a message broadcast by the hub, numbered in the order received
+type feedEvent struct {
+	id      uint64
+	message []byte
+}
+
+// feedHistory numbers the messages broadcast by the hub and keeps the last ones, so that a client
+// can resume from the last event it received. Caller must handle concurrency
+type feedHistory struct {
+	size   int
+	lastID uint64
+	events []feedEvent
+}
+
+func newFeedHistory(size int) *feedHistory {
+	return &feedHistory{
+		size:   size,
+		events: make([]feedEvent, 0, size),
+	}
+}
+
+// add numbers the message and keeps it, dropping the oldest event when the history is full
+func (h *feedHistory) add(message []byte) uint64 {
+	h.lastID++
+	if h.size <= 0 {
+		return h.lastID
+	}
+
+	if len(h.events) == h.size {
+		copy(h.events, h.events[1:])
+		h.events = h.events[:h.size-1]
+	}
+	h.events = append(h.events, feedEvent{id: h.lastID, message: message})
+	return h.lastID
+}
+
+// after returns the events kept that came after the given event ID, oldest first
+func (h *feedHistory) after(eventID uint64) []feedEvent {
+	for i, event := range h.events {
+		if event.id > eventID {
+			return h.events[i:]
+		}
+	}
+
+	return nil
+}
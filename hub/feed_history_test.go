@@ -0,0 +1,36 @@
+package hub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeedHistory_keeps_last_events(t *testing.T) {
+	//Arrange
+	sut := newFeedHistory(2)
+
+	//Act
+	for _, message := range []string{"first", "second", "third"} {
+		sut.add([]byte(message))
+	}
+
+	//Assert
+	assert.Equal(t, uint64(3), sut.lastID)
+	assert.Equal(t, []feedEvent{{id: 2, message: []byte("second")}, {id: 3, message: []byte("third")}}, sut.after(0))
+	assert.Equal(t, []feedEvent{{id: 3, message: []byte("third")}}, sut.after(2))
+	assert.Empty(t, sut.after(3))
+}
+
+func TestFeedHistory_numbers_events_without_keeping_them(t *testing.T) {
+	//Arrange
+	sut := newFeedHistory(0)
+
+	//Act
+	sut.add([]byte("first"))
+	id := sut.add([]byte("second"))
+
+	//Assert
+	assert.Equal(t, uint64(2), id)
+	assert.Empty(t, sut.after(0))
+}
@@ -2,10 +2,13 @@ package hub
 
 import (
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/qredo/signing-agent/config"
 	"github.com/qredo/signing-agent/defs"
+	"github.com/qredo/signing-agent/metrics"
 )
 
 // FeedHub maintains the set of active clients
@@ -14,6 +17,7 @@ import (
 type FeedHub interface {
 	Run() bool
 	Stop()
+	Replay()
 	RegisterClient(client *FeedClient)
 	UnregisterClient(client *FeedClient)
 	IsRunning() bool
@@ -26,9 +30,13 @@ type ConnectedClients interface {
 }
 
 type feedHubImpl struct {
-	source    Source
-	broadcast chan []byte
-	clients   map[*FeedClient]bool
+	source       Source
+	broadcast    chan []byte
+	clients      map[*FeedClient]*clientBuffer
+	history      *feedHistory
+	bufferSize   int
+	policy       string
+	blockTimeout time.Duration
 
 	register   chan *FeedClient
 	unregister chan *FeedClient
@@ -37,15 +45,21 @@ type feedHubImpl struct {
 	isRunning  bool
 }
 
-// NewFeedHub returns a FeedHub object that's an instance of FeedHubImpl
-func NewFeedHub(source Source, log *zap.SugaredLogger) FeedHub {
+// NewFeedHub returns a FeedHub object that's an instance of FeedHubImpl.
+// Every client gets its own buffer of messages, the config policy is applied when it's full.
+// The last messages are kept in a history, so that clients can resume from the last one received
+func NewFeedHub(source Source, log *zap.SugaredLogger, cfg *config.FeedBuffer) FeedHub {
 	return &feedHubImpl{
-		source:     source,
-		log:        log,
-		clients:    make(map[*FeedClient]bool),
-		register:   make(chan *FeedClient),
-		unregister: make(chan *FeedClient),
-		lock:       sync.RWMutex{},
+		source:       source,
+		log:          log,
+		clients:      make(map[*FeedClient]*clientBuffer),
+		history:      newFeedHistory(cfg.HistorySize),
+		bufferSize:   cfg.Size,
+		policy:       cfg.Policy,
+		blockTimeout: time.Duration(cfg.BlockTimeout) * time.Millisecond,
+		register:     make(chan *FeedClient),
+		unregister:   make(chan *FeedClient),
+		lock:         sync.RWMutex{},
 	}
 }
 
@@ -79,13 +93,37 @@ func (w *feedHubImpl) Stop() {
 	}
 }
 
+// Replay broadcasts the actions missed by the source, ex. while the hub was down, to the registered clients
+func (w *feedHubImpl) Replay() {
+	if w.IsRunning() {
+		w.source.Replay()
+	}
+}
+
 // RegisterClient is adding a new active client to send messages to
 func (w *feedHubImpl) RegisterClient(client *FeedClient) {
 	w.lock.Lock()
 	defer w.lock.Unlock()
 
-	w.clients[client] = true
-	w.log.Info("FeedHub: new client registered")
+	if _, registered := w.clients[client]; registered {
+		return
+	}
+
+	size := w.bufferSize
+	if client.IsInternal {
+		size = 0 //the policy isn't applied to the internal clients, they get every message
+	}
+
+	buffer := newClientBuffer(client, size)
+	w.clients[client] = buffer
+	go buffer.run()
+
+	metrics.FeedClientsConnected.WithLabelValues(metrics.ClientType(client.IsInternal)).Inc()
+	w.log.Infof("FeedHub: new client [%v] registered", buffer.name)
+
+	if client.ResumeAfter != nil {
+		w.resume(client, buffer)
+	}
 }
 
 // UnregisterClient is removing a registered client and closes its Feed channel
@@ -93,10 +131,8 @@ func (w *feedHubImpl) UnregisterClient(client *FeedClient) {
 	w.lock.Lock()
 	defer w.lock.Unlock()
 
-	registered := w.clients[client]
-	if registered {
-		close(client.Feed)
-		delete(w.clients, client)
+	if _, registered := w.clients[client]; registered {
+		w.removeClient(client)
 		w.log.Info("FeedHub: client unregistered")
 	}
 }
@@ -121,8 +157,7 @@ func (w *feedHubImpl) cleanUp() {
 
 	for client := range w.clients {
 		w.log.Infof("FeedHub: closing feed clients")
-		close(client.Feed)
-		delete(w.clients, client)
+		w.removeClient(client)
 	}
 }
 
@@ -140,18 +175,113 @@ func (w *feedHubImpl) startHub(wg *sync.WaitGroup) {
 			w.log.Infof("FeedHub: the broadcast channel was closed")
 			return
 		} else {
-			w.lock.Lock()
-			w.log.Infof("FeedHub: message received [%v]", string(message))
-			for client := range w.clients {
-				select {
-				case client.Feed <- message:
-				default:
-					w.log.Debugf("FeedHub: client not listening, removing client")
-					close(client.Feed)
-					delete(w.clients, client)
-				}
+			w.waitForRoom(w.deliverAll(message))
+		}
+	}
+}
+
+// deliverAll adds the message to the buffers of the matching clients, applying the policy to the full ones.
+// It returns the messages left to wait for room in the buffers of the clients with the block policy
+func (w *feedHubImpl) deliverAll(message []byte) map[*clientBuffer][]byte {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.log.Infof("FeedHub: message received [%v]", string(message))
+	metrics.FeedMessagesReceived.Inc()
+	event := feedEvent{id: w.history.add(message), message: message}
+	action := parseFilteredAction(message)
+	blocked := make(map[*clientBuffer][]byte)
+	for client, buffer := range w.clients {
+		if client.Filter != nil && !client.Filter.matches(action) {
+			continue
+		}
+
+		data := formatEvent(client, event)
+		if buffer.offer(data) {
+			continue
+		}
+
+		metrics.FeedBufferOverflows.WithLabelValues(metrics.ClientType(client.IsInternal), w.policy).Inc()
+		switch w.policy {
+		case PolicyDropOldest:
+			w.log.Warnf("FeedHub: buffer of client [%v] is full, dropping its oldest message", buffer.name)
+			buffer.replaceOldest(data)
+		case PolicyBlock:
+			blocked[buffer] = data
+		default:
+			w.log.Warnf("FeedHub: buffer of client [%v] is full, removing client", buffer.name)
+			w.removeClient(client)
+		}
+	}
+
+	return blocked
+}
+
+// waitForRoom waits, without holding the hub lock, for room in the buffers of the blocked clients, each one in its own goroutine.
+// The clients whose buffer is still full after the block timeout are removed
+func (w *feedHubImpl) waitForRoom(blocked map[*clientBuffer][]byte) {
+	if len(blocked) == 0 {
+		return
+	}
+
+	var (
+		wg       sync.WaitGroup
+		fullLock sync.Mutex
+		full     []*clientBuffer
+	)
+
+	for buffer, message := range blocked {
+		wg.Add(1)
+		go func(buffer *clientBuffer, message []byte) {
+			defer wg.Done()
+			if !buffer.waitAndOffer(message, w.blockTimeout) {
+				fullLock.Lock()
+				full = append(full, buffer)
+				fullLock.Unlock()
 			}
-			w.lock.Unlock()
+		}(buffer, message)
+	}
+	wg.Wait()
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for _, buffer := range full {
+		//the client may have been unregistered while waiting
+		if w.clients[buffer.client] == buffer {
+			w.log.Warnf("FeedHub: buffer of client [%v] is still full, removing client", buffer.name)
+			w.removeClient(buffer.client)
 		}
 	}
 }
+
+// resume delivers the events of the history that came after the last one received by the client.
+// They're added to its buffer whatever its size, the history size bounds them. Caller must handle concurrency
+func (w *feedHubImpl) resume(client *FeedClient, buffer *clientBuffer) {
+	events := w.history.after(*client.ResumeAfter)
+	w.log.Infof("FeedHub: resuming client [%v] after event %d, %d event(s) to deliver", buffer.name, *client.ResumeAfter, len(events))
+
+	for _, event := range events {
+		if client.Filter != nil && !client.Filter.Matches(event.message) {
+			continue
+		}
+
+		buffer.push(formatEvent(client, event))
+	}
+}
+
+// formatEvent returns the data written to the Feed channel of the client for the event
+func formatEvent(client *FeedClient, event feedEvent) []byte {
+	if client.Format == nil {
+		return event.message
+	}
+
+	return client.Format(event.id, event.message)
+}
+
+// removeClient closes the client's buffer, which closes its Feed channel, and removes it from the active clients. Caller must handle concurrency
+func (w *feedHubImpl) removeClient(client *FeedClient) {
+	w.clients[client].close()
+	delete(w.clients, client)
+	metrics.FeedClientsConnected.WithLabelValues(metrics.ClientType(client.IsInternal)).Dec()
+}
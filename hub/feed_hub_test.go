@@ -1,6 +1,7 @@
 package hub
 
 import (
+	"fmt"
 	"sync"
 	"testing"
 	"time"
@@ -8,6 +9,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/goleak"
 
+	"github.com/qredo/signing-agent/config"
 	"github.com/qredo/signing-agent/defs"
 	"github.com/qredo/signing-agent/util"
 )
@@ -17,6 +19,7 @@ type mockSourceConnection struct {
 	ListenCalled        bool
 	DisconnectCalled    bool
 	GetReadyStateCalled bool
+	ReplayCalled        bool
 	NextConnect         bool
 	NextReadyState      string
 	RxMessages          chan []byte
@@ -37,6 +40,10 @@ func (m *mockSourceConnection) Listen(wg *sync.WaitGroup) {
 	wg.Done()
 }
 
+func (m *mockSourceConnection) Replay() {
+	m.ReplayCalled = true
+}
+
 func (m *mockSourceConnection) GetFeedUrl() string {
 	return ""
 }
@@ -50,11 +57,13 @@ func (m *mockSourceConnection) GetSendChannel() chan []byte {
 	return m.RxMessages
 }
 
+var testFeedBuffer = &config.FeedBuffer{Size: 1, Policy: PolicyDisconnect}
+
 func TestFeedHub_Run_fails_to_connect(t *testing.T) {
 	//Arrange
 	defer goleak.VerifyNone(t)
 	mockSourceConn := &mockSourceConnection{}
-	feedHub := NewFeedHub(mockSourceConn, util.NewTestLogger())
+	feedHub := NewFeedHub(mockSourceConn, util.NewTestLogger(), testFeedBuffer)
 
 	//Act
 	res := feedHub.Run()
@@ -72,7 +81,7 @@ func TestFeedHub_Run_connects_and_listens(t *testing.T) {
 		NextConnect: true,
 		RxMessages:  make(chan []byte),
 	}
-	feedHub := NewFeedHub(mockSourceConn, util.NewTestLogger())
+	feedHub := NewFeedHub(mockSourceConn, util.NewTestLogger(), testFeedBuffer)
 
 	//Act
 	res := feedHub.Run()
@@ -91,7 +100,7 @@ func TestFeedHub_Stop_not_connected(t *testing.T) {
 	mockSourceConn := &mockSourceConnection{
 		NextConnect: true,
 	}
-	feedHub := NewFeedHub(mockSourceConn, util.NewTestLogger())
+	feedHub := NewFeedHub(mockSourceConn, util.NewTestLogger(), testFeedBuffer)
 
 	//Act
 	feedHub.Stop()
@@ -107,7 +116,7 @@ func TestFeedHub_Stop_connected(t *testing.T) {
 		NextConnect:    true,
 		NextReadyState: defs.ConnectionState.Open,
 	}
-	feedHub := NewFeedHub(mockSourceConn, util.NewTestLogger())
+	feedHub := NewFeedHub(mockSourceConn, util.NewTestLogger(), testFeedBuffer)
 
 	//Act
 	feedHub.Stop()
@@ -117,12 +126,29 @@ func TestFeedHub_Stop_connected(t *testing.T) {
 	assert.True(t, mockSourceConn.DisconnectCalled)
 }
 
+func TestFeedHub_Replay(t *testing.T) {
+	//Arrange
+	mockSourceConn := &mockSourceConnection{}
+	feedHub := NewFeedHub(mockSourceConn, util.NewTestLogger(), testFeedBuffer).(*feedHubImpl)
+
+	//Act
+	feedHub.Replay()
+	notRunningReplayCalled := mockSourceConn.ReplayCalled
+	feedHub.isRunning = true
+	feedHub.Replay()
+
+	//Assert
+	assert.False(t, notRunningReplayCalled)
+	assert.True(t, mockSourceConn.ReplayCalled)
+}
+
 func TestFeedHub_Register_Unregister_client(t *testing.T) {
 	//Arrange
 	defer goleak.VerifyNone(t)
 	feedHub := &feedHubImpl{
-		clients: make(map[*FeedClient]bool),
-		log:     util.NewTestLogger(),
+		clients:    make(map[*FeedClient]*clientBuffer),
+		bufferSize: 1,
+		log:        util.NewTestLogger(),
 	}
 	client := &FeedClient{
 		Feed: make(chan []byte),
@@ -134,6 +160,8 @@ func TestFeedHub_Register_Unregister_client(t *testing.T) {
 
 	feedHub.UnregisterClient(client)
 	assert.Equal(t, 0, len(feedHub.clients))
+	_, open := <-client.Feed
+	assert.False(t, open)
 }
 
 func TestFeedHub_removes_unlistening_client(t *testing.T) {
@@ -141,25 +169,20 @@ func TestFeedHub_removes_unlistening_client(t *testing.T) {
 	defer goleak.VerifyNone(t)
 
 	client := NewFeedClient(false)
-	feedHub := &feedHubImpl{
-		log:       util.NewTestLogger(),
-		clients:   map[*FeedClient]bool{&client: true},
-		broadcast: make(chan []byte),
-	}
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go feedHub.startHub(&wg)
-	wg.Wait()
+	feedHub := newTestFeedHub(PolicyDisconnect)
+	feedHub.RegisterClient(&client)
 
 	//Act
-	go func() {
+	for i := 0; i < 3; i++ {
 		feedHub.broadcast <- []byte("some message")
-	}()
+	}
 
 	<-time.After(time.Second)
 
 	//Assert
+	feedHub.lock.Lock()
 	assert.Equal(t, 0, len(feedHub.clients))
+	feedHub.lock.Unlock()
 	close(feedHub.broadcast)
 }
 
@@ -168,10 +191,12 @@ func TestFeedHub_GetExternalFeedClients(t *testing.T) {
 	defer goleak.VerifyNone(t)
 
 	feedHub := &feedHubImpl{
-		log:       util.NewTestLogger(),
-		clients:   make(map[*FeedClient]bool),
-		broadcast: make(chan []byte),
+		log:        util.NewTestLogger(),
+		clients:    make(map[*FeedClient]*clientBuffer),
+		bufferSize: 1,
+		broadcast:  make(chan []byte),
 	}
+	defer feedHub.cleanUp()
 
 	var wg sync.WaitGroup
 	wg.Add(4)
@@ -190,3 +215,228 @@ func TestFeedHub_GetExternalFeedClients(t *testing.T) {
 
 	assert.Equal(t, 4, feedHub.GetExternalFeedClients())
 }
+
+// newTestFeedHub returns a running feed hub with buffers of a single message
+func newTestFeedHub(policy string) *feedHubImpl {
+	feedHub := &feedHubImpl{
+		log:          util.NewTestLogger(),
+		clients:      make(map[*FeedClient]*clientBuffer),
+		bufferSize:   1,
+		policy:       policy,
+		blockTimeout: 100 * time.Millisecond,
+		broadcast:    make(chan []byte),
+		history:      newFeedHistory(2),
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go feedHub.startHub(&wg)
+	wg.Wait()
+	return feedHub
+}
+
+func TestFeedHub_buffers_messages_of_busy_client(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	client := NewFeedClient(true)
+	feedHub := newTestFeedHub(PolicyDisconnect)
+	feedHub.RegisterClient(&client)
+
+	//Act
+	for _, message := range []string{"first message", "second message"} {
+		feedHub.broadcast <- []byte(message)
+		<-time.After(100 * time.Millisecond)
+	}
+
+	//Assert
+	assert.Equal(t, "first message", string(<-client.Feed))
+	assert.Equal(t, "second message", string(<-client.Feed))
+	assert.Equal(t, 1, len(feedHub.clients))
+	close(feedHub.broadcast)
+}
+
+func TestFeedHub_drops_oldest_message_of_full_buffer(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	client := NewFeedClient(false)
+	feedHub := newTestFeedHub(PolicyDropOldest)
+	feedHub.RegisterClient(&client)
+
+	//Act
+	for _, message := range []string{"first message", "second message", "third message"} {
+		feedHub.broadcast <- []byte(message)
+		<-time.After(100 * time.Millisecond)
+	}
+
+	//Assert
+	assert.Equal(t, "first message", string(<-client.Feed))
+	assert.Equal(t, "third message", string(<-client.Feed))
+	assert.Equal(t, 1, len(feedHub.clients))
+	close(feedHub.broadcast)
+}
+
+func TestFeedHub_block_waits_for_room_in_buffer(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	client := NewFeedClient(false)
+	feedHub := newTestFeedHub(PolicyBlock)
+	feedHub.RegisterClient(&client)
+	first := make(chan string)
+
+	//Act
+	feedHub.broadcast <- []byte("first message")
+	feedHub.broadcast <- []byte("second message")
+	go func() {
+		<-time.After(50 * time.Millisecond)
+		first <- string(<-client.Feed)
+	}()
+	feedHub.broadcast <- []byte("third message")
+	firstReceived := <-first
+	secondReceived := string(<-client.Feed)
+
+	//Assert
+	assert.Equal(t, "first message", firstReceived)
+	assert.Equal(t, "second message", secondReceived)
+	assert.Equal(t, 1, len(feedHub.clients))
+	close(feedHub.broadcast)
+}
+
+func TestFeedHub_block_removes_client_after_timeout(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	client := NewFeedClient(false)
+	feedHub := newTestFeedHub(PolicyBlock)
+	feedHub.RegisterClient(&client)
+
+	//Act
+	for i := 0; i < 3; i++ {
+		feedHub.broadcast <- []byte("some message")
+	}
+	<-time.After(300 * time.Millisecond)
+
+	//Assert
+	feedHub.lock.Lock()
+	assert.Equal(t, 0, len(feedHub.clients))
+	feedHub.lock.Unlock()
+	close(feedHub.broadcast)
+}
+
+func TestFeedHub_block_waits_without_holding_the_hub_lock(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	slow := NewFeedClient(false)
+	fast := NewFeedClient(false)
+	feedHub := newTestFeedHub(PolicyBlock)
+	feedHub.blockTimeout = time.Second
+	feedHub.RegisterClient(&slow)
+	feedHub.RegisterClient(&fast)
+	go func() {
+		for range fast.Feed {
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		feedHub.broadcast <- []byte("some message")
+	}
+
+	//Act
+	start := time.Now()
+	other := NewFeedClient(false)
+	feedHub.RegisterClient(&other)
+	clients := feedHub.GetExternalFeedClients()
+	feedHub.UnregisterClient(&other)
+
+	//Assert
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Equal(t, 3, clients)
+	<-time.After(1500 * time.Millisecond)
+	assert.Equal(t, 1, feedHub.GetExternalFeedClients())
+	close(feedHub.broadcast)
+}
+
+func TestFeedHub_policy_not_applied_to_internal_client(t *testing.T) {
+	for _, policy := range []string{PolicyDisconnect, PolicyDropOldest, PolicyBlock} {
+		t.Run(policy, func(t *testing.T) {
+			//Arrange
+			defer goleak.VerifyNone(t)
+			client := NewFeedClient(true)
+			feedHub := newTestFeedHub(policy)
+			feedHub.RegisterClient(&client)
+			messages := []string{"first message", "second message", "third message"}
+
+			//Act
+			for _, message := range messages {
+				feedHub.broadcast <- []byte(message)
+			}
+			<-time.After(200 * time.Millisecond)
+
+			//Assert
+			for _, message := range messages {
+				assert.Equal(t, message, string(<-client.Feed))
+			}
+			feedHub.lock.Lock()
+			assert.Equal(t, 1, len(feedHub.clients))
+			feedHub.lock.Unlock()
+			close(feedHub.broadcast)
+		})
+	}
+}
+
+func TestFeedHub_delivers_matching_actions_to_filtered_client(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	withdrawals := NewFeedClient(false)
+	withdrawals.Filter = &FeedFilter{Types: []string{"ApproveWithdraw"}}
+	all := NewFeedClient(false)
+	feedHub := newTestFeedHub(PolicyDropOldest)
+	feedHub.RegisterClient(&withdrawals)
+	feedHub.RegisterClient(&all)
+
+	//Act
+	feedHub.broadcast <- []byte(`{"id":"transfer id","type":"ApproveTransfer","status":"pending"}`)
+	<-time.After(100 * time.Millisecond)
+	feedHub.broadcast <- []byte(`{"id":"withdraw id","type":"ApproveWithdraw","status":"pending"}`)
+
+	//Assert
+	assert.Contains(t, string(<-withdrawals.Feed), "withdraw id")
+	assert.Contains(t, string(<-all.Feed), "transfer id")
+	assert.Contains(t, string(<-all.Feed), "withdraw id")
+	close(feedHub.broadcast)
+	_, open := <-withdrawals.Feed
+	assert.False(t, open)
+}
+
+func TestFeedHub_resumes_client_after_last_event_received(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	feedHub := newTestFeedHub(PolicyDropOldest)
+	feedHub.history = newFeedHistory(3)
+	feedHub.bufferSize = 3
+	for _, message := range []string{
+		`{"id":"first id","type":"ApproveWithdraw"}`,
+		`{"id":"second id","type":"ApproveTransfer"}`,
+		`{"id":"third id","type":"ApproveWithdraw"}`,
+	} {
+		feedHub.broadcast <- []byte(message)
+	}
+	<-time.After(100 * time.Millisecond)
+
+	lastEventID := uint64(1)
+	client := NewFeedClient(false)
+	client.ResumeAfter = &lastEventID
+	client.Filter = &FeedFilter{Types: []string{"ApproveWithdraw"}}
+	client.Format = func(eventID uint64, message []byte) []byte {
+		return []byte(fmt.Sprintf("%d %s", eventID, message))
+	}
+
+	//Act
+	feedHub.RegisterClient(&client)
+	feedHub.broadcast <- []byte(`{"id":"fourth id","type":"ApproveWithdraw"}`)
+
+	//Assert
+	assert.Equal(t, `3 {"id":"third id","type":"ApproveWithdraw"}`, string(<-client.Feed))
+	assert.Equal(t, `4 {"id":"fourth id","type":"ApproveWithdraw"}`, string(<-client.Feed))
+	close(feedHub.broadcast)
+	_, open := <-client.Feed
+	assert.False(t, open)
+}
@@ -2,6 +2,8 @@ package hub
 
 import (
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"sync"
 	"time"
@@ -12,6 +14,7 @@ import (
 	"github.com/qredo/signing-agent/config"
 	"github.com/qredo/signing-agent/defs"
 	"github.com/qredo/signing-agent/lib"
+	"github.com/qredo/signing-agent/metrics"
 )
 
 // Source has an underlying websocket connection used to receive messages. It will send these messages to an outbound channel
@@ -19,6 +22,7 @@ type Source interface {
 	Connect() bool
 	Disconnect()
 	Listen(wg *sync.WaitGroup)
+	Replay()
 	GetSendChannel() chan []byte
 	SourceStats
 }
@@ -41,8 +45,23 @@ type websocketSource struct {
 	reconnectInterval    time.Duration
 	rxMessages           chan []byte
 	lock                 sync.RWMutex
+	replay               bool
+	seen                 map[string]int64 // the ids of the actions received, with their expire time
+	seenLock             sync.Mutex
+	lastPrune            time.Time
+	sendLock             sync.Mutex
+	sending              sync.WaitGroup // the messages being sent to the outbound channel
+	closed               bool
+	done                 chan struct{} // closed when the outbound channel is about to be closed
 }
 
+const (
+	// seenPruneInterval is the minimum time between two removals of the expired actions from the seen ones
+	seenPruneInterval = time.Minute
+	// seenTTL is how long an action received without an expire time is kept with the seen ones
+	seenTTL = time.Hour
+)
+
 // NewWebsocketSource returns a Source object that's an instance of websocketSource
 func NewWebsocketSource(dialer WebsocketDialer, feedUrl string, log *zap.SugaredLogger, core lib.SigningAgentClient, config *config.WebSocketConfig) Source {
 	return &websocketSource{
@@ -56,6 +75,9 @@ func NewWebsocketSource(dialer WebsocketDialer, feedUrl string, log *zap.Sugared
 		reconnectInterval:    time.Duration(config.ReconnectInterval) * time.Second,
 		rxMessages:           make(chan []byte),
 		lock:                 sync.RWMutex{},
+		replay:               config.ReplayMissedActions,
+		seen:                 make(map[string]int64),
+		done:                 make(chan struct{}),
 	}
 }
 
@@ -71,6 +93,7 @@ func (w *websocketSource) Connect() bool {
 			return true
 		} else {
 			w.log.Errorf("WebsocketSource: cannot connect to feed: %v, retry connection in %v", err, w.reconnectInterval)
+			metrics.WebsocketConnectErrors.Inc()
 			time.Sleep(w.reconnectInterval)
 		}
 	}
@@ -84,7 +107,7 @@ func (w *websocketSource) Connect() bool {
 func (w *websocketSource) Listen(wg *sync.WaitGroup) {
 	defer func() {
 		w.conn.Close()
-		close(w.rxMessages)
+		w.closeSendChannel()
 	}()
 
 	wg.Done()
@@ -98,16 +121,67 @@ func (w *websocketSource) Listen(wg *sync.WaitGroup) {
 			}
 			//either connection issue or issue reading the message
 			w.log.Errorf("WebsocketSource: unexpected connection error: %v", err)
+			metrics.WebsocketReconnects.Inc()
 			if !w.Connect() {
 				return
 			}
+			w.Replay()
 		} else {
-			w.rxMessages <- message
+			w.markSeen(message)
+			w.send(message)
 		}
 	}
 
 }
 
+// Replay queries the Qredo API for the pending actions of the agent and sends the ones not received yet,
+// ex. the actions pushed while the connection was down, to the outbound channel
+// The replay is turned off for good when the Qredo API doesn't serve the pending actions
+func (w *websocketSource) Replay() {
+	w.lock.RLock()
+	replay := w.replay
+	w.lock.RUnlock()
+	if !replay {
+		return
+	}
+
+	actions, err := w.core.GetPendingActions()
+	if err != nil {
+		if errors.Is(err, lib.ErrPendingActionsNotFound) {
+			w.log.Warnf("WebsocketSource: the Qredo API doesn't serve the pending actions, the missed actions won't be replayed: %v", err)
+			w.lock.Lock()
+			w.replay = false
+			w.lock.Unlock()
+			return
+		}
+		w.log.Errorf("WebsocketSource: failed to get the pending actions to replay: %v", err)
+		return
+	}
+
+	w.pruneSeen()
+
+	replayed := 0
+	for _, action := range actions {
+		if action == nil || !w.setSeen(action.ID, action.ExpireTime) {
+			continue
+		}
+
+		message, err := json.Marshal(action)
+		if err != nil {
+			w.log.Errorf("WebsocketSource: failed to marshal the action [%v] to replay: %v", action.ID, err)
+			continue
+		}
+
+		if !w.send(message) {
+			return
+		}
+		replayed++
+	}
+
+	metrics.FeedActionsReplayed.Add(float64(replayed))
+	w.log.Infof("WebsocketSource: replayed %d missed action(s) of %d pending", replayed, len(actions))
+}
+
 // Disconnect is closing the websocket upon request and signals the reconnect should not happen
 func (w *websocketSource) Disconnect() {
 	w.log.Infof("WebsocketSource: disconnecting from feed %v", w.feedUrl)
@@ -164,6 +238,95 @@ func (w *websocketSource) genConnectHeaders() (http.Header, error) {
 	return headers, nil
 }
 
+// send sends the message to the outbound channel, unless already closed. The lock isn't held while waiting
+// for the receiver, the message is dropped when the channel is closed in the meantime
+func (w *websocketSource) send(message []byte) bool {
+	w.sendLock.Lock()
+	if w.closed {
+		w.sendLock.Unlock()
+		return false
+	}
+	w.sending.Add(1)
+	w.sendLock.Unlock()
+	defer w.sending.Done()
+
+	select {
+	case w.rxMessages <- message:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// closeSendChannel closes the outbound channel, once the messages being sent are either received or dropped
+func (w *websocketSource) closeSendChannel() {
+	w.sendLock.Lock()
+	if w.closed {
+		w.sendLock.Unlock()
+		return
+	}
+	w.closed = true
+	close(w.done)
+	w.sendLock.Unlock()
+
+	w.sending.Wait()
+	close(w.rxMessages)
+}
+
+// markSeen records the action received on the feed, so that it's not replayed
+func (w *websocketSource) markSeen(message []byte) {
+	var action lib.WsActionInfoEvent
+	if err := json.Unmarshal(message, &action); err != nil || len(action.ID) == 0 {
+		return
+	}
+
+	w.setSeen(action.ID, action.ExpireTime)
+}
+
+// setSeen records the action and returns true if it wasn't seen before.
+// The expired actions are forgotten from time to time, the ones without an expire time are kept for seenTTL
+func (w *websocketSource) setSeen(actionID string, expireTime int64) bool {
+	w.seenLock.Lock()
+	defer w.seenLock.Unlock()
+
+	if w.seen == nil {
+		w.seen = make(map[string]int64)
+	}
+
+	if time.Since(w.lastPrune) >= seenPruneInterval {
+		w.prune()
+	}
+
+	if _, ok := w.seen[actionID]; ok {
+		return false
+	}
+
+	if expireTime <= 0 {
+		expireTime = time.Now().Add(seenTTL).Unix()
+	}
+	w.seen[actionID] = expireTime
+	return true
+}
+
+// pruneSeen forgets the expired actions, as they can't be pending anymore
+func (w *websocketSource) pruneSeen() {
+	w.seenLock.Lock()
+	defer w.seenLock.Unlock()
+
+	w.prune()
+}
+
+// prune removes the expired actions from the seen ones. Caller must handle concurrency
+func (w *websocketSource) prune() {
+	now := time.Now()
+	w.lastPrune = now
+	for actionID, expireTime := range w.seen {
+		if expireTime < now.Unix() {
+			delete(w.seen, actionID)
+		}
+	}
+}
+
 func (w *websocketSource) setReadyState(state string) {
 	w.lock.Lock()
 	defer w.lock.Unlock()
@@ -1,8 +1,11 @@
 package hub
 
 import (
+	"encoding/json"
+	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
@@ -133,6 +136,7 @@ func TestWebsocketSource_Listen_don_t_reconnect(t *testing.T) {
 		readyState:      defs.ConnectionState.Closed,
 		log:             util.NewTestLogger(),
 		rxMessages:      make(chan []byte),
+		done:            make(chan struct{}),
 	}
 
 	var wg sync.WaitGroup
@@ -163,6 +167,7 @@ func TestWebsocketSource_Listen_sends_message(t *testing.T) {
 	sut := &websocketSource{
 		conn:       mock_conn,
 		rxMessages: make(chan []byte),
+		done:       make(chan struct{}),
 	}
 	var (
 		message       []byte
@@ -198,3 +203,120 @@ func TestWebsocketSource_Listen_sends_message(t *testing.T) {
 	sut.shouldReconnect = false
 	mock_conn.read <- true
 }
+
+func TestWebsocketSource_Replay_sends_missed_actions_once(t *testing.T) {
+	//Arrange
+	mock_core := &lib.MockSigningAgentClient{
+		NextPendingActions: []*lib.WsActionInfoEvent{
+			{ID: "received action", Status: "pending"},
+			{ID: "missed action", Status: "pending", ExpireTime: time.Now().Add(time.Hour).Unix()},
+		},
+	}
+	sut := NewWebsocketSource(nil, "feed", util.NewTestLogger(), mock_core, &config.WebSocketConfig{ReplayMissedActions: true}).(*websocketSource)
+	sut.rxMessages = make(chan []byte, 10)
+	sut.markSeen([]byte(`{"id":"received action","status":"pending"}`))
+
+	//Act
+	sut.Replay()
+	sut.Replay()
+
+	//Assert
+	assert.True(t, mock_core.GetPendingActionsCalled)
+	assert.Len(t, sut.rxMessages, 1)
+	replayed := &lib.WsActionInfoEvent{}
+	assert.Nil(t, json.Unmarshal(<-sut.rxMessages, replayed))
+	assert.Equal(t, "missed action", replayed.ID)
+	assert.Equal(t, "pending", replayed.Status)
+}
+
+func TestWebsocketSource_Replay_forgets_expired_actions(t *testing.T) {
+	//Arrange
+	mock_core := &lib.MockSigningAgentClient{}
+	sut := NewWebsocketSource(nil, "feed", util.NewTestLogger(), mock_core, &config.WebSocketConfig{ReplayMissedActions: true}).(*websocketSource)
+	sut.markSeen([]byte(fmt.Sprintf(`{"id":"expired action","expireTime":%d}`, time.Now().Add(-time.Minute).Unix())))
+	sut.markSeen([]byte(fmt.Sprintf(`{"id":"action","expireTime":%d}`, time.Now().Add(time.Minute).Unix())))
+
+	//Act
+	sut.Replay()
+
+	//Assert
+	assert.Len(t, sut.seen, 1)
+	assert.Contains(t, sut.seen, "action")
+}
+
+func TestWebsocketSource_markSeen_forgets_expired_actions(t *testing.T) {
+	//Arrange
+	sut := NewWebsocketSource(nil, "feed", util.NewTestLogger(), nil, &config.WebSocketConfig{}).(*websocketSource)
+	sut.markSeen([]byte(fmt.Sprintf(`{"id":"expired action","expireTime":%d}`, time.Now().Add(-time.Minute).Unix())))
+	sut.lastPrune = time.Now().Add(-seenPruneInterval)
+
+	//Act
+	sut.markSeen([]byte(`{"id":"action without expire time"}`))
+
+	//Assert
+	assert.Len(t, sut.seen, 1)
+	assert.Greater(t, sut.seen["action without expire time"], time.Now().Unix())
+}
+
+func TestWebsocketSource_closeSendChannel_drops_message_being_sent(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	sut := NewWebsocketSource(nil, "feed", util.NewTestLogger(), nil, &config.WebSocketConfig{}).(*websocketSource)
+	sent := make(chan bool)
+	go func() {
+		sent <- sut.send([]byte("some message"))
+	}()
+	<-time.After(50 * time.Millisecond)
+
+	//Act
+	sut.closeSendChannel()
+
+	//Assert
+	assert.False(t, <-sent)
+	assert.False(t, sut.send([]byte("some other message")))
+	_, ok := <-sut.rxMessages
+	assert.False(t, ok)
+}
+
+func TestWebsocketSource_Replay_disabled(t *testing.T) {
+	//Arrange
+	mock_core := &lib.MockSigningAgentClient{}
+	sut := NewWebsocketSource(nil, "feed", util.NewTestLogger(), mock_core, &config.WebSocketConfig{ReplayMissedActions: false})
+
+	//Act
+	sut.Replay()
+
+	//Assert
+	assert.False(t, mock_core.GetPendingActionsCalled)
+}
+
+func TestWebsocketSource_Replay_turned_off_when_endpoint_not_found(t *testing.T) {
+	//Arrange
+	mock_core := &lib.MockSigningAgentClient{NextPendingActionsError: lib.ErrPendingActionsNotFound}
+	sut := NewWebsocketSource(nil, "feed", util.NewTestLogger(), mock_core, &config.WebSocketConfig{ReplayMissedActions: true}).(*websocketSource)
+	sut.Replay()
+	mock_core.GetPendingActionsCalled = false
+
+	//Act
+	sut.Replay()
+
+	//Assert
+	assert.False(t, mock_core.GetPendingActionsCalled)
+}
+
+func TestWebsocketSource_Replay_stops_when_closed(t *testing.T) {
+	//Arrange
+	mock_core := &lib.MockSigningAgentClient{
+		NextPendingActions: []*lib.WsActionInfoEvent{{ID: "missed action"}},
+	}
+	sut := NewWebsocketSource(nil, "feed", util.NewTestLogger(), mock_core, &config.WebSocketConfig{ReplayMissedActions: true}).(*websocketSource)
+	sut.closeSendChannel()
+
+	//Act
+	sut.Replay()
+
+	//Assert
+	assert.True(t, mock_core.GetPendingActionsCalled)
+	_, ok := <-sut.rxMessages
+	assert.False(t, ok)
+}
@@ -0,0 +1,60 @@
+package journal
+
+import (
+	"encoding/json"
+
+	"go.uber.org/zap"
+
+	"github.com/qredo/signing-agent/hub"
+	"github.com/qredo/signing-agent/lib"
+)
+
+// FeedRecorder is an internal feed client recording every action received on the feed
+type FeedRecorder struct {
+	hub.FeedClient
+	journal Journal
+	log     *zap.SugaredLogger
+}
+
+// NewFeedRecorder returns a new *FeedRecorder writing the received actions to the journal
+func NewFeedRecorder(journal Journal, log *zap.SugaredLogger) *FeedRecorder {
+	return &FeedRecorder{
+		FeedClient: hub.NewFeedClient(true),
+		journal:    journal,
+		log:        log,
+	}
+}
+
+// GetFeedClient returns the FeedClient registered to the feed hub
+func (r *FeedRecorder) GetFeedClient() *hub.FeedClient {
+	return &r.FeedClient
+}
+
+// Listen is constantly listening for messages on the Feed channel until it's closed by the sender
+func (r *FeedRecorder) Listen() {
+	for {
+		if message, ok := <-r.Feed; !ok {
+			r.log.Info("FeedRecorder: stopped")
+			return
+		} else {
+			r.record(message)
+		}
+	}
+}
+
+func (r *FeedRecorder) record(message []byte) {
+	var action lib.WsActionInfoEvent
+	if err := json.Unmarshal(message, &action); err != nil {
+		r.log.Errorf("FeedRecorder: error [%v] while unmarshaling the message [%v]", err, string(message))
+		return
+	}
+
+	entry := NewEntry(action.ID, EventReceived, SourceFeed, action.Status)
+	entry.AgentID = action.AgentID
+	entry.Type = action.Type
+	entry.ExpireTime = action.ExpireTime
+
+	if err := r.journal.Record(entry); err != nil {
+		r.log.Errorf("FeedRecorder: failed to record action [%v], err: %v", action.ID, err)
+	}
+}
@@ -0,0 +1,35 @@
+package journal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+
+	"github.com/qredo/signing-agent/util"
+)
+
+func TestFeedRecorder_Listen_records_actions(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	journalMock := &MockJournal{}
+	sut := NewFeedRecorder(journalMock, util.NewTestLogger())
+	go sut.Listen()
+
+	//Act
+	sut.Feed <- []byte(`{"id":"action id","coreClientID":"agent id","type":"ApproveWithdraw","status":"pending","expireTime":1676184187}`)
+	sut.Feed <- []byte("not json")
+	close(sut.Feed)
+	<-time.After(100 * time.Millisecond)
+
+	//Assert
+	assert.Equal(t, []string{EventReceived}, journalMock.Events())
+	entry := journalMock.Entries[0]
+	assert.Equal(t, "action id", entry.ActionID)
+	assert.Equal(t, "agent id", entry.AgentID)
+	assert.Equal(t, "ApproveWithdraw", entry.Type)
+	assert.Equal(t, SourceFeed, entry.Source)
+	assert.Equal(t, "pending", entry.Detail)
+	assert.Equal(t, int64(1676184187), entry.ExpireTime)
+}
@@ -0,0 +1,428 @@
+// Package journal keeps a durable record of every action seen by the signing agent,
+// the decisions taken for it, who took them, the retries and the final outcome.
+// Entries are appended to a file, one JSON object per line, and indexed on start: only a summary of every action and
+// the position of its entries are kept in memory, the entries are read from the file when queried.
+// The actions not updated for the retention period are dropped, and the file is rewritten without them.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/config"
+)
+
+// The kinds of events recorded for an action
+const (
+	EventReceived = "received"
+	EventDecision = "decision"
+	EventVote     = "vote"
+	EventRetry    = "retry"
+	EventApproved = "approved"
+	EventRejected = "rejected"
+	EventFailed   = "failed"
+)
+
+// The sources of the recorded events
+const (
+	SourceFeed   = "feed"
+	SourceAuto   = "auto"
+	SourceShadow = "shadow"
+	SourceREST   = "rest"
+)
+
+// Journal records the action events and gives access to the recorded history
+type Journal interface {
+	// Record appends the entry to the journal
+	Record(entry *Entry) error
+	// Query returns the recorded actions matching the filter, most recently updated first
+	Query(filter *Filter) []api.ActionRecord
+}
+
+// Entry is a single event recorded for an action
+type Entry struct {
+	ActionID   string `json:"actionID"`
+	AgentID    string `json:"agentID,omitempty"`
+	Type       string `json:"type,omitempty"`
+	ExpireTime int64  `json:"expireTime,omitempty"`
+	api.ActionEvent
+}
+
+// NewEntry returns an Entry for the action with the event details filled in
+func NewEntry(actionID, event, source, detail string) *Entry {
+	return &Entry{
+		ActionID: actionID,
+		ActionEvent: api.ActionEvent{
+			Event:     event,
+			Source:    source,
+			Detail:    detail,
+			Timestamp: time.Now().Unix(),
+		},
+	}
+}
+
+// Filter is used to select the recorded actions. Empty fields match any value
+type Filter struct {
+	Status string
+	Type   string
+	From   int64
+	To     int64
+}
+
+// pruneInterval is the minimum time between two removals of the actions older than the retention period
+const pruneInterval = time.Hour
+
+type journalImpl struct {
+	lock      sync.RWMutex
+	log       *zap.SugaredLogger
+	enabled   bool
+	fileName  string
+	file      *os.File
+	size      int64 // the size of the journal file, where the next entry is written
+	retention time.Duration
+	lastPrune time.Time
+	actions   map[string]*actionIndex
+}
+
+// actionIndex is what's kept in memory of a recorded action: its summary and where its entries are
+type actionIndex struct {
+	record api.ActionRecord // without the events
+	events []eventIndex
+}
+
+// eventIndex gives the position of an entry in the journal file, or the event itself when there's no file
+type eventIndex struct {
+	timestamp int64
+	offset    int64
+	length    int
+	event     *api.ActionEvent
+}
+
+// NewJournal returns a Journal that's an instance of journalImpl.
+// When a file is configured, the previously recorded entries are indexed and new ones are appended to it
+func NewJournal(cfg *config.Journal, log *zap.SugaredLogger) (Journal, error) {
+	j := &journalImpl{
+		log:       log,
+		enabled:   cfg.Enabled,
+		fileName:  cfg.File,
+		retention: time.Duration(cfg.RetentionDays) * 24 * time.Hour,
+		lastPrune: time.Now(),
+		actions:   make(map[string]*actionIndex),
+	}
+
+	if !cfg.Enabled || len(cfg.File) == 0 {
+		return j, nil
+	}
+
+	if err := j.open(); err != nil {
+		return nil, errors.Wrap(err, "open journal")
+	}
+
+	if err := j.load(); err != nil {
+		return nil, errors.Wrap(err, "load journal")
+	}
+
+	if err := j.prune(); err != nil {
+		return nil, errors.Wrap(err, "prune journal")
+	}
+
+	return j, nil
+}
+
+// Record appends the entry to the journal file, if any, and updates the action index
+func (j *journalImpl) Record(entry *Entry) error {
+	if !j.enabled {
+		return nil
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	index := eventIndex{timestamp: entry.Timestamp}
+	if j.file != nil {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		if _, err = j.file.Write(append(data, '\n')); err != nil {
+			return errors.Wrap(err, "write journal entry")
+		}
+
+		if err = j.file.Sync(); err != nil {
+			return errors.Wrap(err, "sync journal")
+		}
+
+		index.offset, index.length = j.size, len(data)
+		j.size += int64(len(data)) + 1
+	} else {
+		event := entry.ActionEvent
+		index.event = &event
+	}
+
+	j.apply(entry, index)
+
+	if time.Since(j.lastPrune) < pruneInterval {
+		return nil
+	}
+	return errors.Wrap(j.prune(), "prune journal")
+}
+
+// Query returns the recorded actions matching the filter, most recently updated first
+func (j *journalImpl) Query(filter *Filter) []api.ActionRecord {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	result := make([]api.ActionRecord, 0)
+	for _, action := range j.actions {
+		if filter.matches(action) {
+			record := action.record
+			record.Events = j.readEvents(action)
+			result = append(result, record)
+		}
+	}
+
+	sort.Slice(result, func(i, k int) bool {
+		return result[i].LastUpdated > result[k].LastUpdated
+	})
+
+	return result
+}
+
+// readEvents returns the events of the action, read from the journal file. Caller must handle concurrency
+func (j *journalImpl) readEvents(action *actionIndex) []api.ActionEvent {
+	events := make([]api.ActionEvent, 0, len(action.events))
+	for i := range action.events {
+		if action.events[i].event != nil {
+			events = append(events, *action.events[i].event)
+			continue
+		}
+
+		entry := &Entry{}
+		if data, err := j.readEntry(&action.events[i]); err == nil && json.Unmarshal(data, entry) == nil {
+			events = append(events, entry.ActionEvent)
+		}
+	}
+
+	return events
+}
+
+// readEntry returns the line of the journal file where the entry is. Caller must handle concurrency
+func (j *journalImpl) readEntry(index *eventIndex) ([]byte, error) {
+	data := make([]byte, index.length)
+	if _, err := j.file.ReadAt(data, index.offset); err != nil {
+		return nil, errors.Wrap(err, "read journal entry")
+	}
+	return data, nil
+}
+
+// open opens the journal file for reading the entries and appending new ones
+func (j *journalImpl) open() error {
+	f, err := os.OpenFile(j.fileName, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	j.file, j.size = f, info.Size()
+	return nil
+}
+
+// load indexes the entries of the journal file
+func (j *journalImpl) load() error {
+	if _, err := j.file.Seek(0, 0); err != nil {
+		return err
+	}
+
+	var (
+		offset  int64
+		tornErr error // the error of an unparsable line, only allowed as the last line
+		torn    int64 // the offset of the unparsable line
+	)
+	scanner := bufio.NewScanner(j.file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		index := eventIndex{offset: offset, length: len(line)}
+		offset += int64(len(line)) + 1
+		if len(line) == 0 {
+			continue
+		}
+
+		if tornErr != nil {
+			return tornErr
+		}
+
+		entry := &Entry{}
+		if err := json.Unmarshal(line, entry); err != nil {
+			tornErr, torn = err, index.offset
+			continue
+		}
+		index.timestamp = entry.Timestamp
+		j.apply(entry, index)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if tornErr != nil {
+		return j.truncate(torn, tornErr)
+	}
+	return nil
+}
+
+// truncate drops the unparsable last line of the journal file, at offset, as left by a write interrupted by a crash.
+// Caller must handle concurrency
+func (j *journalImpl) truncate(offset int64, err error) error {
+	j.log.Warnf("Journal: dropping the unparsable last line of %v, err: %v", j.fileName, err)
+	if err := j.file.Truncate(offset); err != nil {
+		return errors.Wrap(err, "truncate journal")
+	}
+
+	j.size = offset
+	return nil
+}
+
+// prune drops the actions not updated for the retention period and rewrites the journal file without them.
+// Caller must handle concurrency
+func (j *journalImpl) prune() error {
+	j.lastPrune = time.Now()
+	if j.retention <= 0 {
+		return nil
+	}
+
+	expired := j.lastPrune.Add(-j.retention).Unix()
+	pruned := 0
+	for actionID, action := range j.actions {
+		if action.record.LastUpdated < expired {
+			delete(j.actions, actionID)
+			pruned++
+		}
+	}
+
+	if pruned == 0 || j.file == nil {
+		return nil
+	}
+	return j.compact()
+}
+
+// compact rewrites the journal file with the entries of the indexed actions only, to a temporary file that's then renamed,
+// so that the journal file is never left half written. Caller must handle concurrency
+func (j *journalImpl) compact() error {
+	f, err := os.CreateTemp(filepath.Dir(j.fileName), filepath.Base(j.fileName)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "create journal")
+	}
+	defer os.Remove(f.Name())
+
+	offsets := make(map[*eventIndex]int64)
+	var offset int64
+	w := bufio.NewWriter(f)
+	for _, action := range j.actions {
+		for i := range action.events {
+			data, err := j.readEntry(&action.events[i])
+			if err == nil {
+				_, err = w.Write(append(data, '\n'))
+			}
+			if err != nil {
+				_ = f.Close()
+				return err
+			}
+
+			offsets[&action.events[i]] = offset
+			offset += int64(len(data)) + 1
+		}
+	}
+
+	if err = w.Flush(); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "write journal")
+	}
+
+	if err = os.Rename(f.Name(), j.fileName); err != nil {
+		return errors.Wrap(err, "save journal")
+	}
+
+	for index, offset := range offsets {
+		index.offset = offset
+	}
+	_ = j.file.Close()
+	return j.open()
+}
+
+// apply adds the entry to the action index. Caller must handle concurrency
+func (j *journalImpl) apply(entry *Entry, index eventIndex) {
+	action, ok := j.actions[entry.ActionID]
+	if !ok {
+		action = &actionIndex{
+			record: api.ActionRecord{
+				ActionID:  entry.ActionID,
+				Status:    EventReceived,
+				FirstSeen: entry.Timestamp,
+			},
+		}
+		j.actions[entry.ActionID] = action
+	}
+
+	record := &action.record
+	if len(entry.AgentID) > 0 {
+		record.AgentID = entry.AgentID
+	}
+	if len(entry.Type) > 0 {
+		record.Type = entry.Type
+	}
+	if entry.ExpireTime > 0 {
+		record.ExpireTime = entry.ExpireTime
+	}
+
+	switch entry.Event {
+	case EventApproved, EventRejected, EventFailed:
+		record.Status = entry.Event
+	}
+
+	record.LastUpdated = entry.Timestamp
+	action.events = append(action.events, index)
+}
+
+func (f *Filter) matches(action *actionIndex) bool {
+	if len(f.Status) > 0 && f.Status != action.record.Status {
+		return false
+	}
+
+	if len(f.Type) > 0 && f.Type != action.record.Type {
+		return false
+	}
+
+	if f.From == 0 && f.To == 0 {
+		return true
+	}
+
+	// the action matches if any of its events happened in the time range
+	for _, event := range action.events {
+		if (f.From == 0 || event.timestamp >= f.From) && (f.To == 0 || event.timestamp <= f.To) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,221 @@
+package journal
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qredo/signing-agent/config"
+	"github.com/qredo/signing-agent/util"
+)
+
+const testJournalFilePath = "../testdata/test-journal.db"
+
+func TestJournal_Record_keeps_outcome(t *testing.T) {
+	//Arrange
+	sut, err := NewJournal(&config.Journal{Enabled: true}, util.NewTestLogger())
+	require.Nil(t, err)
+
+	received := NewEntry("action id", EventReceived, SourceFeed, "pending")
+	received.Type = "ApproveWithdraw"
+	received.AgentID = "agent id"
+
+	//Act
+	_ = sut.Record(received)
+	_ = sut.Record(NewEntry("action id", EventDecision, SourceAuto, "approve"))
+	_ = sut.Record(NewEntry("action id", EventRetry, SourceAuto, "some error"))
+	_ = sut.Record(NewEntry("action id", EventApproved, SourceAuto, ""))
+	_ = sut.Record(NewEntry("action id", EventReceived, SourceFeed, "approved"))
+
+	//Assert
+	res := sut.Query(&Filter{})
+	require.Len(t, res, 1)
+	assert.Equal(t, "action id", res[0].ActionID)
+	assert.Equal(t, "agent id", res[0].AgentID)
+	assert.Equal(t, "ApproveWithdraw", res[0].Type)
+	assert.Equal(t, EventApproved, res[0].Status)
+	assert.Len(t, res[0].Events, 5)
+}
+
+func TestJournal_Query_filters(t *testing.T) {
+	//Arrange
+	sut, _ := NewJournal(&config.Journal{Enabled: true}, util.NewTestLogger())
+
+	withdraw := NewEntry("withdraw id", EventReceived, SourceFeed, "pending")
+	withdraw.Type = "ApproveWithdraw"
+	_ = sut.Record(withdraw)
+
+	transfer := NewEntry("transfer id", EventReceived, SourceFeed, "pending")
+	transfer.Type = "ApproveTransfer"
+	transfer.Timestamp = time.Now().Add(-time.Hour).Unix()
+	_ = sut.Record(transfer)
+	_ = sut.Record(NewEntry("transfer id", EventRejected, SourceREST, ""))
+
+	//Act
+	byType := sut.Query(&Filter{Type: "ApproveWithdraw"})
+	byStatus := sut.Query(&Filter{Status: EventRejected})
+	byTime := sut.Query(&Filter{To: time.Now().Add(-time.Minute).Unix()})
+	none := sut.Query(&Filter{From: time.Now().Add(time.Minute).Unix()})
+
+	//Assert
+	require.Len(t, byType, 1)
+	assert.Equal(t, "withdraw id", byType[0].ActionID)
+	require.Len(t, byStatus, 1)
+	assert.Equal(t, "transfer id", byStatus[0].ActionID)
+	require.Len(t, byTime, 1)
+	assert.Equal(t, "transfer id", byTime[0].ActionID)
+	assert.Empty(t, none)
+}
+
+func TestJournal_loads_recorded_entries(t *testing.T) {
+	//Arrange
+	defer os.Remove(testJournalFilePath)
+	cfg := &config.Journal{
+		Enabled: true,
+		File:    testJournalFilePath,
+	}
+	first, err := NewJournal(cfg, util.NewTestLogger())
+	require.Nil(t, err)
+	_ = first.Record(NewEntry("action id", EventReceived, SourceFeed, "pending"))
+	_ = first.Record(NewEntry("action id", EventFailed, SourceREST, "some error"))
+
+	//Act
+	sut, err := NewJournal(cfg, util.NewTestLogger())
+
+	//Assert
+	require.Nil(t, err)
+	res := sut.Query(&Filter{})
+	require.Len(t, res, 1)
+	assert.Equal(t, EventFailed, res[0].Status)
+	assert.Len(t, res[0].Events, 2)
+}
+
+func TestJournal_drops_torn_last_line(t *testing.T) {
+	//Arrange
+	defer os.Remove(testJournalFilePath)
+	cfg := &config.Journal{
+		Enabled: true,
+		File:    testJournalFilePath,
+	}
+	first, err := NewJournal(cfg, util.NewTestLogger())
+	require.Nil(t, err)
+	_ = first.Record(NewEntry("action id", EventReceived, SourceFeed, "pending"))
+
+	f, err := os.OpenFile(testJournalFilePath, os.O_APPEND|os.O_WRONLY, 0600)
+	require.Nil(t, err)
+	_, err = f.WriteString(`{"actionID":"action id","event":"appr`)
+	require.Nil(t, err)
+	require.Nil(t, f.Close())
+
+	//Act
+	sut, err := NewJournal(cfg, util.NewTestLogger())
+
+	//Assert
+	require.Nil(t, err)
+	require.Nil(t, sut.Record(NewEntry("action id", EventApproved, SourceAuto, "")))
+
+	reopened, err := NewJournal(cfg, util.NewTestLogger())
+	require.Nil(t, err)
+	res := reopened.Query(&Filter{})
+	require.Len(t, res, 1)
+	require.Len(t, res[0].Events, 2)
+	assert.Equal(t, EventApproved, res[0].Events[1].Event)
+}
+
+func TestJournal_fails_on_unparsable_line_before_the_last(t *testing.T) {
+	//Arrange
+	defer os.Remove(testJournalFilePath)
+	content := "not json\n" + `{"actionID":"action id","event":"received","source":"feed","timestamp":1}` + "\n"
+	require.Nil(t, os.WriteFile(testJournalFilePath, []byte(content), 0600))
+
+	//Act
+	_, err := NewJournal(&config.Journal{Enabled: true, File: testJournalFilePath}, util.NewTestLogger())
+
+	//Assert
+	assert.NotNil(t, err)
+}
+
+func TestJournal_disabled_doesnt_record(t *testing.T) {
+	//Arrange
+	sut, _ := NewJournal(&config.Journal{Enabled: false, File: testJournalFilePath}, util.NewTestLogger())
+
+	//Act
+	err := sut.Record(NewEntry("action id", EventReceived, SourceFeed, "pending"))
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Empty(t, sut.Query(&Filter{}))
+	_, err = os.Stat(testJournalFilePath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestJournal_drops_actions_older_than_retention(t *testing.T) {
+	//Arrange
+	defer os.Remove(testJournalFilePath)
+	cfg := &config.Journal{
+		Enabled:       true,
+		File:          testJournalFilePath,
+		RetentionDays: 1,
+	}
+	first, err := NewJournal(cfg, util.NewTestLogger())
+	require.Nil(t, err)
+
+	old := NewEntry("old action id", EventReceived, SourceFeed, "pending")
+	old.Timestamp = time.Now().Add(-48 * time.Hour).Unix()
+	_ = first.Record(old)
+	_ = first.Record(NewEntry("action id", EventReceived, SourceFeed, "pending"))
+	_ = first.Record(NewEntry("action id", EventApproved, SourceAuto, ""))
+
+	//Act
+	sut, err := NewJournal(cfg, util.NewTestLogger())
+
+	//Assert
+	require.Nil(t, err)
+	res := sut.Query(&Filter{})
+	require.Len(t, res, 1)
+	assert.Equal(t, "action id", res[0].ActionID)
+	require.Len(t, res[0].Events, 2)
+	assert.Equal(t, EventApproved, res[0].Events[1].Event)
+
+	data, err := os.ReadFile(testJournalFilePath)
+	require.Nil(t, err)
+	assert.NotContains(t, string(data), "old action id")
+}
+
+func TestJournal_Record_prunes_actions_older_than_retention(t *testing.T) {
+	//Arrange
+	defer os.Remove(testJournalFilePath)
+	cfg := &config.Journal{
+		Enabled:       true,
+		File:          testJournalFilePath,
+		RetentionDays: 1,
+	}
+	sut, err := NewJournal(cfg, util.NewTestLogger())
+	require.Nil(t, err)
+
+	old := NewEntry("old action id", EventReceived, SourceFeed, "pending")
+	old.Timestamp = time.Now().Add(-48 * time.Hour).Unix()
+	require.Nil(t, sut.Record(old))
+	require.Len(t, sut.Query(&Filter{}), 1)
+	sut.(*journalImpl).lastPrune = time.Now().Add(-pruneInterval)
+
+	//Act
+	err = sut.Record(NewEntry("action id", EventReceived, SourceFeed, "pending"))
+	_ = sut.Record(NewEntry("action id", EventRejected, SourceREST, ""))
+
+	//Assert
+	assert.Nil(t, err)
+	res := sut.Query(&Filter{})
+	require.Len(t, res, 1)
+	assert.Equal(t, "action id", res[0].ActionID)
+	assert.Equal(t, EventRejected, res[0].Status)
+	require.Len(t, res[0].Events, 2)
+	assert.Equal(t, EventRejected, res[0].Events[1].Event)
+
+	reopened, err := NewJournal(&config.Journal{Enabled: true, File: testJournalFilePath}, util.NewTestLogger())
+	require.Nil(t, err)
+	assert.Len(t, reopened.Query(&Filter{}), 1)
+}
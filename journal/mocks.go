@@ -0,0 +1,44 @@
+package journal
+
+import (
+	"sync"
+
+	"github.com/qredo/signing-agent/api"
+)
+
+type MockJournal struct {
+	lock         sync.Mutex
+	RecordCalled bool
+	QueryCalled  bool
+	Entries      []Entry
+	LastFilter   *Filter
+	NextRecords  []api.ActionRecord
+	NextError    error
+}
+
+func (m *MockJournal) Record(entry *Entry) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.RecordCalled = true
+	m.Entries = append(m.Entries, *entry)
+	return m.NextError
+}
+
+func (m *MockJournal) Query(filter *Filter) []api.ActionRecord {
+	m.QueryCalled = true
+	m.LastFilter = filter
+	return m.NextRecords
+}
+
+// Events returns the kinds of the recorded events, in order
+func (m *MockJournal) Events() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	events := make([]string, len(m.Entries))
+	for i, entry := range m.Entries {
+		events[i] = entry.Event
+	}
+	return events
+}
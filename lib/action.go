@@ -2,6 +2,7 @@ package lib
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"net/http"
 
 	"github.com/pkg/errors"
@@ -12,34 +13,23 @@ import (
 )
 
 func (h *signingAgent) ActionApprove(actionID string) error {
-	agentID := h.store.GetSystemAgentID()
-	if agentID == "" {
-		return defs.ErrNotFound().WithDetail("agentID")
-	}
-	agent := h.store.GetAgent(agentID)
-	if agent == nil {
-		return defs.ErrNotFound().WithDetail("agent")
-	}
-
-	zkpOnePass, err := util.ZKPOnePass(agent.ZKPID, agent.ZKPToken, h.cfg.Base.PIN)
+	agent, err := h.currentAgent()
 	if err != nil {
-		return errors.Wrap(err, "get zkp token")
+		return err
 	}
 
-	header := http.Header{}
-	header.Set(defs.AuthHeader, hex.EncodeToString(zkpOnePass))
-	messagesResp := &api.CoreClientServiceActionMessagesResponse{}
-	if err = h.htc.Request(http.MethodGet, util.URLActionMessages(h.cfg.Base.QredoAPI, actionID), nil, messagesResp, header); err != nil {
+	details, err := h.getAction(agent, actionID)
+	if err != nil {
 		return err
 	}
 
-	if messagesResp.Messages == nil || len(messagesResp.Messages) == 0 {
+	if len(details.Messages) == 0 {
 		return defs.ErrNotFound().WithDetail("messages")
 	}
 
-	signatures := make([]string, len(messagesResp.Messages))
+	signatures := make([]string, len(details.Messages))
 
-	for i, m := range messagesResp.Messages {
+	for i, m := range details.Messages {
 		msg, err := hex.DecodeString(m)
 		if err != nil || len(msg) == 0 {
 			return err
@@ -52,7 +42,7 @@ func (h *signingAgent) ActionApprove(actionID string) error {
 		signatures[i] = hex.EncodeToString(signature)
 	}
 
-	zkpOnePass, err = util.ZKPOnePass(agent.ZKPID, agent.ZKPToken, h.cfg.Base.PIN)
+	zkpOnePass, err := util.ZKPOnePass(agent.ZKPID, agent.ZKPToken, h.cfg.Base.PIN)
 	if err != nil {
 		return errors.Wrap(err, "get zkp token")
 	}
@@ -60,7 +50,7 @@ func (h *signingAgent) ActionApprove(actionID string) error {
 	req := &api.CoreClientServiceActionApproveRequest{
 		Signatures: signatures,
 	}
-	header = http.Header{}
+	header := http.Header{}
 	header.Set(defs.AuthHeader, hex.EncodeToString(zkpOnePass))
 	if err = h.htc.Request(http.MethodPut, util.URLActionApprove(h.cfg.Base.QredoAPI, actionID), req, nil, header); err != nil {
 		return err
@@ -69,7 +59,67 @@ func (h *signingAgent) ActionApprove(actionID string) error {
 	return nil
 }
 
+// GetAction returns the payload of actionID from the Qredo backend: the messages to sign and the transaction metadata
+func (h *signingAgent) GetAction(actionID string) (*api.ActionDetailsResponse, error) {
+	agent, err := h.currentAgent()
+	if err != nil {
+		return nil, err
+	}
+
+	return h.getAction(agent, actionID)
+}
+
+// currentAgent returns the agent the client acts for
+func (h *signingAgent) currentAgent() (*Agent, error) {
+	agentID := h.currentAgentID()
+	if agentID == "" {
+		return nil, defs.ErrNotFound().WithDetail("agentID")
+	}
+	agent := h.store.GetAgent(agentID)
+	if agent == nil {
+		return nil, defs.ErrNotFound().WithDetail("agent")
+	}
+	return agent, nil
+}
+
+// getAction fetches the action on behalf of the agent. The messages are kept apart, and every other field
+// of the response is returned as metadata
+func (h *signingAgent) getAction(agent *Agent, actionID string) (*api.ActionDetailsResponse, error) {
+	zkpOnePass, err := util.ZKPOnePass(agent.ZKPID, agent.ZKPToken, h.cfg.Base.PIN)
+	if err != nil {
+		return nil, errors.Wrap(err, "get zkp token")
+	}
+
+	header := http.Header{}
+	header.Set(defs.AuthHeader, hex.EncodeToString(zkpOnePass))
+	resp := map[string]json.RawMessage{}
+	if err = h.htc.Request(http.MethodGet, util.URLActionMessages(h.cfg.Base.QredoAPI, actionID), nil, &resp, header); err != nil {
+		return nil, err
+	}
+
+	details := &api.ActionDetailsResponse{
+		ActionID: actionID,
+	}
+	if messages, ok := resp["messages"]; ok {
+		if err = json.Unmarshal(messages, &details.Messages); err != nil {
+			return nil, errors.Wrap(err, "decode messages")
+		}
+		delete(resp, "messages")
+	}
+	if len(resp) > 0 {
+		details.Details = resp
+	}
+
+	return details, nil
+}
+
 func (h *signingAgent) ActionReject(actionID string) error {
+	return h.ActionRejectWithReason(actionID, nil)
+}
+
+// ActionRejectWithReason sends a rejection to the Qredo backend for actionID. The reason is sent in the body of the request
+// when sendRejectReason is enabled
+func (h *signingAgent) ActionRejectWithReason(actionID string, reason *api.RejectReason) error {
 	zkpOnePass, err := h.GetAgentZKPOnePass()
 	if err != nil {
 		return errors.Wrap(err, "get zkp token")
@@ -78,9 +128,46 @@ func (h *signingAgent) ActionReject(actionID string) error {
 	header := http.Header{}
 	header.Set(defs.AuthHeader, hex.EncodeToString(zkpOnePass))
 
-	if err = h.htc.Request(http.MethodDelete, util.URLActionReject(h.cfg.Base.QredoAPI, actionID), nil, nil, header); err != nil {
+	var req interface{}
+	if reason != nil && h.cfg.Base.SendRejectReason {
+		req = &api.CoreClientServiceActionRejectRequest{
+			Code:    reason.Code,
+			Message: reason.Message,
+		}
+	}
+
+	if err = h.htc.Request(http.MethodDelete, util.URLActionReject(h.cfg.Base.QredoAPI, actionID), req, nil, header); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// ErrPendingActionsNotFound is returned when the Qredo API answers the query of the pending actions with a 404
+var ErrPendingActionsNotFound = errors.New("pending actions endpoint not found")
+
+// pendingActions is the list of the actions waiting for the agent decision, as returned by the Qredo API
+type pendingActions struct {
+	Actions []*WsActionInfoEvent `json:"actions"`
+}
+
+func (h *signingAgent) GetPendingActions() ([]*WsActionInfoEvent, error) {
+	zkpOnePass, err := h.GetAgentZKPOnePass()
+	if err != nil {
+		return nil, errors.Wrap(err, "get zkp token")
+	}
+
+	header := http.Header{}
+	header.Set(defs.AuthHeader, hex.EncodeToString(zkpOnePass))
+
+	resp := &pendingActions{}
+	if err = h.htc.Request(http.MethodGet, util.URLPendingActions(h.cfg.Base.QredoAPI), nil, resp, header); err != nil {
+		var statusErr *util.StatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+			return nil, ErrPendingActionsNotFound
+		}
+		return nil, err
+	}
+
+	return resp.Actions, nil
+}
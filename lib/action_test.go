@@ -10,6 +10,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/qredo/signing-agent/api"
 	"github.com/qredo/signing-agent/config"
 	"github.com/qredo/signing-agent/util"
 )
@@ -146,6 +147,58 @@ func TestAction(t *testing.T) {
 			assert.Error(t, err)
 		})
 
+	t.Run(
+		"GetAction",
+		func(t *testing.T) {
+			msg := []byte(`{"messages":["0805"],"type":"ApproveWithdraw","amount":100}`)
+			util.GetDoMockHTTPClientFunc = func(request *http.Request) (*http.Response, error) {
+				assert.Equal(t, http.MethodGet, request.Method)
+				assert.Equal(t, util.URLActionMessages(cfg.Base.QredoAPI, actionID), request.URL.String())
+				return &http.Response{
+					Status:     "200 OK",
+					StatusCode: 200,
+					Body:       io.NopCloser(bytes.NewReader(msg)),
+				}, nil
+			}
+
+			details, err := core.GetAction(actionID)
+			assert.NoError(t, err)
+			assert.Equal(t, actionID, details.ActionID)
+			assert.Equal(t, []string{"0805"}, details.Messages)
+			assert.Equal(t, map[string]json.RawMessage{
+				"type":   json.RawMessage(`"ApproveWithdraw"`),
+				"amount": json.RawMessage(`100`),
+			}, details.Details)
+		})
+
+	t.Run(
+		"ActionRejectWithReason",
+		func(t *testing.T) {
+			var body []byte
+			util.GetDoMockHTTPClientFunc = func(request *http.Request) (*http.Response, error) {
+				assert.Equal(t, http.MethodDelete, request.Method)
+				if request.Body != nil {
+					body, _ = io.ReadAll(request.Body)
+				}
+				return &http.Response{
+					Status:     "200 OK",
+					StatusCode: 200,
+					Body:       io.NopCloser(bytes.NewReader([]byte(""))),
+				}, nil
+			}
+			reason := &api.RejectReason{Code: "limit_exceeded", Message: "over the limit"}
+
+			err = core.ActionRejectWithReason(actionID, reason)
+			assert.NoError(t, err)
+			assert.Empty(t, body)
+
+			cfg.Base.SendRejectReason = true
+			defer func() { cfg.Base.SendRejectReason = false }()
+			err = core.ActionRejectWithReason(actionID, reason)
+			assert.NoError(t, err)
+			assert.JSONEq(t, `{"code":"limit_exceeded","message":"over the limit"}`, string(body))
+		})
+
 	t.Run(
 		"ActionReject",
 		func(t *testing.T) {
@@ -160,4 +213,21 @@ func TestAction(t *testing.T) {
 			assert.NoError(t, err)
 		})
 
+	t.Run(
+		"GetPendingActions - endpoint not found",
+		func(t *testing.T) {
+			util.GetDoMockHTTPClientFunc = func(request *http.Request) (*http.Response, error) {
+				assert.Equal(t, util.URLPendingActions(cfg.Base.QredoAPI), request.URL.String())
+				return &http.Response{
+					Status:     "404 Not Found",
+					StatusCode: 404,
+					Body:       io.NopCloser(bytes.NewReader([]byte(""))),
+				}, nil
+			}
+
+			actions, err := core.GetPendingActions()
+			assert.Nil(t, actions)
+			assert.ErrorIs(t, err, ErrPendingActionsNotFound)
+		})
+
 }
@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/qredo/signing-agent/util"
+)
+
+// BackupVersion is the version of the backup bundle written by WriteBackup
+const BackupVersion = 1
+
+// Backup is the content of a backup bundle: every registered agent and the ID of the system agent
+type Backup struct {
+	Version       int      `json:"version"`
+	CreatedAt     int64    `json:"created_at"`
+	SystemAgentID string   `json:"system_agent_id"`
+	Agents        []*Agent `json:"agents"`
+}
+
+// Export returns the backup of all the agents registered in the store
+func (s *Storage) Export() (*Backup, error) {
+	systemAgentID := s.GetSystemAgentID()
+	if len(systemAgentID) == 0 {
+		return nil, errors.New("no agent registered")
+	}
+
+	backup := &Backup{
+		Version:       BackupVersion,
+		CreatedAt:     time.Now().Unix(),
+		SystemAgentID: systemAgentID,
+		Agents:        make([]*Agent, 0),
+	}
+
+	for _, agentID := range s.GetAgentIDs() {
+		agent := s.GetAgent(agentID)
+		if agent == nil {
+			return nil, errors.Errorf("agent %s not found in store", agentID)
+		}
+		backup.Agents = append(backup.Agents, agent)
+	}
+
+	return backup, nil
+}
+
+// Import writes the agents of the backup to the store. Unless overwrite is set,
+// it fails when the store already has a registered agent
+func (s *Storage) Import(backup *Backup, overwrite bool) error {
+	if systemAgentID := s.GetSystemAgentID(); len(systemAgentID) > 0 && !overwrite {
+		return errors.Errorf("store already has the registered agent %s", systemAgentID)
+	}
+
+	for _, agent := range backup.Agents {
+		if err := s.AddAgent(agent.ID, agent); err != nil {
+			return errors.Wrapf(err, "restore agent %s", agent.ID)
+		}
+	}
+
+	if err := s.SetSystemAgentID(backup.SystemAgentID); err != nil {
+		return errors.Wrap(err, "restore system agent ID")
+	}
+
+	for _, agent := range backup.Agents {
+		if err := s.AddAgentID(agent.ID); err != nil {
+			return errors.Wrapf(err, "restore agent ID %s", agent.ID)
+		}
+	}
+
+	return nil
+}
+
+// WriteBackup returns the backup bundle, encrypted with a key derived from the passphrase
+func WriteBackup(backup *Backup, passphrase []byte) ([]byte, error) {
+	data, err := json.Marshal(backup)
+	if err != nil {
+		return nil, err
+	}
+
+	return util.EncryptWithPassphrase(passphrase, data)
+}
+
+// ReadBackup decrypts the backup bundle with the passphrase and checks its content
+func ReadBackup(b, passphrase []byte) (*Backup, error) {
+	data, err := util.DecryptWithPassphrase(passphrase, b)
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypt backup")
+	}
+
+	backup := &Backup{}
+	if err = json.Unmarshal(data, backup); err != nil {
+		return nil, errors.Wrap(err, "parse backup")
+	}
+
+	if backup.Version != BackupVersion {
+		return nil, errors.Errorf("unsupported backup version %d", backup.Version)
+	}
+
+	if len(backup.SystemAgentID) == 0 || len(backup.Agents) == 0 {
+		return nil, errors.New("backup has no agent")
+	}
+
+	systemAgentFound := false
+	for _, agent := range backup.Agents {
+		if agent == nil || len(agent.ID) == 0 || len(agent.BLSSeed) == 0 {
+			return nil, errors.New("backup has an invalid agent")
+		}
+		if agent.ID == backup.SystemAgentID {
+			systemAgentFound = true
+		}
+	}
+
+	if !systemAgentFound {
+		return nil, errors.Errorf("system agent %s not found in backup", backup.SystemAgentID)
+	}
+
+	return backup, nil
+}
@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qredo/signing-agent/util"
+)
+
+func newTestBackupStorage(t *testing.T) *Storage {
+	kv := util.NewFileStore(filepath.Join(t.TempDir(), "ccstore.db"))
+	require.Nil(t, kv.Init())
+	return NewStore(kv)
+}
+
+func newTestBackupSource(t *testing.T) *Storage {
+	store := newTestBackupStorage(t)
+	for _, agent := range []*Agent{
+		{Name: "system agent", ID: "some system agent id", BLSSeed: []byte("some system seed"), ZKPID: []byte("some zkp id")},
+		{Name: "other agent", ID: "some other agent id", BLSSeed: []byte("some other seed"), ZKPToken: []byte("some zkp token")},
+	} {
+		require.Nil(t, store.AddAgent(agent.ID, agent))
+		require.Nil(t, store.AddAgentID(agent.ID))
+	}
+	require.Nil(t, store.SetSystemAgentID("some system agent id"))
+	return store
+}
+
+func TestBackup_export_and_restore_all_agents(t *testing.T) {
+	// Arrange
+	source := newTestBackupSource(t)
+	target := newTestBackupStorage(t)
+	passphrase := []byte("some passphrase")
+
+	// Act
+	backup, err := source.Export()
+	require.Nil(t, err)
+	bundle, err := WriteBackup(backup, passphrase)
+	require.Nil(t, err)
+	restored, err := ReadBackup(bundle, passphrase)
+	require.Nil(t, err)
+	err = target.Import(restored, false)
+
+	// Assert
+	assert.Nil(t, err)
+	assert.NotContains(t, string(bundle), "some system seed")
+	assert.Equal(t, "some system agent id", target.GetSystemAgentID())
+	assert.Equal(t, []string{"some system agent id", "some other agent id"}, target.GetAgentIDs())
+	for _, agentID := range source.GetAgentIDs() {
+		assert.Equal(t, source.GetAgent(agentID), target.GetAgent(agentID))
+	}
+}
+
+func TestBackup_export_fails_without_agent(t *testing.T) {
+	// Arrange
+	source := newTestBackupStorage(t)
+
+	// Act
+	backup, err := source.Export()
+
+	// Assert
+	assert.Nil(t, backup)
+	assert.Equal(t, "no agent registered", err.Error())
+}
+
+func TestBackup_read_fails_with_wrong_passphrase(t *testing.T) {
+	// Arrange
+	backup, err := newTestBackupSource(t).Export()
+	require.Nil(t, err)
+	bundle, err := WriteBackup(backup, []byte("some passphrase"))
+	require.Nil(t, err)
+
+	// Act
+	restored, err := ReadBackup(bundle, []byte("some other passphrase"))
+
+	// Assert
+	assert.Nil(t, restored)
+	assert.Equal(t, "decrypt backup: wrong passphrase or corrupted file", err.Error())
+}
+
+func TestBackup_read_fails_with_unsupported_version(t *testing.T) {
+	// Arrange
+	backup, err := newTestBackupSource(t).Export()
+	require.Nil(t, err)
+	backup.Version = BackupVersion + 1
+	bundle, err := WriteBackup(backup, []byte("some passphrase"))
+	require.Nil(t, err)
+
+	// Act
+	restored, err := ReadBackup(bundle, []byte("some passphrase"))
+
+	// Assert
+	assert.Nil(t, restored)
+	assert.Equal(t, "unsupported backup version 2", err.Error())
+}
+
+func TestBackup_import_fails_on_registered_store_unless_overwrite(t *testing.T) {
+	// Arrange
+	backup, err := newTestBackupSource(t).Export()
+	require.Nil(t, err)
+	target := newTestBackupStorage(t)
+	require.Nil(t, target.SetSystemAgentID("some registered agent id"))
+
+	// Act
+	err = target.Import(backup, false)
+	errOverwrite := target.Import(backup, true)
+
+	// Assert
+	assert.Equal(t, "store already has the registered agent some registered agent id", err.Error())
+	assert.Nil(t, errOverwrite)
+	assert.Equal(t, "some system agent id", target.GetSystemAgentID())
+}
@@ -115,7 +115,14 @@ func (h *signingAgent) ClientRegisterFinish(req *api.ClientRegisterFinishRequest
 		return nil, err
 	}
 
-	err = h.store.SetSystemAgentID(req.AccountCode)
+	// the first agent registered becomes the system agent
+	if len(h.store.GetSystemAgentID()) == 0 {
+		if err = h.store.SetSystemAgentID(req.AccountCode); err != nil {
+			return nil, err
+		}
+	}
+
+	err = h.store.AddAgentID(req.AccountCode)
 	if err != nil {
 		return nil, err
 	}
@@ -127,7 +134,7 @@ func (h *signingAgent) ClientRegisterFinish(req *api.ClientRegisterFinishRequest
 
 // GetAgentID - returns the signing agent ID if registered, empty if not
 func (h *signingAgent) GetAgentID() string {
-	agentID := h.store.GetSystemAgentID()
+	agentID := h.currentAgentID()
 	if len(agentID) > 0 {
 		return agentID
 	} else {
@@ -165,12 +172,13 @@ func (h *signingAgent) SetSystemAgentID(agetID string) error {
 	return h.store.SetSystemAgentID(agetID)
 }
 
+// GetSystemAgentID returns the system agent id, or the agent id when the client acts for a given agent
 func (h *signingAgent) GetSystemAgentID() string {
-	return h.store.GetSystemAgentID()
+	return h.currentAgentID()
 }
 
 func (h *signingAgent) GetAgentZKPOnePass() ([]byte, error) {
-	agentID := h.store.GetSystemAgentID()
+	agentID := h.currentAgentID()
 	if agentID == "" {
 		return nil, errors.Errorf("can not get system agent ID from the store.")
 	}
@@ -29,6 +29,16 @@ type MockSigningAgentClient struct {
 	Last64PrivateKey           string
 	LastActionId               string
 	LastRejectActionId         string
+	NextAgentIDs               []string
+	LastForAgentID             string
+	GetPendingActionsCalled    bool
+	NextPendingActions         []*WsActionInfoEvent
+	NextPendingActionsError    error
+	GetActionCalled            bool
+	LastGetActionId            string
+	NextActionDetails          *api.ActionDetailsResponse
+	NextGetActionError         error
+	LastRejectReason           *api.RejectReason
 }
 
 func NewMockSigningAgentClient(agentId string) *MockSigningAgentClient {
@@ -64,6 +74,15 @@ func (m *MockSigningAgentClient) GetAgentID() string {
 	return m.NextAgentID
 }
 
+func (m *MockSigningAgentClient) GetAgentIDs() []string {
+	return m.NextAgentIDs
+}
+
+func (m *MockSigningAgentClient) ForAgent(agentID string) SigningAgentClient {
+	m.LastForAgentID = agentID
+	return m
+}
+
 func (m *MockSigningAgentClient) ActionApprove(actionID string) error {
 	m.ActionApproveCalled = true
 	m.LastActionId = actionID
@@ -72,11 +91,27 @@ func (m *MockSigningAgentClient) ActionApprove(actionID string) error {
 }
 
 func (m *MockSigningAgentClient) ActionReject(actionID string) error {
+	return m.ActionRejectWithReason(actionID, nil)
+}
+
+func (m *MockSigningAgentClient) ActionRejectWithReason(actionID string, reason *api.RejectReason) error {
 	m.ActionRejectCalled = true
 	m.LastRejectActionId = actionID
+	m.LastRejectReason = reason
 	return m.NextError
 }
 
+func (m *MockSigningAgentClient) GetAction(actionID string) (*api.ActionDetailsResponse, error) {
+	m.GetActionCalled = true
+	m.LastGetActionId = actionID
+	return m.NextActionDetails, m.NextGetActionError
+}
+
+func (m *MockSigningAgentClient) GetPendingActions() ([]*WsActionInfoEvent, error) {
+	m.GetPendingActionsCalled = true
+	return m.NextPendingActions, m.NextPendingActionsError
+}
+
 func (m *MockSigningAgentClient) SetSystemAgentID(agetID string) error {
 	return nil
 }
@@ -16,11 +16,21 @@ type SigningAgentClient interface {
 	ClientRegisterFinish(req *api.ClientRegisterFinishRequest, ref string) (*api.ClientRegisterFinishResponse, error)
 	// GetAgentID returns the agent id if registered
 	GetAgentID() string
+	// GetAgentIDs returns the ids of all the registered agents, the system agent first
+	GetAgentIDs() []string
+	// ForAgent returns a SigningAgentClient acting on behalf of agentID instead of the system agent
+	ForAgent(agentID string) SigningAgentClient
 
 	// ActionApprove signs actionID and sends it for approval to the Qredo backend
 	ActionApprove(actionID string) error
 	// ActionReject sends a rejection to the Qredo backend for actionID
 	ActionReject(actionID string) error
+	// ActionRejectWithReason sends a rejection to the Qredo backend for actionID, with the reason when sendRejectReason is enabled
+	ActionRejectWithReason(actionID string, reason *api.RejectReason) error
+	// GetAction returns the payload of actionID from the Qredo backend, the messages to sign and the transaction metadata
+	GetAction(actionID string) (*api.ActionDetailsResponse, error)
+	// GetPendingActions returns the actions waiting for a decision of the agent from the Qredo backend
+	GetPendingActions() ([]*WsActionInfoEvent, error)
 
 	// SetSystemAgentID function to collect agent ID to storage, so the system will default to a single agent ID (AgentID)
 	SetSystemAgentID(agetID string) error
@@ -34,9 +44,10 @@ type SigningAgentClient interface {
 }
 
 type signingAgent struct {
-	store *Storage
-	cfg   *config.Config
-	htc   *util.Client
+	store   *Storage
+	cfg     *config.Config
+	htc     *util.Client
+	agentID string // the agent the client acts for, the system agent when empty
 }
 
 func New(cfg *config.Config, kv util.KVStore) (*signingAgent, error) {
@@ -46,3 +57,26 @@ func New(cfg *config.Config, kv util.KVStore) (*signingAgent, error) {
 		htc:   util.NewHTTPClient(),
 	}, nil
 }
+
+// ForAgent returns a copy of the client acting on behalf of agentID
+func (h *signingAgent) ForAgent(agentID string) SigningAgentClient {
+	return &signingAgent{
+		cfg:     h.cfg,
+		store:   h.store,
+		htc:     h.htc,
+		agentID: agentID,
+	}
+}
+
+// GetAgentIDs returns the ids of all the registered agents, the system agent first
+func (h *signingAgent) GetAgentIDs() []string {
+	return h.store.GetAgentIDs()
+}
+
+// currentAgentID returns the id of the agent the client acts for
+func (h *signingAgent) currentAgentID() string {
+	if len(h.agentID) > 0 {
+		return h.agentID
+	}
+	return h.store.GetSystemAgentID()
+}
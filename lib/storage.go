@@ -4,15 +4,18 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"sync"
 
 	"github.com/qredo/signing-agent/defs"
 	"github.com/qredo/signing-agent/util"
 )
 
 var agentIDString = "AgentID"
+var agentIDsString = "AgentIDs"
 
 type Storage struct {
-	kv util.KVStore
+	kv         util.KVStore
+	agentsLock sync.Mutex // guards the read-modify-write of the AgentIDs key
 }
 
 func NewStore(store util.KVStore) *Storage {
@@ -161,3 +164,51 @@ func (s *Storage) SetSystemAgentID(agentID string) error {
 	}
 	return nil
 }
+
+// GetAgentIDs returns the IDs of all the registered agents, the system agent first
+func (s *Storage) GetAgentIDs() []string {
+	agentIDs := make([]string, 0)
+
+	systemAgentID := s.GetSystemAgentID()
+	if len(systemAgentID) > 0 {
+		agentIDs = append(agentIDs, systemAgentID)
+	}
+
+	d, err := s.kv.Get(agentIDsString)
+	if err != nil || len(d) == 0 {
+		return agentIDs
+	}
+
+	stored := make([]string, 0)
+	if err = json.Unmarshal(d, &stored); err != nil {
+		return agentIDs
+	}
+
+	for _, agentID := range stored {
+		if agentID != systemAgentID {
+			agentIDs = append(agentIDs, agentID)
+		}
+	}
+
+	return agentIDs
+}
+
+// AddAgentID adds the agentID to the list of the registered agents
+func (s *Storage) AddAgentID(agentID string) error {
+	s.agentsLock.Lock()
+	defer s.agentsLock.Unlock()
+
+	agentIDs := s.GetAgentIDs()
+	for _, id := range agentIDs {
+		if id == agentID {
+			return nil
+		}
+	}
+
+	data, err := json.Marshal(append(agentIDs, agentID))
+	if err != nil {
+		return err
+	}
+
+	return s.kv.Set(agentIDsString, data)
+}
@@ -2,7 +2,9 @@ package lib
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -87,4 +89,44 @@ func TestStorage(t *testing.T) {
 			err := store.RemovePending(refID)
 			assert.NoError(t, err)
 		})
+
+	t.Run(
+		"Operations on storage - several agents",
+		func(t *testing.T) {
+			systemAgentID := "5zPWqLZaPqAaNenjyzWy5rcaGm4PuT1bfP74GgrzFUJn"
+			otherAgentID := "8nL4yDpXT2kRgG1B3tAaRqhZ1sGcS6pUy2wMh4KjLqEd"
+
+			err := store.AddAgentID(systemAgentID)
+			assert.NoError(t, err)
+			err = store.AddAgentID(otherAgentID)
+			assert.NoError(t, err)
+			err = store.AddAgentID(otherAgentID)
+			assert.NoError(t, err)
+
+			assert.Equal(t, []string{systemAgentID, otherAgentID}, store.GetAgentIDs())
+			assert.Equal(t, systemAgentID, store.GetSystemAgentID())
+		})
+
+	t.Run(
+		"Operations on storage - concurrent agent registrations",
+		func(t *testing.T) {
+			wg := sync.WaitGroup{}
+			expected := make([]string, 0, 20)
+			for i := 0; i < 20; i++ {
+				agentID := fmt.Sprintf("concurrent-agent-%d", i)
+				expected = append(expected, agentID)
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					assert.NoError(t, store.AddAgentID(agentID))
+				}()
+			}
+			wg.Wait()
+
+			agentIDs := store.GetAgentIDs()
+			for _, agentID := range expected {
+				assert.Contains(t, agentIDs, agentID)
+			}
+		})
 }
@@ -0,0 +1,187 @@
+// Package metrics defines the Prometheus collectors exposed by the signing agent on the /metrics endpoint.
+// The collectors are registered to the default Prometheus registry.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "signing_agent"
+
+// The sources of the approvals and rejections
+const (
+	SourceAuto = "auto"
+	SourceREST = "rest"
+)
+
+var (
+	// ActionsApproved counts the actions approved, by source
+	ActionsApproved = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "actions_approved_total",
+		Help:      "The number of actions approved.",
+	}, []string{"source"})
+
+	// ActionsRejected counts the actions rejected, by source
+	ActionsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "actions_rejected_total",
+		Help:      "The number of actions rejected.",
+	}, []string{"source"})
+
+	// ActionFailures counts the approvals and rejections that failed, by source and operation
+	ActionFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "action_failures_total",
+		Help:      "The number of approvals and rejections that failed.",
+	}, []string{"source", "operation"})
+
+	// ActionRetries counts the retries of the automatic approvals and rejections, by operation
+	ActionRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "action_retries_total",
+		Help:      "The number of retries of the automatic approvals and rejections.",
+	}, []string{"operation"})
+
+	// ActionDuration observes the time taken by a single approval or rejection call, by source and operation
+	ActionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "action_duration_seconds",
+		Help:      "The time taken by a single approval or rejection call.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"source", "operation"})
+
+	// ActionQueueJobs is the number of actions waiting in the queue for their automatic approval or rejection
+	ActionQueueJobs = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "action_queue_jobs",
+		Help:      "The number of actions waiting for their automatic approval or rejection.",
+	})
+
+	// PolicyDecisions counts the decisions returned by the policy decision point, by decision
+	PolicyDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "policy_decisions_total",
+		Help:      "The number of decisions returned by the policy decision point.",
+	}, []string{"decision"})
+
+	// PolicyFailures counts the failed calls to the policy decision point
+	PolicyFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "policy_failures_total",
+		Help:      "The number of failed calls to the policy decision point.",
+	})
+
+	// ShadowDecisions counts the decisions taken by the auto-approval in shadow mode, by decision
+	ShadowDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auto_approval_shadow_decisions_total",
+		Help:      "The number of decisions taken by the auto-approval in shadow mode.",
+	}, []string{"decision"})
+
+	// PendingActions is the number of actions received from the feed that are waiting for a decision
+	PendingActions = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pending_actions",
+		Help:      "The number of actions waiting for a decision.",
+	})
+
+	// PendingActionsResolved counts the pending actions that were approved, rejected or expired, by status
+	PendingActionsResolved = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pending_actions_resolved_total",
+		Help:      "The number of pending actions that were approved, rejected or expired.",
+	}, []string{"status"})
+
+	// FeedMessagesReceived counts the messages received by the feed hub from the source
+	FeedMessagesReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "feed_messages_received_total",
+		Help:      "The number of messages received by the feed hub.",
+	})
+
+	// FeedActionsReplayed counts the pending actions broadcast after a connection to the feed, as they were missed
+	FeedActionsReplayed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "feed_actions_replayed_total",
+		Help:      "The number of missed actions broadcast after a connection to the feed.",
+	})
+
+	// FeedClientsConnected is the number of clients registered to the feed hub, by type
+	FeedClientsConnected = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "feed_clients_connected",
+		Help:      "The number of clients registered to the feed hub.",
+	}, []string{"type"})
+
+	// FeedClientLag is the number of messages waiting in the buffer of a feed client
+	FeedClientLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "feed_client_lag",
+		Help:      "The number of messages waiting to be delivered to a feed client.",
+	}, []string{"client"})
+
+	// FeedBufferOverflows counts the messages that didn't fit in the buffer of a feed client, by policy applied
+	FeedBufferOverflows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "feed_buffer_overflows_total",
+		Help:      "The number of messages that didn't fit in the buffer of a feed client.",
+	}, []string{"type", "policy"})
+
+	// WebsocketReconnects counts the reconnections to the Qredo websocket feed after a connection error
+	WebsocketReconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_reconnects_total",
+		Help:      "The number of reconnections to the Qredo websocket feed after a connection error.",
+	})
+
+	// WebsocketConnectErrors counts the failed attempts to connect to the Qredo websocket feed
+	WebsocketConnectErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_connect_errors_total",
+		Help:      "The number of failed attempts to connect to the Qredo websocket feed.",
+	})
+
+	// QredoAPIRequestDuration observes the latency of the Qredo API requests, by method, url and status code
+	QredoAPIRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "qredo_api_request_duration_seconds",
+		Help:      "The latency of the Qredo API requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "url", "code"})
+
+	// WebhookDeliveries counts the actions posted to the webhook endpoints, by endpoint and result
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "The number of actions posted to the webhook endpoints.",
+	}, []string{"endpoint", "result"})
+
+	// WebhookRetries counts the retries of the failed webhook deliveries, by endpoint
+	WebhookRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_retries_total",
+		Help:      "The number of retries of the failed webhook deliveries.",
+	}, []string{"endpoint"})
+)
+
+// The results of the webhook deliveries
+const (
+	WebhookDelivered  = "delivered"
+	WebhookDeadLetter = "dead_letter"
+)
+
+// The types of feed clients
+const (
+	ClientInternal = "internal"
+	ClientExternal = "external"
+)
+
+// ClientType returns the label used for a feed client
+func ClientType(isInternal bool) string {
+	if isInternal {
+		return ClientInternal
+	}
+	return ClientExternal
+}
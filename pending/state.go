@@ -0,0 +1,179 @@
+package pending
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/autoapprover"
+	"github.com/qredo/signing-agent/defs"
+)
+
+// actionState is the state of an action and when it was last updated
+type actionState struct {
+	state   string
+	updated time.Time
+}
+
+// isInProgress returns whether the action is being approved or rejected
+func (s actionState) isInProgress() bool {
+	return s.state == api.ActionStateApproving || s.state == api.ActionStateRejecting
+}
+
+// isFinal returns whether the action can no longer be approved or rejected. The states other than
+// received, approving, rejecting and failed are final, including the ones reported by the feed
+func (s actionState) isFinal() bool {
+	switch s.state {
+	case "", api.ActionStateReceived, api.ActionStateFailed, api.ActionStateApproving, api.ActionStateRejecting:
+		return false
+	default:
+		return true
+	}
+}
+
+// State returns the current state of the action, or false when the action is unknown or forgotten
+func (t *Tracker) State(actionID string) (string, bool) {
+	t.sweep()
+
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	state, ok := t.states[actionID]
+	return state.state, ok
+}
+
+// States returns the current states of the actions, the unknown or forgotten ones are left out
+func (t *Tracker) States(actionIDs []string) map[string]string {
+	t.sweep()
+
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	states := make(map[string]string)
+	for _, actionID := range actionIDs {
+		if state, ok := t.states[actionID]; ok {
+			states[actionID] = state.state
+		}
+	}
+	return states
+}
+
+// ActionManager returns an ActionManager calling the given one and keeping the state of the actions it approves or rejects.
+// Approving or rejecting an action again while it's in progress, or once it's done, doesn't call the given ActionManager again
+func (t *Tracker) ActionManager(actionManager autoapprover.ActionManager) autoapprover.ActionManager {
+	return &trackingActionManager{
+		ActionManager: actionManager,
+		tracker:       t,
+	}
+}
+
+// Begin moves the action to approving or rejecting for the decision of the auto-approval, approve or reject.
+// It returns false when the action is already in progress or done, and an error when it's in a conflicting state
+func (t *Tracker) Begin(actionID, decision string) (bool, error) {
+	inProgress, done := decisionStates(decision)
+	_, ok, err := t.begin(actionID, inProgress, done)
+	return ok, err
+}
+
+// End moves the action to approved or rejected for the decision of the auto-approval, or to failed when it failed
+func (t *Tracker) End(actionID, decision string, err error) {
+	inProgress, done := decisionStates(decision)
+	if err != nil {
+		t.end(actionID, inProgress, actionState{state: api.ActionStateFailed, updated: t.now()})
+		return
+	}
+
+	t.Resolve(actionID, done)
+}
+
+// decisionStates returns the in progress and done states of the decision
+func decisionStates(decision string) (string, string) {
+	if decision == autoapprover.DecisionReject {
+		return api.ActionStateRejecting, api.ActionStateRejected
+	}
+	return api.ActionStateApproving, api.ActionStateApproved
+}
+
+// setState must be called with the lock held
+func (t *Tracker) setState(actionID, state string) {
+	t.states[actionID] = actionState{
+		state:   state,
+		updated: t.now(),
+	}
+}
+
+// begin moves the action to the inProgress state, and returns its previous state. It returns false when the action
+// is already in the inProgress or done state, so that it's not handled twice, and an error when it's in another
+// final or in progress state
+func (t *Tracker) begin(actionID, inProgress, done string) (actionState, bool, error) {
+	t.sweep()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	previous := t.states[actionID]
+	switch {
+	case previous.state == inProgress || previous.state == done:
+		return previous, false, nil
+	case previous.isInProgress() || previous.isFinal():
+		return previous, false, defs.ErrConflict().WithDetail(fmt.Sprintf("action is already %s", previous.state))
+	}
+
+	t.setState(actionID, inProgress)
+	return previous, true, nil
+}
+
+// end moves the action from the inProgress state to the given one, unless the state was changed meanwhile
+func (t *Tracker) end(actionID, inProgress string, state actionState) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.states[actionID].state != inProgress {
+		return
+	}
+
+	if state.state == "" {
+		delete(t.states, actionID)
+		return
+	}
+	t.states[actionID] = state
+}
+
+// trackingActionManager keeps the state of the actions it approves or rejects, and resolves the pending actions once they are
+type trackingActionManager struct {
+	autoapprover.ActionManager
+	tracker *Tracker
+}
+
+// Approve the action for the given actionID
+func (m *trackingActionManager) Approve(actionID string) error {
+	return m.handle(actionID, api.ActionStateApproving, api.ActionStateApproved, m.ActionManager.Approve)
+}
+
+// Reject the action for the given actionID, with the reason
+func (m *trackingActionManager) Reject(actionID string, reason api.RejectReason) error {
+	return m.handle(actionID, api.ActionStateRejecting, api.ActionStateRejected, func(actionID string) error {
+		return m.ActionManager.Reject(actionID, reason)
+	})
+}
+
+// handle calls the ActionManager unless the action is already in progress or done. The action is failed when the call fails,
+// and goes back to its previous state when it was handled by another signing agent
+func (m *trackingActionManager) handle(actionID, inProgress, done string, do func(actionID string) error) error {
+	previous, ok, err := m.tracker.begin(actionID, inProgress, done)
+	if err != nil || !ok {
+		return err
+	}
+
+	if err := do(actionID); err != nil {
+		if autoapprover.IsActionHandled(err) {
+			m.tracker.end(actionID, inProgress, previous)
+		} else {
+			m.tracker.end(actionID, inProgress, actionState{state: api.ActionStateFailed, updated: m.tracker.now()})
+		}
+		return err
+	}
+
+	m.tracker.Resolve(actionID, done)
+	return nil
+}
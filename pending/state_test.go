@@ -0,0 +1,189 @@
+package pending
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/autoapprover"
+	"github.com/qredo/signing-agent/defs"
+)
+
+func TestTracker_State(t *testing.T) {
+	//Arrange
+	sut := newTestTracker(1000)
+	sut.track([]byte(`{"id":"received","status":"pending","expireTime":3000}`))
+	sut.track([]byte(`{"id":"expired","status":"pending","expireTime":2000}`))
+	sut.track([]byte(`{"id":"cancelled","status":"pending","expireTime":3000}`))
+	sut.track([]byte(`{"id":"cancelled","status":"cancelled","expireTime":3000}`))
+	sut.now = func() time.Time { return time.Unix(2000, 0) }
+
+	//Act
+	received, _ := sut.State("received")
+	expired, _ := sut.State("expired")
+	cancelled, _ := sut.State("cancelled")
+	_, unknown := sut.State("unknown")
+
+	//Assert
+	assert.Equal(t, api.ActionStateReceived, received)
+	assert.Equal(t, api.ActionStateExpired, expired)
+	assert.Equal(t, "cancelled", cancelled)
+	assert.False(t, unknown)
+}
+
+func TestTracker_States(t *testing.T) {
+	//Arrange
+	sut := newTestTracker(1000)
+	sut.track([]byte(`{"id":"received","status":"pending","expireTime":3000}`))
+	sut.track([]byte(`{"id":"approved","status":"pending","expireTime":3000}`))
+	sut.Resolve("approved", api.ActionStateApproved)
+
+	//Act
+	states := sut.States([]string{"received", "approved", "unknown"})
+
+	//Assert
+	assert.Equal(t, map[string]string{
+		"received": api.ActionStateReceived,
+		"approved": api.ActionStateApproved,
+	}, states)
+}
+
+func TestTracker_ActionManager_is_idempotent(t *testing.T) {
+	//Arrange
+	sut := newTestTracker(1000)
+	sut.track([]byte(`{"id":"some action id","status":"pending","expireTime":2000}`))
+	actionManager := &mockActionManager{}
+	manager := sut.ActionManager(actionManager)
+
+	//Act
+	first := manager.Approve("some action id")
+	second := manager.Approve("some action id")
+	reject := manager.Reject("some action id", api.RejectReason{})
+
+	//Assert
+	assert.Nil(t, first)
+	assert.Nil(t, second)
+	assert.Equal(t, 1, actionManager.Calls)
+	state, _ := sut.State("some action id")
+	assert.Equal(t, api.ActionStateApproved, state)
+	apiErr, ok := reject.(*defs.APIError)
+	assert.True(t, ok)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, "action is already approved", detail)
+}
+
+func TestTracker_ActionManager_in_progress(t *testing.T) {
+	//Arrange
+	sut := newTestTracker(1000)
+	sut.track([]byte(`{"id":"some action id","status":"pending","expireTime":2000}`))
+	actionManager := &mockActionManager{}
+	manager := sut.ActionManager(actionManager)
+	_, _, _ = sut.begin("some action id", api.ActionStateApproving, api.ActionStateApproved)
+
+	//Act
+	approve := manager.Approve("some action id")
+	reject := manager.Reject("some action id", api.RejectReason{})
+
+	//Assert
+	assert.Nil(t, approve)
+	assert.NotNil(t, reject)
+	assert.Equal(t, 0, actionManager.Calls)
+	state, _ := sut.State("some action id")
+	assert.Equal(t, api.ActionStateApproving, state)
+}
+
+func TestTracker_ActionManager_failures(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		err      error
+		expected string
+		known    bool
+	}{
+		{"failed", errors.New("some error"), api.ActionStateFailed, true},
+		{"handled by another signing agent", autoapprover.ErrActionHandled, "", false},
+		{"not found", defs.ErrNotFound().WithDetail("agentID not found"), api.ActionStateFailed, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			sut := newTestTracker(1000)
+			actionManager := &mockActionManager{NextError: tc.err}
+			manager := sut.ActionManager(actionManager)
+
+			//Act
+			failed := manager.Approve("some action id")
+			state, known := sut.State("some action id")
+			actionManager.NextError = nil
+			retried := manager.Approve("some action id")
+
+			//Assert
+			assert.Equal(t, tc.err, failed)
+			assert.Equal(t, tc.expected, state)
+			assert.Equal(t, tc.known, known)
+			assert.Nil(t, retried)
+			assert.Equal(t, 2, actionManager.Calls)
+		})
+	}
+}
+
+func TestTracker_Begin_End(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		decision string
+		err      error
+		expected string
+	}{
+		{"approved", autoapprover.DecisionApprove, nil, api.ActionStateApproved},
+		{"rejected", autoapprover.DecisionReject, nil, api.ActionStateRejected},
+		{"failed", autoapprover.DecisionApprove, errors.New("some error"), api.ActionStateFailed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			sut := newTestTracker(1000)
+			sut.track([]byte(`{"id":"some action id","status":"pending","expireTime":2000}`))
+
+			//Act
+			ok, err := sut.Begin("some action id", tc.decision)
+			sut.End("some action id", tc.decision, tc.err)
+
+			//Assert
+			assert.True(t, ok)
+			assert.Nil(t, err)
+			state, _ := sut.State("some action id")
+			assert.Equal(t, tc.expected, state)
+			_, pending := sut.Get("some action id")
+			assert.Equal(t, tc.err != nil, pending)
+		})
+	}
+}
+
+func TestTracker_ActionManager_during_auto_approval(t *testing.T) {
+	//Arrange
+	sut := newTestTracker(1000)
+	sut.track([]byte(`{"id":"some action id","status":"pending","expireTime":2000}`))
+	actionManager := &mockActionManager{}
+	manager := sut.ActionManager(actionManager)
+	ok, _ := sut.Begin("some action id", autoapprover.DecisionApprove)
+
+	//Act
+	approve := manager.Approve("some action id")
+	reject := manager.Reject("some action id", api.RejectReason{})
+	again, _ := sut.Begin("some action id", autoapprover.DecisionApprove)
+
+	//Assert
+	assert.True(t, ok)
+	assert.Nil(t, approve)
+	assert.NotNil(t, reject)
+	assert.False(t, again)
+	assert.Equal(t, 0, actionManager.Calls)
+	state, _ := sut.State("some action id")
+	assert.Equal(t, api.ActionStateApproving, state)
+}
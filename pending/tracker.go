@@ -0,0 +1,215 @@
+// Package pending keeps track of the actions received from the feed that are waiting for a decision, and of the state
+// of every action the signing agent sees: received, approving or rejecting, then approved, rejected, failed or expired.
+// An action is pending until it's approved or rejected through the signing agent, the feed reports a new status for it,
+// or its expire time is reached.
+
+package pending
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/hub"
+	"github.com/qredo/signing-agent/lib"
+	"github.com/qredo/signing-agent/metrics"
+)
+
+// The statuses of the actions. Only the actions received with the pending status are tracked
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+	StatusExpired  = "expired"
+)
+
+const (
+	// sweepPeriod is how often the expired actions are removed while listening
+	sweepPeriod = time.Second
+
+	// stateRetention is how long the state of an action is remembered once it's no longer pending, so that it isn't
+	// tracked again when it's resolved before its message is received, and that it's not approved or rejected twice
+	stateRetention = time.Hour
+)
+
+// Tracker is an internal feed client keeping the list of the pending actions and the state of the actions
+type Tracker struct {
+	hub.FeedClient
+	log     *zap.SugaredLogger
+	lock    sync.RWMutex
+	actions map[string]api.PendingAction
+	states  map[string]actionState
+	now     func() time.Time
+}
+
+// NewTracker returns a new *Tracker with no pending action
+func NewTracker(log *zap.SugaredLogger) *Tracker {
+	return &Tracker{
+		FeedClient: hub.NewFeedClient(true),
+		log:        log,
+		actions:    make(map[string]api.PendingAction),
+		states:     make(map[string]actionState),
+		now:        time.Now,
+	}
+}
+
+// GetFeedClient returns the FeedClient registered to the feed hub
+func (t *Tracker) GetFeedClient() *hub.FeedClient {
+	return &t.FeedClient
+}
+
+// Listen is constantly listening for messages on the Feed channel until it's closed by the sender.
+// The expired actions are removed periodically meanwhile
+func (t *Tracker) Listen() {
+	ticker := time.NewTicker(sweepPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message, ok := <-t.Feed:
+			if !ok {
+				t.log.Info("Tracker: stopped")
+				return
+			}
+			t.track(message)
+		case <-ticker.C:
+			t.sweep()
+		}
+	}
+}
+
+// List returns the pending actions, the first to expire first
+func (t *Tracker) List() []api.PendingAction {
+	t.sweep()
+
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	actions := make([]api.PendingAction, 0, len(t.actions))
+	for _, action := range t.actions {
+		actions = append(actions, action)
+	}
+
+	sort.Slice(actions, func(i, j int) bool {
+		if actions[i].ExpireTime == actions[j].ExpireTime {
+			return actions[i].ActionID < actions[j].ActionID
+		}
+		return actions[i].ExpireTime < actions[j].ExpireTime
+	})
+
+	return actions
+}
+
+// Get returns the action if it's pending and not expired
+func (t *Tracker) Get(actionID string) (api.PendingAction, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	action, ok := t.actions[actionID]
+	if !ok || (action.ExpireTime > 0 && action.ExpireTime <= t.now().Unix()) {
+		return api.PendingAction{}, false
+	}
+
+	return action, true
+}
+
+// Resolve removes the action from the pending actions, with the status it was resolved to.
+// An action resolved before being received isn't tracked when it's received later
+func (t *Tracker) Resolve(actionID, status string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.setState(actionID, status)
+	t.remove(actionID, status)
+}
+
+func (t *Tracker) track(message []byte) {
+	var action lib.WsActionInfoEvent
+	if err := json.Unmarshal(message, &action); err != nil {
+		t.log.Errorf("Tracker: error [%v] while unmarshaling the message [%v]", err, string(message))
+		return
+	}
+
+	if action.ID == "" {
+		return
+	}
+
+	if action.Status != StatusPending {
+		t.Resolve(action.ID, action.Status)
+		return
+	}
+
+	if action.ExpireTime > 0 && action.ExpireTime <= t.now().Unix() {
+		t.log.Debugf("Tracker: action [%v] received already expired", action.ID)
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if _, ok := t.actions[action.ID]; ok {
+		return
+	}
+	state, ok := t.states[action.ID]
+	if ok && state.isFinal() {
+		t.log.Debugf("Tracker: action [%v] received already %v", action.ID, state.state)
+		return
+	}
+	if !ok {
+		t.setState(action.ID, api.ActionStateReceived)
+	}
+
+	t.actions[action.ID] = api.PendingAction{
+		ActionID:   action.ID,
+		AgentID:    action.AgentID,
+		Type:       action.Type,
+		Timestamp:  action.Timestamp,
+		ExpireTime: action.ExpireTime,
+		Received:   t.now().Unix(),
+	}
+	metrics.PendingActions.Inc()
+	t.log.Debugf("Tracker: action [%v] is pending", action.ID)
+}
+
+// sweep removes the actions whose expire time was reached, and forgets the states of the actions
+// no longer pending for long enough
+func (t *Tracker) sweep() {
+	now := t.now()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for actionID, action := range t.actions {
+		if action.ExpireTime > 0 && action.ExpireTime <= now.Unix() {
+			if !t.states[actionID].isInProgress() {
+				t.setState(actionID, api.ActionStateExpired)
+			}
+			t.remove(actionID, StatusExpired)
+		}
+	}
+
+	for actionID, state := range t.states {
+		if _, pending := t.actions[actionID]; pending || state.isInProgress() {
+			continue
+		}
+		if now.Sub(state.updated) >= stateRetention {
+			delete(t.states, actionID)
+		}
+	}
+}
+
+// remove must be called with the lock held
+func (t *Tracker) remove(actionID, status string) {
+	if _, ok := t.actions[actionID]; !ok {
+		return
+	}
+
+	delete(t.actions, actionID)
+	metrics.PendingActions.Dec()
+	metrics.PendingActionsResolved.WithLabelValues(status).Inc()
+	t.log.Debugf("Tracker: action [%v] is %v", actionID, status)
+}
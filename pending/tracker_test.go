@@ -0,0 +1,146 @@
+package pending
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+
+	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/util"
+)
+
+type mockActionManager struct {
+	NextError error
+	Calls     int
+}
+
+func (m *mockActionManager) Approve(string) error {
+	m.Calls++
+	return m.NextError
+}
+
+func (m *mockActionManager) Reject(string, api.RejectReason) error {
+	m.Calls++
+	return m.NextError
+}
+
+func newTestTracker(now int64) *Tracker {
+	sut := NewTracker(util.NewTestLogger())
+	sut.now = func() time.Time { return time.Unix(now, 0) }
+	return sut
+}
+
+func TestTracker_Listen_tracks_pending_actions(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	sut := newTestTracker(1000)
+	done := make(chan struct{})
+	go func() {
+		sut.Listen()
+		close(done)
+	}()
+
+	//Act
+	sut.Feed <- []byte(`{"id":"second","coreClientID":"agent id","type":"ApproveWithdraw","status":"pending","timestamp":900,"expireTime":3000}`)
+	sut.Feed <- []byte(`{"id":"first","coreClientID":"agent id","type":"ApproveWithdraw","status":"pending","timestamp":900,"expireTime":2000}`)
+	sut.Feed <- []byte(`{"id":"resolved","status":"pending","expireTime":2000}`)
+	sut.Feed <- []byte(`{"id":"resolved","status":"approved","expireTime":2000}`)
+	sut.Feed <- []byte(`{"id":"late","status":"pending","expireTime":1000}`)
+	sut.Feed <- []byte("not json")
+	close(sut.Feed)
+	<-done
+
+	//Assert
+	assert.Equal(t, []api.PendingAction{
+		{ActionID: "first", AgentID: "agent id", Type: "ApproveWithdraw", Timestamp: 900, ExpireTime: 2000, Received: 1000},
+		{ActionID: "second", AgentID: "agent id", Type: "ApproveWithdraw", Timestamp: 900, ExpireTime: 3000, Received: 1000},
+	}, sut.List())
+}
+
+func TestTracker_List_removes_expired_actions(t *testing.T) {
+	//Arrange
+	sut := newTestTracker(1000)
+	sut.track([]byte(`{"id":"first","status":"pending","expireTime":2000}`))
+	sut.track([]byte(`{"id":"second","status":"pending","expireTime":3000}`))
+	sut.now = func() time.Time { return time.Unix(2000, 0) }
+
+	//Act
+	actions := sut.List()
+
+	//Assert
+	assert.Len(t, actions, 1)
+	assert.Equal(t, "second", actions[0].ActionID)
+}
+
+func TestTracker_Get(t *testing.T) {
+	//Arrange
+	sut := newTestTracker(1000)
+	sut.track([]byte(`{"id":"first","type":"ApproveWithdraw","status":"pending","expireTime":2000}`))
+	sut.track([]byte(`{"id":"second","status":"pending","expireTime":3000}`))
+	sut.now = func() time.Time { return time.Unix(2000, 0) }
+
+	//Act
+	_, expired := sut.Get("first")
+	action, pending := sut.Get("second")
+	_, unknown := sut.Get("third")
+
+	//Assert
+	assert.False(t, expired)
+	assert.True(t, pending)
+	assert.Equal(t, "second", action.ActionID)
+	assert.False(t, unknown)
+}
+
+func TestTracker_Resolve_before_received(t *testing.T) {
+	//Arrange
+	sut := newTestTracker(1000)
+
+	//Act
+	sut.Resolve("early", StatusApproved)
+	sut.track([]byte(`{"id":"early","status":"pending","expireTime":2000}`))
+	early := sut.List()
+	sut.now = func() time.Time { return time.Unix(1000, 0).Add(stateRetention) }
+	sut.track([]byte(`{"id":"early","status":"pending","expireTime":2000}`))
+	late := sut.List()
+
+	//Assert
+	assert.Empty(t, early)
+	assert.Empty(t, late)
+	assert.Empty(t, sut.states)
+}
+
+func TestTracker_ActionManager_resolves_actions(t *testing.T) {
+	var testCases = []struct {
+		name      string
+		decide    func(actionManager *mockActionManager, sut *Tracker) error
+		nextError error
+		pending   int
+	}{
+		{"approved", func(m *mockActionManager, sut *Tracker) error { return sut.ActionManager(m).Approve("some action id") }, nil, 0},
+		{"rejected", func(m *mockActionManager, sut *Tracker) error {
+			return sut.ActionManager(m).Reject("some action id", api.RejectReason{})
+		}, nil, 0},
+		{"approve failed", func(m *mockActionManager, sut *Tracker) error { return sut.ActionManager(m).Approve("some action id") }, errors.New("some error"), 1},
+		{"reject failed", func(m *mockActionManager, sut *Tracker) error {
+			return sut.ActionManager(m).Reject("some action id", api.RejectReason{})
+		}, errors.New("some error"), 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			sut := newTestTracker(1000)
+			sut.track([]byte(`{"id":"some action id","status":"pending","expireTime":2000}`))
+
+			//Act
+			err := tc.decide(&mockActionManager{NextError: tc.nextError}, sut)
+
+			//Assert
+			assert.Equal(t, tc.nextError, err)
+			assert.Len(t, sut.List(), tc.pending)
+		})
+	}
+}
@@ -0,0 +1,192 @@
+// Package queue keeps the actions waiting to be approved or rejected automatically.
+// A job stays in the queue until its outcome is known, so that the actions pending when the signing agent
+// stops are resumed on the next start. The queue is written to a file on every change and loaded back on start.
+package queue
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/config"
+	"github.com/qredo/signing-agent/metrics"
+)
+
+// Job is an action waiting for its decision to be carried out
+type Job struct {
+	ActionID   string `json:"actionID"`
+	AgentID    string `json:"agentID"`
+	Type       string `json:"type,omitempty"`
+	ExpireTime int64  `json:"expireTime"`
+	Decision   string `json:"decision"`
+	// Reason is the reason sent and recorded when the action is rejected
+	Reason *api.RejectReason `json:"reason,omitempty"`
+	// Attempts is the number of failed attempts so far
+	Attempts int `json:"attempts"`
+	// Elapsed is the time spent retrying so far, in milliseconds. It's taken from the retry budget when the job is resumed
+	Elapsed int64 `json:"elapsedMs"`
+	// Queued is the time the job was added to the queue
+	Queued int64 `json:"queued"`
+}
+
+// Queue keeps the jobs until they're done
+type Queue interface {
+	// Push adds the job to the queue. It returns false when a job for the same action is already queued
+	Push(job *Job) (bool, error)
+	// Update saves the progress of the job
+	Update(job *Job) error
+	// Done removes the job of the agent's action from the queue
+	Done(agentID, actionID string) error
+	// Pending returns the queued jobs of the agent, oldest first
+	Pending(agentID string) []*Job
+}
+
+type queueImpl struct {
+	lock     sync.Mutex
+	fileName string
+	jobs     map[string]*Job // by jobKey, the agents sharing the queue never see the jobs of each other
+}
+
+// jobKey returns the key of the job of the agent's action
+func jobKey(agentID, actionID string) string {
+	return agentID + "/" + actionID
+}
+
+// NewQueue returns a Queue that's an instance of queueImpl.
+// When a file name is given, the previously queued jobs are loaded and every change is written to it
+func NewQueue(cfg *config.ActionQueue) (Queue, error) {
+	q := &queueImpl{
+		fileName: cfg.File,
+		jobs:     make(map[string]*Job),
+	}
+
+	if len(q.fileName) == 0 {
+		return q, nil
+	}
+
+	if err := q.load(); err != nil {
+		return nil, errors.Wrap(err, "load action queue")
+	}
+	metrics.ActionQueueJobs.Set(float64(len(q.jobs)))
+
+	return q, nil
+}
+
+// Push adds a copy of the job to the queue and saves it
+func (q *queueImpl) Push(job *Job) (bool, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	key := jobKey(job.AgentID, job.ActionID)
+	if _, ok := q.jobs[key]; ok {
+		return false, nil
+	}
+
+	stored := *job
+	q.jobs[key] = &stored
+	return true, q.save()
+}
+
+// Update saves the progress of a queued job. Jobs no longer queued are ignored
+func (q *queueImpl) Update(job *Job) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	key := jobKey(job.AgentID, job.ActionID)
+	if _, ok := q.jobs[key]; !ok {
+		return nil
+	}
+
+	stored := *job
+	q.jobs[key] = &stored
+	return q.save()
+}
+
+// Done removes the job of the agent's action and saves the queue
+func (q *queueImpl) Done(agentID, actionID string) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	key := jobKey(agentID, actionID)
+	if _, ok := q.jobs[key]; !ok {
+		return nil
+	}
+
+	delete(q.jobs, key)
+	return q.save()
+}
+
+// Pending returns copies of the queued jobs of the agent, oldest first
+func (q *queueImpl) Pending(agentID string) []*Job {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	jobs := make([]*Job, 0)
+	for _, job := range q.jobs {
+		if job.AgentID == agentID {
+			pending := *job
+			jobs = append(jobs, &pending)
+		}
+	}
+
+	sort.SliceStable(jobs, func(i, j int) bool {
+		if jobs[i].Queued == jobs[j].Queued {
+			return jobs[i].ActionID < jobs[j].ActionID
+		}
+		return jobs[i].Queued < jobs[j].Queued
+	})
+	return jobs
+}
+
+// save writes all the queued jobs to a temporary file, then renames it, so that the file is never left half written
+func (q *queueImpl) save() error {
+	metrics.ActionQueueJobs.Set(float64(len(q.jobs)))
+
+	if len(q.fileName) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(q.jobs)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(q.fileName), filepath.Base(q.fileName)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "create action queue")
+	}
+	defer os.Remove(f.Name())
+
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "write action queue")
+	}
+
+	return errors.Wrap(os.Rename(f.Name(), q.fileName), "save action queue")
+}
+
+func (q *queueImpl) load() error {
+	data, err := os.ReadFile(q.fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(data, &q.jobs)
+}
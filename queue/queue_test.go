@@ -0,0 +1,118 @@
+package queue
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qredo/signing-agent/config"
+)
+
+func TestQueue_push_ignores_queued_action(t *testing.T) {
+	//Arrange
+	sut, err := NewQueue(&config.ActionQueue{})
+	require.Nil(t, err)
+
+	//Act
+	queued, err := sut.Push(&Job{ActionID: "some action id", AgentID: "some agent id", Decision: "approve"})
+	require.Nil(t, err)
+	duplicate, err := sut.Push(&Job{ActionID: "some action id", AgentID: "some agent id", Decision: "reject"})
+
+	//Assert
+	assert.Nil(t, err)
+	assert.True(t, queued)
+	assert.False(t, duplicate)
+	pending := sut.Pending("some agent id")
+	require.Len(t, pending, 1)
+	assert.Equal(t, "approve", pending[0].Decision)
+}
+
+func TestQueue_pending_returns_agent_jobs_oldest_first(t *testing.T) {
+	//Arrange
+	sut, err := NewQueue(&config.ActionQueue{})
+	require.Nil(t, err)
+	for _, job := range []*Job{
+		{ActionID: "newer action id", AgentID: "some agent id", Queued: 20},
+		{ActionID: "other agent action id", AgentID: "other agent id", Queued: 5},
+		{ActionID: "older action id", AgentID: "some agent id", Queued: 10},
+	} {
+		_, err = sut.Push(job)
+		require.Nil(t, err)
+	}
+
+	//Act
+	pending := sut.Pending("some agent id")
+
+	//Assert
+	require.Len(t, pending, 2)
+	assert.Equal(t, "older action id", pending[0].ActionID)
+	assert.Equal(t, "newer action id", pending[1].ActionID)
+}
+
+func TestQueue_loads_stored_jobs(t *testing.T) {
+	//Arrange
+	cfg := &config.ActionQueue{File: filepath.Join(t.TempDir(), "action_queue.db")}
+	sut, err := NewQueue(cfg)
+	require.Nil(t, err)
+
+	job := &Job{ActionID: "some action id", AgentID: "some agent id", Decision: "approve", ExpireTime: 12360}
+	_, err = sut.Push(job)
+	require.Nil(t, err)
+	_, err = sut.Push(&Job{ActionID: "done action id", AgentID: "some agent id"})
+	require.Nil(t, err)
+
+	job.Attempts = 3
+	job.Elapsed = 15000
+	require.Nil(t, sut.Update(job))
+	require.Nil(t, sut.Done("some agent id", "done action id"))
+
+	//Act
+	reopened, err := NewQueue(cfg)
+
+	//Assert
+	require.Nil(t, err)
+	pending := reopened.Pending("some agent id")
+	require.Len(t, pending, 1)
+	assert.Equal(t, *job, *pending[0])
+}
+
+func TestQueue_update_ignores_done_job(t *testing.T) {
+	//Arrange
+	sut, err := NewQueue(&config.ActionQueue{})
+	require.Nil(t, err)
+	job := &Job{ActionID: "some action id", AgentID: "some agent id"}
+	_, err = sut.Push(job)
+	require.Nil(t, err)
+	require.Nil(t, sut.Done(job.AgentID, job.ActionID))
+
+	//Act
+	job.Attempts = 1
+	err = sut.Update(job)
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Empty(t, sut.Pending("some agent id"))
+}
+
+func TestQueue_keeps_jobs_of_agents_apart(t *testing.T) {
+	//Arrange
+	sut, err := NewQueue(&config.ActionQueue{})
+	require.Nil(t, err)
+	for _, agentID := range []string{"some agent id", "other agent id"} {
+		queued, err := sut.Push(&Job{ActionID: "some action id", AgentID: agentID})
+		require.Nil(t, err)
+		require.True(t, queued)
+	}
+
+	//Act
+	err = sut.Done("other agent id", "some action id")
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Empty(t, sut.Pending("other agent id"))
+	pending := sut.Pending("some agent id")
+	require.Len(t, pending, 1)
+	assert.Equal(t, "some action id", pending[0].ActionID)
+}
@@ -0,0 +1,208 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"github.com/qredo/signing-agent/autoapprover"
+	"github.com/qredo/signing-agent/coapproval"
+	"github.com/qredo/signing-agent/config"
+	"github.com/qredo/signing-agent/defs"
+	"github.com/qredo/signing-agent/hub"
+	"github.com/qredo/signing-agent/journal"
+	"github.com/qredo/signing-agent/lib"
+	"github.com/qredo/signing-agent/pending"
+	"github.com/qredo/signing-agent/queue"
+	rest_handlers "github.com/qredo/signing-agent/rest/handlers"
+	"github.com/qredo/signing-agent/util"
+	"github.com/qredo/signing-agent/webhook"
+)
+
+// agentService groups the components serving a single agent: its own feed connection and hub,
+// its auto approver and the handlers of its routes
+type agentService struct {
+	source              hub.Source
+	feedHub             hub.FeedHub
+	signingAgentHandler *rest_handlers.SigningAgentHandler
+	actionHandler       *rest_handlers.ActionHandler
+	actionManager       autoapprover.ActionManager
+	tracker             *pending.Tracker
+}
+
+// agentServiceFactory creates the agentService of an agent.
+// The agents share the action queue, where their jobs are kept apart, but every agent gets its own syncronizer
+type agentServiceFactory struct {
+	log            *zap.SugaredLogger
+	journal        journal.Journal
+	deadLetters    webhook.DeadLetterStore
+	actionQueue    queue.Queue
+	newSyncronizer func() autoapprover.ActionSyncronizer
+	store          util.KVStore
+}
+
+// coApprovalKey is the key of the approvals collected for the actions of the system agent in the KV store,
+// the ones of the other agents are saved under the key followed by the agent ID
+const coApprovalKey = "CoApprovalBallots"
+
+// newAgentService returns the agentService of the agent, the agentID is empty for the system agent
+func (f *agentServiceFactory) newAgentService(agentID string, core lib.SigningAgentClient, config *config.Config, localFeed string) *agentService {
+	serverConn := hub.NewWebsocketSource(hub.NewDefaultDialer(), genWSQredoCoreClientFeedURL(&config.Websocket), f.log, core, &config.Websocket)
+	feedHub := hub.NewFeedHub(serverConn, f.log, &config.FeedBuffer)
+
+	var syncronizer autoapprover.ActionSyncronizer
+	if f.newSyncronizer != nil {
+		syncronizer = f.newSyncronizer()
+	}
+	autoApprover := autoapprover.NewAutoApprover(core, f.log, config, syncronizer, f.journal, f.actionQueue)
+
+	tracker := pending.NewTracker(f.log)
+	autoApprover.SetTracker(tracker)
+	feedListeners := []hub.FeedListener{tracker}
+	if config.Journal.Enabled {
+		feedListeners = append(feedListeners, journal.NewFeedRecorder(f.journal, f.log))
+	}
+	if config.Webhooks.Enabled {
+		feedListeners = append(feedListeners, webhook.NewDispatcher(&config.Webhooks, f.deadLetters, f.log))
+	}
+
+	upgrader := hub.NewDefaultUpgrader(config.Websocket.ReadBufferSize, config.Websocket.WriteBufferSize)
+	actionManager := tracker.ActionManager(autoapprover.NewActionManager(core, syncronizer, f.log, config.LoadBalancing.Enable, f.journal))
+
+	var coApprover *coapproval.Manager
+	if config.CoApproval.Enabled {
+		coApprover = coapproval.NewManager(&config.CoApproval, actionManager, tracker, f.journal, f.log)
+		if f.store != nil {
+			key := coApprovalKey
+			if len(agentID) > 0 {
+				key += "-" + agentID
+			}
+			if err := coApprover.SetStore(f.store, key); err != nil {
+				f.log.Errorf("CoApproval: failed to load the approvals, err: %v", err)
+			}
+		}
+		actionManager = coApprover
+	}
+
+	actionHandler := rest_handlers.NewActionHandler(actionManager, f.journal, tracker)
+	actionHandler.SetActionFetcher(core)
+	actionHandler.SetBatchConfig(&config.Batch)
+	if coApprover != nil {
+		actionHandler.SetCoApprover(coApprover)
+	}
+
+	signingAgentHandler := rest_handlers.NewSigningAgentHandler(feedHub, core, f.log, config, autoApprover, feedListeners, upgrader, localFeed)
+	signingAgentHandler.SetActionManager(actionManager, tracker)
+
+	return &agentService{
+		source:              serverConn,
+		feedHub:             feedHub,
+		signingAgentHandler: signingAgentHandler,
+		actionHandler:       actionHandler,
+		actionManager:       actionManager,
+		tracker:             tracker,
+	}
+}
+
+// agentRegistry serves the agents registered besides the system agent, each one with its own agentService
+type agentRegistry struct {
+	lock     sync.RWMutex
+	log      *zap.SugaredLogger
+	config   *config.Config
+	core     lib.SigningAgentClient
+	factory  *agentServiceFactory
+	system   *agentService
+	services map[string]*agentService
+}
+
+func newAgentRegistry(log *zap.SugaredLogger, config *config.Config, core lib.SigningAgentClient, factory *agentServiceFactory, system *agentService) *agentRegistry {
+	return &agentRegistry{
+		log:      log,
+		config:   config,
+		core:     core,
+		factory:  factory,
+		system:   system,
+		services: make(map[string]*agentService),
+	}
+}
+
+// AddAgent starts serving the newly registered agent and returns its feed url
+func (r *agentRegistry) AddAgent(agentID string) string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.startAgent(agentID)
+	return r.FeedURL(agentID)
+}
+
+// FeedURL returns the local feed url of the agent
+func (r *agentRegistry) FeedURL(agentID string) string {
+	return fmt.Sprintf("ws://%s%s/client/%s/feed", r.config.HTTP.Addr, defs.PathPrefix, agentID)
+}
+
+// Start starts serving all the registered agents except the system agent
+func (r *agentRegistry) Start() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	systemAgentID := r.core.GetSystemAgentID()
+	for _, agentID := range r.core.GetAgentIDs() {
+		if agentID != systemAgentID {
+			r.startAgent(agentID)
+		}
+	}
+}
+
+// Stop stops the feed hubs of all the agents except the system agent
+func (r *agentRegistry) Stop() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for agentID, service := range r.services {
+		service.signingAgentHandler.StopAgent()
+		delete(r.services, agentID)
+	}
+}
+
+// startAgent creates and starts the agentService of the agent, if not already running. Caller must handle concurrency
+func (r *agentRegistry) startAgent(agentID string) {
+	if _, ok := r.services[agentID]; ok {
+		return
+	}
+
+	r.log.Infof("Starting agent %s", agentID)
+	service := r.factory.newAgentService(agentID, r.core.ForAgent(agentID), r.config.ForAgent(agentID), r.FeedURL(agentID))
+	r.services[agentID] = service
+	service.signingAgentHandler.StartAgent()
+}
+
+// get returns the agentService of the agent, including the system agent
+func (r *agentRegistry) get(agentID string) (*agentService, error) {
+	if len(agentID) > 0 && agentID == r.core.GetSystemAgentID() {
+		return r.system, nil
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if service, ok := r.services[agentID]; ok {
+		return service, nil
+	}
+
+	return nil, defs.ErrNotFound().WithDetail("agent")
+}
+
+// forAgent returns a handler calling the handler of the agent given by the agent_id path parameter
+func (r *agentRegistry) forAgent(handler func(service *agentService) appHandlerFunc) appHandlerFunc {
+	return func(ctx *defs.RequestContext, w http.ResponseWriter, req *http.Request) (interface{}, error) {
+		service, err := r.get(mux.Vars(req)["agent_id"])
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(service)(ctx, w, req)
+	}
+}
@@ -0,0 +1,131 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qredo/signing-agent/autoapprover"
+	"github.com/qredo/signing-agent/config"
+	"github.com/qredo/signing-agent/defs"
+	"github.com/qredo/signing-agent/journal"
+	"github.com/qredo/signing-agent/lib"
+	"github.com/qredo/signing-agent/util"
+)
+
+func testAgentRegistry(core *lib.MockSigningAgentClient) (*agentRegistry, *agentService) {
+	cfg := &config.Config{}
+	cfg.Default()
+	cfg.HTTP.Addr = "some address"
+	cfg.Websocket.ReconnectTimeOut = 0
+
+	factory := &agentServiceFactory{
+		log:     util.NewTestLogger(),
+		journal: &journal.MockJournal{},
+	}
+	system := factory.newAgentService("", core, cfg, "ws://some address/api/v1/client/feed")
+
+	return newAgentRegistry(util.NewTestLogger(), cfg, core, factory, system), system
+}
+
+func TestAgentRegistry_get_returns_system_agent(t *testing.T) {
+	//Arrange
+	sut, system := testAgentRegistry(lib.NewMockSigningAgentClient("system-agent"))
+
+	//Act
+	service, err := sut.get("system-agent")
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Same(t, system, service)
+}
+
+func TestAgentRegistry_get_returns_not_found(t *testing.T) {
+	//Arrange
+	sut, _ := testAgentRegistry(lib.NewMockSigningAgentClient("system-agent"))
+
+	//Act
+	service, err := sut.get("unknown-agent")
+
+	//Assert
+	assert.Nil(t, service)
+	assert.Equal(t, "Not Found", err.Error())
+}
+
+func TestAgentRegistry_AddAgent_serves_agent(t *testing.T) {
+	//Arrange
+	core := lib.NewMockSigningAgentClient("system-agent")
+	sut, system := testAgentRegistry(core)
+
+	//Act
+	feedURL := sut.AddAgent("other-agent")
+	defer sut.Stop()
+
+	//Assert
+	assert.Equal(t, "ws://some address/api/v1/client/other-agent/feed", feedURL)
+	assert.Equal(t, "other-agent", core.LastForAgentID)
+
+	service, err := sut.get("other-agent")
+	assert.Nil(t, err)
+	assert.NotSame(t, system, service)
+}
+
+func TestAgentRegistry_Start_serves_all_agents_but_system(t *testing.T) {
+	//Arrange
+	core := lib.NewMockSigningAgentClient("system-agent")
+	core.NextAgentIDs = []string{"system-agent", "agent-1", "agent-2"}
+	sut, _ := testAgentRegistry(core)
+
+	//Act
+	sut.Start()
+	defer sut.Stop()
+
+	//Assert
+	assert.Len(t, sut.services, 2)
+	assert.Contains(t, sut.services, "agent-1")
+	assert.Contains(t, sut.services, "agent-2")
+}
+
+func TestAgentRegistry_agents_get_their_own_syncronizer(t *testing.T) {
+	//Arrange
+	core := lib.NewMockSigningAgentClient("system-agent")
+	core.NextAgentIDs = []string{"system-agent", "agent-1", "agent-2"}
+	sut, _ := testAgentRegistry(core)
+	created := 0
+	sut.factory.newSyncronizer = func() autoapprover.ActionSyncronizer {
+		created++
+		return nil
+	}
+
+	//Act
+	sut.Start()
+	defer sut.Stop()
+
+	//Assert
+	assert.Equal(t, 2, created)
+}
+
+func TestAgentRegistry_forAgent_calls_agent_handler(t *testing.T) {
+	//Arrange
+	sut, system := testAgentRegistry(lib.NewMockSigningAgentClient("system-agent"))
+	var called *agentService
+	handler := sut.forAgent(func(service *agentService) appHandlerFunc {
+		called = service
+		return func(ctx *defs.RequestContext, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+			return nil, nil
+		}
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/client/system-agent", nil)
+	req = mux.SetURLVars(req, map[string]string{"agent_id": "system-agent"})
+
+	//Act
+	_, err := handler(&defs.RequestContext{}, httptest.NewRecorder(), req)
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Same(t, system, called)
+}
@@ -0,0 +1,206 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"github.com/qredo/signing-agent/config"
+	"github.com/qredo/signing-agent/rpc"
+)
+
+// The headers used to authenticate the requests
+const (
+	HeaderAuthorization = "Authorization"
+	HeaderAPIKey        = "X-API-Key"
+	HeaderKeyID         = "X-Key-Id"
+	HeaderTimestamp     = "X-Timestamp"
+	HeaderSignature     = "X-Signature"
+)
+
+// Authenticator identifies the caller of a request.
+// It returns an empty identity and no error when the request doesn't carry the credentials it handles,
+// and an error when the credentials are present but not valid
+type Authenticator interface {
+	Authenticate(r *http.Request) (string, error)
+}
+
+// NewAuthenticators returns the authenticators for the methods configured in the auth config
+func NewAuthenticators(cfg *config.HttpAuth) []Authenticator {
+	authenticators := make([]Authenticator, 0)
+
+	if cfg.MTLS {
+		authenticators = append(authenticators, &certAuthenticator{})
+	}
+
+	if len(cfg.Tokens) > 0 {
+		authenticators = append(authenticators, &tokenAuthenticator{tokens: cfg.Tokens})
+	}
+
+	if len(cfg.HMACKeys) > 0 {
+		keys := make(map[string][]byte)
+		for _, key := range cfg.HMACKeys {
+			keys[key.ID] = []byte(key.Secret)
+		}
+
+		authenticators = append(authenticators, &hmacAuthenticator{
+			keys:         keys,
+			maxSkew:      time.Duration(cfg.HMACMaxSkewSec) * time.Second,
+			maxBodyBytes: cfg.HMACMaxBodyBytes,
+		})
+	}
+
+	return authenticators
+}
+
+// NewGRPCAuthenticate returns the authentication of the gRPC calls, by the static tokens sent in the `authorization` or `x-api-key`
+// metadata, or by the client certificate. The HMAC signed calls aren't supported, as the signature covers the raw body of a request
+func NewGRPCAuthenticate(cfg *config.HttpAuth) rpc.Authenticate {
+	grpcCfg := *cfg
+	grpcCfg.HMACKeys = nil
+	authenticators := NewAuthenticators(&grpcCfg)
+
+	return func(ctx context.Context) (string, error) {
+		r := &http.Request{Header: make(http.Header)}
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			for key, values := range md {
+				for _, value := range values {
+					r.Header.Add(key, value)
+				}
+			}
+		}
+
+		if p, ok := peer.FromContext(ctx); ok {
+			if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+				r.TLS = &info.State
+			}
+		}
+
+		for _, authenticator := range authenticators {
+			identity, err := authenticator.Authenticate(r)
+			if err != nil {
+				return "", err
+			}
+
+			if len(identity) > 0 {
+				return identity, nil
+			}
+		}
+
+		return "", errors.New("missing credentials")
+	}
+}
+
+// tokenAuthenticator accepts the static tokens sent as Authorization: Bearer <token> or X-API-Key: <token>
+type tokenAuthenticator struct {
+	tokens []config.AuthToken
+}
+
+func (a *tokenAuthenticator) Authenticate(r *http.Request) (string, error) {
+	token := r.Header.Get(HeaderAPIKey)
+	if len(token) == 0 {
+		authorization := r.Header.Get(HeaderAuthorization)
+		if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "bearer ") {
+			return "", nil
+		}
+		token = strings.TrimSpace(authorization[7:])
+	}
+
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1 {
+			return t.Name, nil
+		}
+	}
+
+	return "", errors.New("invalid token")
+}
+
+// hmacAuthenticator verifies the requests signed with a shared secret.
+// The X-Signature header is the hex encoded HMAC-SHA256 of SigningPayload, computed with the secret of the X-Key-Id.
+// The body of a request is read up to maxBodyBytes
+type hmacAuthenticator struct {
+	keys         map[string][]byte
+	maxSkew      time.Duration
+	maxBodyBytes int64
+}
+
+func (a *hmacAuthenticator) Authenticate(r *http.Request) (string, error) {
+	keyID := r.Header.Get(HeaderKeyID)
+	signature := r.Header.Get(HeaderSignature)
+	if len(keyID) == 0 && len(signature) == 0 {
+		return "", nil
+	}
+
+	secret, ok := a.keys[keyID]
+	if !ok {
+		return "", errors.New("unknown key id")
+	}
+
+	timestamp := r.Header.Get(HeaderTimestamp)
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", errors.New("invalid timestamp")
+	}
+
+	skew := time.Since(time.Unix(ts, 0))
+	if a.maxSkew > 0 && (skew > a.maxSkew || skew < -a.maxSkew) {
+		return "", errors.New("timestamp outside the allowed window")
+	}
+
+	var body []byte
+	if r.Body != nil {
+		if body, err = io.ReadAll(http.MaxBytesReader(nil, r.Body, a.maxBodyBytes)); err != nil {
+			return "", errors.Wrap(err, "read body")
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	expected := SignRequest(secret, timestamp, r.Method, r.URL.RequestURI(), body)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		return "", errors.New("invalid signature")
+	}
+
+	return keyID, nil
+}
+
+// SigningPayload returns the data signed by the clients using the HMAC authentication
+func SigningPayload(timestamp, method, requestURI string, body []byte) []byte {
+	return append([]byte(fmt.Sprintf("%s\n%s\n%s\n", timestamp, method, requestURI)), body...)
+}
+
+// SignRequest returns the hex encoded HMAC-SHA256 signature expected in the X-Signature header
+func SignRequest(secret []byte, timestamp, method, requestURI string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(SigningPayload(timestamp, method, requestURI, body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// certAuthenticator identifies the clients by the common name of the client certificate verified by the TLS server
+type certAuthenticator struct{}
+
+func (a *certAuthenticator) Authenticate(r *http.Request) (string, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", nil
+	}
+
+	cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if len(cn) == 0 {
+		return "", errors.New("client certificate without common name")
+	}
+
+	return cn, nil
+}
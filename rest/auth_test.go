@@ -0,0 +1,293 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"github.com/qredo/signing-agent/config"
+	"github.com/qredo/signing-agent/defs"
+	"github.com/qredo/signing-agent/util"
+)
+
+func testAuthConfig() *config.HttpAuth {
+	return &config.HttpAuth{
+		Enabled:          true,
+		OpenPaths:        []string{"/api/v1/healthcheck/status"},
+		Tokens:           []config.AuthToken{{Name: "dashboard", Token: "some token"}},
+		HMACKeys:         []config.AuthHMACKey{{ID: "approver", Secret: "some secret"}},
+		HMACMaxSkewSec:   300,
+		HMACMaxBodyBytes: 64,
+		MTLS:             true,
+	}
+}
+
+func testProtectedHandler(auth *config.HttpAuth) (appHandlerFunc, *string) {
+	var identity string
+	handler := func(ctx *defs.RequestContext, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		identity = ctx.Identity
+		return nil, nil
+	}
+
+	middleware := NewMiddleware(util.NewTestLogger(), false, auth)
+	return middleware.protectedMiddleware(handler), &identity
+}
+
+func TestProtectedMiddleware_lets_request_through_when_auth_disabled(t *testing.T) {
+	//Arrange
+	sut, identity := testProtectedHandler(&config.HttpAuth{})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/client", nil)
+	rr := httptest.NewRecorder()
+
+	//Act
+	sut.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, *identity)
+}
+
+func TestProtectedMiddleware_refuses_request_without_credentials(t *testing.T) {
+	//Arrange
+	sut, _ := testProtectedHandler(testAuthConfig())
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/client", nil)
+	rr := httptest.NewRecorder()
+
+	//Act
+	sut.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, "{\"Code\":401,\"Detail\":\"missing credentials\"}", rr.Body.String())
+}
+
+func TestProtectedMiddleware_refuses_websocket_without_credentials(t *testing.T) {
+	//Arrange
+	sut, _ := testProtectedHandler(testAuthConfig())
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/client/feed", nil)
+	req.Header.Set("Connection", "upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	rr := httptest.NewRecorder()
+
+	//Act
+	sut.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestProtectedMiddleware_accepts_bearer_token(t *testing.T) {
+	//Arrange
+	sut, identity := testProtectedHandler(testAuthConfig())
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/client", nil)
+	req.Header.Set(HeaderAuthorization, "Bearer some token")
+	rr := httptest.NewRecorder()
+
+	//Act
+	sut.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "dashboard", *identity)
+}
+
+func TestProtectedMiddleware_accepts_api_key(t *testing.T) {
+	//Arrange
+	sut, identity := testProtectedHandler(testAuthConfig())
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/client", nil)
+	req.Header.Set(HeaderAPIKey, "some token")
+	rr := httptest.NewRecorder()
+
+	//Act
+	sut.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "dashboard", *identity)
+}
+
+func TestProtectedMiddleware_refuses_invalid_token(t *testing.T) {
+	//Arrange
+	sut, _ := testProtectedHandler(testAuthConfig())
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/client", nil)
+	req.Header.Set(HeaderAuthorization, "Bearer some other token")
+	rr := httptest.NewRecorder()
+
+	//Act
+	sut.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, "{\"Code\":401,\"Detail\":\"invalid token\"}", rr.Body.String())
+}
+
+func TestProtectedMiddleware_accepts_hmac_signed_request(t *testing.T) {
+	//Arrange
+	sut, identity := testProtectedHandler(testAuthConfig())
+	body := []byte("{\"some\":\"body\"}")
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/client/action/some-action?x=1", bytes.NewReader(body))
+	req.Header.Set(HeaderKeyID, "approver")
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, SignRequest([]byte("some secret"), timestamp, http.MethodPut, "/api/v1/client/action/some-action?x=1", body))
+	rr := httptest.NewRecorder()
+
+	//Act
+	sut.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "approver", *identity)
+}
+
+func TestProtectedMiddleware_refuses_hmac_request_with_body_too_large(t *testing.T) {
+	//Arrange
+	sut, _ := testProtectedHandler(testAuthConfig())
+	body := bytes.Repeat([]byte("a"), 65)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/client/action/some-action", bytes.NewReader(body))
+	req.Header.Set(HeaderKeyID, "approver")
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, SignRequest([]byte("some secret"), timestamp, http.MethodPut, "/api/v1/client/action/some-action", body))
+	rr := httptest.NewRecorder()
+
+	//Act
+	sut.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Contains(t, rr.Body.String(), "read body")
+}
+
+func TestProtectedHandler(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		path     string
+		token    string
+		expected int
+	}{
+		{"metrics without credentials", PathMetrics, "", http.StatusUnauthorized},
+		{"metrics with token", PathMetrics, "some token", http.StatusOK},
+		{"open path", "/api/v1/healthcheck/status", "", http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			middleware := NewMiddleware(util.NewTestLogger(), false, testAuthConfig())
+			sut := middleware.protectedHandler(tc.path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if len(tc.token) > 0 {
+				req.Header.Set(HeaderAPIKey, tc.token)
+			}
+			rr := httptest.NewRecorder()
+
+			//Act
+			sut.ServeHTTP(rr, req)
+
+			//Assert
+			assert.Equal(t, tc.expected, rr.Code)
+		})
+	}
+}
+
+func TestProtectedMiddleware_refuses_tampered_hmac_request(t *testing.T) {
+	//Arrange
+	sut, _ := testProtectedHandler(testAuthConfig())
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/client/action/some-other-action", nil)
+	req.Header.Set(HeaderKeyID, "approver")
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, SignRequest([]byte("some secret"), timestamp, http.MethodDelete, "/api/v1/client/action/some-action", nil))
+	rr := httptest.NewRecorder()
+
+	//Act
+	sut.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, "{\"Code\":401,\"Detail\":\"invalid signature\"}", rr.Body.String())
+}
+
+func TestProtectedMiddleware_refuses_expired_hmac_request(t *testing.T) {
+	//Arrange
+	sut, _ := testProtectedHandler(testAuthConfig())
+	timestamp := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/client", nil)
+	req.Header.Set(HeaderKeyID, "approver")
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, SignRequest([]byte("some secret"), timestamp, http.MethodGet, "/api/v1/client", nil))
+	rr := httptest.NewRecorder()
+
+	//Act
+	sut.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, "{\"Code\":401,\"Detail\":\"timestamp outside the allowed window\"}", rr.Body.String())
+}
+
+func TestProtectedMiddleware_accepts_client_certificate(t *testing.T) {
+	//Arrange
+	sut, identity := testProtectedHandler(testAuthConfig())
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/client", nil)
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "approver-service"}}}},
+	}
+	rr := httptest.NewRecorder()
+
+	//Act
+	sut.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "approver-service", *identity)
+}
+
+func TestGRPCAuthenticate(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		ctx      context.Context
+		identity string
+		errMsg   string
+	}{
+		{"bearer token", metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer some token")), "dashboard", ""},
+		{"api key", metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", "some token")), "dashboard", ""},
+		{"invalid token", metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", "other token")), "", "invalid token"},
+		{"client certificate", peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "approver-service"}}}},
+		}}}), "approver-service", ""},
+		{"hmac not supported", metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-key-id", "approver", "x-signature", "some signature")), "", "missing credentials"},
+		{"no credentials", context.Background(), "", "missing credentials"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			sut := NewGRPCAuthenticate(testAuthConfig())
+
+			//Act
+			identity, err := sut(tc.ctx)
+
+			//Assert
+			assert.Equal(t, tc.identity, identity)
+			if tc.errMsg == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, tc.errMsg)
+			}
+		})
+	}
+}
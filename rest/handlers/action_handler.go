@@ -1,24 +1,105 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/qredo/signing-agent/api"
 	"github.com/qredo/signing-agent/autoapprover"
+	"github.com/qredo/signing-agent/config"
 	"github.com/qredo/signing-agent/defs"
+	"github.com/qredo/signing-agent/journal"
+	"github.com/qredo/signing-agent/util"
 
 	"github.com/gorilla/mux"
 )
 
+// PendingActions returns the actions waiting for a decision, and the current state of the actions
+type PendingActions interface {
+	List() []api.PendingAction
+	State(actionID string) (string, bool)
+}
+
+// CoApprover collects the approvals of the authenticated callers until the quorum of the action is reached
+type CoApprover interface {
+	Vote(actionID, approver string) (api.ActionResponse, error)
+}
+
+// ActionFetcher returns the payload of an action from the Qredo backend
+type ActionFetcher interface {
+	GetAction(actionID string) (*api.ActionDetailsResponse, error)
+}
+
 type ActionHandler struct {
 	actionManager autoapprover.ActionManager
+	journal       journal.Journal
+	pending       PendingActions
+	coApprover    CoApprover
+	fetcher       ActionFetcher
+	batch         *config.Batch
 }
 
-func NewActionHandler(actionManager autoapprover.ActionManager) *ActionHandler {
+func NewActionHandler(actionManager autoapprover.ActionManager, journal journal.Journal, pending PendingActions) *ActionHandler {
 	return &ActionHandler{
 		actionManager: actionManager,
+		journal:       journal,
+		pending:       pending,
+	}
+}
+
+// SetCoApprover sets the co-approver collecting the approvals of the actions needing several approvers
+func (h *ActionHandler) SetCoApprover(coApprover CoApprover) {
+	h.coApprover = coApprover
+}
+
+// SetActionFetcher sets the client fetching the payload of the actions
+func (h *ActionHandler) SetActionFetcher(fetcher ActionFetcher) {
+	h.fetcher = fetcher
+}
+
+// SetBatchConfig sets the limits of the batch approvals and rejections
+func (h *ActionHandler) SetBatchConfig(batch *config.Batch) {
+	h.batch = batch
+}
+
+// GetAction
+//
+// swagger:route GET /client/action/{action_id} action GetAction
+//
+// # Get the details of a transaction
+//
+// This endpoint returns the payload of a transaction, based on the transaction ID, `action_id`, passed,
+// as it's fetched from Qredo before signing it: the messages signed to approve it and the transaction metadata.
+// It lets the reviewers inspect the transaction before approving it.
+//
+//	Parameters:
+//	  + name: action_id
+//	    in: path
+//	    description: the ID of the transaction that is received from the feed
+//	    required: true
+//	    type: string
+//
+// Produces:
+//   - application/json
+//
+// Responses:
+//
+// 200: ActionDetailsResponse
+// 400: ErrorResponse description:Bad request
+// 404: ErrorResponse description:Not found
+// 500: ErrorResponse description:Internal error
+func (h *ActionHandler) GetAction(_ *defs.RequestContext, _ http.ResponseWriter, r *http.Request) (interface{}, error) {
+	actionID := mux.Vars(r)["action_id"]
+	actionID = strings.TrimSpace(actionID)
+	if actionID == "" {
+		return nil, defs.ErrBadRequest().WithDetail("empty actionID")
 	}
+
+	return h.fetcher.GetAction(actionID)
 }
 
 // ActionApprove
@@ -28,6 +109,10 @@ func NewActionHandler(actionManager autoapprover.ActionManager) *ActionHandler {
 // # Approve a transaction
 //
 // This endpoint approves a transaction based on the transaction ID, `action_id`, passed.
+// Approving a transaction again while it's being approved, or once it's approved, returns its current state without
+// signing it again, while approving a rejected or expired transaction fails with a conflict.
+// When the co-approval is enabled and the transaction needs several approvers, the approval of the caller is recorded
+// and the transaction stays pending until the quorum is reached.
 //
 //	Parameters:
 //	  + name: action_id
@@ -43,20 +128,23 @@ func NewActionHandler(actionManager autoapprover.ActionManager) *ActionHandler {
 //
 // 200: ActionResponse
 // 400: ErrorResponse description:Bad request
+// 401: ErrorResponse description:Unauthorized
 // 404: ErrorResponse description:Not found
+// 409: ErrorResponse description:Conflict
 // 500: ErrorResponse description:Internal error
-func (h *ActionHandler) ActionApprove(_ *defs.RequestContext, _ http.ResponseWriter, r *http.Request) (interface{}, error) {
+func (h *ActionHandler) ActionApprove(ctx *defs.RequestContext, _ http.ResponseWriter, r *http.Request) (interface{}, error) {
 	actionID := mux.Vars(r)["action_id"]
 	actionID = strings.TrimSpace(actionID)
 	if actionID == "" {
 		return nil, defs.ErrBadRequest().WithDetail("empty actionID")
 	}
 
-	if err := h.actionManager.Approve(actionID); err != nil {
+	response, err := h.approve(ctx, actionID)
+	if err != nil {
 		return nil, err
 	}
 
-	return api.NewApprovedActionResponse(actionID), nil
+	return response, nil
 }
 
 // ActionReject
@@ -66,6 +154,10 @@ func (h *ActionHandler) ActionApprove(_ *defs.RequestContext, _ http.ResponseWri
 // # Reject a transaction
 //
 // This endpoint rejects a transaction based on the transaction ID, `action_id`, passed.
+// The reason of the rejection, a code and a message, can be sent in the body. It's recorded in the journal,
+// and sent to Qredo when it's enabled. The code is manual when it's not set.
+// Rejecting a transaction again while it's being rejected, or once it's rejected, returns its current state without
+// rejecting it again, while rejecting an approved or expired transaction fails with a conflict.
 //
 //	Parameters:
 //	  + name: action_id
@@ -82,6 +174,7 @@ func (h *ActionHandler) ActionApprove(_ *defs.RequestContext, _ http.ResponseWri
 // 200: ActionResponse
 // 400: ErrorResponse description:Bad request
 // 404: ErrorResponse description:Not found
+// 409: ErrorResponse description:Conflict
 // 500: ErrorResponse description:Internal error
 func (h *ActionHandler) ActionReject(_ *defs.RequestContext, _ http.ResponseWriter, r *http.Request) (interface{}, error) {
 	actionID := mux.Vars(r)["action_id"]
@@ -90,9 +183,291 @@ func (h *ActionHandler) ActionReject(_ *defs.RequestContext, _ http.ResponseWrit
 		return nil, defs.ErrBadRequest().WithDetail("empty actionID")
 	}
 
-	if err := h.actionManager.Reject(actionID); err != nil {
+	reason := api.RejectReason{}
+	if r.ContentLength != 0 {
+		if err := util.DecodeRequest(&reason, r); err != nil {
+			return nil, err
+		}
+	}
+
+	response, err := h.reject(actionID, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// BatchApprove
+//
+// swagger:route POST /client/actions/approve action BatchApprove
+//
+// # Approve several transactions
+//
+// This endpoint approves the transactions based on the transaction IDs passed in the body, several at the same time,
+// as set by the batch parallelism in the config. Every transaction is approved as it's approved by the ActionApprove endpoint,
+// and a result is returned for each of them, in the order of the request. A transaction that couldn't be approved
+// has its current state and the reason of the failure, without failing the other ones.
+//
+// Produces:
+//   - application/json
+//
+// Responses:
+//
+// 200: BatchActionResponse
+// 400: ErrorResponse description:Bad request
+// 401: ErrorResponse description:Unauthorized
+func (h *ActionHandler) BatchApprove(ctx *defs.RequestContext, _ http.ResponseWriter, r *http.Request) (interface{}, error) {
+	req := &api.BatchActionRequest{}
+	actionIDs, err := h.decodeBatch(req, r)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.runBatch(actionIDs, func(actionID string) (api.ActionResponse, error) {
+		return h.approve(ctx, actionID)
+	}), nil
+}
+
+// BatchReject
+//
+// swagger:route POST /client/actions/reject action BatchReject
+//
+// # Reject several transactions
+//
+// This endpoint rejects the transactions based on the transaction IDs passed in the body, several at the same time,
+// as set by the batch parallelism in the config. The reason of the rejection, sent in the body, applies to all of them.
+// Every transaction is rejected as it's rejected by the ActionReject endpoint, and a result is returned for each of them,
+// in the order of the request. A transaction that couldn't be rejected has its current state and the reason of the failure,
+// without failing the other ones.
+//
+// Produces:
+//   - application/json
+//
+// Responses:
+//
+// 200: BatchActionResponse
+// 400: ErrorResponse description:Bad request
+func (h *ActionHandler) BatchReject(_ *defs.RequestContext, _ http.ResponseWriter, r *http.Request) (interface{}, error) {
+	req := &api.BatchActionRequest{}
+	actionIDs, err := h.decodeBatch(req, r)
+	if err != nil {
+		return nil, err
+	}
+
+	reason := api.RejectReason{}
+	if req.Reason != nil {
+		reason = *req.Reason
+	}
+
+	return h.runBatch(actionIDs, func(actionID string) (api.ActionResponse, error) {
+		return h.reject(actionID, reason)
+	}), nil
+}
+
+// GetActions
+//
+// swagger:route GET /client/actions action GetActions
+//
+// # Get the recorded transactions
+//
+// This endpoint returns the transactions recorded in the journal, with every event seen for them.
+//
+//	Parameters:
+//	  + name: status
+//	    in: query
+//	    description: the last known outcome of the transaction, ex. received, approved, rejected, failed
+//	    required: false
+//	    type: string
+//	  + name: type
+//	    in: query
+//	    description: the type of the transaction, ex. ApproveWithdraw
+//	    required: false
+//	    type: string
+//	  + name: from
+//	    in: query
+//	    description: the start of the time range, utc unix time
+//	    required: false
+//	    type: integer
+//	  + name: to
+//	    in: query
+//	    description: the end of the time range, utc unix time
+//	    required: false
+//	    type: integer
+//
+// Produces:
+//   - application/json
+//
+// Responses:
+//
+// 200: ActionListResponse
+// 400: ErrorResponse description:Bad request
+func (h *ActionHandler) GetActions(_ *defs.RequestContext, _ http.ResponseWriter, r *http.Request) (interface{}, error) {
+	query := r.URL.Query()
+	filter := &journal.Filter{
+		Status: strings.TrimSpace(query.Get("status")),
+		Type:   strings.TrimSpace(query.Get("type")),
+	}
+
+	var err error
+	if filter.From, err = parseTimeParam(query.Get("from")); err != nil {
+		return nil, defs.ErrBadRequest().WithDetail("invalid from")
+	}
+	if filter.To, err = parseTimeParam(query.Get("to")); err != nil {
+		return nil, defs.ErrBadRequest().WithDetail("invalid to")
+	}
+
+	return api.ActionListResponse{
+		Actions: h.journal.Query(filter),
+	}, nil
+}
+
+// GetPendingActions
+//
+// swagger:route GET /client/actions/pending action GetPendingActions
+//
+// # Get the pending transactions
+//
+// This endpoint returns the transactions received from the feed that are waiting for a decision, the first to expire first.
+// A transaction is no longer pending once it's approved, rejected or expired.
+//
+// Produces:
+//   - application/json
+//
+// Responses:
+//
+// 200: PendingActionListResponse
+func (h *ActionHandler) GetPendingActions(_ *defs.RequestContext, _ http.ResponseWriter, _ *http.Request) (interface{}, error) {
+	return api.PendingActionListResponse{
+		Actions: h.pending.List(),
+	}, nil
+}
+
+// approve the action, or records the approval of the caller when the co-approval is enabled
+func (h *ActionHandler) approve(ctx *defs.RequestContext, actionID string) (api.ActionResponse, error) {
+	if h.coApprover != nil {
+		var approver string
+		if ctx != nil {
+			approver = ctx.Identity
+		}
+		return h.coApprover.Vote(actionID, approver)
+	}
+
+	if err := h.actionManager.Approve(actionID); err != nil {
+		return api.ActionResponse{}, err
+	}
+
+	return h.actionResponse(actionID, api.ActionStateApproved), nil
+}
+
+// reject the action with the reason, the code is manual when it's not set
+func (h *ActionHandler) reject(actionID string, reason api.RejectReason) (api.ActionResponse, error) {
+	if len(reason.Code) == 0 {
+		reason.Code = api.RejectCodeManual
+	}
+
+	if err := h.actionManager.Reject(actionID, reason); err != nil {
+		return api.ActionResponse{}, err
+	}
+
+	return h.actionResponse(actionID, api.ActionStateRejected), nil
+}
+
+// decodeBatch decodes and validates the request, and returns the action IDs without the duplicates, in the order of the request
+func (h *ActionHandler) decodeBatch(req *api.BatchActionRequest, r *http.Request) ([]string, error) {
+	if err := util.DecodeRequest(req, r); err != nil {
 		return nil, err
 	}
 
-	return api.NewRejectedActionResponse(actionID), nil
+	if len(req.ActionIDs) == 0 {
+		return nil, defs.ErrBadRequest().WithDetail("empty actionIDs")
+	}
+
+	if h.batch != nil && len(req.ActionIDs) > h.batch.MaxActions {
+		return nil, defs.ErrBadRequest().WithDetail(fmt.Sprintf("too many actionIDs, the maximum is %d", h.batch.MaxActions))
+	}
+
+	seen := make(map[string]bool, len(req.ActionIDs))
+	actionIDs := make([]string, 0, len(req.ActionIDs))
+	for _, actionID := range req.ActionIDs {
+		actionID = strings.TrimSpace(actionID)
+		if actionID == "" {
+			return nil, defs.ErrBadRequest().WithDetail("empty actionID")
+		}
+		if seen[actionID] {
+			continue
+		}
+		seen[actionID] = true
+		actionIDs = append(actionIDs, actionID)
+	}
+
+	return actionIDs, nil
+}
+
+// runBatch calls do for every action, as many at the same time as the batch parallelism, and returns the results in the
+// order of the actions. The result of a failed action has its current state, failed when it isn't tracked, and the error
+func (h *ActionHandler) runBatch(actionIDs []string, do func(actionID string) (api.ActionResponse, error)) api.BatchActionResponse {
+	parallelism := 1
+	if h.batch != nil && h.batch.Parallelism > 0 {
+		parallelism = h.batch.Parallelism
+	}
+
+	results := make([]api.BatchActionResult, len(actionIDs))
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, actionID := range actionIDs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, actionID string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			response, err := do(actionID)
+			if err != nil {
+				results[i] = api.BatchActionResult{
+					ActionResponse: h.actionResponse(actionID, api.ActionStateFailed),
+					Error:          errorDetail(err),
+				}
+				return
+			}
+			results[i] = api.BatchActionResult{ActionResponse: response}
+		}(i, actionID)
+	}
+	wg.Wait()
+
+	return api.BatchActionResponse{
+		Results: results,
+	}
+}
+
+// errorDetail returns the detail of an API error, or the error message
+func errorDetail(err error) string {
+	var apiErr *defs.APIError
+	if errors.As(err, &apiErr) {
+		if _, detail := apiErr.APIError(); len(detail) > 0 {
+			return detail
+		}
+	}
+	return err.Error()
+}
+
+// actionResponse returns the current state of the action, or the given state when the actions aren't tracked
+func (h *ActionHandler) actionResponse(actionID, state string) api.ActionResponse {
+	if h.pending != nil {
+		if current, ok := h.pending.State(actionID); ok {
+			state = current
+		}
+	}
+	return api.NewActionResponse(actionID, state)
+}
+
+func parseTimeParam(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	return strconv.ParseInt(value, 10, 64)
 }
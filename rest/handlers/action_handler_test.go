@@ -4,10 +4,16 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/config"
 	"github.com/qredo/signing-agent/defs"
+	"github.com/qredo/signing-agent/journal"
+	"github.com/qredo/signing-agent/lib"
 
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
@@ -17,6 +23,7 @@ type mockActionManager struct {
 	ApproveCalled bool
 	RejectCalled  bool
 	LastActionId  string
+	LastReason    api.RejectReason
 	NextError     error
 }
 
@@ -25,8 +32,9 @@ func (m *mockActionManager) Approve(actionID string) error {
 	m.LastActionId = actionID
 	return m.NextError
 }
-func (m *mockActionManager) Reject(actionID string) error {
+func (m *mockActionManager) Reject(actionID string, reason api.RejectReason) error {
 	m.RejectCalled = true
+	m.LastReason = reason
 	m.LastActionId = actionID
 	return m.NextError
 }
@@ -42,7 +50,7 @@ func TestActionHandler_ActionApprove_empty_actionId(t *testing.T) {
 		response interface{}
 	)
 	m.HandleFunc("/client/action/{action_id}", func(w http.ResponseWriter, r *http.Request) {
-		response, err = NewActionHandler(actionManagerMock).ActionApprove(nil, w, r)
+		response, err = NewActionHandler(actionManagerMock, nil, nil).ActionApprove(nil, w, r)
 	})
 
 	//Act
@@ -71,7 +79,7 @@ func TestActionHandler_ActionApprove_error_on_approve(t *testing.T) {
 		response interface{}
 	)
 	m.HandleFunc("/client/action/{action_id}", func(w http.ResponseWriter, r *http.Request) {
-		response, err = NewActionHandler(actionManagerMock).ActionApprove(nil, w, r)
+		response, err = NewActionHandler(actionManagerMock, nil, nil).ActionApprove(nil, w, r)
 	})
 
 	//Act
@@ -94,7 +102,7 @@ func TestActionHandler_ActionApprove(t *testing.T) {
 		response interface{}
 	)
 	m.HandleFunc("/client/action/{action_id}", func(w http.ResponseWriter, r *http.Request) {
-		response, err = NewActionHandler(actionManagerMock).ActionApprove(nil, w, r)
+		response, err = NewActionHandler(actionManagerMock, nil, nil).ActionApprove(nil, w, r)
 	})
 
 	//Act
@@ -110,6 +118,126 @@ func TestActionHandler_ActionApprove(t *testing.T) {
 	assert.Equal(t, "approved", action_response.Status)
 }
 
+func TestActionHandler_reports_current_state(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		method   string
+		state    string
+		expected string
+	}{
+		{"approve in progress", "PUT", api.ActionStateApproving, api.ActionStateApproving},
+		{"approved", "PUT", api.ActionStateApproved, api.ActionStateApproved},
+		{"reject in progress", "DELETE", api.ActionStateRejecting, api.ActionStateRejecting},
+		{"not tracked", "DELETE", "", api.ActionStateRejected},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			sut := NewActionHandler(&mockActionManager{}, nil, &mockPendingActions{NextState: tc.state})
+			req, _ := http.NewRequest(tc.method, "/client/action/some_action_id", nil)
+			m := mux.NewRouter()
+			var (
+				err      error
+				response interface{}
+			)
+			m.HandleFunc("/client/action/{action_id}", func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "PUT" {
+					response, err = sut.ActionApprove(nil, w, r)
+				} else {
+					response, err = sut.ActionReject(nil, w, r)
+				}
+			})
+
+			//Act
+			m.ServeHTTP(httptest.NewRecorder(), req)
+
+			//Assert
+			assert.Nil(t, err)
+			assert.Equal(t, api.NewActionResponse("some_action_id", tc.expected), response)
+		})
+	}
+}
+
+func TestActionHandler_ActionReject_reason(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		body     string
+		expected api.RejectReason
+		err      bool
+	}{
+		{"no body", "", api.RejectReason{Code: api.RejectCodeManual}, false},
+		{"message only", `{"message":"over the limit"}`, api.RejectReason{Code: api.RejectCodeManual, Message: "over the limit"}, false},
+		{"code and message", `{"code":"limit_exceeded","message":"over the limit"}`, api.RejectReason{Code: "limit_exceeded", Message: "over the limit"}, false},
+		{"invalid json", `not json`, api.RejectReason{}, true},
+		{"code too long", `{"code":"` + strings.Repeat("a", 65) + `"}`, api.RejectReason{}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			actionManagerMock := &mockActionManager{}
+			req, _ := http.NewRequest("DELETE", "/client/action/some_action_id", strings.NewReader(tc.body))
+			m := mux.NewRouter()
+			var err error
+			m.HandleFunc("/client/action/{action_id}", func(w http.ResponseWriter, r *http.Request) {
+				_, err = NewActionHandler(actionManagerMock, nil, nil).ActionReject(nil, w, r)
+			})
+
+			//Act
+			m.ServeHTTP(httptest.NewRecorder(), req)
+
+			//Assert
+			if tc.err {
+				assert.NotNil(t, err)
+				assert.False(t, actionManagerMock.RejectCalled)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, actionManagerMock.LastReason)
+		})
+	}
+}
+
+type mockCoApprover struct {
+	LastActionId string
+	LastApprover string
+}
+
+func (m *mockCoApprover) Vote(actionID, approver string) (api.ActionResponse, error) {
+	m.LastActionId = actionID
+	m.LastApprover = approver
+	return api.NewPendingActionResponse(actionID, 1, 2), nil
+}
+
+func TestActionHandler_ActionApprove_votes_with_co_approval(t *testing.T) {
+	//Arrange
+	actionManagerMock := &mockActionManager{}
+	coApproverMock := &mockCoApprover{}
+	sut := NewActionHandler(actionManagerMock, nil, nil)
+	sut.SetCoApprover(coApproverMock)
+	req, _ := http.NewRequest("PUT", "/client/action/some_action_id", nil)
+	rr := httptest.NewRecorder()
+	m := mux.NewRouter()
+	var (
+		err      error
+		response interface{}
+	)
+	m.HandleFunc("/client/action/{action_id}", func(w http.ResponseWriter, r *http.Request) {
+		response, err = sut.ActionApprove(&defs.RequestContext{Identity: "some approver"}, w, r)
+	})
+
+	//Act
+	m.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Nil(t, err)
+	assert.False(t, actionManagerMock.ApproveCalled)
+	assert.Equal(t, "some_action_id", coApproverMock.LastActionId)
+	assert.Equal(t, "some approver", coApproverMock.LastApprover)
+	assert.Equal(t, api.NewPendingActionResponse("some_action_id", 1, 2), response)
+}
+
 func TestActionHandler_ActionReject_empty_actionId(t *testing.T) {
 	//Arrange
 	actionManagerMock := &mockActionManager{}
@@ -121,7 +249,7 @@ func TestActionHandler_ActionReject_empty_actionId(t *testing.T) {
 		response interface{}
 	)
 	m.HandleFunc("/client/action/{action_id}", func(w http.ResponseWriter, r *http.Request) {
-		response, err = NewActionHandler(actionManagerMock).ActionReject(nil, w, r)
+		response, err = NewActionHandler(actionManagerMock, nil, nil).ActionReject(nil, w, r)
 	})
 
 	//Act
@@ -149,7 +277,7 @@ func TestActionHandler_ActionReject_error_on_reject(t *testing.T) {
 		response interface{}
 	)
 	m.HandleFunc("/client/action/{action_id}", func(w http.ResponseWriter, r *http.Request) {
-		response, err = NewActionHandler(actionManagerMock).ActionReject(nil, w, r)
+		response, err = NewActionHandler(actionManagerMock, nil, nil).ActionReject(nil, w, r)
 	})
 
 	//Act
@@ -172,7 +300,7 @@ func TestActionHandler_ActionReject(t *testing.T) {
 		response interface{}
 	)
 	m.HandleFunc("/client/action/{action_id}", func(w http.ResponseWriter, r *http.Request) {
-		response, err = NewActionHandler(actionManagerMock).ActionReject(nil, w, r)
+		response, err = NewActionHandler(actionManagerMock, nil, nil).ActionReject(nil, w, r)
 	})
 
 	//Act
@@ -187,3 +315,268 @@ func TestActionHandler_ActionReject(t *testing.T) {
 	assert.Equal(t, "some_action_id", action_response.ActionID)
 	assert.Equal(t, "rejected", action_response.Status)
 }
+
+func TestActionHandler_GetActions_invalid_time_range(t *testing.T) {
+	//Arrange
+	journalMock := &journal.MockJournal{}
+	req, _ := http.NewRequest("GET", "/client/actions?from=yesterday", nil)
+
+	//Act
+	response, err := NewActionHandler(nil, journalMock, nil).GetActions(nil, httptest.NewRecorder(), req)
+
+	//Assert
+	assert.Nil(t, response)
+	assert.False(t, journalMock.QueryCalled)
+	apiErr := err.(*defs.APIError)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, "invalid from", detail)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestActionHandler_GetActions(t *testing.T) {
+	//Arrange
+	journalMock := &journal.MockJournal{
+		NextRecords: []api.ActionRecord{{ActionID: "some_action_id"}},
+	}
+	req, _ := http.NewRequest("GET", "/client/actions?status=approved&type=ApproveWithdraw&from=100&to=200", nil)
+
+	//Act
+	response, err := NewActionHandler(nil, journalMock, nil).GetActions(nil, httptest.NewRecorder(), req)
+
+	//Assert
+	assert.Nil(t, err)
+	assert.True(t, journalMock.QueryCalled)
+	assert.Equal(t, &journal.Filter{Status: "approved", Type: "ApproveWithdraw", From: 100, To: 200}, journalMock.LastFilter)
+	list, ok := response.(api.ActionListResponse)
+	assert.True(t, ok)
+	assert.Len(t, list.Actions, 1)
+	assert.Equal(t, "some_action_id", list.Actions[0].ActionID)
+}
+
+type mockPendingActions struct {
+	NextActions []api.PendingAction
+	NextState   string
+}
+
+func (m *mockPendingActions) List() []api.PendingAction {
+	return m.NextActions
+}
+
+func (m *mockPendingActions) State(string) (string, bool) {
+	return m.NextState, len(m.NextState) > 0
+}
+
+func TestActionHandler_GetPendingActions(t *testing.T) {
+	//Arrange
+	pendingMock := &mockPendingActions{
+		NextActions: []api.PendingAction{{ActionID: "some_action_id", ExpireTime: 1676184187}},
+	}
+	req, _ := http.NewRequest("GET", "/client/actions/pending", nil)
+
+	//Act
+	response, err := NewActionHandler(nil, nil, pendingMock).GetPendingActions(nil, httptest.NewRecorder(), req)
+
+	//Assert
+	assert.Nil(t, err)
+	list, ok := response.(api.PendingActionListResponse)
+	assert.True(t, ok)
+	assert.Equal(t, pendingMock.NextActions, list.Actions)
+}
+
+func TestActionHandler_GetAction(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		actionID string
+		details  *api.ActionDetailsResponse
+		err      error
+		called   bool
+	}{
+		{"empty actionID", " ", nil, defs.ErrBadRequest().WithDetail("empty actionID"), false},
+		{"fetch error", "some_action_id", nil, errors.New("some error"), true},
+		{"fetched", "some_action_id", &api.ActionDetailsResponse{ActionID: "some_action_id", Messages: []string{"0805"}}, nil, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			coreMock := &lib.MockSigningAgentClient{
+				NextActionDetails:  tc.details,
+				NextGetActionError: tc.err,
+			}
+			sut := NewActionHandler(nil, nil, nil)
+			sut.SetActionFetcher(coreMock)
+			req, _ := http.NewRequest("GET", "/client/action/"+tc.actionID, nil)
+			m := mux.NewRouter()
+			var (
+				err      error
+				response interface{}
+			)
+			m.HandleFunc("/client/action/{action_id}", func(w http.ResponseWriter, r *http.Request) {
+				response, err = sut.GetAction(nil, w, r)
+			})
+
+			//Act
+			m.ServeHTTP(httptest.NewRecorder(), req)
+
+			//Assert
+			assert.Equal(t, tc.called, coreMock.GetActionCalled)
+			assert.Equal(t, tc.err, err)
+			if tc.details != nil {
+				assert.Equal(t, tc.details, response)
+				assert.Equal(t, tc.actionID, coreMock.LastGetActionId)
+			}
+		})
+	}
+}
+
+// mockBatchActionManager fails the actions in Failing, and records the actions handled and the maximum of concurrent calls
+type mockBatchActionManager struct {
+	Failing       map[string]error
+	lock          sync.Mutex
+	handled       []string
+	reasons       []api.RejectReason
+	running       int
+	maxConcurrent int
+}
+
+func (m *mockBatchActionManager) handle(actionID string) error {
+	m.lock.Lock()
+	m.handled = append(m.handled, actionID)
+	m.running++
+	if m.running > m.maxConcurrent {
+		m.maxConcurrent = m.running
+	}
+	m.lock.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	m.lock.Lock()
+	m.running--
+	m.lock.Unlock()
+	return m.Failing[actionID]
+}
+
+func (m *mockBatchActionManager) Approve(actionID string) error {
+	return m.handle(actionID)
+}
+
+func (m *mockBatchActionManager) Reject(actionID string, reason api.RejectReason) error {
+	m.lock.Lock()
+	m.reasons = append(m.reasons, reason)
+	m.lock.Unlock()
+	return m.handle(actionID)
+}
+
+func TestActionHandler_BatchApprove(t *testing.T) {
+	//Arrange
+	actionManagerMock := &mockBatchActionManager{
+		Failing: map[string]error{
+			"second": defs.ErrConflict().WithDetail("action is already rejected"),
+			"third":  errors.New("some error"),
+		},
+	}
+	sut := NewActionHandler(actionManagerMock, nil, nil)
+	sut.SetBatchConfig(&config.Batch{Parallelism: 2, MaxActions: 10})
+	req, _ := http.NewRequest("POST", "/client/actions/approve", strings.NewReader(`{"actionIDs":["first","second","first","third","fourth"]}`))
+
+	//Act
+	response, err := sut.BatchApprove(nil, httptest.NewRecorder(), req)
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Equal(t, api.BatchActionResponse{
+		Results: []api.BatchActionResult{
+			{ActionResponse: api.NewActionResponse("first", api.ActionStateApproved)},
+			{ActionResponse: api.NewActionResponse("second", api.ActionStateFailed), Error: "action is already rejected"},
+			{ActionResponse: api.NewActionResponse("third", api.ActionStateFailed), Error: "some error"},
+			{ActionResponse: api.NewActionResponse("fourth", api.ActionStateApproved)},
+		},
+	}, response)
+	assert.ElementsMatch(t, []string{"first", "second", "third", "fourth"}, actionManagerMock.handled)
+	assert.Equal(t, 2, actionManagerMock.maxConcurrent)
+}
+
+func TestActionHandler_BatchApprove_votes_with_co_approval(t *testing.T) {
+	//Arrange
+	coApproverMock := &mockCoApprover{}
+	sut := NewActionHandler(&mockBatchActionManager{}, nil, nil)
+	sut.SetCoApprover(coApproverMock)
+	req, _ := http.NewRequest("POST", "/client/actions/approve", strings.NewReader(`{"actionIDs":["some_action_id"]}`))
+
+	//Act
+	response, err := sut.BatchApprove(&defs.RequestContext{Identity: "some approver"}, httptest.NewRecorder(), req)
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Equal(t, "some approver", coApproverMock.LastApprover)
+	assert.Equal(t, api.BatchActionResponse{
+		Results: []api.BatchActionResult{{ActionResponse: api.NewPendingActionResponse("some_action_id", 1, 2)}},
+	}, response)
+}
+
+func TestActionHandler_BatchReject(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		body     string
+		expected api.RejectReason
+	}{
+		{"no reason", `{"actionIDs":["first","second"]}`, api.RejectReason{Code: api.RejectCodeManual}},
+		{"reason", `{"actionIDs":["first","second"],"reason":{"code":"limit_exceeded","message":"over the limit"}}`, api.RejectReason{Code: "limit_exceeded", Message: "over the limit"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			actionManagerMock := &mockBatchActionManager{}
+			sut := NewActionHandler(actionManagerMock, nil, &mockPendingActions{NextState: api.ActionStateRejected})
+			sut.SetBatchConfig(&config.Batch{Parallelism: 4, MaxActions: 10})
+			req, _ := http.NewRequest("POST", "/client/actions/reject", strings.NewReader(tc.body))
+
+			//Act
+			response, err := sut.BatchReject(nil, httptest.NewRecorder(), req)
+
+			//Assert
+			assert.Nil(t, err)
+			assert.Equal(t, api.BatchActionResponse{
+				Results: []api.BatchActionResult{
+					{ActionResponse: api.NewActionResponse("first", api.ActionStateRejected)},
+					{ActionResponse: api.NewActionResponse("second", api.ActionStateRejected)},
+				},
+			}, response)
+			assert.Equal(t, []api.RejectReason{tc.expected, tc.expected}, actionManagerMock.reasons)
+		})
+	}
+}
+
+func TestActionHandler_BatchApprove_invalid_request(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{"invalid json", `not json`, "invalid json"},
+		{"no actionIDs", `{}`, "empty actionIDs"},
+		{"empty actionID", `{"actionIDs":["first"," "]}`, "empty actionID"},
+		{"too many actionIDs", `{"actionIDs":["first","second","third"]}`, "too many actionIDs, the maximum is 2"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			actionManagerMock := &mockBatchActionManager{}
+			sut := NewActionHandler(actionManagerMock, nil, nil)
+			sut.SetBatchConfig(&config.Batch{Parallelism: 1, MaxActions: 2})
+			req, _ := http.NewRequest("POST", "/client/actions/approve", strings.NewReader(tc.body))
+
+			//Act
+			response, err := sut.BatchApprove(nil, httptest.NewRecorder(), req)
+
+			//Assert
+			assert.Nil(t, response)
+			assert.Empty(t, actionManagerMock.handled)
+			code, detail := err.(*defs.APIError).APIError()
+			assert.Equal(t, http.StatusBadRequest, code)
+			assert.Equal(t, tc.expected, detail)
+		})
+	}
+}
@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/jinzhu/copier"
 	"go.uber.org/zap"
@@ -17,7 +19,18 @@ import (
 	"github.com/qredo/signing-agent/util"
 )
 
-type newClientFeedFunc func(conn hub.WebsocketConnection, log *zap.SugaredLogger, unregister clientfeed.UnregisterFunc, config *config.WebSocketConfig) clientfeed.ClientFeed
+// AgentRegistry serves the agents registered besides the system agent
+type AgentRegistry interface {
+	// AddAgent starts serving the newly registered agent and returns its feed url
+	AddAgent(agentID string) string
+	// FeedURL returns the local feed url of the agent
+	FeedURL(agentID string) string
+}
+
+// HeaderLastEventID is the header given by a Server-Sent Events client to resume the stream after the last event it received
+const HeaderLastEventID = "Last-Event-ID"
+
+type newClientFeedFunc func(conn hub.WebsocketConnection, log *zap.SugaredLogger, unregister clientfeed.UnregisterFunc, commands clientfeed.ActionCommander, config *config.WebSocketConfig) clientfeed.ClientFeed
 
 type SigningAgentHandler struct {
 	feedHub           hub.FeedHub
@@ -28,12 +41,21 @@ type SigningAgentHandler struct {
 	localFeed         string
 	decode            func(interface{}, *http.Request) error
 	autoApprover      *autoapprover.AutoApprover
+	feedListeners     []hub.FeedListener
 	upgrader          hub.WebsocketUpgrader
-	newClientFeedFunc newClientFeedFunc //function used by the feed clients to unregister themselves from the hub and stop receiving data
+	newClientFeedFunc newClientFeedFunc          //function used by the feed clients to unregister themselves from the hub and stop receiving data
+	agentRegistry     AgentRegistry              //when set, more agents can be registered besides the system agent
+	commands          clientfeed.ActionCommander //when set, the feed clients can approve and reject actions on the feed connection
+}
+
+// feedCommands carries out the feed commands with the ActionManager, and tells the state of the actions from the pending actions
+type feedCommands struct {
+	autoapprover.ActionManager
+	PendingActions
 }
 
 // NewSigningAgentHandler instantiates and returns a new SigningAgentHandler object.
-func NewSigningAgentHandler(feedHub hub.FeedHub, core lib.SigningAgentClient, log *zap.SugaredLogger, config *config.Config, autoApprover *autoapprover.AutoApprover, upgrader hub.WebsocketUpgrader, localFeed string) *SigningAgentHandler {
+func NewSigningAgentHandler(feedHub hub.FeedHub, core lib.SigningAgentClient, log *zap.SugaredLogger, config *config.Config, autoApprover *autoapprover.AutoApprover, feedListeners []hub.FeedListener, upgrader hub.WebsocketUpgrader, localFeed string) *SigningAgentHandler {
 	return &SigningAgentHandler{
 		feedHub:           feedHub,
 		log:               log,
@@ -42,14 +64,27 @@ func NewSigningAgentHandler(feedHub hub.FeedHub, core lib.SigningAgentClient, lo
 		localFeed:         localFeed,
 		decode:            util.DecodeRequest,
 		autoApprover:      autoApprover,
+		feedListeners:     feedListeners,
 		upgrader:          upgrader,
 		websocketConfig:   &config.Websocket,
 		newClientFeedFunc: clientfeed.NewClientFeed,
 	}
 }
 
+// SetAgentRegistry allows the registration of more agents, served by the registry, besides the system agent
+func (h *SigningAgentHandler) SetAgentRegistry(agentRegistry AgentRegistry) {
+	h.agentRegistry = agentRegistry
+}
+
+// SetActionManager allows the feed clients to send approve and reject commands on the feed connection.
+// The responses tell the state of the actions, as kept by pending
+func (h *SigningAgentHandler) SetActionManager(actionManager autoapprover.ActionManager, pending PendingActions) {
+	h.commands = &feedCommands{ActionManager: actionManager, PendingActions: pending}
+}
+
 // StartAgent is running the feed hub if the agent is registered.
-// It also makes sure the auto approver is registered to the hub and is listening for incoming actions, if enabled in the config
+// It also makes sure the feed listeners, ex. the feed recorder, and the auto approver, if enabled in the config,
+// are registered to the hub and are listening for incoming actions, before the missed actions are replayed
 func (h *SigningAgentHandler) StartAgent() {
 	agentID := h.core.GetSystemAgentID()
 	if len(agentID) == 0 {
@@ -62,13 +97,20 @@ func (h *SigningAgentHandler) StartAgent() {
 		return
 	}
 
-	if !h.config.Enabled {
+	for _, listener := range h.feedListeners {
+		h.feedHub.RegisterClient(listener.GetFeedClient())
+		go listener.Listen()
+	}
+
+	if h.config.Enabled || h.config.Shadow {
+		h.feedHub.RegisterClient(&h.autoApprover.FeedClient)
+		go h.autoApprover.Listen()
+	} else {
 		h.log.Debug("Auto-approval feature not enabled in config")
-		return
 	}
 
-	h.feedHub.RegisterClient(&h.autoApprover.FeedClient)
-	go h.autoApprover.Listen()
+	//the clients are registered, the actions missed while the hub was down can be broadcast
+	go h.feedHub.Replay()
 }
 
 // StopAgent is called to stop the feed hub on request, by ex: when the service is stopped
@@ -83,7 +125,8 @@ func (h *SigningAgentHandler) StopAgent() {
 //
 // # Register a new agent
 //
-// This will register the agent only if there is none already registered.
+// This will register the agent. The first agent registered is the system agent, served by the `/client` endpoints.
+// Every other agent is served by the `/client/{agent_id}` endpoints.
 //
 // Consumes:
 //   - application/json
@@ -98,16 +141,42 @@ func (h *SigningAgentHandler) StopAgent() {
 // 404: ErrorResponse description:Not found
 // 500: ErrorResponse description:Internal error
 func (h *SigningAgentHandler) RegisterAgent(_ *defs.RequestContext, w http.ResponseWriter, r *http.Request) (interface{}, error) {
-	if h.core.GetSystemAgentID() != "" {
-		return nil, defs.ErrBadRequest().WithDetail("AgentID already exist. You can not set new one.")
+	if _, err := h.checkRegistration(); err != nil {
+		return nil, err
 	}
 
-	if response, err := h.register(r); err != nil {
+	registerRequest, err := h.validateRegisterRequest(r)
+	if err != nil {
 		return nil, err
-	} else {
+	}
+
+	response, err := h.Register(registerRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	return *response, nil
+}
+
+// Register registers a new agent with the validated request. The first one is the system agent, which starts the feed
+func (h *SigningAgentHandler) Register(registerRequest *api.ClientRegisterRequest) (*api.AgentRegisterResponse, error) {
+	isSystemAgent, err := h.checkRegistration()
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := h.register(registerRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	if isSystemAgent {
 		h.StartAgent()
-		return response, nil
+	} else {
+		response.FeedURL = h.agentRegistry.AddAgent(response.AgentID)
 	}
+
+	return response, nil
 }
 
 // ClientFeed
@@ -117,6 +186,9 @@ func (h *SigningAgentHandler) RegisterAgent(_ *defs.RequestContext, w http.Respo
 // # Get approval requests Feed (via websocket) from Qredo Backend
 //
 // This endpoint feeds approval requests coming from the Qredo Backend to the agent.
+// The actions can be filtered with the `types`, `statuses` and `agentIDs` query parameters, each one a comma separated list.
+// The client can approve or reject actions by sending JSON-RPC style commands, ex. `{"id":1,"method":"approve","params":{"actionID":"..."}}`,
+// each one answered with a FeedCommandResponse.
 //
 //	Produces:
 //	- application/json
@@ -137,8 +209,15 @@ func (h *SigningAgentHandler) ClientFeed(_ *defs.RequestContext, w http.Response
 			go clientFeed.Start(&wg)
 			wg.Wait() //wait for the client to set up the conn handling
 
-			h.feedHub.RegisterClient(clientFeed.GetFeedClient())
+			feedClient := clientFeed.GetFeedClient()
+			feedClient.Filter = hub.NewFeedFilter(r.URL.Query())
+			if feedClient.Filter != nil {
+				h.log.Debugf("handler: feed filtered by types %v, statuses %v, agent IDs %v", feedClient.Filter.Types, feedClient.Filter.Statuses, feedClient.Filter.AgentIDs)
+			}
+
+			h.feedHub.RegisterClient(feedClient)
 			go clientFeed.Listen()
+			go clientFeed.ReadCommands()
 			h.log.Info("handler: connected to feed, listening ...")
 		}
 	} else {
@@ -148,6 +227,55 @@ func (h *SigningAgentHandler) ClientFeed(_ *defs.RequestContext, w http.Response
 	return nil, nil
 }
 
+// ClientFeedSSE
+//
+// swagger:route GET /client/feed/sse client ClientFeedSSE
+//
+// # Get approval requests Feed (via Server-Sent Events) from Qredo Backend
+//
+// This endpoint streams the approval requests coming from the Qredo Backend as Server-Sent Events of type `action`.
+// Every event has an ID, the stream resumes after the event given by the `Last-Event-ID` header from the last events kept in memory.
+// A heartbeat comment is sent when no event was sent for a ping period.
+// The actions can be filtered with the `types`, `statuses` and `agentIDs` query parameters, each one a comma separated list.
+//
+//	Produces:
+//	- text/event-stream
+//
+// Responses:
+// 200: ClientFeedResponse
+func (h *SigningAgentHandler) ClientFeedSSE(_ *defs.RequestContext, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	if !h.feedHub.IsRunning() {
+		h.log.Debugf("handler: failed to stream the feed, hub not running")
+		return nil, defs.ErrServiceUnavailable().WithDetail("feed not available")
+	}
+
+	stream, err := clientfeed.NewEventStream(w, h.log, time.Duration(h.websocketConfig.PingPeriod)*time.Second)
+	if err != nil {
+		return nil, defs.ErrInternal().Wrap(err)
+	}
+
+	feedClient := stream.GetFeedClient()
+	if lastEventID := r.Header.Get(HeaderLastEventID); len(lastEventID) > 0 {
+		eventID, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			return nil, defs.ErrBadRequest().WithDetail("invalid " + HeaderLastEventID)
+		}
+		feedClient.ResumeAfter = &eventID
+	}
+
+	feedClient.Filter = hub.NewFeedFilter(r.URL.Query())
+	if feedClient.Filter != nil {
+		h.log.Debugf("handler: feed filtered by types %v, statuses %v, agent IDs %v", feedClient.Filter.Types, feedClient.Filter.Statuses, feedClient.Filter.AgentIDs)
+	}
+
+	h.feedHub.RegisterClient(feedClient)
+	h.log.Info("handler: streaming the feed ...")
+	stream.Serve(r.Context(), h.feedHub.UnregisterClient)
+	h.log.Info("handler: feed stream closed")
+
+	return nil, nil
+}
+
 // GetClient
 //
 // swagger:route GET /client client GetClient
@@ -163,13 +291,107 @@ func (h *SigningAgentHandler) ClientFeed(_ *defs.RequestContext, w http.Response
 //
 //	200: GetClientResponse
 func (h *SigningAgentHandler) GetClient(_ *defs.RequestContext, w http.ResponseWriter, _ *http.Request) (interface{}, error) {
-	response := api.GetClientResponse{
+	return h.Client(), nil
+}
+
+// Client returns the ID and the local feed url of the agent
+func (h *SigningAgentHandler) Client() api.GetClientResponse {
+	return api.GetClientResponse{
 		AgentID: h.core.GetAgentID(),
 		FeedURL: h.localFeed,
 	}
+}
+
+// GetAgents
+//
+// swagger:route GET /agents client GetAgents
+//
+// # Get the registered agents
+//
+// This endpoint lists the `agentID` and `feedURL` of every registered agent, the system agent first.
+//
+// Produces:
+//   - application/json
+//
+// Responses:
+//
+//	200: AgentListResponse
+func (h *SigningAgentHandler) GetAgents(_ *defs.RequestContext, w http.ResponseWriter, _ *http.Request) (interface{}, error) {
+	response := api.AgentListResponse{
+		Agents: make([]api.GetClientResponse, 0),
+	}
+
+	for i, agentID := range h.core.GetAgentIDs() {
+		feedURL := h.localFeed
+		if i > 0 && h.agentRegistry != nil {
+			feedURL = h.agentRegistry.FeedURL(agentID)
+		}
+
+		response.Agents = append(response.Agents, api.GetClientResponse{
+			AgentID: agentID,
+			FeedURL: feedURL,
+		})
+	}
+
 	return response, nil
 }
 
+// GetShadowDecisions
+//
+// swagger:route GET /client/autoapproval/shadow client GetShadowDecisions
+//
+// # Get the shadow decisions of the auto-approval
+//
+// This endpoint returns whether the auto-approval runs in shadow mode, and the latest decisions it took in shadow mode,
+// with the outcome of the transaction when one is recorded in the journal.
+//
+// Produces:
+//   - application/json
+//
+// Responses:
+//
+//	200: ShadowDecisionListResponse
+func (h *SigningAgentHandler) GetShadowDecisions(_ *defs.RequestContext, _ http.ResponseWriter, _ *http.Request) (interface{}, error) {
+	return api.ShadowDecisionListResponse{
+		Shadow:    h.autoApprover.IsShadow(),
+		Decisions: h.autoApprover.ShadowDecisions(),
+	}, nil
+}
+
+// SetShadowMode
+//
+// swagger:route PUT /client/autoapproval/shadow client SetShadowMode
+//
+// # Switch the shadow mode of the auto-approval
+//
+// This endpoint switches the shadow mode on or off. In shadow mode, the auto-approval records the decision for every transaction
+// received but never approves or rejects it. The auto-approval must be enabled, or started in shadow mode, in the config.
+//
+// Consumes:
+//   - application/json
+//
+// Produces:
+//   - application/json
+//
+// Responses:
+//
+//	200: ShadowDecisionListResponse
+//	400: ErrorResponse description:Bad request
+func (h *SigningAgentHandler) SetShadowMode(ctx *defs.RequestContext, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	if !h.config.Enabled && !h.config.Shadow {
+		return nil, defs.ErrBadRequest().WithDetail("auto-approval not started, enable autoApproval or its shadow mode in the config")
+	}
+
+	request := &api.ShadowModeRequest{}
+	if err := h.decode(request, r); err != nil {
+		h.log.Debugf("failed to decode shadow mode request, %v", err)
+		return nil, err
+	}
+
+	h.autoApprover.SetShadow(request.Shadow)
+	return h.GetShadowDecisions(ctx, w, r)
+}
+
 func (h *SigningAgentHandler) newClientFeed(w http.ResponseWriter, r *http.Request) clientfeed.ClientFeed {
 	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -177,15 +399,20 @@ func (h *SigningAgentHandler) newClientFeed(w http.ResponseWriter, r *http.Reque
 		return nil
 	}
 
-	return h.newClientFeedFunc(conn, h.log, h.feedHub.UnregisterClient, h.websocketConfig)
+	return h.newClientFeedFunc(conn, h.log, h.feedHub.UnregisterClient, h.commands, h.websocketConfig)
 }
 
-func (h *SigningAgentHandler) register(r *http.Request) (interface{}, error) {
-	registerRequest, err := h.validateRegisterRequest(r)
-	if err != nil {
-		return nil, err
+// checkRegistration returns whether the next agent registered is the system agent, or an error when no other agent can be registered
+func (h *SigningAgentHandler) checkRegistration() (bool, error) {
+	isSystemAgent := h.core.GetSystemAgentID() == ""
+	if !isSystemAgent && h.agentRegistry == nil {
+		return false, defs.ErrBadRequest().WithDetail("AgentID already exist. You can not set new one.")
 	}
 
+	return isSystemAgent, nil
+}
+
+func (h *SigningAgentHandler) register(registerRequest *api.ClientRegisterRequest) (*api.AgentRegisterResponse, error) {
 	registerResults, err := h.core.ClientRegister(registerRequest.Name) // Get BLS and EC public keys
 	if err != nil {
 		h.log.Debugf("error while trying to register the client [%s], err: %v", registerRequest.Name, err)
@@ -202,7 +429,7 @@ func (h *SigningAgentHandler) register(r *http.Request) (interface{}, error) {
 		return nil, err
 	}
 
-	response := api.AgentRegisterResponse{
+	response := &api.AgentRegisterResponse{
 		AgentID: initResults.AccountCode,
 		FeedURL: h.localFeed,
 	}
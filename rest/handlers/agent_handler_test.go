@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -20,7 +21,9 @@ import (
 	"github.com/qredo/signing-agent/config"
 	"github.com/qredo/signing-agent/defs"
 	"github.com/qredo/signing-agent/hub"
+	"github.com/qredo/signing-agent/journal"
 	"github.com/qredo/signing-agent/lib"
+	"github.com/qredo/signing-agent/queue"
 	"github.com/qredo/signing-agent/util"
 )
 
@@ -31,6 +34,7 @@ type mockFeedHub struct {
 	UnregisterClientCalled bool
 	StopCalled             bool
 	IsRunningCalled        bool
+	ReplayCalled           bool
 	LastRegisteredClient   *hub.FeedClient
 	LastUnregisteredClient *hub.FeedClient
 }
@@ -45,6 +49,10 @@ func (m *mockFeedHub) Run() bool {
 	return m.NextRun
 }
 
+func (m *mockFeedHub) Replay() {
+	m.ReplayCalled = true
+}
+
 func (m *mockFeedHub) Stop() {
 	m.StopCalled = true
 }
@@ -66,6 +74,7 @@ func (m *mockFeedHub) GetExternalFeedClients() int {
 type mockClientFeed struct {
 	StartCalled         bool
 	ListenCalled        bool
+	ReadCommandsCalled  bool
 	GetFeedClientCalled bool
 	NextFeedClient      *hub.FeedClient
 }
@@ -78,6 +87,10 @@ func (m *mockClientFeed) Start(wg *sync.WaitGroup) {
 func (m *mockClientFeed) Listen() {
 	m.ListenCalled = true
 }
+
+func (m *mockClientFeed) ReadCommands() {
+	m.ReadCommandsCalled = true
+}
 func (m *mockClientFeed) GetFeedClient() *hub.FeedClient {
 	m.GetFeedClientCalled = true
 	return m.NextFeedClient
@@ -116,7 +129,7 @@ func TestSigningAgentHandler_RegisterAgent_already_registered(t *testing.T) {
 		NextAgentID: "some agent id",
 	}
 	handler := NewSigningAgentHandler(&mockFeedHub{}, mock_core, testLog, &config.Config{
-		HTTP: config.HttpSettings{}}, nil, nil, "")
+		HTTP: config.HttpSettings{}}, nil, nil, nil, "")
 
 	rr := httptest.NewRecorder()
 
@@ -178,7 +191,7 @@ func TestSigningAgentHandler_RegisterAgent_doesnt_validate_request(t *testing.T)
 	mock_core := lib.NewMockSigningAgentClient("")
 
 	handler := NewSigningAgentHandler(&mockFeedHub{}, mock_core, testLog, &config.Config{
-		HTTP: config.HttpSettings{}}, nil, nil, "")
+		HTTP: config.HttpSettings{}}, nil, nil, nil, "")
 
 	req, _ := http.NewRequest("POST", "/path", bytes.NewReader([]byte(`
 	{
@@ -205,7 +218,7 @@ func TestSigningAgentHandler_RegisterAgent_fails_to_register_client(t *testing.T
 	}
 
 	handler := NewSigningAgentHandler(&mockFeedHub{}, mock_core, testLog, &config.Config{
-		HTTP: config.HttpSettings{}}, nil, nil, "")
+		HTTP: config.HttpSettings{}}, nil, nil, nil, "")
 
 	//Act
 	response, err := handler.RegisterAgent(nil, httptest.NewRecorder(), NewTestRequest())
@@ -227,7 +240,7 @@ func TestSigningAgentHandler_RegisterAgent_fails_to_init_registration(t *testing
 	}
 
 	handler := NewSigningAgentHandler(&mockFeedHub{}, mock_core, testLog, &config.Config{
-		HTTP: config.HttpSettings{}}, nil, nil, "")
+		HTTP: config.HttpSettings{}}, nil, nil, nil, "")
 
 	//Act
 	response, err := handler.RegisterAgent(nil, httptest.NewRecorder(), NewTestRequest())
@@ -254,7 +267,7 @@ func TestSigningAgentHandler_RegisterAgent_fails_to_finish_registration(t *testi
 	}
 
 	handler := NewSigningAgentHandler(&mockFeedHub{}, mock_core, testLog, &config.Config{
-		HTTP: config.HttpSettings{}}, nil, nil, "")
+		HTTP: config.HttpSettings{}}, nil, nil, nil, "")
 
 	//Act
 	response, err := handler.RegisterAgent(nil, httptest.NewRecorder(), NewTestRequest())
@@ -284,7 +297,7 @@ func TestSigningAgentHandler_RegisterAgent_returns_response(t *testing.T) {
 	handler := NewSigningAgentHandler(&mockFeedHub{}, mock_core, testLog, &config.Config{
 		HTTP: config.HttpSettings{
 			Addr: "some address",
-		}}, nil, nil, "ws://some address/api/v1/client/feed")
+		}}, nil, nil, nil, "ws://some address/api/v1/client/feed")
 
 	//Act
 	response, err := handler.RegisterAgent(nil, httptest.NewRecorder(), NewTestRequest())
@@ -301,6 +314,68 @@ func TestSigningAgentHandler_RegisterAgent_returns_response(t *testing.T) {
 	assert.Equal(t, "ws://some address/api/v1/client/feed", res.FeedURL)
 }
 
+type mockAgentRegistry struct {
+	LastAddedAgentID string
+}
+
+func (m *mockAgentRegistry) AddAgent(agentID string) string {
+	m.LastAddedAgentID = agentID
+	return "ws://some address/api/v1/client/" + agentID + "/feed"
+}
+
+func (m *mockAgentRegistry) FeedURL(agentID string) string {
+	return "ws://some address/api/v1/client/" + agentID + "/feed"
+}
+
+func TestSigningAgentHandler_RegisterAgent_registers_another_agent(t *testing.T) {
+	//Arrange
+	mock_core := &lib.MockSigningAgentClient{
+		NextAgentID:                "some agent id",
+		NextClientRegisterResponse: testClientRegisterResponse,
+		NextRegisterInitResponse:   testRegisterInitResponse,
+		NextRegisterFinishResponse: &api.ClientRegisterFinishResponse{},
+	}
+	feedHub := &mockFeedHub{}
+	registry := &mockAgentRegistry{}
+	handler := NewSigningAgentHandler(feedHub, mock_core, testLog, &config.Config{}, nil, nil, nil, "ws://some address/api/v1/client/feed")
+	handler.SetAgentRegistry(registry)
+
+	//Act
+	response, err := handler.RegisterAgent(nil, httptest.NewRecorder(), NewTestRequest())
+
+	//Assert
+	assert.Nil(t, err)
+	assert.True(t, mock_core.ClientRegisterFinishCalled)
+	assert.False(t, feedHub.RunCalled)
+	assert.Equal(t, "account code", registry.LastAddedAgentID)
+
+	res, ok := response.(api.AgentRegisterResponse)
+	assert.True(t, ok)
+	assert.Equal(t, "account code", res.AgentID)
+	assert.Equal(t, "ws://some address/api/v1/client/account code/feed", res.FeedURL)
+}
+
+func TestSigningAgentHandler_GetAgents_lists_agents(t *testing.T) {
+	//Arrange
+	mock_core := &lib.MockSigningAgentClient{
+		NextAgentIDs: []string{"system agent id", "other agent id"},
+	}
+	handler := NewSigningAgentHandler(&mockFeedHub{}, mock_core, testLog, &config.Config{}, nil, nil, nil, "ws://some address/api/v1/client/feed")
+	handler.SetAgentRegistry(&mockAgentRegistry{})
+
+	//Act
+	response, err := handler.GetAgents(nil, httptest.NewRecorder(), nil)
+
+	//Assert
+	assert.Nil(t, err)
+	res, ok := response.(api.AgentListResponse)
+	assert.True(t, ok)
+	assert.Equal(t, []api.GetClientResponse{
+		{AgentID: "system agent id", FeedURL: "ws://some address/api/v1/client/feed"},
+		{AgentID: "other agent id", FeedURL: "ws://some address/api/v1/client/other agent id/feed"},
+	}, res.Agents)
+}
+
 func TestSigningAgentHandler_StartAgent_runs_feedHub(t *testing.T) {
 	//Arrange
 	defer goleak.VerifyNone(t)
@@ -308,7 +383,7 @@ func TestSigningAgentHandler_StartAgent_runs_feedHub(t *testing.T) {
 	mockCore := lib.NewMockSigningAgentClient("valid_agentID")
 	handler := NewSigningAgentHandler(mockFeedHub, mockCore, testLog,
 		&config.Config{
-			HTTP: config.HttpSettings{}}, nil, nil, "")
+			HTTP: config.HttpSettings{}}, nil, nil, nil, "")
 
 	//Act
 	handler.StartAgent()
@@ -330,7 +405,7 @@ func TestSigningAgentHandler_StartAgent_doesnt_start_autoApproval(t *testing.T)
 		HTTP:        config.HttpSettings{},
 		AutoApprove: config.AutoApprove{},
 	}
-	handler := NewSigningAgentHandler(mockFeedHub, mockCore, testLog, config, nil, nil, "")
+	handler := NewSigningAgentHandler(mockFeedHub, mockCore, testLog, config, nil, nil, nil, "")
 
 	//Act
 	handler.StartAgent()
@@ -341,6 +416,27 @@ func TestSigningAgentHandler_StartAgent_doesnt_start_autoApproval(t *testing.T)
 	assert.False(t, mockFeedHub.RegisterClientCalled)
 }
 
+func TestSigningAgentHandler_StartAgent_registers_feed_listeners(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	mockFeedHub := &mockFeedHub{
+		NextRun: true,
+	}
+	mockCore := lib.NewMockSigningAgentClient("valid_agentID")
+	feedRecorder := journal.NewFeedRecorder(&journal.MockJournal{}, testLog)
+	handler := NewSigningAgentHandler(mockFeedHub, mockCore, testLog, &config.Config{}, nil, []hub.FeedListener{feedRecorder}, nil, "")
+
+	//Act
+	handler.StartAgent()
+	close(feedRecorder.Feed)
+	<-time.After(100 * time.Millisecond)
+
+	//Assert
+	assert.True(t, mockFeedHub.RegisterClientCalled)
+	assert.Same(t, &feedRecorder.FeedClient, mockFeedHub.LastRegisteredClient)
+	assert.True(t, mockFeedHub.ReplayCalled)
+}
+
 func TestSigningAgentHandler_StartAgent_registers_auto_approval(t *testing.T) {
 	//Arrange
 	defer goleak.VerifyNone(t)
@@ -348,12 +444,13 @@ func TestSigningAgentHandler_StartAgent_registers_auto_approval(t *testing.T) {
 		NextRun: true,
 	}
 	mockCore := lib.NewMockSigningAgentClient("valid_agentID")
+	actionQueue, _ := queue.NewQueue(&config.ActionQueue{})
 
 	handler := NewSigningAgentHandler(mockFeedHub, mockCore, testLog, &config.Config{
 		AutoApprove: config.AutoApprove{
 			Enabled: true,
 		},
-	}, autoapprover.NewAutoApprover(mockCore, testLog, &config.Config{}, nil), nil, "")
+	}, autoapprover.NewAutoApprover(mockCore, testLog, &config.Config{}, nil, &journal.MockJournal{}, actionQueue), nil, nil, "")
 
 	//Act
 	handler.StartAgent()
@@ -373,7 +470,7 @@ func TestSigningAgentHandler_StopAgent(t *testing.T) {
 	//Arrange
 	mockFeedHub := &mockFeedHub{}
 	handler := NewSigningAgentHandler(mockFeedHub, nil, util.NewTestLogger(), &config.Config{
-		HTTP: config.HttpSettings{}}, &autoapprover.AutoApprover{}, nil, "")
+		HTTP: config.HttpSettings{}}, &autoapprover.AutoApprover{}, nil, nil, "")
 
 	//Act
 	handler.StopAgent()
@@ -382,6 +479,96 @@ func TestSigningAgentHandler_StopAgent(t *testing.T) {
 	assert.True(t, mockFeedHub.StopCalled)
 }
 
+// streamingFeedHub sends the messages to the client registered, then calls done. The Feed channel is closed on unregister
+type streamingFeedHub struct {
+	mockFeedHub
+	messages []string
+	done     func()
+}
+
+func (m *streamingFeedHub) RegisterClient(client *hub.FeedClient) {
+	m.mockFeedHub.RegisterClient(client)
+	go func() {
+		for i, message := range m.messages {
+			client.Feed <- client.Format(uint64(i+1), []byte(message))
+		}
+		m.done()
+	}()
+}
+
+func (m *streamingFeedHub) UnregisterClient(client *hub.FeedClient) {
+	m.mockFeedHub.UnregisterClient(client)
+	close(client.Feed)
+}
+
+func TestSigningAgentHandler_ClientFeedSSE_streams_events(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	mockHub := &streamingFeedHub{
+		mockFeedHub: mockFeedHub{NextRun: true},
+		messages:    []string{`{"id":"first action id"}`, `{"id":"second action id"}`},
+		done:        cancel,
+	}
+	handler := &SigningAgentHandler{
+		feedHub:         mockHub,
+		log:             testLog,
+		websocketConfig: &config.WebSocketConfig{PingPeriod: 5},
+	}
+	recorder := httptest.NewRecorder()
+	test_req, _ := http.NewRequestWithContext(ctx, "GET", "/path?types=ApproveWithdraw", nil)
+	test_req.Header.Set(HeaderLastEventID, "41")
+
+	//Act
+	response, err := handler.ClientFeedSSE(nil, recorder, test_req)
+
+	//Assert
+	assert.Nil(t, response)
+	assert.Nil(t, err)
+	assert.Equal(t, clientfeed.SSEContentType, recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "id: 1\nevent: action\ndata: {\"id\":\"first action id\"}\n\nid: 2\nevent: action\ndata: {\"id\":\"second action id\"}\n\n", recorder.Body.String())
+	assert.True(t, mockHub.UnregisterClientCalled)
+	assert.Equal(t, mockHub.LastRegisteredClient, mockHub.LastUnregisteredClient)
+	assert.Equal(t, uint64(41), *mockHub.LastRegisteredClient.ResumeAfter)
+	assert.Equal(t, []string{"ApproveWithdraw"}, mockHub.LastRegisteredClient.Filter.Types)
+}
+
+func TestSigningAgentHandler_ClientFeedSSE_errors(t *testing.T) {
+	var testCases = []struct {
+		name        string
+		running     bool
+		lastEventID string
+		code        int
+	}{
+		{"hub not running", false, "", http.StatusServiceUnavailable},
+		{"invalid last event id", true, "not a number", http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			mockHub := &mockFeedHub{NextRun: tc.running}
+			handler := &SigningAgentHandler{
+				feedHub:         mockHub,
+				log:             testLog,
+				websocketConfig: &config.WebSocketConfig{PingPeriod: 5},
+			}
+			test_req, _ := http.NewRequest("GET", "/path", nil)
+			test_req.Header.Set(HeaderLastEventID, tc.lastEventID)
+
+			//Act
+			response, err := handler.ClientFeedSSE(nil, httptest.NewRecorder(), test_req)
+
+			//Assert
+			assert.Nil(t, response)
+			var apiErr *defs.APIError
+			assert.True(t, errors.As(err, &apiErr))
+			assert.Equal(t, tc.code, apiErr.Code())
+			assert.False(t, mockHub.RegisterClientCalled)
+		})
+	}
+}
+
 func TestSigningAgentHandler_ClientFeed_hub_not_running(t *testing.T) {
 	//Arrange
 	mockHub := &mockFeedHub{}
@@ -445,7 +632,7 @@ func TestSigningAgentHandler_ClientFeed_registers_client(t *testing.T) {
 	mockFeedClient := &mockClientFeed{
 		NextFeedClient: &feedClient,
 	}
-	newClientfunc := func(conn hub.WebsocketConnection, log *zap.SugaredLogger, unregister clientfeed.UnregisterFunc, config *config.WebSocketConfig) clientfeed.ClientFeed {
+	newClientfunc := func(conn hub.WebsocketConnection, log *zap.SugaredLogger, unregister clientfeed.UnregisterFunc, commands clientfeed.ActionCommander, config *config.WebSocketConfig) clientfeed.ClientFeed {
 		return mockFeedClient
 	}
 	handler := &SigningAgentHandler{
@@ -470,6 +657,7 @@ func TestSigningAgentHandler_ClientFeed_registers_client(t *testing.T) {
 	assert.True(t, mockFeedClient.StartCalled)
 	assert.True(t, mockFeedClient.GetFeedClientCalled)
 	assert.True(t, mockFeedClient.ListenCalled)
+	assert.True(t, mockFeedClient.ReadCommandsCalled)
 }
 
 func TestSigningAgentHandler_GetClient(t *testing.T) {
@@ -497,3 +685,73 @@ func TestSigningAgentHandler_GetClient(t *testing.T) {
 
 	assert.Equal(t, "{\"agentID\":\"client 1\",\"feedURL\":\"feed/path\"}", string(data))
 }
+
+func TestSigningAgentHandler_ClientFeed_sets_filter(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	mockHub := &mockFeedHub{
+		NextRun: true,
+	}
+	feedClient := hub.NewFeedClient(false)
+	newClientfunc := func(conn hub.WebsocketConnection, log *zap.SugaredLogger, unregister clientfeed.UnregisterFunc, commands clientfeed.ActionCommander, config *config.WebSocketConfig) clientfeed.ClientFeed {
+		return &mockClientFeed{NextFeedClient: &feedClient}
+	}
+	handler := &SigningAgentHandler{
+		feedHub: mockHub,
+		log:     testLog,
+		upgrader: &hub.MockWebsocketUpgrader{
+			NextWebsocketConnection: &hub.MockWebsocketConnection{},
+		},
+		websocketConfig:   &config.WebSocketConfig{},
+		newClientFeedFunc: newClientfunc,
+	}
+
+	test_req, _ := http.NewRequest("GET", "/path?types=ApproveWithdraw,ApproveTransfer&statuses=pending", nil)
+
+	//Act
+	_, _ = handler.ClientFeed(nil, httptest.NewRecorder(), test_req)
+	<-time.After(time.Second)
+
+	//Assert
+	assert.True(t, mockHub.RegisterClientCalled)
+	assert.Equal(t, &hub.FeedFilter{
+		Types:    []string{"ApproveWithdraw", "ApproveTransfer"},
+		Statuses: []string{"pending"},
+		AgentIDs: []string{},
+	}, mockHub.LastRegisteredClient.Filter)
+}
+
+func TestSigningAgentHandler_SetShadowMode(t *testing.T) {
+	//Arrange
+	cfg := &config.Config{
+		AutoApprove: config.AutoApprove{Enabled: true},
+	}
+	actionQueue, _ := queue.NewQueue(&config.ActionQueue{})
+	autoApprover := autoapprover.NewAutoApprover(lib.NewMockSigningAgentClient("valid_agentID"), testLog, cfg, nil, &journal.MockJournal{}, actionQueue)
+	handler := NewSigningAgentHandler(&mockFeedHub{}, nil, testLog, cfg, autoApprover, nil, nil, "")
+	req, _ := http.NewRequest("PUT", "/client/autoapproval/shadow", bytes.NewBufferString(`{"shadow":true}`))
+
+	//Act
+	response, err := handler.SetShadowMode(nil, httptest.NewRecorder(), req)
+
+	//Assert
+	assert.Nil(t, err)
+	assert.True(t, autoApprover.IsShadow())
+	assert.Equal(t, api.ShadowDecisionListResponse{Shadow: true, Decisions: []api.ShadowDecision{}}, response)
+}
+
+func TestSigningAgentHandler_SetShadowMode_auto_approval_not_started(t *testing.T) {
+	//Arrange
+	handler := NewSigningAgentHandler(&mockFeedHub{}, nil, testLog, &config.Config{}, nil, nil, nil, "")
+	req, _ := http.NewRequest("PUT", "/client/autoapproval/shadow", bytes.NewBufferString(`{"shadow":true}`))
+
+	//Act
+	response, err := handler.SetShadowMode(nil, httptest.NewRecorder(), req)
+
+	//Assert
+	assert.Nil(t, response)
+	apiErr := err.(*defs.APIError)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "auto-approval not started, enable autoApproval or its shadow mode in the config", detail)
+}
@@ -79,13 +79,16 @@ func (h *HealthCheckHandler) HealthCheckConfig(_ *defs.RequestContext, w http.Re
 //
 //	200: StatusResponse
 func (h *HealthCheckHandler) HealthCheckStatus(_ *defs.RequestContext, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	return h.Status(), nil
+}
+
+// Status returns the state of the connection to the Qredo feed and the number of clients of the local feed
+func (h *HealthCheckHandler) Status() api.HealthCheckStatusResponse {
 	readyState := h.source.GetReadyState()
 	sourceFeedUrl := h.source.GetFeedUrl()
 	connectedFeedClients := h.feedClients.GetExternalFeedClients()
 
-	response := api.HealthCheckStatusResponse{
+	return api.HealthCheckStatusResponse{
 		WebsocketStatus: api.NewWebsocketStatus(readyState, sourceFeedUrl, h.localFeedUrl, connectedFeedClients),
 	}
-
-	return response, nil
 }
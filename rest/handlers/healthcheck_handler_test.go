@@ -121,4 +121,7 @@ func TestHealthCheckHandler_HealthCheckConfig(t *testing.T) {
 
 	data, _ := json.Marshal(response)
 	assert.NotEmpty(t, string(data))
+	assert.Contains(t, string(data), "\"pin\":0")
+	assert.Contains(t, string(data), "\"qredoAPI\":\"some url\"")
+	assert.Equal(t, 25, config.Base.PIN)
 }
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/defs"
+
+	"github.com/gorilla/mux"
+)
+
+// DeadLetters returns the actions that couldn't be delivered to the webhook endpoints
+type DeadLetters interface {
+	List() []api.DeadLetter
+}
+
+// DeadLetterReplayer posts the dead letters of a delivery to the webhook endpoints again
+type DeadLetterReplayer interface {
+	Replay(id string) []api.DeadLetterReplayResult
+}
+
+type WebhookHandler struct {
+	deadLetters DeadLetters
+	replayer    DeadLetterReplayer
+}
+
+func NewWebhookHandler(deadLetters DeadLetters, replayer DeadLetterReplayer) *WebhookHandler {
+	return &WebhookHandler{
+		deadLetters: deadLetters,
+		replayer:    replayer,
+	}
+}
+
+// GetDeadLetters
+//
+// swagger:route GET /webhooks/deadletters webhooks GetDeadLetters
+//
+// # List the undelivered webhook actions
+//
+// This endpoint returns the actions that couldn't be delivered to the webhook endpoints, oldest first,
+// with the number of attempts and the error of the last one.
+//
+// Produces:
+//   - application/json
+//
+// Responses:
+//
+//	200: DeadLetterListResponse
+func (h *WebhookHandler) GetDeadLetters(_ *defs.RequestContext, _ http.ResponseWriter, _ *http.Request) (interface{}, error) {
+	return api.DeadLetterListResponse{
+		DeadLetters: h.deadLetters.List(),
+	}, nil
+}
+
+// ReplayDeadLetter
+//
+// swagger:route POST /webhooks/deadletters/{delivery_id}/replay webhooks ReplayDeadLetter
+//
+// # Replay an undelivered webhook action
+//
+// This endpoint posts the action of the delivery, `delivery_id`, again to every endpoint it couldn't be delivered to,
+// with a single attempt each. The delivered actions are removed from the dead letters, the others are kept.
+//
+//	Parameters:
+//	  + name: delivery_id
+//	    in: path
+//	    description: the ID of the delivery, as listed in the dead letters
+//	    required: true
+//	    type: string
+//
+// Produces:
+//   - application/json
+//
+// Responses:
+//
+//	200: DeadLetterReplayResponse
+//	400: ErrorResponse description:Bad request
+//	404: ErrorResponse description:Not found
+func (h *WebhookHandler) ReplayDeadLetter(_ *defs.RequestContext, _ http.ResponseWriter, r *http.Request) (interface{}, error) {
+	id := strings.TrimSpace(mux.Vars(r)["delivery_id"])
+	if id == "" {
+		return nil, defs.ErrBadRequest().WithDetail("empty delivery_id")
+	}
+
+	results := h.replayer.Replay(id)
+	if len(results) == 0 {
+		return nil, defs.ErrNotFound().WithDetail("no dead letter for the delivery")
+	}
+
+	return api.DeadLetterReplayResponse{
+		ID:      id,
+		Results: results,
+	}, nil
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/defs"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockDeadLetters struct {
+	letters      []api.DeadLetter
+	results      []api.DeadLetterReplayResult
+	lastReplayID string
+}
+
+func (m *mockDeadLetters) List() []api.DeadLetter {
+	return m.letters
+}
+
+func (m *mockDeadLetters) Replay(id string) []api.DeadLetterReplayResult {
+	m.lastReplayID = id
+	return m.results
+}
+
+func TestWebhookHandler_GetDeadLetters(t *testing.T) {
+	//Arrange
+	deadLetters := &mockDeadLetters{letters: []api.DeadLetter{{ID: "some id", Endpoint: "some endpoint", Attempts: 5}}}
+	sut := NewWebhookHandler(deadLetters, deadLetters)
+
+	//Act
+	response, err := sut.GetDeadLetters(nil, nil, nil)
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Equal(t, api.DeadLetterListResponse{DeadLetters: deadLetters.letters}, response)
+}
+
+func TestWebhookHandler_ReplayDeadLetter(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		path       string
+		results    []api.DeadLetterReplayResult
+		expected   interface{}
+		statusCode int
+	}{
+		{
+			name:     "replays the delivery",
+			path:     "/webhooks/deadletters/some id/replay",
+			results:  []api.DeadLetterReplayResult{{Endpoint: "some endpoint", Delivered: true}},
+			expected: api.DeadLetterReplayResponse{ID: "some id", Results: []api.DeadLetterReplayResult{{Endpoint: "some endpoint", Delivered: true}}},
+		},
+		{
+			name:       "empty delivery id",
+			path:       "/webhooks/deadletters/ /replay",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "no dead letter",
+			path:       "/webhooks/deadletters/unknown id/replay",
+			statusCode: http.StatusNotFound,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			deadLetters := &mockDeadLetters{results: tc.results}
+			sut := NewWebhookHandler(deadLetters, deadLetters)
+			req, _ := http.NewRequest(http.MethodPost, tc.path, nil)
+			m := mux.NewRouter()
+			var (
+				err      error
+				response interface{}
+			)
+			m.HandleFunc("/webhooks/deadletters/{delivery_id}/replay", func(w http.ResponseWriter, r *http.Request) {
+				response, err = sut.ReplayDeadLetter(nil, w, r)
+			})
+
+			//Act
+			m.ServeHTTP(httptest.NewRecorder(), req)
+
+			//Assert
+			if tc.statusCode != 0 {
+				assert.Nil(t, response)
+				apiErr, ok := err.(*defs.APIError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.statusCode, apiErr.Code())
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, response)
+			assert.Equal(t, "some id", deadLetters.lastReplayID)
+		})
+	}
+}
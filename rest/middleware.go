@@ -12,15 +12,22 @@ import (
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 
+	"github.com/qredo/signing-agent/config"
 	"github.com/qredo/signing-agent/defs"
 )
 
-func NewMiddleware(log *zap.SugaredLogger, logAllRequests bool) *Middleware {
+func NewMiddleware(log *zap.SugaredLogger, logAllRequests bool, auth *config.HttpAuth) *Middleware {
 	l := log.Desugar()
 	ll := l.WithOptions(zap.AddCallerSkip(1)).Sugar()
 	mw := &Middleware{
 		log:            ll,
 		logAllRequests: logAllRequests,
+		authEnabled:    auth.Enabled,
+		authenticators: NewAuthenticators(auth),
+		openPaths:      make(map[string]bool),
+	}
+	for _, path := range auth.OpenPaths {
+		mw.openPaths[path] = true
 	}
 	return mw
 }
@@ -28,6 +35,9 @@ func NewMiddleware(log *zap.SugaredLogger, logAllRequests bool) *Middleware {
 type Middleware struct {
 	log            *zap.SugaredLogger
 	logAllRequests bool
+	authEnabled    bool
+	authenticators []Authenticator
+	openPaths      map[string]bool
 }
 
 func (m *Middleware) sessionMiddleware(next appHandlerFunc) appHandlerFunc {
@@ -47,6 +57,63 @@ func (m *Middleware) notProtectedMiddleware(next appHandlerFunc) appHandlerFunc
 	}
 }
 
+// isOpen returns true when the path is open to the clients that don't authenticate, as given by the auth config
+func (m *Middleware) isOpen(path string) bool {
+	return m.openPaths[path]
+}
+
+// protectedMiddleware lets the request through only when one of the authenticators identifies the caller.
+// It's the same as notProtectedMiddleware when the authentication is disabled
+func (m *Middleware) protectedMiddleware(next appHandlerFunc) appHandlerFunc {
+	if !m.authEnabled {
+		return m.notProtectedMiddleware(next)
+	}
+
+	return func(ctx *defs.RequestContext, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		identity, err := m.authenticate(r)
+		if err != nil {
+			return nil, err
+		}
+
+		ctx.Identity = identity
+		context.Set(r, "ctx", *ctx)
+		return next(ctx, w, r)
+	}
+}
+
+// protectedHandler is the protectedMiddleware of the handlers served outside the API, ex. the metrics.
+// The handler is left open when the path is one of the open paths or the authentication is disabled
+func (m *Middleware) protectedHandler(path string, next http.Handler) http.Handler {
+	if !m.authEnabled || m.isOpen(path) {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := m.authenticate(r); err != nil {
+			WriteHTTPError(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate returns the identity of the caller given by the first authenticator recognizing its credentials
+func (m *Middleware) authenticate(r *http.Request) (string, error) {
+	for _, authenticator := range m.authenticators {
+		identity, err := authenticator.Authenticate(r)
+		if err != nil {
+			return "", defs.ErrUnauthorized().WithDetail(err.Error())
+		}
+
+		if len(identity) > 0 {
+			return identity, nil
+		}
+	}
+
+	return "", defs.ErrUnauthorized().WithDetail("missing credentials")
+}
+
 type loggingResponseWriter struct {
 	http.ResponseWriter
 	hijacked   bool
@@ -61,6 +128,12 @@ func (lrw *loggingResponseWriter) WriteHeader(code int) {
 	lrw.ResponseWriter.WriteHeader(code)
 }
 
+func (lrw *loggingResponseWriter) Flush() {
+	if f, ok := lrw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func (lrw *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	h, ok := lrw.ResponseWriter.(http.Hijacker)
 	if !ok {
@@ -83,12 +156,13 @@ func (m *Middleware) loggingMiddleware(next http.Handler) http.Handler {
 
 		next.ServeHTTP(lw, r)
 
-		var traceID string
+		var traceID, identity string
 
 		ctxI := context.Get(r, "ctx")
 		if ctxI != nil {
 			if ctx, ok := ctxI.(defs.RequestContext); ok {
 				traceID = ctx.TraceID
+				identity = ctx.Identity
 			}
 		}
 
@@ -109,7 +183,11 @@ func (m *Middleware) loggingMiddleware(next http.Handler) http.Handler {
 
 		// TODO: Make requests method logging configurable
 		if m.logAllRequests || r.Method != http.MethodGet || errI != nil {
-			m.log.Infof("REQ %s %v %v %v - [%v]", traceID, lw.statusCode, r.Method, r.RequestURI, time.Since(startTime))
+			if len(identity) > 0 {
+				m.log.Infof("REQ %s %v %v %v by %s - [%v]", traceID, lw.statusCode, r.Method, r.RequestURI, identity, time.Since(startTime))
+			} else {
+				m.log.Infof("REQ %s %v %v %v - [%v]", traceID, lw.statusCode, r.Method, r.RequestURI, time.Since(startTime))
+			}
 		}
 	})
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/gorilla/context"
 	"github.com/pkg/errors"
 
+	"github.com/qredo/signing-agent/clientfeed"
 	"github.com/qredo/signing-agent/defs"
 )
 
@@ -32,10 +33,20 @@ func (a appHandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			}
 
 			context.Set(r, "error", apiErr)
+
+			// the connection is not upgraded when the request is refused by the middleware
+			if apiErr.Code() == http.StatusUnauthorized {
+				WriteHTTPError(w, r, apiErr)
+			}
 		}
 		return
 	}
 
+	// the response was already written by a handler streaming events
+	if err == nil && w.Header().Get("Content-Type") == clientfeed.SSEContentType {
+		return
+	}
+
 	FormatJSONResp(w, r, resp, err)
 }
 
@@ -1,9 +1,13 @@
 package rest
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
 	"strings"
 
 	"github.com/go-redis/redis/v8"
@@ -13,26 +17,49 @@ import (
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/credentials"
 
 	"github.com/qredo/signing-agent/autoapprover"
 	"github.com/qredo/signing-agent/config"
 	"github.com/qredo/signing-agent/defs"
-	"github.com/qredo/signing-agent/hub"
+	"github.com/qredo/signing-agent/journal"
 	"github.com/qredo/signing-agent/lib"
+	"github.com/qredo/signing-agent/queue"
 	rest_handlers "github.com/qredo/signing-agent/rest/handlers"
 	"github.com/qredo/signing-agent/rest/version"
+	"github.com/qredo/signing-agent/rpc"
 	"github.com/qredo/signing-agent/util"
+	"github.com/qredo/signing-agent/webhook"
 )
 
 const (
-	PathHealthcheckVersion = "/healthcheck/version"
-	PathHealthCheckConfig  = "/healthcheck/config"
-	PathHealthCheckStatus  = "/healthcheck/status"
-	PathClientFullRegister = "/register"
-	PathClient             = "/client"
-	PathAction             = "/client/action/{action_id}"
-	PathClientFeed         = "/client/feed"
+	PathHealthcheckVersion  = "/healthcheck/version"
+	PathHealthCheckConfig   = "/healthcheck/config"
+	PathHealthCheckStatus   = "/healthcheck/status"
+	PathClientFullRegister  = "/register"
+	PathClient              = "/client"
+	PathAction              = "/client/action/{action_id}"
+	PathActions             = "/client/actions"
+	PathPendingActions      = "/client/actions/pending"
+	PathBatchApprove        = "/client/actions/approve"
+	PathBatchReject         = "/client/actions/reject"
+	PathClientFeed          = "/client/feed"
+	PathClientFeedSSE       = "/client/feed/sse"
+	PathShadowMode          = "/client/autoapproval/shadow"
+	PathMetrics             = "/metrics"
+	PathAgents              = "/agents"
+	PathAgent               = "/client/{agent_id}"
+	PathAgentAction         = "/client/{agent_id}/action/{action_id}"
+	PathAgentPendingActions = "/client/{agent_id}/actions/pending"
+	PathAgentBatchApprove   = "/client/{agent_id}/actions/approve"
+	PathAgentBatchReject    = "/client/{agent_id}/actions/reject"
+	PathAgentFeed           = "/client/{agent_id}/feed"
+	PathAgentFeedSSE        = "/client/{agent_id}/feed/sse"
+	PathAgentShadowMode     = "/client/{agent_id}/autoapproval/shadow"
+	PathDeadLetters         = "/webhooks/deadletters"
+	PathDeadLetterReplay    = "/webhooks/deadletters/{delivery_id}/replay"
 )
 
 type Router struct {
@@ -44,6 +71,9 @@ type Router struct {
 	version             *version.Version
 	signingAgentHandler *rest_handlers.SigningAgentHandler
 	healthCheckHandler  *rest_handlers.HealthCheckHandler
+	agents              *agentRegistry
+	webhookHandler      *rest_handlers.WebhookHandler
+	grpcServer          *rpc.Server
 }
 
 func NewQRouter(log *zap.SugaredLogger, config *config.Config, version *version.Version) (*Router, error) {
@@ -65,27 +95,60 @@ func NewQRouter(log *zap.SugaredLogger, config *config.Config, version *version.
 		return nil, errors.Wrap(err, "failed to initialise core")
 	}
 
-	serverConn := hub.NewWebsocketSource(hub.NewDefaultDialer(), genWSQredoCoreClientFeedURL(&config.Websocket), log, core, &config.Websocket)
-	feedHub := hub.NewFeedHub(serverConn, log)
+	actionJournal, err := journal.NewJournal(&config.Journal, log)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise journal")
+	}
+
+	actionQueue, err := queue.NewQueue(&config.ActionQueue)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialise action queue")
+	}
+
+	var deadLetters webhook.DeadLetterStore
+	if config.Webhooks.Enabled {
+		if deadLetters, err = webhook.NewDeadLetterStore(config.Webhooks.DeadLetterFile); err != nil {
+			return nil, errors.Wrap(err, "failed to initialise webhook dead letters")
+		}
+	}
 
 	localFeed := fmt.Sprintf("ws://%s%s/client/feed", config.HTTP.Addr, defs.PathPrefix)
 
-	syncronizer := newActionSyncronizer(&config.LoadBalancing)
-	autoApprover := autoapprover.NewAutoApprover(core, log, config, syncronizer)
-	upgrader := hub.NewDefaultUpgrader(config.Websocket.ReadBufferSize, config.Websocket.WriteBufferSize)
+	factory := &agentServiceFactory{
+		log:            log,
+		journal:        actionJournal,
+		deadLetters:    deadLetters,
+		actionQueue:    actionQueue,
+		newSyncronizer: newActionSyncronizer(&config.LoadBalancing),
+		store:          store,
+	}
+
+	systemAgent := factory.newAgentService("", core, config, localFeed)
+	agents := newAgentRegistry(log, config, core, factory, systemAgent)
+	systemAgent.signingAgentHandler.SetAgentRegistry(agents)
 
-	signingAgentHandler := rest_handlers.NewSigningAgentHandler(feedHub, core, log, config, autoApprover, upgrader, localFeed)
-	healthCheckHandler := rest_handlers.NewHealthCheckHandler(serverConn, version, config, feedHub, localFeed)
-	actionHandler := rest_handlers.NewActionHandler(autoapprover.NewActionManager(core, syncronizer, log, config.LoadBalancing.Enable))
+	healthCheckHandler := rest_handlers.NewHealthCheckHandler(systemAgent.source, version, config, systemAgent.feedHub, localFeed)
 
 	rt := &Router{
 		log:                 log,
 		config:              config,
-		middleware:          NewMiddleware(log, config.HTTP.LogAllRequests),
+		middleware:          NewMiddleware(log, config.HTTP.LogAllRequests, &config.HTTP.Auth),
 		version:             version,
-		signingAgentHandler: signingAgentHandler,
+		signingAgentHandler: systemAgent.signingAgentHandler,
 		healthCheckHandler:  healthCheckHandler,
-		actionHandler:       actionHandler,
+		actionHandler:       systemAgent.actionHandler,
+		agents:              agents,
+	}
+
+	// the dead letters are replayed by a dispatcher of their own, that isn't listening to any feed
+	if config.Webhooks.Enabled {
+		rt.webhookHandler = rest_handlers.NewWebhookHandler(deadLetters, webhook.NewDispatcher(&config.Webhooks, deadLetters, log))
+	}
+
+	if config.GRPC.Enabled {
+		if rt.grpcServer, err = newGRPCServer(log, config, systemAgent, healthCheckHandler); err != nil {
+			return nil, errors.Wrap(err, "failed to initialise gRPC server")
+		}
 	}
 
 	rt.router = rt.SetHandlers()
@@ -93,6 +156,33 @@ func NewQRouter(log *zap.SugaredLogger, config *config.Config, version *version.
 	return rt, nil
 }
 
+// newGRPCServer returns the gRPC server of the system agent, with the TLS and auth settings of the build in API
+func newGRPCServer(log *zap.SugaredLogger, config *config.Config, systemAgent *agentService, healthCheckHandler *rest_handlers.HealthCheckHandler) (*rpc.Server, error) {
+	var creds credentials.TransportCredentials
+	if config.HTTP.TLS.Enabled {
+		tlsConfig, err := newTLSConfig(&config.HTTP.TLS)
+		if err != nil {
+			return nil, err
+		}
+
+		cert, err := tls.LoadX509KeyPair(config.HTTP.TLS.CertFile, config.HTTP.TLS.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load TLS key pair")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	var authenticate rpc.Authenticate
+	if config.HTTP.Auth.Enabled {
+		authenticate = NewGRPCAuthenticate(&config.HTTP.Auth)
+	}
+
+	server := rpc.NewServer(log, systemAgent.signingAgentHandler, healthCheckHandler, systemAgent.actionManager, systemAgent.feedHub, creds, authenticate)
+	server.SetActionStates(systemAgent.tracker)
+	return server, nil
+}
+
 // SetHandlers set all handlers
 func (r *Router) SetHandlers() http.Handler {
 
@@ -102,15 +192,48 @@ func (r *Router) SetHandlers() http.Handler {
 		{PathHealthCheckStatus, http.MethodGet, r.healthCheckHandler.HealthCheckStatus},
 		{PathClientFullRegister, http.MethodPost, r.signingAgentHandler.RegisterAgent},
 		{PathClient, http.MethodGet, r.signingAgentHandler.GetClient},
+		{PathAction, http.MethodGet, r.actionHandler.GetAction},
 		{PathAction, http.MethodPut, r.actionHandler.ActionApprove},
 		{PathAction, http.MethodDelete, r.actionHandler.ActionReject},
+		{PathActions, http.MethodGet, r.actionHandler.GetActions},
+		{PathPendingActions, http.MethodGet, r.actionHandler.GetPendingActions},
+		{PathBatchApprove, http.MethodPost, r.actionHandler.BatchApprove},
+		{PathBatchReject, http.MethodPost, r.actionHandler.BatchReject},
 		{PathClientFeed, defs.MethodWebsocket, r.signingAgentHandler.ClientFeed},
+		{PathClientFeedSSE, http.MethodGet, r.signingAgentHandler.ClientFeedSSE},
+		{PathShadowMode, http.MethodGet, r.signingAgentHandler.GetShadowDecisions},
+		{PathShadowMode, http.MethodPut, r.signingAgentHandler.SetShadowMode},
+		{PathAgents, http.MethodGet, r.signingAgentHandler.GetAgents},
+		{PathAgent, http.MethodGet, r.agents.forAgent(func(s *agentService) appHandlerFunc { return s.signingAgentHandler.GetClient })},
+		{PathAgentAction, http.MethodGet, r.agents.forAgent(func(s *agentService) appHandlerFunc { return s.actionHandler.GetAction })},
+		{PathAgentAction, http.MethodPut, r.agents.forAgent(func(s *agentService) appHandlerFunc { return s.actionHandler.ActionApprove })},
+		{PathAgentAction, http.MethodDelete, r.agents.forAgent(func(s *agentService) appHandlerFunc { return s.actionHandler.ActionReject })},
+		{PathAgentPendingActions, http.MethodGet, r.agents.forAgent(func(s *agentService) appHandlerFunc { return s.actionHandler.GetPendingActions })},
+		{PathAgentBatchApprove, http.MethodPost, r.agents.forAgent(func(s *agentService) appHandlerFunc { return s.actionHandler.BatchApprove })},
+		{PathAgentBatchReject, http.MethodPost, r.agents.forAgent(func(s *agentService) appHandlerFunc { return s.actionHandler.BatchReject })},
+		{PathAgentShadowMode, http.MethodGet, r.agents.forAgent(func(s *agentService) appHandlerFunc { return s.signingAgentHandler.GetShadowDecisions })},
+		{PathAgentShadowMode, http.MethodPut, r.agents.forAgent(func(s *agentService) appHandlerFunc { return s.signingAgentHandler.SetShadowMode })},
+		{PathAgentFeed, defs.MethodWebsocket, r.agents.forAgent(func(s *agentService) appHandlerFunc { return s.signingAgentHandler.ClientFeed })},
+		{PathAgentFeedSSE, http.MethodGet, r.agents.forAgent(func(s *agentService) appHandlerFunc { return s.signingAgentHandler.ClientFeedSSE })},
+	}
+
+	if r.webhookHandler != nil {
+		routes = append(routes,
+			route{PathDeadLetters, http.MethodGet, r.webhookHandler.GetDeadLetters},
+			route{PathDeadLetterReplay, http.MethodPost, r.webhookHandler.ReplayDeadLetter},
+		)
 	}
 
-	router := mux.NewRouter().PathPrefix(defs.PathPrefix).Subrouter()
+	root := mux.NewRouter()
+	root.Handle(PathMetrics, r.middleware.protectedHandler(PathMetrics, promhttp.Handler())).Methods(http.MethodGet)
+
+	router := root.PathPrefix(defs.PathPrefix).Subrouter()
 	for _, route := range routes {
 
-		middle := r.middleware.notProtectedMiddleware
+		middle := r.middleware.protectedMiddleware
+		if r.middleware.isOpen(WrapPathPrefix(route.path)) {
+			middle = r.middleware.notProtectedMiddleware
+		}
 
 		if route.method == defs.MethodWebsocket {
 			router.Handle(route.path, r.middleware.sessionMiddleware(middle(route.handler)))
@@ -121,9 +244,9 @@ func (r *Router) SetHandlers() http.Handler {
 
 	router.Use(r.middleware.loggingMiddleware)
 
-	r.printRoutes(router)
+	r.printRoutes(root)
 
-	return r.setupCORS(router)
+	return r.setupCORS(root)
 }
 
 // Start starts the service
@@ -138,12 +261,31 @@ func (r *Router) Start() error {
 func (r *Router) StartHTTPListener(errChan chan error) {
 	r.log.Infof("CORS policy: %s", strings.Join(r.config.HTTP.CORSAllowOrigins, ","))
 	r.log.Infof("Starting listener on %v", r.config.HTTP.Addr)
+	if r.config.HTTP.Auth.Enabled {
+		r.log.Info("API authentication enabled")
+	}
 
 	r.signingAgentHandler.StartAgent()
+	r.agents.Start()
+
+	if r.grpcServer != nil {
+		go r.startGRPCListener(errChan)
+	}
 
 	if r.config.HTTP.TLS.Enabled {
 		r.log.Info("Start listening on HTTPS")
-		errChan <- http.ListenAndServeTLS(r.config.HTTP.Addr, r.config.HTTP.TLS.CertFile, r.config.HTTP.TLS.KeyFile, context.ClearHandler(r.router))
+		tlsConfig, err := newTLSConfig(&r.config.HTTP.TLS)
+		if err != nil {
+			errChan <- err
+			return
+		}
+
+		server := &http.Server{
+			Addr:      r.config.HTTP.Addr,
+			Handler:   context.ClearHandler(r.router),
+			TLSConfig: tlsConfig,
+		}
+		errChan <- server.ListenAndServeTLS(r.config.HTTP.TLS.CertFile, r.config.HTTP.TLS.KeyFile)
 	} else {
 		r.log.Info("Start listening on HTTP")
 		errChan <- http.ListenAndServe(r.config.HTTP.Addr, context.ClearHandler(r.router))
@@ -189,14 +331,53 @@ func FormatJSONResp(w http.ResponseWriter, r *http.Request, v interface{}, err e
 	}
 }
 
+// startGRPCListener serves the gRPC API until the server is stopped
+func (r *Router) startGRPCListener(errChan chan error) {
+	r.log.Infof("Starting gRPC listener on %v", r.config.GRPC.Addr)
+	listener, err := net.Listen("tcp", r.config.GRPC.Addr)
+	if err != nil {
+		errChan <- errors.Wrap(err, "gRPC listener")
+		return
+	}
+
+	errChan <- r.grpcServer.Serve(listener)
+}
+
 // Stop closes the signing agent
 func (r *Router) Stop() {
+	if r.grpcServer != nil {
+		r.grpcServer.Stop()
+	}
 	r.signingAgentHandler.StopAgent()
+	r.agents.Stop()
+}
+
+// newTLSConfig returns the TLS config of the server. When a client CA file is set, the client certificates are verified if given
+func newTLSConfig(cfg *config.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if len(cfg.ClientCAFile) == 0 {
+		return tlsConfig, nil
+	}
+
+	caCert, err := os.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "read client CA file")
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, errors.New("no certificates found in the client CA file")
+	}
+
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+
+	return tlsConfig, nil
 }
 
 func (r *Router) setupCORS(h http.Handler) http.Handler {
 	cors := handlers.CORS(
-		handlers.AllowedHeaders([]string{"Content-Type", "X-Requested-With"}),
+		handlers.AllowedHeaders([]string{"Content-Type", "X-Requested-With", HeaderAuthorization, HeaderAPIKey, HeaderKeyID, HeaderTimestamp, HeaderSignature}),
 		handlers.AllowedOrigins(r.config.HTTP.CORSAllowOrigins),
 		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "HEAD"}),
 		handlers.AllowCredentials(),
@@ -224,7 +405,9 @@ func genWSQredoCoreClientFeedURL(config *config.WebSocketConfig) string {
 	return config.QredoWebsocket
 }
 
-func newActionSyncronizer(config *config.LoadBalancing) autoapprover.ActionSyncronizer {
+// newActionSyncronizer returns a function creating the ActionSyncronizer of an agent. The agents share the redis connection,
+// but each one holds its own locks
+func newActionSyncronizer(config *config.LoadBalancing) func() autoapprover.ActionSyncronizer {
 	rds := redis.NewClient(&redis.Options{
 		Addr:     fmt.Sprintf("%s:%d", config.RedisConfig.Host, config.RedisConfig.Port),
 		Password: config.RedisConfig.Password,
@@ -233,5 +416,7 @@ func newActionSyncronizer(config *config.LoadBalancing) autoapprover.ActionSyncr
 	pool := goredis.NewPool(rds)
 	rs := redsync.New(pool)
 
-	return autoapprover.NewSyncronizer(config, rds, rs)
+	return func() autoapprover.ActionSyncronizer {
+		return autoapprover.NewSyncronizer(config, rds, rs)
+	}
 }
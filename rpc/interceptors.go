@@ -0,0 +1,62 @@
+package rpc
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Authenticate identifies the caller of a call from its metadata or its peer.
+// It returns an error when the caller can't be identified
+type Authenticate func(ctx context.Context) (string, error)
+
+// openMethods are called without authentication, like the healthcheck status endpoint of the REST API
+var openMethods = map[string]bool{
+	SigningAgent_GetStatus_FullMethodName: true,
+}
+
+func (a Authenticate) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := a.check(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (a Authenticate) stream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := a.check(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+
+	return handler(srv, stream)
+}
+
+// check lets every call through when no authentication is set
+func (a Authenticate) check(ctx context.Context, method string) error {
+	if a == nil || openMethods[method] {
+		return nil
+	}
+
+	if _, err := a(ctx); err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	return nil
+}
+
+func (s *Server) logUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	startTime := time.Now()
+	res, err := handler(ctx, req)
+	s.log.Debugf("rpc: %s %s %v", info.FullMethod, status.Code(err), time.Since(startTime))
+	return res, err
+}
+
+func (s *Server) logStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	startTime := time.Now()
+	err := handler(srv, stream)
+	s.log.Debugf("rpc: %s %s %v", info.FullMethod, status.Code(err), time.Since(startTime))
+	return err
+}
@@ -0,0 +1,311 @@
+// Package rpc serves the gRPC API of the signing agent, next to the REST API.
+// It mirrors the REST endpoints of the system agent: registration, client info, approve/reject, status and the action feed.
+// The messages and the service are generated from signing_agent.proto.
+
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/autoapprover"
+	"github.com/qredo/signing-agent/defs"
+	"github.com/qredo/signing-agent/hub"
+)
+
+// AgentService registers the system agent and returns its details
+type AgentService interface {
+	Register(registerRequest *api.ClientRegisterRequest) (*api.AgentRegisterResponse, error)
+	Client() api.GetClientResponse
+}
+
+// StatusService returns the status of the feed
+type StatusService interface {
+	Status() api.HealthCheckStatusResponse
+}
+
+// ActionStates returns the current state of the actions
+type ActionStates interface {
+	State(actionID string) (string, bool)
+}
+
+// Server is the gRPC server of the signing agent
+type Server struct {
+	UnimplementedSigningAgentServer
+	log           *zap.SugaredLogger
+	agent         AgentService
+	status        StatusService
+	actionManager autoapprover.ActionManager
+	states        ActionStates
+	feedHub       hub.FeedHub
+	server        *grpc.Server
+}
+
+// NewServer returns a gRPC server calling the given services. The calls are authenticated with authenticate when it's set,
+// and the connections are encrypted when creds are set
+func NewServer(log *zap.SugaredLogger, agent AgentService, statusService StatusService, actionManager autoapprover.ActionManager, feedHub hub.FeedHub, creds credentials.TransportCredentials, authenticate Authenticate) *Server {
+	s := &Server{
+		log:           log,
+		agent:         agent,
+		status:        statusService,
+		actionManager: actionManager,
+		feedHub:       feedHub,
+	}
+
+	options := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(s.logUnary, authenticate.unary),
+		grpc.ChainStreamInterceptor(s.logStream, authenticate.stream),
+	}
+	if creds != nil {
+		options = append(options, grpc.Creds(creds))
+	}
+
+	s.server = grpc.NewServer(options...)
+	RegisterSigningAgentServer(s.server, s)
+
+	return s
+}
+
+// SetActionStates sets the states the responses of Approve and Reject are built from, as the REST responses are.
+// Without them, the responses tell the requested state
+func (s *Server) SetActionStates(states ActionStates) {
+	s.states = states
+}
+
+// Serve accepts the connections on the listener until the server is stopped
+func (s *Server) Serve(listener net.Listener) error {
+	return s.server.Serve(listener)
+}
+
+// Stop closes the listeners and the connections, the feed streams are ended
+func (s *Server) Stop() {
+	s.server.Stop()
+}
+
+// Register registers the system agent, like POST /register
+func (s *Server) Register(_ context.Context, req *RegisterRequest) (*RegisterResponse, error) {
+	registerRequest := &api.ClientRegisterRequest{
+		Name:             req.GetName(),
+		APIKey:           req.GetApiKey(),
+		Base64PrivateKey: req.GetBase64PrivateKey(),
+	}
+	if err := registerRequest.Validate(); err != nil {
+		s.log.Debugf("rpc: failed to validate register request, %v", err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	response, err := s.agent.Register(registerRequest)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return &RegisterResponse{
+		AgentId: response.AgentID,
+		FeedUrl: response.FeedURL,
+	}, nil
+}
+
+// GetClient returns the registered agent, like GET /client
+func (s *Server) GetClient(context.Context, *GetClientRequest) (*GetClientResponse, error) {
+	response := s.agent.Client()
+	return &GetClientResponse{
+		AgentId: response.AgentID,
+		FeedUrl: response.FeedURL,
+	}, nil
+}
+
+// Approve approves an action, like PUT /client/action/{action_id}
+func (s *Server) Approve(_ context.Context, req *ActionRequest) (*ActionResponse, error) {
+	actionID := strings.TrimSpace(req.GetActionId())
+	if actionID == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty actionID")
+	}
+
+	if err := s.actionManager.Approve(actionID); err != nil {
+		return nil, statusError(err)
+	}
+
+	return newActionResponse(s.actionResponse(actionID, api.ActionStateApproved)), nil
+}
+
+// Reject rejects an action with the reason of the request, like DELETE /client/action/{action_id}
+func (s *Server) Reject(_ context.Context, req *ActionRequest) (*ActionResponse, error) {
+	actionID := strings.TrimSpace(req.GetActionId())
+	if actionID == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty actionID")
+	}
+
+	reason := api.RejectReason{
+		Code:    req.GetReason().GetCode(),
+		Message: req.GetReason().GetMessage(),
+	}
+	if err := validator.New().Struct(&reason); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if len(reason.Code) == 0 {
+		reason.Code = api.RejectCodeManual
+	}
+
+	if err := s.actionManager.Reject(actionID, reason); err != nil {
+		return nil, statusError(err)
+	}
+
+	return newActionResponse(s.actionResponse(actionID, api.ActionStateRejected)), nil
+}
+
+// actionResponse returns the current state of the action, or the given state when it isn't tracked
+func (s *Server) actionResponse(actionID, state string) api.ActionResponse {
+	if s.states != nil {
+		if current, ok := s.states.State(actionID); ok {
+			state = current
+		}
+	}
+	return api.NewActionResponse(actionID, state)
+}
+
+// GetStatus returns the status of the feed, like GET /healthcheck/status
+func (s *Server) GetStatus(context.Context, *GetStatusRequest) (*GetStatusResponse, error) {
+	response := s.status.Status().WebsocketStatus
+	return &GetStatusResponse{
+		ReadyState:       response.ReadyState,
+		RemoteFeedUrl:    response.RemoteFeedUrl,
+		LocalFeedUrl:     response.LocalFeedUrl,
+		ConnectedClients: response.ConnectedClients,
+	}, nil
+}
+
+// Feed registers a client to the feed hub and streams the actions it receives, like the /client/feed websocket.
+// The stream ends when the feed hub is stopped
+func (s *Server) Feed(req *FeedRequest, stream SigningAgent_FeedServer) error {
+	if !s.feedHub.IsRunning() {
+		s.log.Debugf("rpc: failed to stream the feed, hub not running")
+		return status.Error(codes.Unavailable, "feed not available")
+	}
+
+	client := hub.NewFeedClient(false)
+	client.Filter = hub.NewFeedFilter(url.Values{
+		hub.FilterParamTypes:    req.GetTypes(),
+		hub.FilterParamStatuses: req.GetStatuses(),
+		hub.FilterParamAgentIDs: req.GetAgentIds(),
+	})
+	client.ResumeAfter = req.LastEventId
+	client.Format = formatEvent
+
+	s.feedHub.RegisterClient(&client)
+	s.log.Info("rpc: streaming the feed ...")
+
+	for {
+		select {
+		case data, ok := <-client.Feed:
+			if !ok {
+				s.log.Debug("rpc: client feed channel was closed")
+				return nil
+			}
+
+			event := &FeedEvent{}
+			if err := proto.Unmarshal(data, event); err != nil {
+				s.log.Errorf("rpc: error while decoding the feed event, err: %v", err)
+				continue
+			}
+
+			if err := stream.Send(event); err != nil {
+				s.log.Debugf("rpc: error while sending the feed event, err: %v", err)
+				s.unregister(&client)
+				return err
+			}
+		case <-stream.Context().Done():
+			s.log.Debug("rpc: feed stream done")
+			s.unregister(&client)
+			return nil
+		}
+	}
+}
+
+// unregister removes the client from the feed hub and drops the events left until its Feed channel is closed
+func (s *Server) unregister(client *hub.FeedClient) {
+	s.feedHub.UnregisterClient(client)
+	for range client.Feed {
+	}
+}
+
+// feedAction holds the fields of an action message copied to the feed events
+type feedAction struct {
+	ID           string `json:"id"`
+	CoreClientID string `json:"coreClientID"`
+	Type         string `json:"type"`
+	Status       string `json:"status"`
+	Timestamp    int64  `json:"timestamp"`
+	ExpireTime   int64  `json:"expireTime"`
+}
+
+// formatEvent encodes the message as a FeedEvent, so that its event ID is kept on the Feed channel
+func formatEvent(eventID uint64, message []byte) []byte {
+	event := &FeedEvent{
+		EventId: eventID,
+		Json:    string(message),
+	}
+
+	action := &feedAction{}
+	if err := json.Unmarshal(message, action); err == nil {
+		event.Id = action.ID
+		event.CoreClientId = action.CoreClientID
+		event.Type = action.Type
+		event.Status = action.Status
+		event.Timestamp = action.Timestamp
+		event.ExpireTime = action.ExpireTime
+	}
+
+	data, _ := proto.Marshal(event)
+	return data
+}
+
+func newActionResponse(response api.ActionResponse) *ActionResponse {
+	return &ActionResponse{
+		ActionId: response.ActionID,
+		Status:   response.Status,
+	}
+}
+
+// statusError returns the gRPC status matching the API error, or an internal error
+func statusError(err error) error {
+	var apiErr *defs.APIError
+	if !errors.As(err, &apiErr) {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	code, detail := apiErr.APIError()
+	if detail == "" {
+		detail = apiErr.Error()
+	}
+
+	switch code {
+	case http.StatusBadRequest:
+		return status.Error(codes.InvalidArgument, detail)
+	case http.StatusUnauthorized:
+		return status.Error(codes.Unauthenticated, detail)
+	case http.StatusForbidden:
+		return status.Error(codes.PermissionDenied, detail)
+	case http.StatusNotFound:
+		return status.Error(codes.NotFound, detail)
+	case http.StatusConflict:
+		return status.Error(codes.FailedPrecondition, detail)
+	case http.StatusServiceUnavailable:
+		return status.Error(codes.Unavailable, detail)
+	default:
+		return status.Error(codes.Internal, detail)
+	}
+}
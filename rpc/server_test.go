@@ -0,0 +1,340 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/defs"
+	"github.com/qredo/signing-agent/hub"
+	"github.com/qredo/signing-agent/util"
+)
+
+type mockAgentService struct {
+	LastRegisterRequest *api.ClientRegisterRequest
+	NextError           error
+}
+
+func (m *mockAgentService) Register(registerRequest *api.ClientRegisterRequest) (*api.AgentRegisterResponse, error) {
+	m.LastRegisterRequest = registerRequest
+	if m.NextError != nil {
+		return nil, m.NextError
+	}
+	return &api.AgentRegisterResponse{AgentID: "some agent id", FeedURL: "some feed url"}, nil
+}
+
+func (m *mockAgentService) Client() api.GetClientResponse {
+	return api.GetClientResponse{AgentID: "some agent id", FeedURL: "some feed url"}
+}
+
+type mockStatusService struct{}
+
+func (m *mockStatusService) Status() api.HealthCheckStatusResponse {
+	return api.HealthCheckStatusResponse{
+		WebsocketStatus: api.NewWebsocketStatus("OPEN", "some remote url", "some local url", 2),
+	}
+}
+
+type mockActionManager struct {
+	Approved  []string
+	Rejected  []string
+	Reasons   []api.RejectReason
+	NextError error
+}
+
+func (m *mockActionManager) Approve(actionID string) error {
+	m.Approved = append(m.Approved, actionID)
+	return m.NextError
+}
+
+func (m *mockActionManager) Reject(actionID string, reason api.RejectReason) error {
+	m.Rejected = append(m.Rejected, actionID)
+	m.Reasons = append(m.Reasons, reason)
+	return m.NextError
+}
+
+// mockFeedHub sends the messages to the client registered. The Feed channel is closed on unregister
+type mockFeedHub struct {
+	NextRunning          bool
+	Messages             []string
+	LastRegisteredClient chan *hub.FeedClient
+	Unregistered         chan *hub.FeedClient
+}
+
+func (m *mockFeedHub) Run() bool       { return m.NextRunning }
+func (m *mockFeedHub) Stop()           {}
+func (m *mockFeedHub) Replay()         {}
+func (m *mockFeedHub) IsRunning() bool { return m.NextRunning }
+
+func (m *mockFeedHub) GetExternalFeedClients() int {
+	return 0
+}
+
+func (m *mockFeedHub) RegisterClient(client *hub.FeedClient) {
+	m.LastRegisteredClient <- client
+	go func() {
+		for i, message := range m.Messages {
+			client.Feed <- client.Format(uint64(i+1), []byte(message))
+		}
+	}()
+}
+
+func (m *mockFeedHub) UnregisterClient(client *hub.FeedClient) {
+	close(client.Feed)
+	m.Unregistered <- client
+}
+
+// startTestServer serves the server on an in-memory listener and returns a client connected to it, and the func stopping both
+func startTestServer(t *testing.T, server *Server) (SigningAgentClient, func()) {
+	listener := bufconn.Listen(1024 * 1024)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.Nil(t, err)
+
+	return NewSigningAgentClient(conn), func() {
+		conn.Close()
+		server.Stop()
+	}
+}
+
+func newTestServer(feedHub hub.FeedHub, actionManager *mockActionManager, authenticate Authenticate) *Server {
+	return NewServer(util.NewTestLogger(), &mockAgentService{}, &mockStatusService{}, actionManager, feedHub, nil, authenticate)
+}
+
+func TestServer_unary_calls(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	actionManager := &mockActionManager{}
+	client, stop := startTestServer(t, newTestServer(&mockFeedHub{}, actionManager, nil))
+	defer stop()
+	ctx := context.Background()
+
+	//Act
+	clientRes, clientErr := client.GetClient(ctx, &GetClientRequest{})
+	statusRes, statusErr := client.GetStatus(ctx, &GetStatusRequest{})
+	approveRes, approveErr := client.Approve(ctx, &ActionRequest{ActionId: " some action id "})
+	rejectRes, rejectErr := client.Reject(ctx, &ActionRequest{ActionId: "other action id"})
+
+	//Assert
+	require.Nil(t, clientErr)
+	assert.Equal(t, "some agent id", clientRes.AgentId)
+	assert.Equal(t, "some feed url", clientRes.FeedUrl)
+	require.Nil(t, statusErr)
+	assert.Equal(t, "OPEN", statusRes.ReadyState)
+	assert.Equal(t, uint32(2), statusRes.ConnectedClients)
+	require.Nil(t, approveErr)
+	assert.Equal(t, "approved", approveRes.Status)
+	require.Nil(t, rejectErr)
+	assert.Equal(t, "rejected", rejectRes.Status)
+	assert.Equal(t, []string{"some action id"}, actionManager.Approved)
+	assert.Equal(t, []string{"other action id"}, actionManager.Rejected)
+}
+
+type mockActionStates map[string]string
+
+func (m mockActionStates) State(actionID string) (string, bool) {
+	state, ok := m[actionID]
+	return state, ok
+}
+
+func TestServer_action_responses_tell_tracked_state(t *testing.T) {
+	//Arrange
+	sut := newTestServer(&mockFeedHub{}, &mockActionManager{}, nil)
+	sut.SetActionStates(mockActionStates{
+		"approving action id": api.ActionStateApproving,
+		"expired action id":   api.ActionStateExpired,
+	})
+
+	//Act
+	approving, approvingErr := sut.Approve(context.Background(), &ActionRequest{ActionId: "approving action id"})
+	untracked, untrackedErr := sut.Approve(context.Background(), &ActionRequest{ActionId: "untracked action id"})
+	expired, expiredErr := sut.Reject(context.Background(), &ActionRequest{ActionId: "expired action id"})
+
+	//Assert
+	require.Nil(t, approvingErr)
+	assert.Equal(t, api.ActionStateApproving, approving.Status)
+	require.Nil(t, untrackedErr)
+	assert.Equal(t, api.ActionStateApproved, untracked.Status)
+	require.Nil(t, expiredErr)
+	assert.Equal(t, api.ActionStateExpired, expired.Status)
+}
+
+func TestServer_Reject_passes_reason(t *testing.T) {
+	//Arrange
+	actionManager := &mockActionManager{}
+	sut := newTestServer(&mockFeedHub{}, actionManager, nil)
+
+	//Act
+	_, withoutErr := sut.Reject(context.Background(), &ActionRequest{ActionId: "some action id"})
+	_, messageErr := sut.Reject(context.Background(), &ActionRequest{ActionId: "some action id", Reason: &RejectReason{Message: "over the limit"}})
+	_, codeErr := sut.Reject(context.Background(), &ActionRequest{ActionId: "some action id", Reason: &RejectReason{Code: "limit_exceeded", Message: "over the limit"}})
+	_, invalidErr := sut.Reject(context.Background(), &ActionRequest{ActionId: "some action id", Reason: &RejectReason{Code: strings.Repeat("c", 65)}})
+
+	//Assert
+	assert.Nil(t, withoutErr)
+	assert.Nil(t, messageErr)
+	assert.Nil(t, codeErr)
+	assert.Equal(t, codes.InvalidArgument, status.Code(invalidErr))
+	assert.Equal(t, []api.RejectReason{
+		{Code: api.RejectCodeManual},
+		{Code: api.RejectCodeManual, Message: "over the limit"},
+		{Code: "limit_exceeded", Message: "over the limit"},
+	}, actionManager.Reasons)
+}
+
+func TestServer_Register(t *testing.T) {
+	//Arrange
+	agent := &mockAgentService{}
+	sut := &Server{log: util.NewTestLogger(), agent: agent}
+
+	//Act
+	res, err := sut.Register(context.Background(), &RegisterRequest{Name: "some name ", ApiKey: "some api key", Base64PrivateKey: "some key"})
+
+	//Assert
+	require.Nil(t, err)
+	assert.Equal(t, &RegisterResponse{AgentId: "some agent id", FeedUrl: "some feed url"}, res)
+	assert.Equal(t, &api.ClientRegisterRequest{Name: "some name", APIKey: "some api key", Base64PrivateKey: "some key"}, agent.LastRegisterRequest)
+}
+
+func TestServer_errors(t *testing.T) {
+	var testCases = []struct {
+		name string
+		call func(sut *Server) error
+		code codes.Code
+	}{
+		{"register invalid request", func(sut *Server) error {
+			_, err := sut.Register(context.Background(), &RegisterRequest{Name: "some name"})
+			return err
+		}, codes.InvalidArgument},
+		{"register already registered", func(sut *Server) error {
+			sut.agent = &mockAgentService{NextError: defs.ErrBadRequest().WithDetail("AgentID already exist. You can not set new one.")}
+			_, err := sut.Register(context.Background(), &RegisterRequest{Name: "some name", ApiKey: "some api key", Base64PrivateKey: "some key"})
+			return err
+		}, codes.InvalidArgument},
+		{"approve empty action id", func(sut *Server) error {
+			_, err := sut.Approve(context.Background(), &ActionRequest{ActionId: " "})
+			return err
+		}, codes.InvalidArgument},
+		{"approve not found", func(sut *Server) error {
+			sut.actionManager = &mockActionManager{NextError: defs.ErrNotFound()}
+			_, err := sut.Approve(context.Background(), &ActionRequest{ActionId: "some action id"})
+			return err
+		}, codes.NotFound},
+		{"reject failed", func(sut *Server) error {
+			sut.actionManager = &mockActionManager{NextError: errors.New("some error")}
+			_, err := sut.Reject(context.Background(), &ActionRequest{ActionId: "some action id"})
+			return err
+		}, codes.Internal},
+		{"feed not running", func(sut *Server) error {
+			return sut.Feed(&FeedRequest{}, nil)
+		}, codes.Unavailable},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			sut := &Server{
+				log:     util.NewTestLogger(),
+				agent:   &mockAgentService{},
+				feedHub: &mockFeedHub{},
+			}
+
+			//Act
+			err := tc.call(sut)
+
+			//Assert
+			assert.Equal(t, tc.code, status.Code(err))
+		})
+	}
+}
+
+func TestServer_Feed_streams_events(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	feedHub := &mockFeedHub{
+		NextRunning: true,
+		Messages: []string{
+			`{"id":"some action id","coreClientID":"some agent id","type":"ApproveWithdraw","status":"pending","timestamp":1670341423,"expireTime":1676184187}`,
+			`not an action`,
+		},
+		LastRegisteredClient: make(chan *hub.FeedClient, 1),
+		Unregistered:         make(chan *hub.FeedClient, 1),
+	}
+	client, stop := startTestServer(t, newTestServer(feedHub, &mockActionManager{}, nil))
+	defer stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	lastEventID := uint64(41)
+
+	//Act
+	stream, err := client.Feed(ctx, &FeedRequest{Types: []string{"ApproveWithdraw"}, LastEventId: &lastEventID})
+	require.Nil(t, err)
+	first, firstErr := stream.Recv()
+	second, secondErr := stream.Recv()
+	cancel()
+
+	//Assert
+	require.Nil(t, firstErr)
+	assert.Equal(t, uint64(1), first.EventId)
+	assert.Equal(t, "some action id", first.Id)
+	assert.Equal(t, "some agent id", first.CoreClientId)
+	assert.Equal(t, "ApproveWithdraw", first.Type)
+	assert.Equal(t, "pending", first.Status)
+	assert.Equal(t, int64(1670341423), first.Timestamp)
+	assert.Equal(t, int64(1676184187), first.ExpireTime)
+	require.Nil(t, secondErr)
+	assert.Equal(t, uint64(2), second.EventId)
+	assert.Empty(t, second.Id)
+	assert.Equal(t, "not an action", second.Json)
+
+	registered := <-feedHub.LastRegisteredClient
+	assert.Equal(t, []string{"ApproveWithdraw"}, registered.Filter.Types)
+	assert.Equal(t, uint64(41), *registered.ResumeAfter)
+	assert.Equal(t, registered, <-feedHub.Unregistered)
+}
+
+func TestServer_authenticates_calls(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	authenticate := func(ctx context.Context) (string, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if len(md.Get("x-api-key")) == 0 {
+			return "", errors.New("missing credentials")
+		}
+		return "some identity", nil
+	}
+	client, stop := startTestServer(t, newTestServer(&mockFeedHub{}, &mockActionManager{}, authenticate))
+	defer stop()
+
+	//Act
+	_, refusedErr := client.GetClient(context.Background(), &GetClientRequest{})
+	_, acceptedErr := client.GetClient(metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "some token"), &GetClientRequest{})
+	_, openErr := client.GetStatus(context.Background(), &GetStatusRequest{})
+	stream, _ := client.Feed(context.Background(), &FeedRequest{})
+	_, streamErr := stream.Recv()
+
+	//Assert
+	assert.Equal(t, codes.Unauthenticated, status.Code(refusedErr))
+	assert.Nil(t, acceptedErr)
+	assert.Nil(t, openErr)
+	assert.Equal(t, codes.Unauthenticated, status.Code(streamErr))
+	assert.NotEqual(t, io.EOF, streamErr)
+}
@@ -0,0 +1,334 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.24.4
+// source: rpc/signing_agent.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	SigningAgent_Register_FullMethodName  = "/signingagent.v1.SigningAgent/Register"
+	SigningAgent_GetClient_FullMethodName = "/signingagent.v1.SigningAgent/GetClient"
+	SigningAgent_Approve_FullMethodName   = "/signingagent.v1.SigningAgent/Approve"
+	SigningAgent_Reject_FullMethodName    = "/signingagent.v1.SigningAgent/Reject"
+	SigningAgent_GetStatus_FullMethodName = "/signingagent.v1.SigningAgent/GetStatus"
+	SigningAgent_Feed_FullMethodName      = "/signingagent.v1.SigningAgent/Feed"
+)
+
+// SigningAgentClient is the client API for SigningAgent service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SigningAgentClient interface {
+	// Register registers the system agent, like POST /register
+	Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error)
+	// GetClient returns the registered agent, like GET /client
+	GetClient(ctx context.Context, in *GetClientRequest, opts ...grpc.CallOption) (*GetClientResponse, error)
+	// Approve approves an action, like PUT /client/action/{action_id}
+	Approve(ctx context.Context, in *ActionRequest, opts ...grpc.CallOption) (*ActionResponse, error)
+	// Reject rejects an action, like DELETE /client/action/{action_id}
+	Reject(ctx context.Context, in *ActionRequest, opts ...grpc.CallOption) (*ActionResponse, error)
+	// GetStatus returns the status of the feed, like GET /healthcheck/status
+	GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*GetStatusResponse, error)
+	// Feed streams the actions received from Qredo, like the /client/feed websocket
+	Feed(ctx context.Context, in *FeedRequest, opts ...grpc.CallOption) (SigningAgent_FeedClient, error)
+}
+
+type signingAgentClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSigningAgentClient(cc grpc.ClientConnInterface) SigningAgentClient {
+	return &signingAgentClient{cc}
+}
+
+func (c *signingAgentClient) Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error) {
+	out := new(RegisterResponse)
+	err := c.cc.Invoke(ctx, SigningAgent_Register_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *signingAgentClient) GetClient(ctx context.Context, in *GetClientRequest, opts ...grpc.CallOption) (*GetClientResponse, error) {
+	out := new(GetClientResponse)
+	err := c.cc.Invoke(ctx, SigningAgent_GetClient_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *signingAgentClient) Approve(ctx context.Context, in *ActionRequest, opts ...grpc.CallOption) (*ActionResponse, error) {
+	out := new(ActionResponse)
+	err := c.cc.Invoke(ctx, SigningAgent_Approve_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *signingAgentClient) Reject(ctx context.Context, in *ActionRequest, opts ...grpc.CallOption) (*ActionResponse, error) {
+	out := new(ActionResponse)
+	err := c.cc.Invoke(ctx, SigningAgent_Reject_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *signingAgentClient) GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*GetStatusResponse, error) {
+	out := new(GetStatusResponse)
+	err := c.cc.Invoke(ctx, SigningAgent_GetStatus_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *signingAgentClient) Feed(ctx context.Context, in *FeedRequest, opts ...grpc.CallOption) (SigningAgent_FeedClient, error) {
+	stream, err := c.cc.NewStream(ctx, &SigningAgent_ServiceDesc.Streams[0], SigningAgent_Feed_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &signingAgentFeedClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type SigningAgent_FeedClient interface {
+	Recv() (*FeedEvent, error)
+	grpc.ClientStream
+}
+
+type signingAgentFeedClient struct {
+	grpc.ClientStream
+}
+
+func (x *signingAgentFeedClient) Recv() (*FeedEvent, error) {
+	m := new(FeedEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SigningAgentServer is the server API for SigningAgent service.
+// All implementations must embed UnimplementedSigningAgentServer
+// for forward compatibility
+type SigningAgentServer interface {
+	// Register registers the system agent, like POST /register
+	Register(context.Context, *RegisterRequest) (*RegisterResponse, error)
+	// GetClient returns the registered agent, like GET /client
+	GetClient(context.Context, *GetClientRequest) (*GetClientResponse, error)
+	// Approve approves an action, like PUT /client/action/{action_id}
+	Approve(context.Context, *ActionRequest) (*ActionResponse, error)
+	// Reject rejects an action, like DELETE /client/action/{action_id}
+	Reject(context.Context, *ActionRequest) (*ActionResponse, error)
+	// GetStatus returns the status of the feed, like GET /healthcheck/status
+	GetStatus(context.Context, *GetStatusRequest) (*GetStatusResponse, error)
+	// Feed streams the actions received from Qredo, like the /client/feed websocket
+	Feed(*FeedRequest, SigningAgent_FeedServer) error
+	mustEmbedUnimplementedSigningAgentServer()
+}
+
+// UnimplementedSigningAgentServer must be embedded to have forward compatible implementations.
+type UnimplementedSigningAgentServer struct {
+}
+
+func (UnimplementedSigningAgentServer) Register(context.Context, *RegisterRequest) (*RegisterResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Register not implemented")
+}
+func (UnimplementedSigningAgentServer) GetClient(context.Context, *GetClientRequest) (*GetClientResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetClient not implemented")
+}
+func (UnimplementedSigningAgentServer) Approve(context.Context, *ActionRequest) (*ActionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Approve not implemented")
+}
+func (UnimplementedSigningAgentServer) Reject(context.Context, *ActionRequest) (*ActionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Reject not implemented")
+}
+func (UnimplementedSigningAgentServer) GetStatus(context.Context, *GetStatusRequest) (*GetStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatus not implemented")
+}
+func (UnimplementedSigningAgentServer) Feed(*FeedRequest, SigningAgent_FeedServer) error {
+	return status.Errorf(codes.Unimplemented, "method Feed not implemented")
+}
+func (UnimplementedSigningAgentServer) mustEmbedUnimplementedSigningAgentServer() {}
+
+// UnsafeSigningAgentServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SigningAgentServer will
+// result in compilation errors.
+type UnsafeSigningAgentServer interface {
+	mustEmbedUnimplementedSigningAgentServer()
+}
+
+func RegisterSigningAgentServer(s grpc.ServiceRegistrar, srv SigningAgentServer) {
+	s.RegisterService(&SigningAgent_ServiceDesc, srv)
+}
+
+func _SigningAgent_Register_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SigningAgentServer).Register(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SigningAgent_Register_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SigningAgentServer).Register(ctx, req.(*RegisterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SigningAgent_GetClient_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetClientRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SigningAgentServer).GetClient(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SigningAgent_GetClient_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SigningAgentServer).GetClient(ctx, req.(*GetClientRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SigningAgent_Approve_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ActionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SigningAgentServer).Approve(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SigningAgent_Approve_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SigningAgentServer).Approve(ctx, req.(*ActionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SigningAgent_Reject_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ActionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SigningAgentServer).Reject(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SigningAgent_Reject_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SigningAgentServer).Reject(ctx, req.(*ActionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SigningAgent_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SigningAgentServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SigningAgent_GetStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SigningAgentServer).GetStatus(ctx, req.(*GetStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SigningAgent_Feed_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(FeedRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SigningAgentServer).Feed(m, &signingAgentFeedServer{stream})
+}
+
+type SigningAgent_FeedServer interface {
+	Send(*FeedEvent) error
+	grpc.ServerStream
+}
+
+type signingAgentFeedServer struct {
+	grpc.ServerStream
+}
+
+func (x *signingAgentFeedServer) Send(m *FeedEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// SigningAgent_ServiceDesc is the grpc.ServiceDesc for SigningAgent service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SigningAgent_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "signingagent.v1.SigningAgent",
+	HandlerType: (*SigningAgentServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Register",
+			Handler:    _SigningAgent_Register_Handler,
+		},
+		{
+			MethodName: "GetClient",
+			Handler:    _SigningAgent_GetClient_Handler,
+		},
+		{
+			MethodName: "Approve",
+			Handler:    _SigningAgent_Approve_Handler,
+		},
+		{
+			MethodName: "Reject",
+			Handler:    _SigningAgent_Reject_Handler,
+		},
+		{
+			MethodName: "GetStatus",
+			Handler:    _SigningAgent_GetStatus_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Feed",
+			Handler:       _SigningAgent_Feed_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "rpc/signing_agent.proto",
+}
@@ -0,0 +1,150 @@
+package e2e
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qredo/signing-agent/config"
+	"github.com/qredo/signing-agent/defs"
+	"github.com/qredo/signing-agent/hub"
+	"github.com/qredo/signing-agent/lib"
+	"github.com/qredo/signing-agent/util"
+)
+
+const fixturePathAgent = "../../testdata/lib/agent.json"
+
+// mockQredo is a local stand-in for the Qredo feed and the pending actions API.
+// The first feed connection sends the first action once ready and is then dropped, the next ones are kept open
+type mockQredo struct {
+	lock        sync.Mutex
+	ready       chan bool
+	connections int
+	pending     []lib.WsActionInfoEvent
+	authHeaders []string
+	statuses    []string
+}
+
+func (q *mockQredo) handler() http.Handler {
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/coreclient/feed", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		q.lock.Lock()
+		q.connections++
+		first := q.connections == 1
+		q.lock.Unlock()
+
+		if first {
+			<-q.ready
+			message, _ := json.Marshal(q.pending[0])
+			_ = conn.WriteMessage(websocket.TextMessage, message)
+			return
+		}
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	mux.HandleFunc("/coreclient/actions", func(w http.ResponseWriter, r *http.Request) {
+		q.lock.Lock()
+		q.authHeaders = append(q.authHeaders, r.Header.Get(defs.AuthHeader))
+		q.statuses = append(q.statuses, r.URL.Query().Get("status"))
+		q.lock.Unlock()
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"actions": q.pending})
+	})
+	return mux
+}
+
+func createReplayCore(t *testing.T, qredoAPI string) lib.SigningAgentClient {
+	cfg := &config.Config{}
+	cfg.Default()
+	cfg.Base.PIN = 1234
+	cfg.Base.QredoAPI = qredoAPI
+
+	kv := util.NewFileStore(filepath.Join(t.TempDir(), "ccstore.db"))
+	require.Nil(t, kv.Init())
+
+	data, err := os.ReadFile(fixturePathAgent)
+	require.Nil(t, err)
+	agent := &lib.Agent{}
+	require.Nil(t, json.Unmarshal(data, agent))
+
+	store := lib.NewStore(kv)
+	require.Nil(t, store.AddAgent(agent.ID, agent))
+	require.Nil(t, store.SetSystemAgentID(agent.ID))
+
+	core, err := lib.New(cfg, kv)
+	require.Nil(t, err)
+	return core
+}
+
+// TestFeedReplaysMissedActionsAfterReconnect checks the actions pending on the Qredo side but not received on the feed
+// are broadcast once the feed is reconnected, and only once
+func TestFeedReplaysMissedActionsAfterReconnect(t *testing.T) {
+	qredo := &mockQredo{
+		ready: make(chan bool),
+		pending: []lib.WsActionInfoEvent{
+			{ID: "received action", Type: "ApproveWithdraw", Status: "pending", ExpireTime: time.Now().Add(time.Hour).Unix()},
+			{ID: "missed action", Type: "ApproveTransfer", Status: "pending", ExpireTime: time.Now().Add(time.Hour).Unix()},
+		},
+	}
+	server := httptest.NewServer(qredo.handler())
+	defer server.Close()
+
+	wsConfig := &config.WebSocketConfig{ReconnectTimeOut: 10, ReconnectInterval: 1, ReplayMissedActions: true}
+	feedURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/coreclient/feed"
+	source := hub.NewWebsocketSource(hub.NewDefaultDialer(), feedURL, util.NewTestLogger(), createReplayCore(t, server.URL), wsConfig)
+	feedHub := hub.NewFeedHub(source, util.NewTestLogger(), &config.FeedBuffer{Size: 10, Policy: hub.PolicyDisconnect})
+
+	require.True(t, feedHub.Run())
+	client := hub.NewFeedClient(true)
+	feedHub.RegisterClient(&client)
+
+	received := make([]lib.WsActionInfoEvent, 0)
+	done := make(chan bool)
+	go func() {
+		for message := range client.Feed {
+			action := lib.WsActionInfoEvent{}
+			_ = json.Unmarshal(message, &action)
+			received = append(received, action)
+		}
+		close(done)
+	}()
+
+	close(qredo.ready)
+	<-time.After(3 * time.Second)
+	feedHub.Replay()
+
+	feedHub.Stop()
+	<-done
+
+	require.Len(t, received, 2)
+	assert.Equal(t, "received action", received[0].ID)
+	assert.Equal(t, "missed action", received[1].ID)
+	assert.Equal(t, "ApproveTransfer", received[1].Type)
+
+	qredo.lock.Lock()
+	defer qredo.lock.Unlock()
+	assert.Equal(t, 2, qredo.connections)
+	assert.Equal(t, []string{"pending", "pending"}, qredo.statuses)
+	assert.NotEmpty(t, qredo.authHeaders[0])
+}
@@ -49,6 +49,7 @@ func createTestConfig() config.Config {
 	cfg.Store.FileConfig = TestDataDBStoreFilePath
 	cfg.Websocket.QredoWebsocket = "wss://play-api.qredo.network/api/v1/p/coreclient/feed"
 	cfg.AutoApprove.Enabled = true
+	cfg.AutoApprove.DefaultDecision = "approve"
 	cfg.Base.QredoAPI = TestQredoAPI
 	return cfg
 }
@@ -0,0 +1,273 @@
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qredo/signing-agent/config"
+	"github.com/qredo/signing-agent/util"
+)
+
+// mockVault is a local stand-in for the parts of the HashiCorp Vault HTTP API used by the Vault store:
+// the KV version 2 secrets engine mounted at `secret`, the token, AppRole and Kubernetes auth methods
+type mockVault struct {
+	lock          sync.Mutex
+	tokens        map[string]bool
+	data          map[string]json.RawMessage
+	version       int
+	leaseDuration int
+	renewCount    int
+	logins        int
+	failNextWrite bool
+}
+
+func newMockVault(leaseDuration int) *mockVault {
+	return &mockVault{
+		tokens:        map[string]bool{"root-token": true},
+		leaseDuration: leaseDuration,
+	}
+}
+
+func (v *mockVault) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/auth/approle/login", v.login(func(req map[string]string) bool {
+		return req["role_id"] == "some role id" && req["secret_id"] == "some secret id"
+	}))
+	mux.HandleFunc("/v1/auth/kubernetes/login", v.login(func(req map[string]string) bool {
+		return req["role"] == "signing-agent" && req["jwt"] == "some service account jwt"
+	}))
+	mux.HandleFunc("/v1/auth/token/lookup-self", v.authenticated(v.lookupSelf))
+	mux.HandleFunc("/v1/auth/token/renew-self", v.authenticated(v.renewSelf))
+	mux.HandleFunc("/v1/secret/data/signing-agent", v.authenticated(v.secret))
+	return mux
+}
+
+func (v *mockVault) login(valid func(req map[string]string) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := map[string]string{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if !valid(req) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":["invalid credentials"]}`))
+			return
+		}
+
+		v.lock.Lock()
+		v.logins++
+		token := fmt.Sprintf("token-%d", v.logins)
+		v.tokens[token] = true
+		v.lock.Unlock()
+
+		v.writeAuth(w, token)
+	}
+}
+
+func (v *mockVault) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v.lock.Lock()
+		ok := v.tokens[r.Header.Get("X-Vault-Token")]
+		v.lock.Unlock()
+
+		if !ok {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (v *mockVault) lookupSelf(w http.ResponseWriter, _ *http.Request) {
+	_, _ = fmt.Fprintf(w, `{"data":{"ttl":%d,"renewable":true}}`, v.leaseDuration)
+}
+
+func (v *mockVault) renewSelf(w http.ResponseWriter, r *http.Request) {
+	v.lock.Lock()
+	v.renewCount++
+	v.lock.Unlock()
+
+	v.writeAuth(w, r.Header.Get("X-Vault-Token"))
+}
+
+func (v *mockVault) writeAuth(w http.ResponseWriter, token string) {
+	_, _ = fmt.Fprintf(w, `{"auth":{"client_token":"%s","lease_duration":%d,"renewable":true}}`, token, v.leaseDuration)
+}
+
+func (v *mockVault) secret(w http.ResponseWriter, r *http.Request) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		if v.version == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		resp := map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     v.data,
+				"metadata": map[string]int{"version": v.version},
+			},
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	case http.MethodPost:
+		req := struct {
+			Options struct {
+				CAS int `json:"cas"`
+			} `json:"options"`
+			Data map[string]json.RawMessage `json:"data"`
+		}{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		if req.Options.CAS != v.version || v.failNextWrite {
+			v.failNextWrite = false
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":["check-and-set parameter did not match the current version"]}`))
+			return
+		}
+
+		v.data = req.Data
+		v.version++
+		_, _ = fmt.Fprintf(w, `{"data":{"version":%d}}`, v.version)
+	}
+}
+
+func createVaultConfig(address string) config.VaultConfig {
+	cfg := config.Config{}
+	cfg.Default()
+	cfg.Store.VaultConfig.Address = address
+	cfg.Store.VaultConfig.Token = "root-token"
+	return cfg.Store.VaultConfig
+}
+
+// TestVaultStoreSetGetDel checks the store can be initialised and the keys can be set, read and deleted.
+func TestVaultStoreSetGetDel(t *testing.T) {
+	vault := newMockVault(0)
+	server := httptest.NewServer(vault.handler())
+	defer server.Close()
+
+	store := util.NewVaultStore(createVaultConfig(server.URL))
+	err := store.Init()
+	require.Nil(t, err)
+
+	_, err = store.Get("some_unknown_key")
+	assert.Equal(t, "not found", err.Error())
+
+	key := "PoPCorn"
+	value := "sweet or salty?"
+	err = store.Set(key, []byte(value))
+	assert.Nil(t, err)
+
+	result, err := store.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, value, string(result))
+
+	err = store.Del(key)
+	assert.Nil(t, err)
+	_, err = store.Get(key)
+	assert.Equal(t, "not found", err.Error())
+}
+
+// TestVaultStoreKeepsOtherInstanceChanges checks a write conflicting with another instance is retried on the latest data.
+func TestVaultStoreKeepsOtherInstanceChanges(t *testing.T) {
+	vault := newMockVault(0)
+	server := httptest.NewServer(vault.handler())
+	defer server.Close()
+
+	store := util.NewVaultStore(createVaultConfig(server.URL))
+	require.Nil(t, store.Init())
+	other := util.NewVaultStore(createVaultConfig(server.URL))
+	require.Nil(t, other.Init())
+
+	require.Nil(t, store.Set("first", []byte("first value")))
+	require.Nil(t, other.Set("second", []byte("second value")))
+
+	vault.lock.Lock()
+	vault.failNextWrite = true
+	vault.lock.Unlock()
+
+	err := store.Set("third", []byte("third value"))
+	assert.Nil(t, err)
+
+	for key, value := range map[string]string{"first": "first value", "second": "second value", "third": "third value"} {
+		result, err := other.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, value, string(result))
+	}
+}
+
+// TestVaultStoreInitFailsWithInvalidToken checks the store can't be initialised with a token Vault doesn't know.
+func TestVaultStoreInitFailsWithInvalidToken(t *testing.T) {
+	server := httptest.NewServer(newMockVault(0).handler())
+	defer server.Close()
+
+	cfg := createVaultConfig(server.URL)
+	cfg.Token = "some unknown token"
+	store := util.NewVaultStore(cfg)
+
+	err := store.Init()
+	assert.NotNil(t, err)
+}
+
+// TestVaultStoreAppRoleLoginRenewsLease checks the AppRole login and the renewal of the token lease.
+func TestVaultStoreAppRoleLoginRenewsLease(t *testing.T) {
+	vault := newMockVault(1)
+	server := httptest.NewServer(vault.handler())
+	defer server.Close()
+
+	cfg := createVaultConfig(server.URL)
+	cfg.AuthMethod = util.VaultAuthAppRole
+	cfg.Token = ""
+	cfg.RoleID = "some role id"
+	cfg.SecretID = "some secret id"
+
+	store := util.NewVaultStore(cfg)
+	require.Nil(t, store.Init())
+	require.Nil(t, store.Set("PoPCorn", []byte("sweet or salty?")))
+
+	<-time.After(1500 * time.Millisecond)
+
+	vault.lock.Lock()
+	assert.Equal(t, 1, vault.logins)
+	assert.GreaterOrEqual(t, vault.renewCount, 1)
+	vault.lock.Unlock()
+
+	result, err := store.Get("PoPCorn")
+	assert.Nil(t, err)
+	assert.Equal(t, "sweet or salty?", string(result))
+}
+
+// TestVaultStoreKubernetesLogin checks the Kubernetes login with the service account token.
+func TestVaultStoreKubernetesLogin(t *testing.T) {
+	vault := newMockVault(0)
+	server := httptest.NewServer(vault.handler())
+	defer server.Close()
+
+	jwtFile := filepath.Join(t.TempDir(), "token")
+	require.Nil(t, os.WriteFile(jwtFile, []byte("some service account jwt\n"), 0600))
+
+	cfg := createVaultConfig(server.URL)
+	cfg.AuthMethod = util.VaultAuthKubernetes
+	cfg.Token = ""
+	cfg.Role = "signing-agent"
+	cfg.ServiceAccountTokenFile = jwtFile
+
+	store := util.NewVaultStore(cfg)
+	err := store.Init()
+
+	assert.Nil(t, err)
+	vault.lock.Lock()
+	assert.Equal(t, 1, vault.logins)
+	vault.lock.Unlock()
+}
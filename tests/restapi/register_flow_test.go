@@ -326,12 +326,19 @@ func clientActionTests(e *httpexpect.Expect) {
 	// action approve
 	e.PUT(rest.WrapPathPrefix(rest.PathAction)).
 		Expect().
-		Status(http.StatusOK)
+		Status(http.StatusOK).
+		JSON().Object().Value("status").String().Equal(api.ActionStateApproved)
 
-	// action reject
+	// action approve again, the approved action isn't signed twice
+	e.PUT(rest.WrapPathPrefix(rest.PathAction)).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status").String().Equal(api.ActionStateApproved)
+
+	// action reject, the action is already approved
 	e.DELETE(rest.WrapPathPrefix(rest.PathAction)).
 		Expect().
-		Status(http.StatusOK)
+		Status(http.StatusConflict)
 }
 
 // websocketTest tests the signing agent websocket (/client/feed)
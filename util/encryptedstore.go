@@ -0,0 +1,301 @@
+package util
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/term"
+
+	"github.com/qredo/signing-agent/config"
+)
+
+const (
+	encryptedStoreVersion = 1
+	encryptedStoreKDF     = "argon2id"
+
+	// the argon2id parameters recommended by RFC 9106 for memory constrained environments
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2SaltLen = 16
+)
+
+// encryptedFile is the content of an encrypted store file. The data is the JSON encoded store data
+// encrypted with XChaCha20-Poly1305, using a key derived from the passphrase with argon2id
+type encryptedFile struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// NewEncryptedFileStore returns a FileStore encrypting its file with a key derived from the passphrase
+// configured in cfg. The passphrase is read when the store is initialised
+func NewEncryptedFileStore(cfg config.EncryptedFileConfig) KVStore {
+	return &FileStore{
+		fileName: cfg.File,
+		data:     map[string][]byte{},
+		codec:    &encryptedCodec{cfg: cfg},
+	}
+}
+
+// encryptedCodec encrypts the store data. The key is derived once, when the file is read or first written
+type encryptedCodec struct {
+	cfg        config.EncryptedFileConfig
+	passphrase []byte
+	file       *encryptedFile
+	key        []byte
+}
+
+func (c *encryptedCodec) init() error {
+	passphrase, err := ReadPassphrase(&c.cfg)
+	if err != nil {
+		return errors.Wrap(err, "read store passphrase")
+	}
+
+	c.passphrase = passphrase
+	return nil
+}
+
+func (c *encryptedCodec) encode(data map[string][]byte) ([]byte, error) {
+	if c.key == nil {
+		file, err := newEncryptedFile()
+		if err != nil {
+			return nil, err
+		}
+
+		c.file = file
+		c.key = file.deriveKey(c.passphrase)
+	}
+
+	plain, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.file.seal(c.key, plain)
+}
+
+func (c *encryptedCodec) decode(b []byte, data *map[string][]byte) error {
+	file, key, plain, err := openEncryptedFile(b, c.passphrase)
+	if err != nil {
+		return errors.Wrap(err, "decrypt store")
+	}
+
+	if err := json.Unmarshal(plain, data); err != nil {
+		return err
+	}
+
+	c.file = file
+	c.key = key
+	return nil
+}
+
+// EncryptWithPassphrase encrypts data the same way as the encrypted store, with a key derived from the passphrase
+func EncryptWithPassphrase(passphrase, data []byte) ([]byte, error) {
+	file, err := newEncryptedFile()
+	if err != nil {
+		return nil, err
+	}
+
+	return file.seal(file.deriveKey(passphrase), data)
+}
+
+// DecryptWithPassphrase decrypts the data encrypted by EncryptWithPassphrase
+func DecryptWithPassphrase(passphrase, b []byte) ([]byte, error) {
+	_, _, plain, err := openEncryptedFile(b, passphrase)
+	return plain, err
+}
+
+// newEncryptedFile returns an encryptedFile with a new random salt and the default key derivation parameters
+func newEncryptedFile() (*encryptedFile, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	return &encryptedFile{
+		Version: encryptedStoreVersion,
+		KDF:     encryptedStoreKDF,
+		Salt:    salt,
+		Time:    argon2Time,
+		Memory:  argon2Memory,
+		Threads: argon2Threads,
+	}, nil
+}
+
+// seal encrypts plain with key and returns the JSON encoded file
+func (f *encryptedFile) seal(key, plain []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	file := *f
+	file.Nonce = nonce
+	file.Data = aead.Seal(nil, nonce, plain, file.additionalData())
+
+	return json.Marshal(file)
+}
+
+// openEncryptedFile parses the JSON encoded file and decrypts its data with a key derived from the passphrase
+func openEncryptedFile(b, passphrase []byte) (*encryptedFile, []byte, []byte, error) {
+	file := &encryptedFile{}
+	if err := json.Unmarshal(b, file); err != nil {
+		return nil, nil, nil, errors.Wrap(err, "parse encrypted data")
+	}
+
+	if file.Version != encryptedStoreVersion || file.KDF != encryptedStoreKDF {
+		return nil, nil, nil, errors.Errorf("unsupported encryption version %d, kdf [%s]", file.Version, file.KDF)
+	}
+
+	key := file.deriveKey(passphrase)
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if len(file.Nonce) != aead.NonceSize() {
+		return nil, nil, nil, errors.New("invalid nonce")
+	}
+
+	plain, err := aead.Open(nil, file.Nonce, file.Data, file.additionalData())
+	if err != nil {
+		return nil, nil, nil, errors.New("wrong passphrase or corrupted file")
+	}
+
+	return file, key, plain, nil
+}
+
+func (f *encryptedFile) deriveKey(passphrase []byte) []byte {
+	return argon2.IDKey(passphrase, f.Salt, f.Time, f.Memory, f.Threads, chacha20poly1305.KeySize)
+}
+
+// additionalData binds the key derivation parameters to the ciphertext
+func (f *encryptedFile) additionalData() []byte {
+	return []byte(fmt.Sprintf("%d:%s:%x:%d:%d:%d", f.Version, f.KDF, f.Salt, f.Time, f.Memory, f.Threads))
+}
+
+// ReadPassphrase returns the passphrase of the encrypted store, read from the configured environment variable,
+// else from the configured file, else prompted for when running in a terminal. The prompted passphrase is asked twice
+// when the store file doesn't exist yet, so that a typo doesn't lock the new store
+func ReadPassphrase(cfg *config.EncryptedFileConfig) ([]byte, error) {
+	if _, err := os.Stat(cfg.File); os.IsNotExist(err) {
+		return ReadNewPassphraseFrom(cfg.PassphraseEnv, cfg.PassphraseFile, "Store passphrase: ")
+	}
+
+	return ReadPassphraseFrom(cfg.PassphraseEnv, cfg.PassphraseFile, "Store passphrase: ")
+}
+
+// ReadPassphraseFrom returns the passphrase read from the env environment variable, else from the file,
+// else prompted for with prompt when running in a terminal. env and file are ignored when empty
+func ReadPassphraseFrom(env, file, prompt string) ([]byte, error) {
+	return readPassphraseFrom(env, file, prompt, false)
+}
+
+// ReadNewPassphraseFrom is like ReadPassphraseFrom, but the prompted passphrase is asked twice and both must match.
+// It's meant for the passphrases encrypting new data
+func ReadNewPassphraseFrom(env, file, prompt string) ([]byte, error) {
+	return readPassphraseFrom(env, file, prompt, true)
+}
+
+func readPassphraseFrom(env, file, prompt string, confirm bool) ([]byte, error) {
+	if len(env) > 0 {
+		if passphrase := os.Getenv(env); len(passphrase) > 0 {
+			return []byte(passphrase), nil
+		}
+	}
+
+	if len(file) > 0 {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		passphrase := bytes.TrimRight(b, "\r\n")
+		if len(passphrase) == 0 {
+			return nil, errors.New("empty passphrase file")
+		}
+		return passphrase, nil
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, errors.New("no passphrase configured")
+	}
+
+	return promptPassphrase(prompt, confirm, func(prompt string) ([]byte, error) {
+		fmt.Print(prompt)
+		passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+		return passphrase, err
+	})
+}
+
+// promptPassphrase reads the passphrase with read and, when confirm is set, reads it again and checks both match
+func promptPassphrase(prompt string, confirm bool, read func(prompt string) ([]byte, error)) ([]byte, error) {
+	passphrase, err := read(prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+
+	if confirm {
+		repeated, err := read("Confirm passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+
+		if !bytes.Equal(passphrase, repeated) {
+			return nil, errors.New("passphrases don't match")
+		}
+	}
+
+	return passphrase, nil
+}
+
+// MigrateFileStore writes the data of the plain file store to a new encrypted store configured by cfg.
+// The encrypted store file must not exist. The plain file is left untouched
+func MigrateFileStore(plainFile string, cfg config.EncryptedFileConfig) error {
+	if _, err := os.Stat(cfg.File); err == nil {
+		return errors.Errorf("encrypted store [%s] already exists", cfg.File)
+	}
+
+	b, err := os.ReadFile(plainFile)
+	if err != nil {
+		return errors.Wrap(err, "read plain store")
+	}
+
+	data := map[string][]byte{}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return errors.Wrap(err, "parse plain store")
+	}
+
+	store := NewEncryptedFileStore(cfg).(*FileStore)
+	if err := store.Init(); err != nil {
+		return errors.Wrap(err, "init encrypted store")
+	}
+
+	store.Lock()
+	defer store.Unlock()
+
+	store.data = data
+	return store.save()
+}
@@ -0,0 +1,141 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/test-go/testify/require"
+
+	"github.com/qredo/signing-agent/config"
+)
+
+const testPassphraseEnv = "TEST_SIGNING_AGENT_STORE_PASSPHRASE"
+
+func testEncryptedConfig(t *testing.T) config.EncryptedFileConfig {
+	t.Setenv(testPassphraseEnv, "some passphrase")
+	return config.EncryptedFileConfig{
+		File:          filepath.Join(t.TempDir(), "ccstore.enc"),
+		PassphraseEnv: testPassphraseEnv,
+	}
+}
+
+func TestEncryptedFileStore_keeps_data_encrypted(t *testing.T) {
+	// Arrange
+	cfg := testEncryptedConfig(t)
+	sut := NewEncryptedFileStore(cfg)
+	require.Nil(t, sut.Init())
+
+	// Act
+	err := sut.Set("some key", []byte("some secret seed"))
+
+	// Assert
+	assert.Nil(t, err)
+	content, err := os.ReadFile(cfg.File)
+	require.Nil(t, err)
+	assert.NotContains(t, string(content), "some key")
+	assert.NotContains(t, string(content), "c29tZSBzZWNyZXQgc2VlZA==")
+
+	reopened := NewEncryptedFileStore(cfg)
+	require.Nil(t, reopened.Init())
+	data, err := reopened.Get("some key")
+	assert.Nil(t, err)
+	assert.Equal(t, "some secret seed", string(data))
+}
+
+func TestEncryptedFileStore_fails_with_wrong_passphrase(t *testing.T) {
+	// Arrange
+	cfg := testEncryptedConfig(t)
+	sut := NewEncryptedFileStore(cfg)
+	require.Nil(t, sut.Init())
+	require.Nil(t, sut.Set("some key", []byte("some secret seed")))
+
+	t.Setenv(testPassphraseEnv, "some other passphrase")
+
+	// Act
+	err := NewEncryptedFileStore(cfg).Init()
+
+	// Assert
+	assert.NotNil(t, err)
+	assert.Equal(t, "decrypt store: wrong passphrase or corrupted file", err.Error())
+}
+
+func TestEncryptedFileStore_reads_passphrase_file(t *testing.T) {
+	// Arrange
+	passphraseFile := filepath.Join(t.TempDir(), "passphrase")
+	require.Nil(t, os.WriteFile(passphraseFile, []byte("some passphrase\n"), 0600))
+	cfg := config.EncryptedFileConfig{
+		PassphraseFile: passphraseFile,
+	}
+
+	// Act
+	passphrase, err := ReadPassphrase(&cfg)
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Equal(t, "some passphrase", string(passphrase))
+}
+
+func TestMigrateFileStore_encrypts_plain_store(t *testing.T) {
+	// Arrange
+	cfg := testEncryptedConfig(t)
+	plainFile := filepath.Join(t.TempDir(), "ccstore.db")
+	plain := NewFileStore(plainFile)
+	require.Nil(t, plain.Init())
+	require.Nil(t, plain.Set("AgentID", []byte("some agent id")))
+
+	// Act
+	err := MigrateFileStore(plainFile, cfg)
+
+	// Assert
+	assert.Nil(t, err)
+	sut := NewEncryptedFileStore(cfg)
+	require.Nil(t, sut.Init())
+	data, err := sut.Get("AgentID")
+	assert.Nil(t, err)
+	assert.Equal(t, "some agent id", string(data))
+
+	err = MigrateFileStore(plainFile, cfg)
+	assert.NotNil(t, err)
+}
+
+func TestPromptPassphrase(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		confirm     bool
+		answers     []string
+		expected    string
+		expectedErr string
+		prompts     []string
+	}{
+		{"reads once", false, []string{"some passphrase"}, "some passphrase", "", []string{"Store passphrase: "}},
+		{"confirms", true, []string{"some passphrase", "some passphrase"}, "some passphrase", "", []string{"Store passphrase: ", "Confirm passphrase: "}},
+		{"confirmation differs", true, []string{"some passphrase", "some pasphrase"}, "", "passphrases don't match", []string{"Store passphrase: ", "Confirm passphrase: "}},
+		{"empty", true, []string{""}, "", "empty passphrase", []string{"Store passphrase: "}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// Arrange
+			var prompts []string
+			read := func(prompt string) ([]byte, error) {
+				prompts = append(prompts, prompt)
+				answer := tc.answers[len(prompts)-1]
+				return []byte(answer), nil
+			}
+
+			// Act
+			passphrase, err := promptPassphrase("Store passphrase: ", tc.confirm, read)
+
+			// Assert
+			assert.Equal(t, tc.prompts, prompts)
+			if len(tc.expectedErr) > 0 {
+				assert.EqualError(t, err, tc.expectedErr)
+				assert.Nil(t, passphrase)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, string(passphrase))
+		})
+	}
+}
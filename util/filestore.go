@@ -12,21 +12,35 @@ func NewFileStore(fileName string) KVStore {
 	fs := &FileStore{
 		fileName: fileName,
 		data:     map[string][]byte{},
+		codec:    &jsonCodec{},
 	}
 
 	return fs
 }
 
+// fileCodec converts the data of a FileStore to and from the content of its file
+type fileCodec interface {
+	// init is called once, before the store file is read or created
+	init() error
+	encode(data map[string][]byte) ([]byte, error)
+	decode(b []byte, data *map[string][]byte) error
+}
+
 type FileStore struct {
 	sync.RWMutex
 	fileName string
 	data     map[string][]byte
+	codec    fileCodec
 }
 
 func (s *FileStore) Init() error {
 	s.Lock()
 	defer s.Unlock()
 
+	if err := s.codec.init(); err != nil {
+		return err
+	}
+
 	b, err := os.ReadFile(s.fileName)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -35,7 +49,7 @@ func (s *FileStore) Init() error {
 		return err
 	}
 
-	if err := json.Unmarshal(b, &s.data); err != nil {
+	if err := s.codec.decode(b, &s.data); err != nil {
 		return err
 	}
 
@@ -45,7 +59,7 @@ func (s *FileStore) Init() error {
 // caller must handle concurrency
 func (s *FileStore) save() error {
 
-	b, err := json.Marshal(s.data)
+	b, err := s.codec.encode(s.data)
 	if err != nil {
 		return err
 	}
@@ -84,3 +98,18 @@ func (s *FileStore) Del(key string) error {
 
 	return nil
 }
+
+// jsonCodec stores the data as plain JSON
+type jsonCodec struct{}
+
+func (c *jsonCodec) init() error {
+	return nil
+}
+
+func (c *jsonCodec) encode(data map[string][]byte) ([]byte, error) {
+	return json.Marshal(data)
+}
+
+func (c *jsonCodec) decode(b []byte, data *map[string][]byte) error {
+	return json.Unmarshal(b, data)
+}
@@ -3,11 +3,15 @@ package util
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/pkg/errors"
+
+	"github.com/qredo/signing-agent/metrics"
 )
 
 // HTTPClient interface
@@ -44,6 +48,23 @@ func NewHTTPMockClient() *Client {
 	return &Client{httpClient: &MockHTTPClient{}}
 }
 
+// StatusError is returned by the requests answered with a status other than 2xx
+type StatusError struct {
+	StatusCode int
+	message    string
+}
+
+func (e *StatusError) Error() string {
+	return e.message
+}
+
+func newStatusError(method, url string, resp *http.Response) *StatusError {
+	if b, err := io.ReadAll(resp.Body); err == nil && len(b) > 0 {
+		return &StatusError{resp.StatusCode, fmt.Sprintf("%v %v Status %v (%v) with body: %s", method, url, resp.StatusCode, resp.Status, b)}
+	}
+	return &StatusError{resp.StatusCode, fmt.Sprintf("%v %v Status %v (%v)", method, url, resp.StatusCode, resp.Status)}
+}
+
 func (c *Client) Request(method string, url string, reqData interface{}, respData interface{}, headers http.Header) error {
 	var body io.Reader
 	if reqData != nil {
@@ -62,7 +83,7 @@ func (c *Client) Request(method string, url string, reqData interface{}, respDat
 	if headers != nil {
 		req.Header = headers
 	}
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return errors.Wrap(err, "request error")
 	}
@@ -70,10 +91,7 @@ func (c *Client) Request(method string, url string, reqData interface{}, respDat
 
 	statusOK := resp.StatusCode >= 200 && resp.StatusCode < 300
 	if !statusOK {
-		if b, err := io.ReadAll(resp.Body); err == nil && len(b) > 0 {
-			return errors.Errorf("%v %v Status %v (%v) with body: %s", method, url, resp.StatusCode, resp.Status, b)
-		}
-		return errors.Errorf("%v %v Status %v (%v)", method, url, resp.StatusCode, resp.Status)
+		return newStatusError(method, url, resp)
 	}
 
 	switch respData := respData.(type) {
@@ -116,7 +134,7 @@ func (c *Client) RequestNoLog(method string, url string, reqData interface{}, re
 		req.Header = headers
 	}
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return errors.Wrap(err, "request error")
 	}
@@ -124,10 +142,7 @@ func (c *Client) RequestNoLog(method string, url string, reqData interface{}, re
 
 	statusOK := resp.StatusCode >= 200 && resp.StatusCode < 300
 	if !statusOK {
-		if b, err := io.ReadAll(resp.Body); err == nil && len(b) > 0 {
-			return errors.Errorf("%v %v Status %v (%v) with body: %s", method, url, resp.StatusCode, resp.Status, b)
-		}
-		return errors.Errorf("%v %v Status %v (%v)", method, url, resp.StatusCode, resp.Status)
+		return newStatusError(method, url, resp)
 	}
 
 	switch respData := respData.(type) {
@@ -150,3 +165,17 @@ func (c *Client) RequestNoLog(method string, url string, reqData interface{}, re
 
 	return nil
 }
+
+// do sends the request and observes its latency
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	metrics.QredoAPIRequestDuration.WithLabelValues(req.Method, URLMetricLabel(req.URL.String()), code).Observe(time.Since(start).Seconds())
+
+	return resp, err
+}
@@ -12,7 +12,7 @@ import (
 
 func DecodeRequest(req interface{}, hr *http.Request) error {
 	switch hr.Method {
-	case http.MethodPost, http.MethodPut, http.MethodPatch:
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
 		if err := DecodeJSON(hr, req); err != nil {
 			if err != io.EOF {
 				return defs.ErrBadRequest().WithDetail("invalid json").Wrap(err)
@@ -8,10 +8,14 @@ func CreateStore(cfg *config.Config) KVStore {
 	switch cfg.Store.Type {
 	case "file":
 		return NewFileStore(cfg.Store.FileConfig)
+	case "encrypted":
+		return NewEncryptedFileStore(cfg.Store.EncryptedConfig)
 	case "oci":
 		return NewOciStore(cfg.Store.OciConfig)
 	case "aws":
 		return NewAWSStore(cfg.Store.AwsConfig)
+	case "vault":
+		return NewVaultStore(cfg.Store.VaultConfig)
 	default:
 		return nil
 	}
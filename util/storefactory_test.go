@@ -26,6 +26,38 @@ func Test_StoreFactory_CreateStore_Creates_file_store(t *testing.T) {
 	assert.Equal(t, reflect.TypeOf(sut).String(), "*util.FileStore")
 }
 
+func Test_StoreFactory_CreateStore_Creates_encrypted_store(t *testing.T) {
+	// Arrange
+	cfg := &config.Config{
+		Store: config.Store{
+			Type: "encrypted",
+		},
+	}
+
+	// Act
+	sut := CreateStore(cfg)
+
+	// Assert
+	require.NotNil(t, sut)
+	assert.Equal(t, reflect.TypeOf(sut).String(), "*util.FileStore")
+}
+
+func Test_StoreFactory_CreateStore_Creates_vault_store(t *testing.T) {
+	// Arrange
+	cfg := &config.Config{
+		Store: config.Store{
+			Type: "vault",
+		},
+	}
+
+	// Act
+	sut := CreateStore(cfg)
+
+	// Assert
+	require.NotNil(t, sut)
+	assert.Equal(t, reflect.TypeOf(sut).String(), "*util.VaultStore")
+}
+
 func Test_StoreFactory_CreateStore_Creates_oci_store(t *testing.T) {
 	// Arrange
 	cfg := &config.Config{
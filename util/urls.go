@@ -2,6 +2,8 @@ package util
 
 import (
 	"fmt"
+	"net/url"
+	"strings"
 )
 
 func URLClientInit(baseURL string) string {
@@ -23,3 +25,25 @@ func URLActionApprove(baseURL, actionID string) string {
 func URLActionReject(baseURL, actionID string) string {
 	return fmt.Sprintf("%s/coreclient/action/%s", baseURL, actionID)
 }
+
+func URLPendingActions(baseURL string) string {
+	return fmt.Sprintf("%s/coreclient/actions?status=pending", baseURL)
+}
+
+// URLMetricLabel returns the path of the url with the action id replaced by a placeholder,
+// so that it can be used as a metric label without creating a new series for every action
+func URLMetricLabel(rawURL string) string {
+	path := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		path = u.Path
+	}
+
+	segments := strings.Split(path, "/")
+	for i := 1; i < len(segments); i++ {
+		if segments[i-1] == "action" && len(segments[i]) > 0 {
+			segments[i] = "{action_id}"
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
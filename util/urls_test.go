@@ -0,0 +1,29 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestURLMetricLabel_replaces_action_id(t *testing.T) {
+	// Arrange
+	url := URLActionApprove("https://play-api.qredo.network/api/v1/p", "2JiSPsNkB8ZVYpkuyvlmwp6gyFE")
+
+	// Act
+	label := URLMetricLabel(url)
+
+	// Assert
+	assert.Equal(t, "/api/v1/p/coreclient/action/{action_id}", label)
+}
+
+func TestURLMetricLabel_keeps_path_without_action(t *testing.T) {
+	// Arrange
+	url := URLRegisterConfirm("https://play-api.qredo.network/api/v1/p")
+
+	// Act
+	label := URLMetricLabel(url)
+
+	// Assert
+	assert.Equal(t, "/api/v1/p/coreclient/finish", label)
+}
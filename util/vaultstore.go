@@ -0,0 +1,376 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/qredo/signing-agent/config"
+	"github.com/qredo/signing-agent/defs"
+)
+
+// The auth methods supported by the Vault store
+const (
+	VaultAuthToken      = "token"
+	VaultAuthAppRole    = "approle"
+	VaultAuthKubernetes = "kubernetes"
+)
+
+const (
+	// vaultCASRetries is the number of times a write is retried when the secret was updated by someone else in between
+	vaultCASRetries = 3
+	// vaultRenewRetryInterval is the time waited before retrying a failed token renewal
+	vaultRenewRetryInterval = 10 * time.Second
+)
+
+var errVaultCASMismatch = errors.New("vault secret updated concurrently")
+
+type VaultStore struct {
+	lock       sync.Mutex
+	cfg        config.VaultConfig
+	httpClient *http.Client
+	tokenLock  sync.RWMutex
+	token      string
+	renewable  bool
+	leaseTTL   time.Duration
+}
+
+// NewVaultStore creates and returns the HashiCorp Vault KVStore.
+func NewVaultStore(cfg config.VaultConfig) KVStore {
+	if len(cfg.AuthMount) == 0 {
+		cfg.AuthMount = cfg.AuthMethod
+	}
+
+	return &VaultStore{
+		cfg: cfg,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// vaultSecret is the secret data and version returned by the KV version 2 secrets engine
+type vaultSecret struct {
+	Data struct {
+		Data     map[string][]byte `json:"data"`
+		Metadata struct {
+			Version int `json:"version"`
+		} `json:"metadata"`
+	} `json:"data"`
+}
+
+// vaultAuth is the auth info returned by the login and token endpoints
+type vaultAuth struct {
+	Auth *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+	Data *struct {
+		TTL       int  `json:"ttl"`
+		Renewable bool `json:"renewable"`
+	} `json:"data"`
+}
+
+// Init logs in to Vault, makes sure the secret exists and starts the renewal of the token lease, if renewable.
+func (s *VaultStore) Init() error {
+	if err := s.login(); err != nil {
+		return errors.Wrap(err, "cannot initialise Vault store")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, _, err := s.readSecret(); err != nil {
+		if err != defs.KVErrNotFound {
+			return errors.Wrap(err, "cannot initialise Vault store")
+		}
+
+		if err = s.writeSecret(map[string][]byte{}, 0); err != nil {
+			return errors.Wrap(err, "cannot initialise Vault store")
+		}
+	}
+
+	if s.renewable && s.leaseTTL > 0 {
+		go s.renewLease()
+	}
+
+	return nil
+}
+
+// Get returns the value of the named key, or error if not found.
+func (s *VaultStore) Get(key string) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	data, _, err := s.readSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if val, ok := data[key]; ok {
+		return val, nil
+	}
+
+	return nil, defs.KVErrNotFound
+}
+
+// Set adds/updates the named key with value in data.
+func (s *VaultStore) Set(key string, data []byte) error {
+	return s.update(func(secret map[string][]byte) {
+		secret[key] = data
+	})
+}
+
+// Del deletes the named key.
+func (s *VaultStore) Del(key string) error {
+	return s.update(func(secret map[string][]byte) {
+		delete(secret, key)
+	})
+}
+
+// update applies the change to the secret data. The write fails if the secret was updated in between,
+// by ex. by another signing agent instance, in which case the change is applied again to the new data
+func (s *VaultStore) update(change func(secret map[string][]byte)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i := 0; i < vaultCASRetries; i++ {
+		secret, version, err := s.readSecret()
+		if err != nil {
+			return err
+		}
+
+		change(secret)
+
+		if err = s.writeSecret(secret, version); err != errVaultCASMismatch {
+			return err
+		}
+	}
+
+	return errVaultCASMismatch
+}
+
+// readSecret returns the secret data and its version. Caller must handle concurrency
+func (s *VaultStore) readSecret() (map[string][]byte, int, error) {
+	secret := &vaultSecret{}
+	status, err := s.request(http.MethodGet, s.secretPath(), nil, secret)
+	if status == http.StatusNotFound {
+		return nil, 0, defs.KVErrNotFound
+	}
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "read Vault secret")
+	}
+
+	data := secret.Data.Data
+	if data == nil {
+		data = map[string][]byte{}
+	}
+
+	return data, secret.Data.Metadata.Version, nil
+}
+
+// writeSecret writes the secret data if the current version of the secret is version, 0 meaning it must not exist.
+// Caller must handle concurrency
+func (s *VaultStore) writeSecret(data map[string][]byte, version int) error {
+	req := map[string]interface{}{
+		"options": map[string]int{"cas": version},
+		"data":    data,
+	}
+
+	status, err := s.request(http.MethodPost, s.secretPath(), req, nil)
+	if status == http.StatusBadRequest && err != nil && strings.Contains(err.Error(), "check-and-set") {
+		return errVaultCASMismatch
+	}
+	if err != nil {
+		return errors.Wrap(err, "write Vault secret")
+	}
+
+	return nil
+}
+
+func (s *VaultStore) secretPath() string {
+	return fmt.Sprintf("/v1/%s/data/%s", strings.Trim(s.cfg.Mount, "/"), strings.Trim(s.cfg.Path, "/"))
+}
+
+// login gets a Vault token using the configured auth method
+func (s *VaultStore) login() error {
+	var path string
+	var req map[string]string
+
+	switch s.cfg.AuthMethod {
+	case VaultAuthToken:
+		token := s.cfg.Token
+		if len(token) == 0 {
+			token = os.Getenv("VAULT_TOKEN")
+		}
+		if len(token) == 0 {
+			return errors.New("no Vault token configured")
+		}
+		s.setToken(token)
+		return s.lookupToken()
+	case VaultAuthAppRole:
+		path = fmt.Sprintf("/v1/auth/%s/login", strings.Trim(s.cfg.AuthMount, "/"))
+		req = map[string]string{
+			"role_id":   s.cfg.RoleID,
+			"secret_id": s.cfg.SecretID,
+		}
+	case VaultAuthKubernetes:
+		jwt, err := os.ReadFile(s.cfg.ServiceAccountTokenFile)
+		if err != nil {
+			return errors.Wrap(err, "read service account token")
+		}
+		path = fmt.Sprintf("/v1/auth/%s/login", strings.Trim(s.cfg.AuthMount, "/"))
+		req = map[string]string{
+			"role": s.cfg.Role,
+			"jwt":  strings.TrimSpace(string(jwt)),
+		}
+	default:
+		return errors.Errorf("unsupported Vault auth method [%s]", s.cfg.AuthMethod)
+	}
+
+	auth := &vaultAuth{}
+	if _, err := s.request(http.MethodPost, path, req, auth); err != nil {
+		return errors.Wrap(err, "Vault login")
+	}
+
+	return s.applyAuth(auth)
+}
+
+// lookupToken reads the lease of the configured token
+func (s *VaultStore) lookupToken() error {
+	auth := &vaultAuth{}
+	if _, err := s.request(http.MethodGet, "/v1/auth/token/lookup-self", nil, auth); err != nil {
+		return errors.Wrap(err, "lookup Vault token")
+	}
+
+	if auth.Data != nil {
+		s.tokenLock.Lock()
+		s.renewable = auth.Data.Renewable
+		s.leaseTTL = time.Duration(auth.Data.TTL) * time.Second
+		s.tokenLock.Unlock()
+	}
+
+	return nil
+}
+
+func (s *VaultStore) applyAuth(auth *vaultAuth) error {
+	if auth.Auth == nil || len(auth.Auth.ClientToken) == 0 {
+		return errors.New("no token in Vault response")
+	}
+
+	s.tokenLock.Lock()
+	defer s.tokenLock.Unlock()
+
+	s.token = auth.Auth.ClientToken
+	s.renewable = auth.Auth.Renewable
+	s.leaseTTL = time.Duration(auth.Auth.LeaseDuration) * time.Second
+	return nil
+}
+
+// renewLease renews the token when two thirds of its lease have passed.
+// When the token can't be renewed anymore, a new one is requested with the auth method, unless a static token is used
+func (s *VaultStore) renewLease() {
+	for {
+		s.tokenLock.RLock()
+		wait := s.leaseTTL * 2 / 3
+		s.tokenLock.RUnlock()
+
+		if wait <= 0 {
+			return
+		}
+		time.Sleep(wait)
+
+		if err := s.renew(); err == nil {
+			continue
+		}
+
+		if s.cfg.AuthMethod == VaultAuthToken {
+			time.Sleep(vaultRenewRetryInterval)
+			continue
+		}
+
+		if err := s.login(); err != nil {
+			time.Sleep(vaultRenewRetryInterval)
+		}
+	}
+}
+
+func (s *VaultStore) renew() error {
+	auth := &vaultAuth{}
+	if _, err := s.request(http.MethodPost, "/v1/auth/token/renew-self", map[string]string{}, auth); err != nil {
+		return err
+	}
+
+	return s.applyAuth(auth)
+}
+
+func (s *VaultStore) setToken(token string) {
+	s.tokenLock.Lock()
+	defer s.tokenLock.Unlock()
+
+	s.token = token
+}
+
+// request sends the request to Vault and decodes the response into respData, if not nil.
+// It returns the response status code, 0 if the request couldn't be sent
+func (s *VaultStore) request(method, path string, reqData, respData interface{}) (int, error) {
+	var body io.Reader
+	if reqData != nil {
+		jd, err := json.Marshal(reqData)
+		if err != nil {
+			return 0, errors.Wrap(err, "marshal request as JSON")
+		}
+		body = bytes.NewBuffer(jd)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimRight(s.cfg.Address, "/")+path, body)
+	if err != nil {
+		return 0, errors.Wrap(err, "create request")
+	}
+
+	s.tokenLock.RLock()
+	token := s.token
+	s.tokenLock.RUnlock()
+
+	if len(token) > 0 {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if len(s.cfg.Namespace) > 0 {
+		req.Header.Set("X-Vault-Namespace", s.cfg.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return 0, errors.Wrap(err, "request error")
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, errors.Wrap(err, "read response body")
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, errors.Errorf("%v %v Status %v with body: %s", method, path, resp.StatusCode, b)
+	}
+
+	if respData != nil && len(b) > 0 {
+		if err := json.Unmarshal(b, respData); err != nil {
+			return resp.StatusCode, errors.Wrap(err, "decode response as JSON")
+		}
+	}
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,189 @@
+package webhook
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/qredo/signing-agent/api"
+)
+
+// DeadLetterStore keeps the actions that couldn't be delivered
+type DeadLetterStore interface {
+	// Add appends the dead letter to the store
+	Add(letter *api.DeadLetter) error
+	// List returns the stored dead letters, oldest first
+	List() []api.DeadLetter
+	// Remove deletes the dead letter of the delivery to the endpoint
+	Remove(id, endpoint string) error
+}
+
+type deadLetterStoreImpl struct {
+	lock     sync.RWMutex
+	fileName string
+	file     *os.File
+	letters  []api.DeadLetter
+}
+
+// NewDeadLetterStore returns a DeadLetterStore that's an instance of deadLetterStoreImpl.
+// When a file name is given, the previously stored dead letters are loaded and new ones are appended to it
+func NewDeadLetterStore(fileName string) (DeadLetterStore, error) {
+	s := &deadLetterStoreImpl{
+		fileName: fileName,
+		letters:  make([]api.DeadLetter, 0),
+	}
+
+	if len(fileName) == 0 {
+		return s, nil
+	}
+
+	if err := s.load(fileName); err != nil {
+		return nil, errors.Wrap(err, "load dead letters")
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *deadLetterStoreImpl) open() error {
+	f, err := os.OpenFile(s.fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "open dead letters")
+	}
+	s.file = f
+
+	return nil
+}
+
+// Add appends the dead letter to the file, if any, and keeps it in memory
+func (s *deadLetterStoreImpl) Add(letter *api.DeadLetter) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file != nil {
+		data, err := json.Marshal(letter)
+		if err != nil {
+			return err
+		}
+
+		if _, err = s.file.Write(append(data, '\n')); err != nil {
+			return errors.Wrap(err, "write dead letter")
+		}
+
+		if err = s.file.Sync(); err != nil {
+			return errors.Wrap(err, "sync dead letters")
+		}
+	}
+
+	s.letters = append(s.letters, *letter)
+	return nil
+}
+
+// List returns the stored dead letters, oldest first
+func (s *deadLetterStoreImpl) List() []api.DeadLetter {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return append([]api.DeadLetter{}, s.letters...)
+}
+
+// Remove deletes the dead letter of the delivery to the endpoint. The file, if any, is rewritten without it
+func (s *deadLetterStoreImpl) Remove(id, endpoint string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	letters := make([]api.DeadLetter, 0, len(s.letters))
+	for _, letter := range s.letters {
+		if letter.ID != id || letter.Endpoint != endpoint {
+			letters = append(letters, letter)
+		}
+	}
+
+	if len(letters) == len(s.letters) {
+		return nil
+	}
+
+	if s.file != nil {
+		if err := s.rewrite(letters); err != nil {
+			return errors.Wrap(err, "rewrite dead letters")
+		}
+	}
+
+	s.letters = letters
+	return nil
+}
+
+// rewrite replaces the file with the letters, through a temporary file renamed over it
+func (s *deadLetterStoreImpl) rewrite(letters []api.DeadLetter) error {
+	tmpName := s.fileName + ".tmp"
+	tmp, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	for _, letter := range letters {
+		data, err := json.Marshal(letter)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		if _, err = w.Write(append(data, '\n')); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if err = w.Flush(); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = s.file.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpName, s.fileName); err != nil {
+		_ = s.open()
+		return err
+	}
+
+	return s.open()
+}
+
+func (s *deadLetterStoreImpl) load(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		letter := api.DeadLetter{}
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			return err
+		}
+		s.letters = append(s.letters, letter)
+	}
+
+	return scanner.Err()
+}
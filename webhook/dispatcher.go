@@ -0,0 +1,271 @@
+// Package webhook posts the actions received on the feed to the configured HTTP endpoints.
+// Every request is signed with HMAC-SHA256, the failed deliveries are retried with an exponential backoff
+// and the actions that can't be delivered are written to a dead-letter store, from where they can be replayed.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/config"
+	"github.com/qredo/signing-agent/hub"
+	"github.com/qredo/signing-agent/metrics"
+)
+
+// The headers sent with every delivery
+const (
+	HeaderID        = "X-Webhook-Id"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Dispatcher is an internal feed client posting every action received on the feed to the webhook endpoints.
+// Each endpoint has its own queue, so that a slow endpoint doesn't delay the others or the feed hub
+type Dispatcher struct {
+	hub.FeedClient
+	log              *zap.SugaredLogger
+	endpoints        []*endpoint
+	deadLetters      DeadLetterStore
+	httpClient       *http.Client
+	maxAttempts      int
+	retryInterval    time.Duration
+	retryIntervalMax time.Duration
+}
+
+type endpoint struct {
+	config.WebhookEndpoint
+	queue chan *delivery
+}
+
+type delivery struct {
+	id      string
+	payload []byte
+}
+
+// NewDispatcher returns a new *Dispatcher posting the received actions to the endpoints in the config
+func NewDispatcher(cfg *config.Webhooks, deadLetters DeadLetterStore, log *zap.SugaredLogger) *Dispatcher {
+	d := &Dispatcher{
+		FeedClient:  hub.NewFeedClient(true),
+		log:         log,
+		deadLetters: deadLetters,
+		httpClient: &http.Client{
+			Timeout: time.Duration(cfg.Timeout) * time.Second,
+		},
+		maxAttempts:      cfg.MaxAttempts,
+		retryInterval:    time.Duration(cfg.RetryInterval) * time.Second,
+		retryIntervalMax: time.Duration(cfg.RetryIntervalMax) * time.Second,
+	}
+
+	for _, e := range cfg.Endpoints {
+		d.endpoints = append(d.endpoints, &endpoint{
+			WebhookEndpoint: e,
+			queue:           make(chan *delivery, cfg.QueueSize),
+		})
+	}
+
+	return d
+}
+
+// GetFeedClient returns the FeedClient registered to the feed hub
+func (d *Dispatcher) GetFeedClient() *hub.FeedClient {
+	return &d.FeedClient
+}
+
+// Listen is constantly listening for messages on the Feed channel until it's closed by the sender.
+// It then waits for the queued deliveries to complete
+func (d *Dispatcher) Listen() {
+	var wg sync.WaitGroup
+	for _, e := range d.endpoints {
+		wg.Add(1)
+		go func(e *endpoint) {
+			defer wg.Done()
+			for del := range e.queue {
+				d.deliver(e, del)
+			}
+		}(e)
+	}
+
+	for {
+		if message, ok := <-d.Feed; !ok {
+			d.log.Info("Webhook: dispatcher stopped")
+			for _, e := range d.endpoints {
+				close(e.queue)
+			}
+			wg.Wait()
+			return
+		} else {
+			d.dispatch(message)
+		}
+	}
+}
+
+// dispatch queues the message for every endpoint. When the queue of an endpoint is full, the message is dead-lettered
+func (d *Dispatcher) dispatch(message []byte) {
+	if !json.Valid(message) {
+		d.log.Errorf("Webhook: invalid message [%v] not delivered", string(message))
+		return
+	}
+
+	del := &delivery{
+		id:      uuid.NewString(),
+		payload: message,
+	}
+
+	for _, e := range d.endpoints {
+		select {
+		case e.queue <- del:
+		default:
+			d.deadLetter(e, del, 0, errors.New("queue full"))
+		}
+	}
+}
+
+// deliver posts the message to the endpoint, retrying with an exponential backoff
+func (d *Dispatcher) deliver(e *endpoint, del *delivery) {
+	wait := d.retryInterval
+	attempt := 0
+
+	var err error
+	for attempt < d.maxAttempts {
+		if attempt > 0 {
+			metrics.WebhookRetries.WithLabelValues(e.Name).Inc()
+			time.Sleep(wait)
+			if wait *= 2; wait > d.retryIntervalMax {
+				wait = d.retryIntervalMax
+			}
+		}
+		attempt++
+
+		var retry bool
+		if retry, err = d.post(e, del); err == nil {
+			metrics.WebhookDeliveries.WithLabelValues(e.Name, metrics.WebhookDelivered).Inc()
+			d.log.Debugf("Webhook: delivery [%v] posted to [%v]", del.id, e.Name)
+			return
+		}
+
+		d.log.Warnf("Webhook: attempt %d of delivery [%v] to [%v] failed, err: %v", attempt, del.id, e.Name, err)
+		if !retry {
+			break
+		}
+	}
+
+	d.deadLetter(e, del, attempt, err)
+}
+
+// post sends the signed request to the endpoint. It returns whether a failed delivery can be retried
+func (d *Dispatcher) post(e *endpoint, del *delivery) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, e.URL, bytes.NewReader(del.payload))
+	if err != nil {
+		return false, errors.Wrap(err, "create request")
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderID, del.id)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(e.Secret, timestamp, del.payload))
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return true, errors.Wrap(err, "request error")
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, errors.Errorf("status %v", resp.StatusCode)
+}
+
+func (d *Dispatcher) deadLetter(e *endpoint, del *delivery, attempts int, err error) {
+	metrics.WebhookDeliveries.WithLabelValues(e.Name, metrics.WebhookDeadLetter).Inc()
+	d.log.Errorf("Webhook: delivery [%v] to [%v] failed after %d attempt(s), err: %v", del.id, e.Name, attempts, err)
+
+	letter := &api.DeadLetter{
+		ID:        del.id,
+		Endpoint:  e.Name,
+		URL:       e.URL,
+		Payload:   del.payload,
+		Attempts:  attempts,
+		Error:     err.Error(),
+		Timestamp: time.Now().Unix(),
+	}
+
+	if err := d.deadLetters.Add(letter); err != nil {
+		d.log.Errorf("Webhook: failed to store dead letter [%v], err: %v", del.id, err)
+	}
+}
+
+// Replay posts the dead letters of the delivery again to the endpoints they failed for, with a single attempt each.
+// The delivered ones are removed from the dead-letter store, the others are kept. It returns no result when the
+// delivery has no dead letter
+func (d *Dispatcher) Replay(id string) []api.DeadLetterReplayResult {
+	results := make([]api.DeadLetterReplayResult, 0)
+	for _, letter := range d.deadLetters.List() {
+		if letter.ID != id {
+			continue
+		}
+
+		result := api.DeadLetterReplayResult{Endpoint: letter.Endpoint}
+		if err := d.replay(&letter); err != nil {
+			d.log.Warnf("Webhook: replay of delivery [%v] to [%v] failed, err: %v", id, letter.Endpoint, err)
+			result.Error = err.Error()
+		} else {
+			result.Delivered = true
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
+
+func (d *Dispatcher) replay(letter *api.DeadLetter) error {
+	var e *endpoint
+	for _, candidate := range d.endpoints {
+		if candidate.Name == letter.Endpoint {
+			e = candidate
+			break
+		}
+	}
+	if e == nil {
+		return errors.New("endpoint not configured")
+	}
+
+	if _, err := d.post(e, &delivery{id: letter.ID, payload: letter.Payload}); err != nil {
+		return err
+	}
+
+	metrics.WebhookDeliveries.WithLabelValues(e.Name, metrics.WebhookDelivered).Inc()
+	d.log.Infof("Webhook: delivery [%v] replayed to [%v]", letter.ID, e.Name)
+	if err := d.deadLetters.Remove(letter.ID, letter.Endpoint); err != nil {
+		d.log.Errorf("Webhook: failed to remove dead letter [%v], err: %v", letter.ID, err)
+	}
+
+	return nil
+}
+
+// Sign returns the X-Webhook-Signature of a delivery: the hex encoded HMAC-SHA256 of the timestamp,
+// a dot and the request body, keyed with the secret of the endpoint, prefixed by `sha256=`
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%d.", timestamp)))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,252 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+
+	"github.com/qredo/signing-agent/api"
+	"github.com/qredo/signing-agent/config"
+	"github.com/qredo/signing-agent/util"
+)
+
+const testAction = `{"id":"action id","coreClientID":"agent id","type":"ApproveWithdraw","status":"pending"}`
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// testEndpoint answers with the next status of the list, then with 200 OK
+type testEndpoint struct {
+	lock     sync.Mutex
+	statuses []int
+	received []receivedRequest
+}
+
+func (e *testEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.received = append(e.received, receivedRequest{r.Header, body})
+	status := http.StatusOK
+	if len(e.statuses) > 0 {
+		status, e.statuses = e.statuses[0], e.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (e *testEndpoint) requests() []receivedRequest {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return append([]receivedRequest{}, e.received...)
+}
+
+func newTestDispatcher(t *testing.T, urls ...string) (*Dispatcher, DeadLetterStore) {
+	cfg := config.Config{}
+	cfg.Default()
+	for i, url := range urls {
+		cfg.Webhooks.Endpoints = append(cfg.Webhooks.Endpoints, config.WebhookEndpoint{
+			Name:   "endpoint " + strconv.Itoa(i),
+			URL:    url,
+			Secret: "some secret",
+		})
+	}
+	cfg.Webhooks.MaxAttempts = 3
+
+	deadLetters, err := NewDeadLetterStore(filepath.Join(t.TempDir(), "deadletter.db"))
+	require.Nil(t, err)
+
+	sut := NewDispatcher(&cfg.Webhooks, deadLetters, util.NewTestLogger())
+	sut.retryInterval = 10 * time.Millisecond
+	sut.retryIntervalMax = 20 * time.Millisecond
+	return sut, deadLetters
+}
+
+func runDispatcher(sut *Dispatcher, messages ...string) {
+	done := make(chan bool)
+	go func() {
+		sut.Listen()
+		close(done)
+	}()
+
+	for _, message := range messages {
+		sut.Feed <- []byte(message)
+	}
+	close(sut.Feed)
+	<-done
+}
+
+func TestDispatcher_posts_signed_actions(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	endpoint := &testEndpoint{}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+	sut, deadLetters := newTestDispatcher(t, server.URL)
+
+	//Act
+	runDispatcher(sut, testAction, "not json")
+
+	//Assert
+	requests := endpoint.requests()
+	require.Len(t, requests, 1)
+	assert.Equal(t, testAction, string(requests[0].body))
+	assert.Equal(t, "application/json", requests[0].header.Get("Content-Type"))
+	assert.NotEmpty(t, requests[0].header.Get(HeaderID))
+
+	timestamp, err := strconv.ParseInt(requests[0].header.Get(HeaderTimestamp), 10, 64)
+	require.Nil(t, err)
+	assert.Equal(t, Sign("some secret", timestamp, []byte(testAction)), requests[0].header.Get(HeaderSignature))
+	assert.Empty(t, deadLetters.List())
+}
+
+func TestDispatcher_retries_failed_delivery(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	endpoint := &testEndpoint{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+	sut, deadLetters := newTestDispatcher(t, server.URL)
+
+	//Act
+	runDispatcher(sut, testAction)
+
+	//Assert
+	requests := endpoint.requests()
+	require.Len(t, requests, 3)
+	assert.Equal(t, requests[0].header.Get(HeaderID), requests[2].header.Get(HeaderID))
+	assert.Empty(t, deadLetters.List())
+}
+
+func TestDispatcher_dead_letters_undelivered_actions(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t)
+	failing := &testEndpoint{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable}}
+	failingServer := httptest.NewServer(failing)
+	defer failingServer.Close()
+	rejecting := &testEndpoint{statuses: []int{http.StatusBadRequest}}
+	rejectingServer := httptest.NewServer(rejecting)
+	defer rejectingServer.Close()
+	sut, deadLetters := newTestDispatcher(t, failingServer.URL, rejectingServer.URL)
+
+	//Act
+	runDispatcher(sut, testAction)
+
+	//Assert
+	assert.Len(t, failing.requests(), 3)
+	assert.Len(t, rejecting.requests(), 1)
+
+	letters := deadLetters.List()
+	require.Len(t, letters, 2)
+	byEndpoint := map[string]api.DeadLetter{letters[0].Endpoint: letters[0], letters[1].Endpoint: letters[1]}
+
+	assert.Equal(t, 3, byEndpoint["endpoint 0"].Attempts)
+	assert.Equal(t, "status 503", byEndpoint["endpoint 0"].Error)
+	assert.Equal(t, 1, byEndpoint["endpoint 1"].Attempts)
+	assert.Equal(t, "status 400", byEndpoint["endpoint 1"].Error)
+	assert.Equal(t, rejectingServer.URL, byEndpoint["endpoint 1"].URL)
+	assert.JSONEq(t, testAction, string(byEndpoint["endpoint 1"].Payload))
+}
+
+func TestDeadLetterStore_loads_stored_letters(t *testing.T) {
+	//Arrange
+	fileName := filepath.Join(t.TempDir(), "deadletter.db")
+	store, err := NewDeadLetterStore(fileName)
+	require.Nil(t, err)
+	require.Nil(t, store.Add(&api.DeadLetter{ID: "some id", Endpoint: "some endpoint", Payload: []byte(testAction), Attempts: 5, Error: "status 500"}))
+
+	//Act
+	reopened, err := NewDeadLetterStore(fileName)
+
+	//Assert
+	require.Nil(t, err)
+	letters := reopened.List()
+	require.Len(t, letters, 1)
+	assert.Equal(t, "some id", letters[0].ID)
+	assert.Equal(t, 5, letters[0].Attempts)
+	assert.JSONEq(t, testAction, string(letters[0].Payload))
+}
+
+func TestDeadLetterStore_removes_letter(t *testing.T) {
+	//Arrange
+	fileName := filepath.Join(t.TempDir(), "deadletter.db")
+	store, err := NewDeadLetterStore(fileName)
+	require.Nil(t, err)
+	require.Nil(t, store.Add(&api.DeadLetter{ID: "some id", Endpoint: "endpoint 0", Payload: []byte(testAction)}))
+	require.Nil(t, store.Add(&api.DeadLetter{ID: "some id", Endpoint: "endpoint 1", Payload: []byte(testAction)}))
+	require.Nil(t, store.Add(&api.DeadLetter{ID: "other id", Endpoint: "endpoint 0", Payload: []byte(testAction)}))
+
+	//Act
+	err = store.Remove("some id", "endpoint 0")
+
+	//Assert
+	require.Nil(t, err)
+	require.Nil(t, store.Add(&api.DeadLetter{ID: "new id", Endpoint: "endpoint 0", Payload: []byte(testAction)}))
+
+	for _, s := range []DeadLetterStore{store, reopen(t, fileName)} {
+		letters := s.List()
+		require.Len(t, letters, 3)
+		assert.Equal(t, "some id", letters[0].ID)
+		assert.Equal(t, "endpoint 1", letters[0].Endpoint)
+		assert.Equal(t, "other id", letters[1].ID)
+		assert.Equal(t, "new id", letters[2].ID)
+	}
+}
+
+func reopen(t *testing.T, fileName string) DeadLetterStore {
+	store, err := NewDeadLetterStore(fileName)
+	require.Nil(t, err)
+	return store
+}
+
+func TestDispatcher_Replay(t *testing.T) {
+	//Arrange
+	delivering := &testEndpoint{}
+	deliveringServer := httptest.NewServer(delivering)
+	defer deliveringServer.Close()
+	failing := &testEndpoint{statuses: []int{http.StatusServiceUnavailable}}
+	failingServer := httptest.NewServer(failing)
+	defer failingServer.Close()
+	sut, deadLetters := newTestDispatcher(t, deliveringServer.URL, failingServer.URL)
+
+	require.Nil(t, deadLetters.Add(&api.DeadLetter{ID: "some id", Endpoint: "endpoint 0", Payload: []byte(testAction), Attempts: 3}))
+	require.Nil(t, deadLetters.Add(&api.DeadLetter{ID: "some id", Endpoint: "endpoint 1", Payload: []byte(testAction), Attempts: 3}))
+	require.Nil(t, deadLetters.Add(&api.DeadLetter{ID: "some id", Endpoint: "removed endpoint", Payload: []byte(testAction), Attempts: 3}))
+	require.Nil(t, deadLetters.Add(&api.DeadLetter{ID: "other id", Endpoint: "endpoint 0", Payload: []byte(testAction), Attempts: 3}))
+
+	//Act
+	results := sut.Replay("some id")
+
+	//Assert
+	assert.Equal(t, []api.DeadLetterReplayResult{
+		{Endpoint: "endpoint 0", Delivered: true},
+		{Endpoint: "endpoint 1", Error: "status 503"},
+		{Endpoint: "removed endpoint", Error: "endpoint not configured"},
+	}, results)
+
+	requests := delivering.requests()
+	require.Len(t, requests, 1)
+	assert.Equal(t, "some id", requests[0].header.Get(HeaderID))
+	assert.Equal(t, testAction, string(requests[0].body))
+	assert.Len(t, failing.requests(), 1)
+
+	letters := deadLetters.List()
+	require.Len(t, letters, 3)
+	assert.Equal(t, "endpoint 1", letters[0].Endpoint)
+	assert.Equal(t, "removed endpoint", letters[1].Endpoint)
+	assert.Equal(t, "other id", letters[2].ID)
+	assert.Empty(t, sut.Replay("unknown id"))
+}